@@ -0,0 +1,122 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apierr provides error responses with stable machine-readable
+// error codes so API clients do not have to parse error messages.
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+type Code string
+
+// generic codes derived from HTTP statuses
+const (
+	CodeBadRequest         Code = "BAD_REQUEST"
+	CodeNotFound           Code = "NOT_FOUND"
+	CodeConflict           Code = "CONFLICT"
+	CodeUnprocessable      Code = "UNPROCESSABLE"
+	CodeTooManyRequests    Code = "TOO_MANY_REQUESTS"
+	CodeServiceUnavailable Code = "SERVICE_UNAVAILABLE"
+	CodeInternalError      Code = "INTERNAL_ERROR"
+)
+
+// codes of specific (known) errors
+const (
+	CodeRecordNotFound         Code = "RECORD_NOT_FOUND"
+	CodeNotIndexable           Code = "NOT_INDEXABLE"
+	CodeMissingRecordData      Code = "MISSING_RECORD_DATA"
+	CodeIndexLocked            Code = "INDEX_LOCKED"
+	CodeIndexOpening           Code = "INDEX_OPENING"
+	CodeOptimizationInProgress Code = "OPTIMIZATION_IN_PROGRESS"
+	CodeInvalidSupertype       Code = "INVALID_SUPERTYPE"
+	CodeInvalidSortField       Code = "INVALID_SORT_FIELD"
+	CodeInvalidSearchTerm      Code = "INVALID_SEARCH_TERM"
+	CodeFieldNotFacetable      Code = "FIELD_NOT_FACETABLE"
+	CodeNoReindexCheckpoint    Code = "NO_REINDEX_CHECKPOINT"
+	CodeTooDemandingQuery      Code = "TOO_DEMANDING_QUERY"
+)
+
+// KnownError maps a sentinel error to an error code and an HTTP status
+type KnownError struct {
+	Err    error
+	Code   Code
+	Status int
+}
+
+type errorResponse struct {
+	Code      int      `json:"code"`
+	ErrorCode Code     `json:"errorCode"`
+	Error     string   `json:"error"`
+	Details   []string `json:"details"`
+}
+
+func codeFromStatus(status int) Code {
+	switch {
+	case status == http.StatusNotFound:
+		return CodeNotFound
+	case status == http.StatusConflict:
+		return CodeConflict
+	case status == http.StatusUnprocessableEntity:
+		return CodeUnprocessable
+	case status == http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case status == http.StatusServiceUnavailable:
+		return CodeServiceUnavailable
+	case status >= 400 && status < 500:
+		return CodeBadRequest
+	default:
+		return CodeInternalError
+	}
+}
+
+// Resolve finds an error code and an HTTP status for err. Errors found
+// (via errors.Is) in `known` get their specific code and status, other
+// errors get a generic code derived from the provided status.
+func Resolve(err error, status int, known []KnownError) (Code, int) {
+	for _, ke := range known {
+		if errors.Is(err, ke.Err) {
+			return ke.Code, ke.Status
+		}
+	}
+	return codeFromStatus(status), status
+}
+
+// Respond writes an error response with an error code resolved
+// via Resolve. The response is compatible with the one produced
+// by uniresp.RespondWithErrorJSON (it just adds the `errorCode` field).
+func Respond(ctx *gin.Context, err error, status int, known []KnownError) {
+	code, status := Resolve(err, status, known)
+	ctx.Error(err)
+	jsonAns, jerr := json.Marshal(errorResponse{
+		Code:      status,
+		ErrorCode: code,
+		Error:     err.Error(),
+	})
+	if jerr != nil {
+		log.Error().Err(jerr).Msg("failed to encode error response")
+		http.Error(ctx.Writer, jerr.Error(), http.StatusInternalServerError)
+		return
+	}
+	ctx.Writer.WriteHeader(status)
+	ctx.Writer.Write(jsonAns)
+}
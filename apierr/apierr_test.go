@@ -0,0 +1,68 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+var errTest = errors.New("test error")
+
+var testKnownErrors = []KnownError{
+	{Err: errTest, Code: CodeRecordNotFound, Status: http.StatusNotFound},
+}
+
+func TestResolveKnownError(t *testing.T) {
+	code, status := Resolve(
+		fmt.Errorf("failed to load: %w", errTest), http.StatusInternalServerError, testKnownErrors)
+	assert.Equal(t, CodeRecordNotFound, code)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestResolveUnknownError(t *testing.T) {
+	code, status := Resolve(errors.New("foo"), http.StatusBadRequest, testKnownErrors)
+	assert.Equal(t, CodeBadRequest, code)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	code, status = Resolve(errors.New("foo"), http.StatusTooManyRequests, testKnownErrors)
+	assert.Equal(t, CodeTooManyRequests, code)
+	assert.Equal(t, http.StatusTooManyRequests, status)
+
+	code, status = Resolve(errors.New("foo"), http.StatusInternalServerError, testKnownErrors)
+	assert.Equal(t, CodeInternalError, code)
+	assert.Equal(t, http.StatusInternalServerError, status)
+}
+
+func TestRespond(t *testing.T) {
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	Respond(ctx, errTest, http.StatusInternalServerError, testKnownErrors)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var resp map[string]any
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "RECORD_NOT_FOUND", resp["errorCode"])
+	assert.Equal(t, "test error", resp["error"])
+	assert.Equal(t, float64(http.StatusNotFound), resp["code"])
+}
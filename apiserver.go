@@ -18,11 +18,15 @@ package main
 
 import (
 	"camus/archiver"
+	"camus/cncdb"
 	"camus/cnf"
 	"camus/indexer"
+	"camus/reporting"
 	"context"
+	"database/sql"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/czcorpus/cnc-gokit/logging"
@@ -31,12 +35,94 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	readinessCheckTimeout = 3 * time.Second
+)
+
 type apiServer struct {
 	server          *http.Server
 	conf            *cnf.Conf
 	arch            *archiver.ArchKeeper
 	fulltextService *indexer.Service
 	rdb             *archiver.RedisAdapter
+	db              *sql.DB
+	recentStats     *reporting.InMemoryReporting
+	mergeAudits     *cncdb.MergeAuditLog
+
+	// ready is set once all the services are started
+	// (see SetReady)
+	ready atomic.Bool
+}
+
+// SetReady marks the server (and the whole application) as ready
+// to serve requests.
+func (api *apiServer) SetReady() {
+	api.ready.Store(true)
+}
+
+// Livez responds with 200 as long as the process is able to handle
+// HTTP requests.
+func (api *apiServer) Livez(ctx *gin.Context) {
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"ok": true})
+}
+
+// Readyz tests whether all the services are started and whether
+// Redis, MySQL and the fulltext index are available. In case of any
+// failure (including the index still being opened), 503 is returned.
+func (api *apiServer) Readyz(ctx *gin.Context) {
+	checks := make(map[string]string)
+	ok := api.ready.Load()
+	if ok {
+		checks["services"] = "ok"
+
+	} else {
+		checks["services"] = "not started yet"
+	}
+	tctx, cancel := context.WithTimeout(ctx.Request.Context(), readinessCheckTimeout)
+	defer cancel()
+	if err := api.rdb.Ping(tctx); err != nil {
+		checks["redis"] = err.Error()
+		ok = false
+
+	} else {
+		checks["redis"] = "ok"
+	}
+	if err := api.db.PingContext(tctx); err != nil {
+		checks["mysql"] = err.Error()
+		ok = false
+
+	} else {
+		checks["mysql"] = "ok"
+	}
+	switch api.fulltextService.IndexState() {
+	case indexer.IndexStateOpening:
+		checks["index"] = "opening"
+		ok = false
+	case indexer.IndexStateOpenTimeout:
+		checks["index"] = fmt.Sprintf(
+			"not opened within %s (likely in use)", api.conf.Indexer.IndexOpenTimeout)
+		ok = false
+	default:
+		if _, err := api.fulltextService.Indexer().DocCount(); err != nil {
+			checks["index"] = err.Error()
+			ok = false
+
+		} else {
+			checks["index"] = "ok"
+		}
+	}
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusServiceUnavailable
+	}
+	uniresp.WriteJSONResponseWithStatus(
+		ctx.Writer, status, map[string]any{"ok": ok, "checks": checks})
+}
+
+// Config responds with the effective configuration (i.e. including
+// the values set by ValidateAndDefaults) with sensitive values redacted.
+func (api *apiServer) Config(ctx *gin.Context) {
+	uniresp.WriteJSONResponse(ctx.Writer, api.conf.Redacted())
 }
 
 func (api *apiServer) Start(ctx context.Context) {
@@ -51,21 +137,54 @@ func (api *apiServer) Start(ctx context.Context) {
 	engine.NoMethod(uniresp.NoMethodHandler)
 	engine.NoRoute(uniresp.NotFoundHandler)
 
-	archHandler := Actions{ArchKeeper: api.arch}
+	archHandler := Actions{
+		ArchKeeper:    api.arch,
+		RecentStats:   api.recentStats,
+		MergeAuditLog: api.mergeAudits,
+		TZ:            api.conf.TimezoneLocation(),
+	}
+
+	engine.GET("/livez", api.Livez)
+	engine.GET("/readyz", api.Readyz)
+	engine.GET("/config", api.Config)
 
 	engine.GET("/overview", archHandler.Overview)
+	engine.GET("/stats/daily", archHandler.DailyStats)
 	engine.GET("/record/:id", archHandler.GetRecord)
 	engine.GET("/validate/:id", archHandler.Validate)
+	engine.POST("/validate-batch", archHandler.ValidateBatch)
+	engine.GET("/chain/:id", archHandler.Chain)
 	engine.POST("/fix/:id", archHandler.Fix)
 	engine.POST("/dedup-reset", archHandler.DedupReset)
+	engine.POST("/dedup-rebuild", archHandler.DedupRebuild)
+	engine.POST("/dedup-save", archHandler.DedupSave)
+	engine.GET("/errors", archHandler.ListErrors)
+	engine.GET("/merge-audits", archHandler.MergeAudits)
+	engine.GET("/queue/peek", archHandler.PeekQueue)
+	engine.GET("/recent", archHandler.RecentRecords)
+	engine.POST("/archive/flush", archHandler.Flush)
+	engine.POST("/query-history/requeue", archHandler.RequeueHistory)
 
 	indexerHandler := indexer.NewActions(api.fulltextService)
-	engine.GET("/query-history/build", indexerHandler.IndexLatestRecords)
-	engine.GET("/query-history/rec2doc", indexerHandler.RecordToDoc)
-	engine.GET("/query-history/index-info", indexerHandler.IndexInfo)
-	engine.POST("/user-query-history/:userId", indexerHandler.Search)
-	engine.POST("/user-query-history/:userId/:queryId/:created", indexerHandler.Update)
-	engine.DELETE("/user-query-history/:userId/:queryId/:created", indexerHandler.Delete)
+	searchRateLimit := newRateLimitMiddleware(api.conf.SearchRateLimit)
+	// the server is started before the index is opened
+	// so the indexer routes must wait for it
+	idxRoutes := engine.Group("", indexerHandler.RequireOpenIndex)
+	idxRoutes.GET("/query-history/build", indexerHandler.IndexLatestRecords)
+	idxRoutes.GET("/query-history/rec2doc", indexerHandler.RecordToDoc)
+	idxRoutes.GET("/query-history/conversion-report", indexerHandler.ConversionReport)
+	idxRoutes.GET("/query-history/index-info", indexerHandler.IndexInfo)
+	idxRoutes.GET("/query-history/mapping", indexerHandler.Mapping)
+	idxRoutes.POST("/query-history/optimize", indexerHandler.Optimize)
+	idxRoutes.POST("/query-history/reindex-failed", indexerHandler.ReindexFailed)
+	idxRoutes.POST("/purge/:id", indexerHandler.Purge)
+	idxRoutes.GET("/facets", indexerHandler.Facets)
+	idxRoutes.GET("/stats/query-types", newAdminAuthMiddleware(api.conf), indexerHandler.QueryTypeStats)
+	idxRoutes.POST("/user-query-history/:userId", searchRateLimit, indexerHandler.Search)
+	idxRoutes.GET("/user-query-history/:userId/suggest", searchRateLimit, indexerHandler.Suggest)
+	idxRoutes.POST("/user-query-history/:userId/:queryId/:created", indexerHandler.Update)
+	idxRoutes.DELETE("/user-query-history/:userId/:queryId/:created", indexerHandler.Delete)
+	engine.POST("/cql/extract", indexerHandler.ExtractCQL)
 
 	api.server = &http.Server{
 		Handler:      engine,
@@ -0,0 +1,90 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"camus/archiver"
+	"camus/cncdb"
+	"camus/cnf"
+	"camus/indexer"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type readyzResponse struct {
+	OK     bool              `json:"ok"`
+	Checks map[string]string `json:"checks"`
+}
+
+func callReadyz(t *testing.T, api *apiServer) (int, readyzResponse) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/readyz", api.Readyz)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var ans readyzResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &ans))
+	return w.Code, ans
+}
+
+func TestReadyzIndexOpening(t *testing.T) {
+	mr := miniredis.RunT(t)
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err)
+	defer db.Close()
+	mock.ExpectPing()
+	mock.ExpectPing()
+	tempDir, err := os.MkdirTemp("", "test-index")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	idxConf := &indexer.Conf{IndexDirPath: tempDir, IndexOpenTimeout: "10s"}
+	api := &apiServer{
+		conf: &cnf.Conf{Indexer: idxConf},
+		rdb: archiver.NewRedisAdapter(
+			context.Background(),
+			&archiver.RedisConf{Host: mr.Host(), Port: mr.Server().Addr().Port},
+		),
+		db:              db,
+		fulltextService: indexer.NewService(idxConf, nil, nil),
+	}
+	api.SetReady()
+
+	status, ans := callReadyz(t, api)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.False(t, ans.OK)
+	assert.Equal(t, "opening", ans.Checks["index"])
+	assert.Equal(t, "ok", ans.Checks["redis"])
+	assert.Equal(t, "ok", ans.Checks["mysql"])
+
+	idxer, err := api.fulltextService.OpenIndex(
+		&cncdb.DummyConcArchSQL{}, &cncdb.MySQLQueryHistDryRun{}, nil)
+	assert.NoError(t, err)
+	defer idxer.Close()
+	status, ans = callReadyz(t, api)
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, ans.OK)
+	assert.Equal(t, "ok", ans.Checks["index"])
+}
@@ -21,6 +21,8 @@ import (
 	"camus/reporting"
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -51,6 +53,20 @@ type ArchKeeper struct {
 	tz          *time.Location
 	stats       reporting.OpStats
 	recsToIndex chan<- cncdb.HistoryRecord
+
+	// checkMutex prevents concurrent runs of performCheck
+	// (scheduled vs. explicitly triggered via Flush)
+	checkMutex sync.Mutex
+
+	// lastActivity is a unix time (in nanoseconds) of the last
+	// check which processed at least one item
+	lastActivity atomic.Int64
+
+	// activity signals processed items to the dedup. flushing loop
+	activity chan struct{}
+
+	// recordTypes contains handlers of supported queue record types
+	recordTypes recordTypeRegistry
 }
 
 // Start starts the ArchKeeper service
@@ -64,12 +80,70 @@ func (job *ArchKeeper) Start(ctx context.Context) {
 				log.Info().Msg("about to close ArchKeeper")
 				return
 			case <-ticker.C:
-				if err := job.performCheck(); err != nil {
+				if !job.checkMutex.TryLock() {
+					log.Warn().Msg("skipping regular check - another check is in progress")
+					continue
+				}
+				if _, err := job.performCheck(); err != nil {
 					log.Error().Err(err).Msg("Failed to archive query persistence items")
 				}
+				job.checkMutex.Unlock()
 			}
 		}
 	}()
+	if job.conf.DDStateFlushInterval() > 0 {
+		go job.runDedupFlushing(ctx)
+	}
+}
+
+// markActivity records that the archiver has just processed
+// some items
+func (job *ArchKeeper) markActivity() {
+	job.lastActivity.Store(time.Now().UnixNano())
+	select {
+	case job.activity <- struct{}{}:
+	default:
+	}
+}
+
+// IdleFor returns time elapsed since the archiver processed
+// any items
+func (job *ArchKeeper) IdleFor() time.Duration {
+	return time.Since(time.Unix(0, job.lastActivity.Load()))
+}
+
+// runDedupFlushing periodically stores the deduplicator state to disk.
+// Once the archiver becomes idle (see Conf.DDStateIdleThresholdSecs),
+// the state is stored for the last time and the flushing is paused
+// until there are new processed items.
+func (job *ArchKeeper) runDedupFlushing(ctx context.Context) {
+	ticker := time.NewTicker(job.conf.DDStateFlushInterval())
+	defer ticker.Stop()
+	var paused bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-job.activity:
+			if paused {
+				log.Debug().Msg("archiver active again, resuming periodic dedup. state flushing")
+				ticker.Reset(job.conf.DDStateFlushInterval())
+				paused = false
+			}
+		case <-ticker.C:
+			if err := job.dedup.StoreToDisk(); err != nil {
+				log.Error().Err(err).Msg("failed to flush deduplicator state")
+				continue
+			}
+			if idle := job.IdleFor(); idle >= job.conf.DDStateIdleThreshold() {
+				log.Debug().
+					Dur("idleFor", idle).
+					Msg("archiver idle, pausing periodic dedup. state flushing")
+				ticker.Stop()
+				paused = true
+			}
+		}
+	}
 }
 
 // Stop stops the ArchKeeper service
@@ -88,129 +162,313 @@ func (job *ArchKeeper) StoreToDisk() error {
 	return job.dedup.StoreToDisk()
 }
 
+// DedupStateInfo returns info about the deduplicator filter
+// and its stored state.
+func (job *ArchKeeper) DedupStateInfo() DedupStateInfo {
+	return job.dedup.StateInfo()
+}
+
 // Reset clears current operations data stored in RAM
 // and initializes itself according to the configuration.
 func (job *ArchKeeper) Reset() error {
 	return job.dedup.Reset()
 }
 
+// RebuildDedup replaces the deduplicator data with a fresh set
+// of the most recent record IDs. It returns number of loaded IDs.
+func (job *ArchKeeper) RebuildDedup() (int, error) {
+	return job.dedup.Rebuild()
+}
+
 // GetStats returns statistics related to ArchKeeper operations.
 // We use it mainly for pushing stats to a TimescaleDB instance.
 func (job *ArchKeeper) GetStats() reporting.OpStats {
 	return job.stats
 }
 
+// ListErrors returns up to `limit` most recent items from the failed queue.
+// With withData set, the stored record data are attached too.
+func (job *ArchKeeper) ListErrors(limit int, withData bool) ([]FailedItem, error) {
+	items, err := job.redis.ListErrors(job.conf.FailedQueueKey, limit)
+	if err != nil {
+		return []FailedItem{}, err
+	}
+	var data map[string]string
+	if withData {
+		keys := make([]string, len(items))
+		for i, item := range items {
+			keys[i] = item.Key
+		}
+		data, err = job.redis.GetErrorsData(job.conf.FailedRecordsKey, keys)
+		if err != nil {
+			return []FailedItem{}, err
+		}
+	}
+	ans := make([]FailedItem, len(items))
+	for i, item := range items {
+		ans[i] = FailedItem{Item: item, Data: data[item.Key]}
+	}
+	return ans, nil
+}
+
+// PeekQueue returns up to `limit` items the archiver is about
+// to process next. The queue is not modified.
+func (job *ArchKeeper) PeekQueue(limit int) ([]queueRecord, error) {
+	return job.redis.PeekQueue(job.conf.QueueKey, int64(limit))
+}
+
+// LoadRecentRecords returns up to `num` most recently archived records
+func (job *ArchKeeper) LoadRecentRecords(num int) ([]cncdb.ArchRecord, error) {
+	return job.dbArch.LoadRecentNRecords(num)
+}
+
+// HistoryQueueItem identifies a query history item to be (re)indexed
+type HistoryQueueItem struct {
+	QueryID string `json:"queryId"`
+	UserID  int    `json:"userId"`
+	Created int64  `json:"created"`
+	Name    string `json:"name"`
+}
+
+// RequeueHistory pushes query history items to the archive queue
+// so they are processed (and passed to the indexer) the same
+// way as items pushed there by KonText. It returns the number
+// of enqueued items.
+func (job *ArchKeeper) RequeueHistory(items []HistoryQueueItem) (int, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+	recs := make([]queueRecord, len(items))
+	for i, item := range items {
+		recs[i] = queueRecord{
+			Type:    QRTypeHistory,
+			Key:     item.QueryID,
+			UserID:  item.UserID,
+			Created: item.Created,
+			Name:    item.Name,
+		}
+	}
+	if err := job.redis.PushQueueItems(job.conf.QueueKey, recs); err != nil {
+		return 0, fmt.Errorf("failed to requeue history items: %w", err)
+	}
+	return len(recs), nil
+}
+
 func (job *ArchKeeper) LoadRecordsByID(concID string) ([]cncdb.ArchRecord, error) {
 	return job.dbArch.LoadRecordsByID(concID)
 }
 
+// addError stores a failed item to the failed queue and, if configured,
+// trims the queue so it cannot grow indefinitely.
+func (job *ArchKeeper) addError(item queueRecord, rec *cncdb.ArchRecord) error {
+	if err := job.redis.AddError(job.conf.FailedQueueKey, job.conf.FailedRecordsKey, item, rec); err != nil {
+		return err
+	}
+	if job.conf.FailedQueueMaxLen > 0 {
+		numDropped, err := job.redis.TrimErrors(
+			job.conf.FailedQueueKey, job.conf.FailedRecordsKey, int64(job.conf.FailedQueueMaxLen))
+		if err != nil {
+			return err
+		}
+		if numDropped > 0 {
+			log.Warn().
+				Int("numDropped", numDropped).
+				Int("maxLen", job.conf.FailedQueueMaxLen).
+				Msg("failed queue reached its size limit, oldest errors dropped")
+		}
+	}
+	return nil
+}
+
+// timedOut tests whether the processing of an item failed
+// due to the exceeded time limit. In such case, the item is
+// marked accordingly and true is returned. The caller should
+// not register the failure any further then (see performCheck).
+func timedOut(ctx context.Context, ans *itemResult) bool {
+	if ctx.Err() != nil {
+		ans.timedOut = true
+		return true
+	}
+	return false
+}
+
 // handleImplicitReq returns true if everything was ok, otherwise
 // false. Possible problems are logged.
 func (job *ArchKeeper) handleImplicitReq(
-	rec cncdb.ArchRecord, item queueRecord, currStats *reporting.OpStats) bool {
+	ctx context.Context, rec cncdb.ArchRecord, item queueRecord, ans *itemResult) bool {
 
-	match, err := job.dedup.TestAndSolve(rec)
+	match, err := job.dedup.TestAndSolve(ctx, rec)
 	if err != nil {
+		if timedOut(ctx, ans) {
+			return false
+		}
 		log.Error().
 			Err(err).
 			Str("recordId", item.Key).
 			Msg("failed to insert record, skipping")
-		if err := job.redis.AddError(job.conf.FailedQueueKey, item, &rec); err != nil {
+		if err := job.addError(item, &rec); err != nil {
 			log.Error().Err(err).Msg("failed to insert error key")
 		}
-		currStats.NumErrors++
+		ans.stats.NumErrors++
 		return false
 	}
 	if match {
 		log.Warn().
 			Str("recordId", item.Key).
 			Msg("record already archived, data merged")
-		currStats.NumMerged++
+		ans.stats.NumMerged++
 		return true
 	}
-	if err := job.dbArch.InsertRecord(rec); err != nil {
+	if err := job.dbArch.InsertRecordCtx(ctx, rec); err != nil {
+		if timedOut(ctx, ans) {
+			// the insert may have been performed anyway so we keep
+			// the ID in the dedup. filter - a retry will then merge
+			// the record instead of creating a duplicate
+			job.dedup.Add(rec.ID)
+			return false
+		}
 		log.Error().
 			Err(err).
 			Str("recordId", item.Key).
 			Msg("failed to insert record, skipping")
-		if err := job.redis.AddError(job.conf.FailedQueueKey, item, &rec); err != nil {
+		if err := job.addError(item, &rec); err != nil {
 			log.Error().Err(err).Msg("failed to insert error key")
 		}
 	}
 	job.dedup.Add(rec.ID)
-	currStats.NumInserted++
+	ans.stats.NumInserted++
 	return false
 }
 
 func (job *ArchKeeper) handleExplicitReq(
-	rec cncdb.ArchRecord, item queueRecord, currStats *reporting.OpStats) {
-	exists, err := job.dbArch.ContainsRecord(rec.ID)
+	ctx context.Context, rec cncdb.ArchRecord, item queueRecord, ans *itemResult) {
+	inserted, err := job.dbArch.InsertRecordIfMissingCtx(ctx, rec)
 	if err != nil {
-		currStats.NumErrors++
+		if timedOut(ctx, ans) {
+			return
+		}
+		ans.stats.NumErrors++
 		log.Error().
 			Err(err).
 			Str("recordId", item.Key).
-			Msg("failed to test record existence, skipping")
+			Msg("failed to insert record, skipping")
+
+	} else if inserted {
+		ans.stats.NumInserted++
 	}
-	if !exists {
-		err := job.dbArch.InsertRecord(rec)
-		if err != nil {
-			currStats.NumErrors++
-			log.Error().
-				Err(err).
-				Str("recordId", item.Key).
-				Msg("failed to insert record, skipping")
+	job.dedup.Add(rec.ID)
+}
+
+// writeLatencyTracker is implemented by database adapters
+// measuring durations of their write operations
+// (see cncdb.TimedConcArchOps)
+type writeLatencyTracker interface {
+	PopWriteLatencyP95() time.Duration
+}
+
+// itemResult is a result of processing of a single queued item
+type itemResult struct {
+	stats reporting.OpStats
+
+	// histRec is set in case the item is a query history record
+	// which should be passed to the indexer
+	histRec *cncdb.HistoryRecord
+
+	// timedOut specifies that the processing has been interrupted
+	// due to the exceeded time limit
+	timedOut bool
+}
 
-		} else {
-			currStats.NumInserted++
+// processItem processes a single queued record. All the Redis and database
+// operations are bound to the provided context.
+func (job *ArchKeeper) processItem(ctx context.Context, item queueRecord) itemResult {
+	var ans itemResult
+	handler, ok := job.recordTypes.get(item.Type)
+	if !ok {
+		log.Error().
+			Str("recordId", item.Key).
+			Str("type", string(item.Type)).
+			Msg("unknown queue record type, skipping")
+		item.ErrorReason = fmt.Sprintf("unknown queue record type `%s`", item.Type)
+		if err := job.addError(item, nil); err != nil {
+			log.Error().Err(err).Msg("failed to insert error key")
+		}
+		ans.stats.NumErrors++
+		return ans
+	}
+	rec, err := job.redis.GetConcRecordCtx(ctx, item.KeyCode())
+	if err != nil {
+		if timedOut(ctx, &ans) {
+			return ans
 		}
-		job.dedup.Add(rec.ID)
+		log.Error().
+			Err(err).
+			Str("recordId", item.Key).
+			Msg("failed to get record from Redis, skipping")
+		if err := job.addError(item, nil); err != nil {
+			log.Error().Err(err).Msg("failed to insert error key")
+		}
+		ans.stats.NumErrors++
+		return ans
 	}
+	rec.Created = time.Now().In(job.tz)
+	handler(ctx, job, rec, item, &ans)
+	return ans
 }
 
-func (job *ArchKeeper) performCheck() error {
+// processItemWithTimeout runs processItem with the configured time
+// limit. In case the limit is exceeded, the pending Redis and database
+// operations are interrupted and false is returned. The processing is
+// always finished once the method returns so the caller can safely
+// register the item as failed.
+func (job *ArchKeeper) processItemWithTimeout(item queueRecord) (itemResult, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), job.conf.RecordProcTimeout())
+	defer cancel()
+	res := job.processItem(ctx, item)
+	return res, !res.timedOut
+}
+
+// Flush performs a single archive check immediately (i.e. without
+// waiting for the ticker). In case a scheduled check is in progress,
+// the method waits for it to finish.
+func (job *ArchKeeper) Flush() (reporting.OpStats, error) {
+	job.checkMutex.Lock()
+	defer job.checkMutex.Unlock()
+	return job.performCheck()
+}
+
+func (job *ArchKeeper) performCheck() (reporting.OpStats, error) {
 	items, err := job.redis.NextNArchItems(job.conf.QueueKey, int64(job.conf.CheckIntervalChunk))
 	log.Debug().
 		AnErr("error", err).
 		Int("itemsToProcess", len(items)).
 		Msg("doing regular check")
 	if err != nil {
-		return fmt.Errorf("failed to fetch next queued chunk: %w", err)
+		return reporting.OpStats{}, fmt.Errorf("failed to fetch next queued chunk: %w", err)
+	}
+	if len(items) > 0 {
+		job.markActivity()
 	}
 	var currStats reporting.OpStats
 	var numFetched int
 	for _, item := range items {
 		currStats.NumFetched++
-		rec, err := job.redis.GetConcRecord(item.KeyCode())
-		if err != nil {
+		res, ok := job.processItemWithTimeout(item)
+		if !ok {
+			currStats.UpdateBy(res.stats)
 			log.Error().
-				Err(err).
 				Str("recordId", item.Key).
-				Msg("failed to get record from Redis, skipping")
-			if err := job.redis.AddError(job.conf.FailedQueueKey, item, nil); err != nil {
+				Dur("timeout", job.conf.RecordProcTimeout()).
+				Msg("processing of record timed out, skipping")
+			if err := job.addError(item, nil); err != nil {
 				log.Error().Err(err).Msg("failed to insert error key")
 			}
-			currStats.NumErrors++
+			currStats.NumTimeouts++
 			continue
 		}
-		rec.Created = time.Now().In(job.tz)
-
-		switch item.Type {
-		case QRTypeArchive, "":
-			if item.Explicit {
-				job.handleExplicitReq(rec, item, &currStats)
-
-			} else {
-				job.handleImplicitReq(rec, item, &currStats)
-			}
-		case QRTypeHistory:
-			job.recsToIndex <- cncdb.HistoryRecord{
-				QueryID: item.Key,
-				UserID:  item.UserID,
-				Created: item.Created,
-				Name:    item.Name,
-				Rec:     &rec,
-			}
+		currStats.UpdateBy(res.stats)
+		if res.histRec != nil {
+			job.recsToIndex <- *res.histRec
 		}
 	}
 	if currStats.ShowsActivity() {
@@ -218,12 +476,18 @@ func (job *ArchKeeper) performCheck() error {
 			Int("numInserted", currStats.NumInserted).
 			Int("numMerged", currStats.NumMerged).
 			Int("numErrors", currStats.NumErrors).
+			Int("numTimeouts", currStats.NumTimeouts).
 			Int("numFetched", numFetched).
 			Msg("regular archiving report")
 	}
+	currStats.NumDedupFalsePositives = job.dedup.PopNumFalsePositives()
+	currStats.DedupFalsePositiveRatio = job.dedup.FalsePositiveRatio()
+	if lt, ok := job.dbArch.(writeLatencyTracker); ok {
+		currStats.WriteLatencyP95Ms = lt.PopWriteLatencyP95().Milliseconds()
+	}
 	job.reporting.WriteOperationsStatus(currStats)
 	job.stats.UpdateBy(currStats)
-	return nil
+	return currStats, nil
 }
 
 func (job *ArchKeeper) DeduplicateInArchive(
@@ -240,7 +504,7 @@ func NewArchKeeper(
 	tz *time.Location,
 	conf *Conf,
 ) *ArchKeeper {
-	return &ArchKeeper{
+	ans := &ArchKeeper{
 		redis:       redis,
 		dbArch:      concArchDb,
 		dedup:       dedup,
@@ -248,5 +512,9 @@ func NewArchKeeper(
 		reporting:   reporting,
 		tz:          tz,
 		conf:        conf,
+		activity:    make(chan struct{}, 1),
+		recordTypes: newRecordTypeRegistry(),
 	}
+	ans.lastActivity.Store(time.Now().UnixNano())
+	return ans
 }
@@ -0,0 +1,115 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archiver
+
+import (
+	"camus/cncdb"
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bits-and-blooms/bloom/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowConcArch simulates a database which is unable to finish
+// write operations within the item processing time limit
+type slowConcArch struct {
+	cncdb.IConcArchOps
+	numStarted  atomic.Int32
+	numFinished atomic.Int32
+}
+
+func (db *slowConcArch) wait(ctx context.Context) error {
+	db.numStarted.Add(1)
+	defer db.numFinished.Add(1)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(5 * time.Second):
+		return nil
+	}
+}
+
+func (db *slowConcArch) InsertRecordCtx(ctx context.Context, rec cncdb.ArchRecord) error {
+	return db.wait(ctx)
+}
+
+func (db *slowConcArch) InsertRecordIfMissingCtx(ctx context.Context, rec cncdb.ArchRecord) (bool, error) {
+	if err := db.wait(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func newTestArchKeeper(db cncdb.IConcArchOps) *ArchKeeper {
+	conf := &Conf{DDFalsePositiveWindow: 10}
+	dedup := &Deduplicator{
+		knownIDs:      bloom.NewWithEstimates(1000, bloomFilterProbCollision),
+		knownIDsMutex: &sync.RWMutex{},
+		concDB:        db,
+		conf:          conf,
+		tz:            time.UTC,
+		fpTracker:     newFPTracker(conf.DDFalsePositiveWindow),
+	}
+	// note: redis is nil so any attempt to register an error
+	// would make the test fail
+	return NewArchKeeper(nil, db, dedup, nil, nil, time.UTC, conf)
+}
+
+func TestHandleImplicitReqTimeout(t *testing.T) {
+	db := &slowConcArch{}
+	job := newTestArchKeeper(db)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var ans itemResult
+	job.handleImplicitReq(ctx, cncdb.ArchRecord{ID: "abc"}, queueRecord{Key: "abc"}, &ans)
+	assert.True(t, ans.timedOut)
+	assert.Equal(t, 0, ans.stats.NumInserted)
+	assert.Equal(t, 0, ans.stats.NumErrors)
+	// the database operation is finished once the handler returns
+	assert.Equal(t, int32(1), db.numStarted.Load())
+	assert.Equal(t, int32(1), db.numFinished.Load())
+	// the insert may have been performed so a retry must test for the record
+	assert.True(t, job.dedup.TestRecord("abc"))
+}
+
+func TestHandleExplicitReqTimeout(t *testing.T) {
+	db := &slowConcArch{}
+	job := newTestArchKeeper(db)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var ans itemResult
+	job.handleExplicitReq(ctx, cncdb.ArchRecord{ID: "abc"}, queueRecord{Key: "abc"}, &ans)
+	assert.True(t, ans.timedOut)
+	assert.Equal(t, 0, ans.stats.NumInserted)
+	assert.Equal(t, 0, ans.stats.NumErrors)
+	assert.Equal(t, int32(1), db.numFinished.Load())
+	assert.False(t, job.dedup.TestRecord("abc"))
+}
+
+func TestHandleExplicitReqWithinLimit(t *testing.T) {
+	job := newTestArchKeeper(&cncdb.DummyConcArchSQL{})
+	var ans itemResult
+	job.handleExplicitReq(
+		context.Background(), cncdb.ArchRecord{ID: "abc"}, queueRecord{Key: "abc"}, &ans)
+	assert.False(t, ans.timedOut)
+	assert.Equal(t, 1, ans.stats.NumInserted)
+	assert.True(t, job.dedup.TestRecord("abc"))
+}
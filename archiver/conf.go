@@ -25,7 +25,16 @@ import (
 )
 
 const (
-	dfltPreloadLastNItems = 500
+	dfltPreloadLastNItems    = 500
+	dfltRebuildLastNItems    = 5000
+	dfltRecordProcTimeoutMs  = 10000
+	dfltSlowWriteThresholdMs = 1000
+
+	dfltDDStateFlushIntervalSecs = 300
+	dfltDDStateIdleThresholdSecs = 900
+
+	dfltDDFalsePositiveWindow    = 1000
+	dfltDDFalsePositiveWarnRatio = 0.05
 )
 
 type Conf struct {
@@ -34,6 +43,29 @@ type Conf struct {
 	// can store its status
 	DDStateFilePath string `json:"ddStateFilePath"`
 
+	// DDStateFlushIntervalSecs specifies how often the deduplicator
+	// state is stored to disk while the archiver is active (it is
+	// always stored on shutdown). Negative value disables the periodic
+	// storing.
+	DDStateFlushIntervalSecs int `json:"ddStateFlushIntervalSecs"`
+
+	// DDStateIdleThresholdSecs specifies how long the archiver must be
+	// without any processed items to be considered idle. Once idle,
+	// the deduplicator state is stored one last time and the periodic
+	// storing is paused until new items arrive.
+	DDStateIdleThresholdSecs int `json:"ddStateIdleThresholdSecs"`
+
+	// DDFalsePositiveWindow specifies how many recent deduplicator tests
+	// of not yet archived records are used to calculate the observed
+	// Bloom filter false positive ratio.
+	DDFalsePositiveWindow int `json:"ddFalsePositiveWindow"`
+
+	// DDFalsePositiveWarnRatio specifies the observed false positive
+	// ratio above which a warning is logged. Such a state means the
+	// filter is (over)saturated and it should be rebuilt or its
+	// capacity increased.
+	DDFalsePositiveWarnRatio float64 `json:"ddFalsePositiveWarnRatio"`
+
 	// CheckIntervalSecs specifies how often will Camus check for
 	// incoming conc/wlist/etc. records. This should be tuned
 	// along with CheckIntervalChunk so Camus keeps up with the
@@ -57,15 +89,62 @@ type Conf struct {
 	// avoid them to save disk space and make database more responsive.
 	PreloadLastNItems int `json:"preloadLastNItems"`
 
+	// RebuildLastNItems specifies how many recent records are loaded
+	// into a fresh deduplicator filter when rebuilding it (see
+	// Deduplicator.Rebuild). This removes stale IDs (e.g. of deleted
+	// records) from the filter and keeps its false positive rate low.
+	RebuildLastNItems int `json:"rebuildLastNItems"`
+
 	QueueKey         string `json:"queueKey"`
 	FailedQueueKey   string `json:"failedQueueKey"`
 	FailedRecordsKey string `json:"failedRecordsKey"`
+
+	// FailedQueueMaxLen specifies max. number of items kept in the failed
+	// queue. Older items are dropped (along with their stored data).
+	// Zero means no limit.
+	FailedQueueMaxLen int `json:"failedQueueMaxLen"`
+
+	// RecordProcTimeoutMs specifies max. time for processing a single
+	// queued record. Records exceeding the limit are moved to the failed
+	// queue so they do not block the rest of the processed chunk.
+	RecordProcTimeoutMs int `json:"recordProcTimeoutMs"`
+
+	// SlowWriteThresholdMs specifies a duration of archive write
+	// operations (insert, deduplication) above which a warning
+	// is logged. Negative value disables the logging.
+	SlowWriteThresholdMs int `json:"slowWriteThresholdMs"`
+
+	// MergeAuditNumRetain specifies how many recent merges of archive
+	// record variants (deduplication) are audited and kept in memory
+	// (see the `/merge-audits` endpoint). The audits are also logged.
+	// Zero (default) disables the audit.
+	MergeAuditNumRetain int `json:"mergeAuditNumRetain"`
 }
 
 func (conf *Conf) CheckInterval() time.Duration {
 	return time.Duration(conf.CheckIntervalSecs) * time.Second
 }
 
+// DDStateFlushInterval returns the interval of periodic storing
+// of the deduplicator state. Zero means the storing is disabled.
+func (conf *Conf) DDStateFlushInterval() time.Duration {
+	return time.Duration(max(conf.DDStateFlushIntervalSecs, 0)) * time.Second
+}
+
+func (conf *Conf) DDStateIdleThreshold() time.Duration {
+	return time.Duration(conf.DDStateIdleThresholdSecs) * time.Second
+}
+
+func (conf *Conf) RecordProcTimeout() time.Duration {
+	return time.Duration(conf.RecordProcTimeoutMs) * time.Millisecond
+}
+
+// SlowWriteThreshold returns the threshold for logging slow
+// archive writes. Zero means the logging is disabled.
+func (conf *Conf) SlowWriteThreshold() time.Duration {
+	return time.Duration(max(conf.SlowWriteThresholdMs, 0)) * time.Millisecond
+}
+
 func (conf *Conf) ValidateAndDefaults() error {
 	if conf == nil {
 		return fmt.Errorf("missing `archiver` section")
@@ -73,6 +152,39 @@ func (conf *Conf) ValidateAndDefaults() error {
 	if conf.DDStateFilePath == "" {
 		return fmt.Errorf("value `archiver.ddStateFilePath` missing")
 	}
+	if conf.DDStateFlushIntervalSecs == 0 {
+		conf.DDStateFlushIntervalSecs = dfltDDStateFlushIntervalSecs
+		log.Warn().
+			Int("value", conf.DDStateFlushIntervalSecs).
+			Msg("value `archiver.ddStateFlushIntervalSecs` not set, using default")
+	}
+	if conf.DDStateIdleThresholdSecs < 0 {
+		return fmt.Errorf("invalid value for `archiver.ddStateIdleThresholdSecs` (must be >= 0)")
+	}
+	if conf.DDFalsePositiveWindow < 0 {
+		return fmt.Errorf("invalid value for `archiver.ddFalsePositiveWindow` (must be >= 0)")
+	}
+	if conf.DDFalsePositiveWindow == 0 {
+		conf.DDFalsePositiveWindow = dfltDDFalsePositiveWindow
+		log.Warn().
+			Int("value", conf.DDFalsePositiveWindow).
+			Msg("value `archiver.ddFalsePositiveWindow` not set, using default")
+	}
+	if conf.DDFalsePositiveWarnRatio < 0 || conf.DDFalsePositiveWarnRatio > 1 {
+		return fmt.Errorf("invalid value for `archiver.ddFalsePositiveWarnRatio` (must be between 0 and 1)")
+	}
+	if conf.DDFalsePositiveWarnRatio == 0 {
+		conf.DDFalsePositiveWarnRatio = dfltDDFalsePositiveWarnRatio
+		log.Warn().
+			Float64("value", conf.DDFalsePositiveWarnRatio).
+			Msg("value `archiver.ddFalsePositiveWarnRatio` not set, using default")
+	}
+	if conf.DDStateIdleThresholdSecs == 0 {
+		conf.DDStateIdleThresholdSecs = dfltDDStateIdleThresholdSecs
+		log.Warn().
+			Int("value", conf.DDStateIdleThresholdSecs).
+			Msg("value `archiver.ddStateIdleThresholdSecs` not set, using default")
+	}
 
 	tmp, err := util.NearestPrime(conf.CheckIntervalSecs)
 	if err != nil {
@@ -92,6 +204,15 @@ func (conf *Conf) ValidateAndDefaults() error {
 			Int("value", conf.PreloadLastNItems).
 			Msg("value `archiver.preloadLastNItems` not set, using default")
 	}
+	if conf.RebuildLastNItems < 0 {
+		return fmt.Errorf("invalid value for `archiver.rebuildLastNItems` (must be >= 0)")
+	}
+	if conf.RebuildLastNItems == 0 {
+		conf.RebuildLastNItems = dfltRebuildLastNItems
+		log.Warn().
+			Int("value", conf.RebuildLastNItems).
+			Msg("value `archiver.rebuildLastNItems` not set, using default")
+	}
 
 	if conf.QueueKey == "" {
 		return fmt.Errorf("missing configuration: `archiver.queueKey`")
@@ -105,6 +226,27 @@ func (conf *Conf) ValidateAndDefaults() error {
 	if conf.FailedRecordsKey == "" {
 		return fmt.Errorf("missing configuration: `archiver.failedRecordsKey`")
 	}
+	if conf.FailedQueueMaxLen < 0 {
+		return fmt.Errorf("invalid value for `archiver.failedQueueMaxLen` (must be >= 0)")
+	}
+	if conf.RecordProcTimeoutMs < 0 {
+		return fmt.Errorf("invalid value for `archiver.recordProcTimeoutMs` (must be > 0)")
+	}
+	if conf.RecordProcTimeoutMs == 0 {
+		conf.RecordProcTimeoutMs = dfltRecordProcTimeoutMs
+		log.Warn().
+			Int("value", conf.RecordProcTimeoutMs).
+			Msg("value `archiver.recordProcTimeoutMs` not set, using default")
+	}
+	if conf.SlowWriteThresholdMs == 0 {
+		conf.SlowWriteThresholdMs = dfltSlowWriteThresholdMs
+		log.Warn().
+			Int("value", conf.SlowWriteThresholdMs).
+			Msg("value `archiver.slowWriteThresholdMs` not set, using default")
+	}
+	if conf.MergeAuditNumRetain < 0 {
+		return fmt.Errorf("invalid value for `archiver.mergeAuditNumRetain` (must be >= 0)")
+	}
 
 	return nil
 }
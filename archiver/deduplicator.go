@@ -18,6 +18,7 @@ package archiver
 
 import (
 	"camus/cncdb"
+	"context"
 	"fmt"
 	"os"
 	"sync"
@@ -39,9 +40,37 @@ type Deduplicator struct {
 	concDB        cncdb.IConcArchOps
 	tz            *time.Location
 	conf          *Conf
+	fpTracker     *fpTracker
+
+	// rebuildAdds contains IDs added while a rebuild is in progress
+	// so they can be replayed to the new filter (guarded by knownIDsMutex,
+	// nil if there is no rebuild in progress)
+	rebuildAdds []string
+
+	// rebuildMutex prevents concurrent rebuilds
+	rebuildMutex sync.Mutex
+
+	// storeMutex serializes writes of the state file
+	// (periodic flushing vs. explicit saves via API)
+	storeMutex sync.Mutex
+
+	// lastStored is the time of the last successful state save
+	// (guarded by storeMutex)
+	lastStored time.Time
+}
+
+// DedupStateInfo describes the deduplicator filter and its
+// persistent state
+type DedupStateInfo struct {
+	// NumKnownIDs is an approximate number of IDs stored in the filter
+	NumKnownIDs   uint32     `json:"numKnownIds"`
+	StateFilePath string     `json:"stateFilePath"`
+	LastStored    *time.Time `json:"lastStored"`
 }
 
 func (dd *Deduplicator) StoreToDisk() error {
+	dd.storeMutex.Lock()
+	defer dd.storeMutex.Unlock()
 	f, err := os.OpenFile(dd.conf.DDStateFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to store deduplicator state to disk: %w", err)
@@ -53,9 +82,25 @@ func (dd *Deduplicator) StoreToDisk() error {
 	if err != nil {
 		return fmt.Errorf("failed to store deduplicator state to disk: %w", err)
 	}
+	dd.lastStored = time.Now().In(dd.tz)
 	return nil
 }
 
+// StateInfo returns basic info about the filter and its stored state
+func (dd *Deduplicator) StateInfo() DedupStateInfo {
+	ans := DedupStateInfo{StateFilePath: dd.conf.DDStateFilePath}
+	dd.knownIDsMutex.RLock()
+	ans.NumKnownIDs = dd.knownIDs.ApproximatedSize()
+	dd.knownIDsMutex.RUnlock()
+	dd.storeMutex.Lock()
+	if !dd.lastStored.IsZero() {
+		t := dd.lastStored
+		ans.LastStored = &t
+	}
+	dd.storeMutex.Unlock()
+	return ans
+}
+
 func (dd *Deduplicator) OnClose() error {
 	return dd.StoreToDisk()
 }
@@ -79,6 +124,9 @@ func (dd *Deduplicator) Add(concID string) {
 	dd.knownIDsMutex.Lock()
 	defer dd.knownIDsMutex.Unlock()
 	dd.knownIDs.AddString(concID)
+	if dd.rebuildAdds != nil {
+		dd.rebuildAdds = append(dd.rebuildAdds, concID)
+	}
 }
 
 func (dd *Deduplicator) Reset() error {
@@ -92,6 +140,52 @@ func (dd *Deduplicator) Reset() error {
 	return nil
 }
 
+// Rebuild replaces the current filter with a new one containing
+// only IDs of the last N records (see Conf.RebuildLastNItems).
+// Unlike Reset, the current filter remains in use while the records
+// are loaded from the database. IDs added in the meantime are recorded
+// and replayed to the new filter. The new state is stored to disk.
+// The method returns number of IDs added to the new filter.
+func (dd *Deduplicator) Rebuild() (int, error) {
+	dd.rebuildMutex.Lock()
+	defer dd.rebuildMutex.Unlock()
+	log.Warn().Msg("performing deduplicator rebuild")
+	dd.knownIDsMutex.Lock()
+	dd.rebuildAdds = make([]string, 0, 100)
+	dd.knownIDsMutex.Unlock()
+	items, err := dd.concDB.LoadRecentNRecords(dd.conf.RebuildLastNItems)
+	if err != nil {
+		dd.knownIDsMutex.Lock()
+		dd.rebuildAdds = nil
+		dd.knownIDsMutex.Unlock()
+		return 0, fmt.Errorf("failed to rebuild deduplicator: %w", err)
+	}
+	filter := bloom.NewWithEstimates(bloomFilterNumBits, bloomFilterProbCollision)
+	for _, item := range items {
+		filter.AddString(item.ID)
+	}
+	dd.knownIDsMutex.Lock()
+	for _, id := range dd.rebuildAdds {
+		filter.AddString(id)
+	}
+	numReplayed := len(dd.rebuildAdds)
+	dd.rebuildAdds = nil
+	dd.knownIDs = filter
+	dd.knownIDsMutex.Unlock()
+	if numReplayed > 0 {
+		log.Debug().
+			Int("numItems", numReplayed).
+			Msg("replayed IDs added during deduplicator rebuild")
+	}
+	if err := dd.StoreToDisk(); err != nil {
+		return len(items), fmt.Errorf("failed to rebuild deduplicator: %w", err)
+	}
+	log.Info().
+		Int("numItems", len(items)).
+		Msg("rebuilt deduplicator filter")
+	return len(items), nil
+}
+
 func (dd *Deduplicator) preloadLastNItems() error {
 	items, err := dd.concDB.LoadRecentNRecords(dd.conf.PreloadLastNItems)
 	if err != nil {
@@ -118,11 +212,13 @@ func (dd *Deduplicator) TestRecord(concID string) bool {
 // The "recently used" means that we keep track of recently stored IDs and test
 // for them only. I.e. we do not perform full search in query persistence db
 // for each and every concID we want to store.
-func (dd *Deduplicator) TestAndSolve(newRec cncdb.ArchRecord) (bool, error) {
+// All the database operations are bound to the provided context.
+func (dd *Deduplicator) TestAndSolve(ctx context.Context, newRec cncdb.ArchRecord) (bool, error) {
 	if !dd.TestRecord(newRec.ID) {
+		dd.observeFalsePositive(false)
 		return false, nil
 	}
-	recs, err := dd.concDB.LoadRecordsByID(newRec.ID)
+	recs, err := dd.concDB.LoadRecordsByIDCtx(ctx, newRec.ID)
 	if err != nil {
 		return false, fmt.Errorf("failed to deduplicate id %s: %w", newRec.ID, err)
 	}
@@ -130,6 +226,7 @@ func (dd *Deduplicator) TestAndSolve(newRec cncdb.ArchRecord) (bool, error) {
 		log.Warn().
 			Str("concId", newRec.ID).
 			Msg("possible Bloom filter false positive")
+		dd.observeFalsePositive(true)
 		return false, nil
 	}
 	log.Debug().
@@ -163,10 +260,33 @@ func (dd *Deduplicator) TestAndSolve(newRec cncdb.ArchRecord) (bool, error) {
 				Msg("Conc. persistence consistency error")
 		}
 	}
-	_, err = dd.concDB.DeduplicateInArchive(queryTest[bestRecKey], newRec)
+	_, err = dd.concDB.DeduplicateInArchiveCtx(ctx, queryTest[bestRecKey], newRec)
 	return true, err
 }
 
+func (dd *Deduplicator) observeFalsePositive(isFalsePositive bool) {
+	if dd.fpTracker.observe(isFalsePositive) {
+		if ratio := dd.fpTracker.ratio(); ratio > dd.conf.DDFalsePositiveWarnRatio {
+			log.Warn().
+				Float64("ratio", ratio).
+				Float64("threshold", dd.conf.DDFalsePositiveWarnRatio).
+				Msg("observed Bloom filter false positive ratio too high, consider a rebuild or increasing capacity")
+		}
+	}
+}
+
+// FalsePositiveRatio returns the Bloom filter false positive
+// ratio observed over the recent tests (see Conf.DDFalsePositiveWindow)
+func (dd *Deduplicator) FalsePositiveRatio() float64 {
+	return dd.fpTracker.ratio()
+}
+
+// PopNumFalsePositives returns number of false positives observed
+// since the last call of the method.
+func (dd *Deduplicator) PopNumFalsePositives() int {
+	return dd.fpTracker.popNumFalsePositives()
+}
+
 func NewDeduplicator(
 	concDB cncdb.IConcArchOps, conf *Conf, loc *time.Location) (*Deduplicator, error) {
 	filter := bloom.NewWithEstimates(bloomFilterNumBits, bloomFilterProbCollision)
@@ -176,6 +296,7 @@ func NewDeduplicator(
 		concDB:        concDB,
 		conf:          conf,
 		knownIDsMutex: &sync.RWMutex{},
+		fpTracker:     newFPTracker(conf.DDFalsePositiveWindow),
 	}
 	isf, err := fs.IsFile(conf.DDStateFilePath)
 	if err != nil {
@@ -0,0 +1,55 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archiver
+
+import (
+	"camus/cncdb"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// concurrentAddConcArch simulates records archived while
+// the deduplicator rebuild loads recent records
+type concurrentAddConcArch struct {
+	cncdb.IConcArchOps
+	dedup *Deduplicator
+}
+
+func (db *concurrentAddConcArch) LoadRecentNRecords(num int) ([]cncdb.ArchRecord, error) {
+	db.dedup.Add("added-during-rebuild")
+	return []cncdb.ArchRecord{{ID: "recent1"}, {ID: "recent2"}}, nil
+}
+
+func TestRebuildKeepsConcurrentAdds(t *testing.T) {
+	db := &concurrentAddConcArch{}
+	dedup := newTestArchKeeper(db).dedup
+	dedup.conf.DDStateFilePath = filepath.Join(t.TempDir(), "dedup.state")
+	dedup.conf.RebuildLastNItems = 10
+	db.dedup = dedup
+	dedup.Add("old")
+
+	numItems, err := dedup.Rebuild()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, numItems)
+	assert.True(t, dedup.TestRecord("recent1"))
+	assert.True(t, dedup.TestRecord("recent2"))
+	assert.True(t, dedup.TestRecord("added-during-rebuild"))
+	assert.False(t, dedup.TestRecord("old"))
+	assert.Nil(t, dedup.rebuildAdds)
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archiver
+
+import (
+	"sync"
+)
+
+// fpTracker observes Bloom filter false positives over a window
+// of the last N tested records which turned out not to be archived
+// (i.e. true negatives and false positives).
+type fpTracker struct {
+	window []bool
+	next   int
+	full   bool
+
+	// numFP is the number of false positives within the window
+	numFP int
+
+	// numObservedFP counts all the false positives since
+	// the last call of popNumFalsePositives
+	numObservedFP int
+
+	// numSinceWarn counts observations since the last
+	// threshold check so we do not log with each observation
+	numSinceWarn int
+
+	mu sync.Mutex
+}
+
+// observe adds a new observation and returns true if the window
+// has just been filled (again) so its ratio should be checked
+func (fpt *fpTracker) observe(isFalsePositive bool) bool {
+	fpt.mu.Lock()
+	defer fpt.mu.Unlock()
+	if fpt.window[fpt.next] {
+		fpt.numFP--
+	}
+	fpt.window[fpt.next] = isFalsePositive
+	if isFalsePositive {
+		fpt.numFP++
+		fpt.numObservedFP++
+	}
+	fpt.next = (fpt.next + 1) % len(fpt.window)
+	if fpt.next == 0 {
+		fpt.full = true
+	}
+	fpt.numSinceWarn++
+	if fpt.full && fpt.numSinceWarn >= len(fpt.window) {
+		fpt.numSinceWarn = 0
+		return true
+	}
+	return false
+}
+
+// ratio returns the observed false positive ratio within the window
+func (fpt *fpTracker) ratio() float64 {
+	fpt.mu.Lock()
+	defer fpt.mu.Unlock()
+	size := fpt.next
+	if fpt.full {
+		size = len(fpt.window)
+	}
+	if size == 0 {
+		return 0
+	}
+	return float64(fpt.numFP) / float64(size)
+}
+
+func (fpt *fpTracker) popNumFalsePositives() int {
+	fpt.mu.Lock()
+	defer fpt.mu.Unlock()
+	ans := fpt.numObservedFP
+	fpt.numObservedFP = 0
+	return ans
+}
+
+func newFPTracker(windowSize int) *fpTracker {
+	return &fpTracker{window: make([]bool, max(windowSize, 1))}
+}
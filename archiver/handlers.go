@@ -0,0 +1,78 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archiver
+
+import (
+	"camus/cncdb"
+	"context"
+	"fmt"
+)
+
+// recordTypeHandler processes a single queued item of a specific type
+// along with its record data loaded from Redis. Results (stats, records
+// to be indexed) are written to ans. All the database operations should
+// be bound to ctx which limits the processing time of the item.
+type recordTypeHandler func(
+	ctx context.Context, job *ArchKeeper, rec cncdb.ArchRecord, item queueRecord, ans *itemResult)
+
+// recordTypeRegistry maps queue record types to their handlers.
+// An empty type is handled the same way as QRTypeArchive
+// to support items pushed by older clients.
+type recordTypeRegistry map[QueueRecordType]recordTypeHandler
+
+func (reg recordTypeRegistry) register(tp QueueRecordType, handler recordTypeHandler) {
+	if _, ok := reg[tp]; ok {
+		panic(fmt.Sprintf("queue record type `%s` already registered", tp))
+	}
+	reg[tp] = handler
+}
+
+func (reg recordTypeRegistry) get(tp QueueRecordType) (recordTypeHandler, bool) {
+	if tp == "" {
+		tp = QRTypeArchive
+	}
+	h, ok := reg[tp]
+	return h, ok
+}
+
+func handleArchiveItem(
+	ctx context.Context, job *ArchKeeper, rec cncdb.ArchRecord, item queueRecord, ans *itemResult) {
+	if item.Explicit {
+		job.handleExplicitReq(ctx, rec, item, ans)
+
+	} else {
+		job.handleImplicitReq(ctx, rec, item, ans)
+	}
+}
+
+func handleHistoryItem(
+	ctx context.Context, job *ArchKeeper, rec cncdb.ArchRecord, item queueRecord, ans *itemResult) {
+	ans.histRec = &cncdb.HistoryRecord{
+		QueryID: item.Key,
+		UserID:  item.UserID,
+		Created: item.Created,
+		Name:    item.Name,
+		Rec:     &rec,
+	}
+}
+
+func newRecordTypeRegistry() recordTypeRegistry {
+	ans := make(recordTypeRegistry)
+	ans.register(QRTypeArchive, handleArchiveItem)
+	ans.register(QRTypeHistory, handleHistoryItem)
+	return ans
+}
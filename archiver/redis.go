@@ -23,10 +23,25 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// sAddCappedScript adds ARGV[1] to the set KEYS[1] unless the set
+// already has ARGV[2] (or more) members. It returns 1 if the member
+// is stored in the set, 0 otherwise.
+var sAddCappedScript = redis.NewScript(`
+if redis.call("SISMEMBER", KEYS[1], ARGV[1]) == 1 then
+	return 1
+end
+if redis.call("SCARD", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("SADD", KEYS[1], ARGV[1])
+return 1
+`)
+
 type QueueRecordType string
 
 const (
@@ -45,6 +60,10 @@ type queueRecord struct {
 	UserID  int    `json:"user_id"`
 	Created int64  `json:"created"`
 	Name    string `json:"name"`
+
+	// ErrorReason is set for items moved to the failed queue
+	// in case there is a reason worth explaining
+	ErrorReason string `json:"error_reason,omitempty"`
 }
 
 func (qr queueRecord) IsArchive() bool {
@@ -64,23 +83,31 @@ func (qr queueRecord) KeyCode() string {
 
 type RedisAdapter struct {
 	conf  *RedisConf
-	redis *redis.Client
+	redis redis.UniversalClient
 	ctx   context.Context
 }
 
 func (rd *RedisAdapter) String() string {
 	if rd.redis == nil {
 		return fmt.Sprintf(
-			"RedisAdapter (inactive), address %s:%d, db %d",
-			rd.conf.Host, rd.conf.Port, rd.conf.DB,
+			"RedisAdapter (inactive), mode %s, address %s, db %d",
+			rd.conf.Mode, rd.conf.Address(), rd.conf.DB,
 		)
 	}
 	return fmt.Sprintf(
-		"RedisAdapter (active) address %s:%d, db %d",
-		rd.conf.Host, rd.conf.Port, rd.conf.DB,
+		"RedisAdapter (active), mode %s, address %s, db %d",
+		rd.conf.Mode, rd.conf.Address(), rd.conf.DB,
 	)
 }
 
+// Ping tests whether the Redis server is reachable
+func (rd *RedisAdapter) Ping(ctx context.Context) error {
+	if err := rd.redis.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to ping Redis: %w", err)
+	}
+	return nil
+}
+
 func (rd *RedisAdapter) Type(k string) (string, error) {
 	cmd := rd.redis.Type(rd.ctx, k)
 	if cmd.Err() != nil {
@@ -108,6 +135,33 @@ func (rd *RedisAdapter) Set(k string, v any) error {
 	return nil
 }
 
+// SetWithTTL sets a key which expires after ttl. Zero ttl means no expiration.
+func (rd *RedisAdapter) SetWithTTL(k string, v any, ttl time.Duration) error {
+	cmd := rd.redis.Set(rd.ctx, k, v, ttl)
+	if cmd.Err() != nil {
+		return fmt.Errorf("failed to set Redis item %s: %w", k, cmd.Err())
+	}
+	return nil
+}
+
+// Expire sets expiration of an existing key. Zero ttl is ignored.
+func (rd *RedisAdapter) Expire(key string, ttl time.Duration) error {
+	if ttl == 0 {
+		return nil
+	}
+	if err := rd.redis.Expire(rd.ctx, key, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set expiration of %s: %w", key, err)
+	}
+	return nil
+}
+
+func (rd *RedisAdapter) Delete(key string) error {
+	if err := rd.redis.Del(rd.ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to delete key %s: %w", key, err)
+	}
+	return nil
+}
+
 func (rd *RedisAdapter) Exists(key string) (bool, error) {
 	cmd := rd.redis.Exists(rd.ctx, key)
 	if cmd.Err() != nil {
@@ -133,32 +187,117 @@ func (rd *RedisAdapter) ZCard(key string) (int, error) {
 	return int(cmd.Val()), cmd.Err()
 }
 
-// IntZRemLowest removes and returns an element with lowest score from ZSET
-// returns true if the record was found and removed, otherwise false
-// (i.e. not finding the record is not an error)
+// SAddCapped adds a member to a set unless the set already contains
+// maxSize (or more) members. The returned bool specifies whether
+// the member is stored in the set (i.e. it was either added or
+// it had been present before).
+// The operation is performed atomically by a Lua script so concurrent
+// callers cannot exceed the limit.
+func (rd *RedisAdapter) SAddCapped(key, member string, maxSize int) (bool, error) {
+	stored, err := sAddCappedScript.Run(rd.ctx, rd.redis, []string{key}, member, maxSize).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to add set member: %w", err)
+	}
+	return stored == 1, nil
+}
+
+func (rd *RedisAdapter) SMembers(key string) ([]string, error) {
+	ans, err := rd.redis.SMembers(rd.ctx, key).Result()
+	if err != nil {
+		return []string{}, fmt.Errorf("failed to get set members: %w", err)
+	}
+	return ans, nil
+}
+
+func (rd *RedisAdapter) SRem(key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	args := make([]any, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	if err := rd.redis.SRem(rd.ctx, key, args...).Err(); err != nil {
+		return fmt.Errorf("failed to remove set members: %w", err)
+	}
+	return nil
+}
+
+func (rd *RedisAdapter) SAdd(key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	args := make([]any, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	if err := rd.redis.SAdd(rd.ctx, key, args...).Err(); err != nil {
+		return fmt.Errorf("failed to add set members: %w", err)
+	}
+	return nil
+}
+
+// HSetNXInt sets a hash field to a provided value unless
+// the field already exists.
+func (rd *RedisAdapter) HSetNXInt(key, field string, v int) error {
+	if err := rd.redis.HSetNX(rd.ctx, key, field, v).Err(); err != nil {
+		return fmt.Errorf("failed to set hash field: %w", err)
+	}
+	return nil
+}
+
+// HIncrBy increments a (numeric) hash field and returns
+// the new value.
+func (rd *RedisAdapter) HIncrBy(key, field string, incr int) (int, error) {
+	ans, err := rd.redis.HIncrBy(rd.ctx, key, field, int64(incr)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment hash field: %w", err)
+	}
+	return int(ans), nil
+}
+
+func (rd *RedisAdapter) HKeys(key string) ([]string, error) {
+	ans, err := rd.redis.HKeys(rd.ctx, key).Result()
+	if err != nil {
+		return []string{}, fmt.Errorf("failed to get hash keys: %w", err)
+	}
+	return ans, nil
+}
+
+func (rd *RedisAdapter) HDel(key string, fields ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	if err := rd.redis.HDel(rd.ctx, key, fields...).Err(); err != nil {
+		return fmt.Errorf("failed to remove hash fields: %w", err)
+	}
+	return nil
+}
+
+// UintZRemLowest removes and returns an element with lowest score from ZSET.
+// In case the set is empty (or missing), -1 is returned (i.e. not finding
+// the record is not an error). The operation is atomic so it can be safely
+// called concurrently.
 func (rd *RedisAdapter) UintZRemLowest(key string) (int, error) {
-	cmd := rd.redis.ZRange(rd.ctx, key, 0, 0)
-	if cmd.Err() == redis.Nil {
+	items, err := rd.redis.ZPopMin(rd.ctx, key, 1).Result()
+	if err == redis.Nil {
 		return -1, nil
 
-	} else if cmd.Err() != nil {
-		return -1, cmd.Err()
+	} else if err != nil {
+		return -1, fmt.Errorf("UintZRemLowest failed: %w", err)
 	}
-	if len(cmd.Val()) == 0 {
+	if len(items) == 0 {
 		return -1, nil
-
-	} else if len(cmd.Val()) > 1 {
-		return -1, fmt.Errorf("IntZRemLowest failed - more than one matching item")
 	}
-	vToRem, err := strconv.Atoi(cmd.Val()[0])
-	if err != nil {
-		return 0, fmt.Errorf("IntZRemLowest failed - item is not an integer")
+	member, ok := items[0].Member.(string)
+	if !ok {
+		return -1, fmt.Errorf("UintZRemLowest failed - unexpected item type")
 	}
-	cmd2 := rd.redis.ZRem(rd.ctx, key, vToRem)
-	if cmd2.Err() != nil {
-		err = fmt.Errorf("IntZRemLowest failed: %w", cmd2.Err())
+	ans, err := strconv.Atoi(member)
+	if err != nil {
+		return -1, fmt.Errorf("UintZRemLowest failed - item is not an integer")
 	}
-	return vToRem, err
+	return ans, nil
 }
 
 // ChannelSubscribe subscribe to a Redis channel with a specified name.
@@ -192,22 +331,70 @@ func (rd *RedisAdapter) NextNArchItems(queueKey string, n int64) ([]queueRecord,
 		return []queueRecord{}, fmt.Errorf("failed to get items from queue: %w", err)
 	}
 	for i := len(items) - 1; i >= 0; i-- {
-		if strings.Contains(items[i], `"key"`) {
-			var v queueRecord
-			err := json.Unmarshal([]byte(items[i]), &v)
-			if err != nil {
-				return []queueRecord{}, fmt.Errorf("failed to decode queue item `%s`: %w", items[i], err)
-			}
-			ans = append(ans, v)
-
-		} else {
-			ans = append(ans, queueRecord{Key: items[i]})
+		v, err := decodeQueueItem(items[i])
+		if err != nil {
+			return []queueRecord{}, err
+		}
+		ans = append(ans, v)
+	}
+	return ans, nil
+}
+
+// PeekQueue returns (up to) n items which are about to be processed
+// next (see NextNArchItems) without removing them from the queue.
+func (rd *RedisAdapter) PeekQueue(queueKey string, n int64) ([]queueRecord, error) {
+	items, err := rd.redis.LRange(rd.ctx, queueKey, -n, -1).Result()
+	if err != nil {
+		return []queueRecord{}, fmt.Errorf("failed to peek queue items: %w", err)
+	}
+	ans := make([]queueRecord, 0, len(items))
+	for i := len(items) - 1; i >= 0; i-- {
+		v, err := decodeQueueItem(items[i])
+		if err != nil {
+			return []queueRecord{}, err
 		}
+		ans = append(ans, v)
 	}
 	return ans, nil
 }
 
-func (rd *RedisAdapter) AddError(errQueue string, item queueRecord, rec *cncdb.ArchRecord) error {
+// PushQueueItems appends (RPUSH) items to the queue. As items are
+// taken from the end of the queue, they will be processed first.
+func (rd *RedisAdapter) PushQueueItems(queueKey string, items []queueRecord) error {
+	values := make([]any, len(items))
+	for i, item := range items {
+		itemJSON, err := json.Marshal(item)
+		if err != nil {
+			return fmt.Errorf("failed to push items to queue: %w", err)
+		}
+		values[i] = string(itemJSON)
+	}
+	if err := rd.redis.RPush(rd.ctx, queueKey, values...).Err(); err != nil {
+		return fmt.Errorf("failed to push items to queue: %w", err)
+	}
+	return nil
+}
+
+// decodeQueueItem decodes a raw queue entry. Both JSON-encoded
+// records and legacy plain string keys are supported.
+func decodeQueueItem(item string) (queueRecord, error) {
+	if strings.Contains(item, `"key"`) {
+		var v queueRecord
+		if err := json.Unmarshal([]byte(item), &v); err != nil {
+			return queueRecord{}, fmt.Errorf("failed to decode queue item `%s`: %w", item, err)
+		}
+		return v, nil
+	}
+	return queueRecord{Key: item}, nil
+}
+
+// AddError pushes a failed queue item to the errQueue list. If rec is provided,
+// its data are stored to the errRecordsKey hash (using item's key as the field).
+func (rd *RedisAdapter) AddError(
+	errQueue, errRecordsKey string,
+	item queueRecord,
+	rec *cncdb.ArchRecord,
+) error {
 	itemJSON, err := json.Marshal(item)
 	if err != nil {
 		return fmt.Errorf("failed to add error record %s: %w", item.Key, err)
@@ -217,7 +404,7 @@ func (rd *RedisAdapter) AddError(errQueue string, item queueRecord, rec *cncdb.A
 		return fmt.Errorf("failed to insert error key %s: %w", item.Key, cmd.Err())
 	}
 	if rec != nil {
-		cmd = rd.redis.HSet(rd.ctx, errQueue, item.Key, rec.Data)
+		cmd = rd.redis.HSet(rd.ctx, errRecordsKey, item.Key, rec.Data)
 		if cmd.Err() != nil {
 			return fmt.Errorf("failed to insert error record %s: %w", item.Key, cmd.Err())
 		}
@@ -225,6 +412,106 @@ func (rd *RedisAdapter) AddError(errQueue string, item queueRecord, rec *cncdb.A
 	return nil
 }
 
+// ListErrors returns n most recent items from the errQueue list
+// (without removing them).
+func (rd *RedisAdapter) ListErrors(errQueue string, n int) ([]queueRecord, error) {
+	items, err := rd.redis.LRange(rd.ctx, errQueue, 0, int64(n)-1).Result()
+	if err != nil {
+		return []queueRecord{}, fmt.Errorf("failed to list error queue items: %w", err)
+	}
+	ans := make([]queueRecord, 0, len(items))
+	for _, v := range items {
+		item, err := decodeQueueItem(v)
+		if err != nil {
+			return []queueRecord{}, fmt.Errorf("failed to list error queue items: %w", err)
+		}
+		ans = append(ans, item)
+	}
+	return ans, nil
+}
+
+// GetErrorsData returns stored data of failed records identified by keys.
+// Keys without stored data are not present in the result.
+func (rd *RedisAdapter) GetErrorsData(errRecordsKey string, keys []string) (map[string]string, error) {
+	ans := make(map[string]string)
+	if len(keys) == 0 {
+		return ans, nil
+	}
+	vals, err := rd.redis.HMGet(rd.ctx, errRecordsKey, keys...).Result()
+	if err != nil {
+		return ans, fmt.Errorf("failed to get error records data: %w", err)
+	}
+	for i, v := range vals {
+		if tv, ok := v.(string); ok {
+			ans[keys[i]] = tv
+		}
+	}
+	return ans, nil
+}
+
+// trimmedErrorKeys returns record keys of the dropped error queue items
+// which are not referred to by any of the kept items (multiple items
+// may share a single key, e.g. after repeated failures of the same
+// record).
+func trimmedErrorKeys(dropped, kept []string) ([]string, error) {
+	keptKeys := make(map[string]bool)
+	for _, v := range kept {
+		item, err := decodeQueueItem(v)
+		if err != nil {
+			return []string{}, err
+		}
+		keptKeys[item.Key] = true
+	}
+	ans := make([]string, 0, len(dropped))
+	for _, v := range dropped {
+		item, err := decodeQueueItem(v)
+		if err != nil {
+			return []string{}, err
+		}
+		if !keptKeys[item.Key] {
+			keptKeys[item.Key] = true // prevents duplicates in ans
+			ans = append(ans, item.Key)
+		}
+	}
+	return ans, nil
+}
+
+// TrimErrors caps the errQueue list to maxLen items by removing the oldest
+// ones. Data of the removed items are also deleted from the errRecordsKey hash
+// unless they are still referred to by some of the kept items.
+// The method returns number of removed items.
+func (rd *RedisAdapter) TrimErrors(errQueue, errRecordsKey string, maxLen int64) (int, error) {
+	ppl := rd.redis.TxPipeline()
+	keptCmd := ppl.LRange(rd.ctx, errQueue, 0, maxLen-1)
+	lrangeCmd := ppl.LRange(rd.ctx, errQueue, maxLen, -1)
+	ppl.LTrim(rd.ctx, errQueue, 0, maxLen-1)
+	if _, err := ppl.Exec(rd.ctx); err != nil {
+		return 0, fmt.Errorf("failed to trim error queue: %w", err)
+	}
+	dropped, err := lrangeCmd.Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to trim error queue: %w", err)
+	}
+	if len(dropped) == 0 {
+		return 0, nil
+	}
+	kept, err := keptCmd.Result()
+	if err != nil {
+		return len(dropped), fmt.Errorf("failed to trim error queue: %w", err)
+	}
+	keys, err := trimmedErrorKeys(dropped, kept)
+	if err != nil {
+		return len(dropped), fmt.Errorf("failed to trim error queue: %w", err)
+	}
+	if len(keys) == 0 {
+		return len(dropped), nil
+	}
+	if err := rd.redis.HDel(rd.ctx, errRecordsKey, keys...).Err(); err != nil {
+		return len(dropped), fmt.Errorf("failed to remove trimmed error records: %w", err)
+	}
+	return len(dropped), nil
+}
+
 func (rd *RedisAdapter) mkKey(id string) string {
 	return fmt.Sprintf("concordance:%s", id)
 }
@@ -233,7 +520,13 @@ func (rd *RedisAdapter) mkKey(id string) string {
 // with a specified ID. In case no such record is found, ErrRecordNotFound
 // is returned.
 func (rd *RedisAdapter) GetConcRecord(id string) (cncdb.ArchRecord, error) {
-	ans := rd.redis.Get(rd.ctx, rd.mkKey(id))
+	return rd.GetConcRecordCtx(rd.ctx, id)
+}
+
+// GetConcRecordCtx is a variant of GetConcRecord with a custom context
+// (e.g. to apply a timeout).
+func (rd *RedisAdapter) GetConcRecordCtx(ctx context.Context, id string) (cncdb.ArchRecord, error) {
+	ans := rd.redis.Get(ctx, rd.mkKey(id))
 	if ans.Err() == redis.Nil {
 		return cncdb.ArchRecord{}, cncdb.ErrRecordNotFound
 	}
@@ -246,15 +539,34 @@ func (rd *RedisAdapter) GetConcRecord(id string) (cncdb.ArchRecord, error) {
 	}, nil
 }
 
-func NewRedisAdapter(ctx context.Context, conf *RedisConf) *RedisAdapter {
-	ans := &RedisAdapter{
-		conf: conf,
-		redis: redis.NewClient(&redis.Options{
+func newRedisClient(conf *RedisConf) redis.UniversalClient {
+	switch conf.Mode {
+	case RedisModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    conf.MasterName,
+			SentinelAddrs: conf.Addrs,
+			Password:      conf.Password,
+			DB:            conf.DB,
+		})
+	case RedisModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    conf.Addrs,
+			Password: conf.Password,
+		})
+	default:
+		return redis.NewClient(&redis.Options{
 			Addr:     fmt.Sprintf("%s:%d", conf.Host, conf.Port),
 			Password: conf.Password,
 			DB:       conf.DB,
-		}),
-		ctx: ctx,
+		})
+	}
+}
+
+func NewRedisAdapter(ctx context.Context, conf *RedisConf) *RedisAdapter {
+	ans := &RedisAdapter{
+		conf:  conf,
+		redis: newRedisClient(conf),
+		ctx:   ctx,
 	}
 	return ans
 }
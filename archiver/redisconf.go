@@ -18,18 +18,73 @@ package archiver
 
 import (
 	"fmt"
+	"strings"
+)
+
+const (
+	RedisModeSingle   = "single"
+	RedisModeSentinel = "sentinel"
+	RedisModeCluster  = "cluster"
 )
 
 type RedisConf struct {
+	// Mode specifies how we connect to Redis
+	// (`single` (default), `sentinel`, `cluster`)
+	Mode     string `json:"mode"`
 	Host     string `json:"host"`
 	Port     int    `json:"port"`
 	DB       int    `json:"db"`
 	Password string `json:"password"`
+
+	// Addrs is a list of `host:port` addresses of Sentinel
+	// nodes (mode `sentinel`) or cluster nodes (mode `cluster`)
+	Addrs []string `json:"addrs"`
+
+	// MasterName is the name of the master monitored by
+	// Sentinel (mode `sentinel` only)
+	MasterName string `json:"masterName"`
 }
 
 func (conf *RedisConf) ValidateAndDefaults() error {
-	if conf.DB == 0 {
-		return fmt.Errorf("missing Redis configuration: `db`")
+	if conf == nil {
+		return fmt.Errorf("missing `redis` section")
+	}
+	if conf.Mode == "" {
+		conf.Mode = RedisModeSingle
+	}
+	switch conf.Mode {
+	case RedisModeSingle:
+		if conf.DB == 0 {
+			return fmt.Errorf("missing Redis configuration: `db`")
+		}
+	case RedisModeSentinel:
+		if conf.DB == 0 {
+			return fmt.Errorf("missing Redis configuration: `db`")
+		}
+		if len(conf.Addrs) == 0 {
+			return fmt.Errorf("missing Redis configuration: `addrs` (required in mode `%s`)", conf.Mode)
+		}
+		if conf.MasterName == "" {
+			return fmt.Errorf("missing Redis configuration: `masterName` (required in mode `%s`)", conf.Mode)
+		}
+	case RedisModeCluster:
+		if len(conf.Addrs) == 0 {
+			return fmt.Errorf("missing Redis configuration: `addrs` (required in mode `%s`)", conf.Mode)
+		}
+		if conf.DB != 0 {
+			return fmt.Errorf("invalid Redis configuration: `db` is not supported in mode `%s`", conf.Mode)
+		}
+	default:
+		return fmt.Errorf("invalid Redis configuration: unknown mode `%s`", conf.Mode)
 	}
 	return nil
 }
+
+// Address returns a human readable description of
+// the Redis server address(es).
+func (conf *RedisConf) Address() string {
+	if conf.Mode == RedisModeSentinel || conf.Mode == RedisModeCluster {
+		return strings.Join(conf.Addrs, ", ")
+	}
+	return fmt.Sprintf("%s:%d", conf.Host, conf.Port)
+}
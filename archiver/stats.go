@@ -24,7 +24,11 @@ import (
 )
 
 const (
-	yearStatsCacheKey = "camus_years_stats"
+	yearStatsCacheKey        = "camus_years_stats"
+	dailyStatsCacheKeyPrefix = "camus_daily_stats"
+	dailyStatsCacheTTL       = 5 * time.Minute
+	totalRecordsCacheKey     = "camus_total_records"
+	totalRecordsCacheTTL     = time.Minute
 )
 
 type CountPerYear struct {
@@ -75,3 +79,100 @@ func (job *ArchKeeper) YearsStats(forceReload bool) (YearsStats, error) {
 	}
 	return ans, nil
 }
+
+// ------
+
+// TotalRecords is a (possibly approximate) total number
+// of archived records
+type TotalRecords struct {
+	Count         int64     `json:"count"`
+	IsApproximate bool      `json:"isApproximate"`
+	LastUpdate    time.Time `json:"lastUpdate"`
+}
+
+// TotalRecords returns the total number of archived records. To keep
+// the call cheap, the value is estimated from table statistics (see
+// cncdb.IConcArchOps.EstimateRecordCount) and cached for a short time.
+func (job *ArchKeeper) TotalRecords() (TotalRecords, error) {
+	var ans TotalRecords
+	cached, err := job.redis.Get(totalRecordsCacheKey)
+	if err != nil {
+		return ans, fmt.Errorf("failed to get cached total records: %w", err)
+	}
+	if cached != "" {
+		if err := json.Unmarshal([]byte(cached), &ans); err != nil {
+			return ans, fmt.Errorf("failed to unmarshal total records from cache: %w", err)
+		}
+		return ans, nil
+	}
+	ans.Count, err = job.dbArch.EstimateRecordCount()
+	if err != nil {
+		return ans, fmt.Errorf("failed to load total records from db: %w", err)
+	}
+	ans.IsApproximate = true
+	ans.LastUpdate = time.Now().In(job.tz)
+	jsonData, err := json.Marshal(ans)
+	if err != nil {
+		return ans, fmt.Errorf("failed to marshal total records: %w", err)
+	}
+	if err := job.redis.SetWithTTL(totalRecordsCacheKey, jsonData, totalRecordsCacheTTL); err != nil {
+		return ans, fmt.Errorf("failed to store total records to cache: %w", err)
+	}
+	return ans, nil
+}
+
+// ------
+
+type DailyStats struct {
+	Days       []cncdb.DayCount `json:"days"`
+	LastUpdate time.Time        `json:"lastUpdate"`
+}
+
+// fillDayGaps creates a dense series of days within [from, to)
+// with days missing in data set to zero
+func fillDayGaps(from, to time.Time, data []cncdb.DayCount) []cncdb.DayCount {
+	counts := make(map[string]int)
+	for _, item := range data {
+		counts[item.Date] = item.Count
+	}
+	ans := make([]cncdb.DayCount, 0, len(data))
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		ans = append(ans, cncdb.DayCount{Date: date, Count: counts[date]})
+	}
+	return ans
+}
+
+// DailyStats returns numbers of archived records per day within
+// [from, to). Both values are expected to be at midnight. Days with
+// no records are included (with zero count). Results are cached
+// in Redis for a short time.
+func (job *ArchKeeper) DailyStats(from, to time.Time) (DailyStats, error) {
+	var ans DailyStats
+	cacheKey := fmt.Sprintf(
+		"%s:%s:%s", dailyStatsCacheKeyPrefix, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	cached, err := job.redis.Get(cacheKey)
+	if err != nil {
+		return ans, fmt.Errorf("failed to get cached daily stats: %w", err)
+	}
+	if cached != "" {
+		if err := json.Unmarshal([]byte(cached), &ans); err != nil {
+			return ans, fmt.Errorf("failed to unmarshal daily stats from cache: %w", err)
+		}
+		return ans, nil
+	}
+	data, err := job.dbArch.GetArchCountsByDay(from, to)
+	if err != nil {
+		return ans, fmt.Errorf("failed to load daily stats from db: %w", err)
+	}
+	ans.LastUpdate = time.Now().In(job.tz)
+	ans.Days = fillDayGaps(from, to, data)
+	jsonData, err := json.Marshal(ans)
+	if err != nil {
+		return ans, fmt.Errorf("failed to marshal daily stats data: %w", err)
+	}
+	if err := job.redis.SetWithTTL(cacheKey, jsonData, dailyStatsCacheTTL); err != nil {
+		return ans, fmt.Errorf("failed to store daily stats to cache: %w", err)
+	}
+	return ans, nil
+}
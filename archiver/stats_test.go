@@ -0,0 +1,66 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archiver
+
+import (
+	"camus/cncdb"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFillDayGaps(t *testing.T) {
+	from := time.Date(2024, 2, 27, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	data := []cncdb.DayCount{
+		{Date: "2024-02-28", Count: 5},
+		{Date: "2024-03-01", Count: 7},
+	}
+	assert.Equal(
+		t,
+		[]cncdb.DayCount{
+			{Date: "2024-02-27", Count: 0},
+			{Date: "2024-02-28", Count: 5},
+			{Date: "2024-02-29", Count: 0},
+			{Date: "2024-03-01", Count: 7},
+		},
+		fillDayGaps(from, to, data),
+	)
+}
+
+func TestFillDayGapsEmptyInterval(t *testing.T) {
+	day := time.Date(2024, 2, 27, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []cncdb.DayCount{}, fillDayGaps(day, day, nil))
+}
+
+func TestFillDayGapsDSTChange(t *testing.T) {
+	tz, err := time.LoadLocation("Europe/Prague")
+	assert.NoError(t, err)
+	from := time.Date(2024, 10, 26, 0, 0, 0, 0, tz)
+	to := time.Date(2024, 10, 29, 0, 0, 0, 0, tz)
+	ans := fillDayGaps(from, to, []cncdb.DayCount{{Date: "2024-10-27", Count: 3}})
+	assert.Equal(
+		t,
+		[]cncdb.DayCount{
+			{Date: "2024-10-26", Count: 0},
+			{Date: "2024-10-27", Count: 3},
+			{Date: "2024-10-28", Count: 0},
+		},
+		ans,
+	)
+}
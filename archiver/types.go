@@ -50,3 +50,12 @@ func (dedup Deduplication) MarshalJSON() ([]byte, error) {
 		},
 	)
 }
+
+// -------------------------
+
+// FailedItem represents an item from the failed queue
+// along with its stored record data (if available).
+type FailedItem struct {
+	Item queueRecord `json:"item"`
+	Data string      `json:"data,omitempty"`
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"camus/cnf"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAdminAuthMiddleware creates a middleware which allows only requests
+// with one of the configured tokens (conf.AuthTokens) in the conf.AuthHeaderName
+// header. Requests without the token are refused with 401, requests with
+// an unknown token with 403. In case no tokens are configured, all
+// the requests are refused.
+func newAdminAuthMiddleware(conf *cnf.Conf) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token := ctx.GetHeader(conf.AuthHeaderName)
+		if token == "" {
+			respondWithError(
+				ctx,
+				fmt.Errorf("missing authentication header %s", conf.AuthHeaderName),
+				http.StatusUnauthorized,
+			)
+			ctx.Abort()
+			return
+		}
+		for _, allowed := range conf.AuthTokens {
+			if allowed != "" && subtle.ConstantTimeCompare([]byte(token), []byte(allowed)) == 1 {
+				ctx.Next()
+				return
+			}
+		}
+		respondWithError(ctx, fmt.Errorf("invalid authentication token"), http.StatusForbidden)
+		ctx.Abort()
+	}
+}
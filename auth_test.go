@@ -0,0 +1,63 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"camus/cnf"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAdminTestEngine(conf *cnf.Conf) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/stats/query-types", newAdminAuthMiddleware(conf), func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	return engine
+}
+
+func callAdminEndpoint(engine *gin.Engine, header, token string) int {
+	req := httptest.NewRequest(http.MethodGet, "/stats/query-types", nil)
+	if token != "" {
+		req.Header.Set(header, token)
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestAdminAuth(t *testing.T) {
+	conf := &cnf.Conf{AuthHeaderName: "X-Api-Key", AuthTokens: []string{"secret1", "secret2"}}
+	engine := newAdminTestEngine(conf)
+	assert.Equal(t, http.StatusUnauthorized, callAdminEndpoint(engine, "X-Api-Key", ""))
+	assert.Equal(t, http.StatusForbidden, callAdminEndpoint(engine, "X-Api-Key", "secret3"))
+	assert.Equal(t, http.StatusUnauthorized, callAdminEndpoint(engine, "X-Other", "secret1"))
+	assert.Equal(t, http.StatusOK, callAdminEndpoint(engine, "X-Api-Key", "secret1"))
+	assert.Equal(t, http.StatusOK, callAdminEndpoint(engine, "X-Api-Key", "secret2"))
+}
+
+func TestAdminAuthNoTokensConfigured(t *testing.T) {
+	conf := &cnf.Conf{AuthHeaderName: "X-Api-Key", AuthTokens: []string{""}}
+	engine := newAdminTestEngine(conf)
+	assert.Equal(t, http.StatusUnauthorized, callAdminEndpoint(engine, "X-Api-Key", ""))
+	assert.Equal(t, http.StatusForbidden, callAdminEndpoint(engine, "X-Api-Key", "anything"))
+}
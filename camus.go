@@ -25,6 +25,7 @@ import (
 	"camus/indexer"
 	"camus/reporting"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -56,6 +57,11 @@ type service interface {
 	Stop(ctx context.Context) error
 }
 
+type namedService struct {
+	name string
+	srv  service
+}
+
 func createArchiver(
 	db cncdb.IConcArchOps,
 	rdb *archiver.RedisAdapter,
@@ -63,6 +69,7 @@ func createArchiver(
 	reporting reporting.IReporting,
 	conf *cnf.Conf,
 ) *archiver.ArchKeeper {
+	db = cncdb.NewTimedConcArchOps(db, conf.Archiver.SlowWriteThreshold())
 	dedup, err := archiver.NewDeduplicator(db, conf.Archiver, conf.TimezoneLocation())
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to initialize deduplicator")
@@ -80,6 +87,18 @@ func createArchiver(
 	)
 }
 
+// parseExportDate parses a date (interpreted in the provided timezone)
+// or an RFC3339 datetime. An empty value means the zero time.
+func parseExportDate(v string, tz *time.Location) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, v, tz); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
 func cleanVersionInfo(v string) string {
 	return strings.TrimLeft(strings.Trim(v, "'"), "v")
 }
@@ -95,6 +114,10 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Usage:\n\t%s [options] start [config.json]\n", filepath.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "\t%s [options] init-query-history [config.json]\n", filepath.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "\t%s [options] gc-query-history [config.json]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s [options] index-stats [config.json]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s [options] migrate-index [config.json]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s [options] export-archive [config.json]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s [options] import-archive [config.json]\n", filepath.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "\t%s [options] version\n", filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
 	}
@@ -112,10 +135,56 @@ func main() {
 	initQHCmd := flag.NewFlagSet("init-query-history", flag.ExitOnError)
 	initChunkSize := initQHCmd.Int("chunk-size", 100, "How many items to process per run (can be run mulitple times while preserving proc. state)")
 	logToConsole := initQHCmd.Bool("console-log", false, "Log to console (even if a file is specified in config json)")
+	forceRestart := initQHCmd.Bool("force-restart", false, "Remove state of a previous (possibly unfinished) run and start from scratch")
 
 	gcQueryHistoryCmd := flag.NewFlagSet("gc-query-history", flag.ExitOnError)
 	initChunkSize2 := gcQueryHistoryCmd.Int("chunk-size", 100, "How many items to process per run (can be run mulitple times while preserving proc. state)")
 	logToConsole2 := gcQueryHistoryCmd.Bool("console-log", false, "Log to console (even if a file is specified in config json)")
+	forceRestart2 := gcQueryHistoryCmd.Bool("force-restart", false, "Remove state of a previous (possibly unfinished) run and start from scratch")
+
+	indexStatsCmd := flag.NewFlagSet("index-stats", flag.ExitOnError)
+	indexStatsCmd.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Camus - print fulltext index stats as JSON\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] index-stats [config.json]\n", filepath.Base(os.Args[0]))
+		indexStatsCmd.PrintDefaults()
+	}
+	lockTimeout := indexStatsCmd.Duration("lock-timeout", 5*time.Second, "How long to wait for the index in case it is held by another process")
+
+	migrateIndexCmd := flag.NewFlagSet("migrate-index", flag.ExitOnError)
+	migrateIndexCmd.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Camus - re-create fulltext index with the current mapping (the service must not run)\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] migrate-index [config.json]\n", filepath.Base(os.Args[0]))
+		migrateIndexCmd.PrintDefaults()
+	}
+	lockTimeout2 := migrateIndexCmd.Duration("lock-timeout", 5*time.Second, "How long to wait for the index in case it is held by another process")
+	logToConsole3 := migrateIndexCmd.Bool("console-log", false, "Log to console (even if a file is specified in config json)")
+	forceRestart3 := migrateIndexCmd.Bool("force-restart", false, "Remove state of a previous (possibly unfinished) run and start from scratch")
+
+	exportArchiveCmd := flag.NewFlagSet("export-archive", flag.ExitOnError)
+	exportArchiveCmd.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Camus - export archive records to a JSONL file (an interrupted export is resumed when run again)\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] export-archive [config.json]\n", filepath.Base(os.Args[0]))
+		exportArchiveCmd.PrintDefaults()
+	}
+	exportFrom := exportArchiveCmd.String("from", "", "Export records created at or after this date (YYYY-MM-DD or RFC3339)")
+	exportTo := exportArchiveCmd.String("to", "", "Export records created before this date (YYYY-MM-DD or RFC3339, default: now)")
+	exportOut := exportArchiveCmd.String("out", "", "Output file (required)")
+	exportGzip := exportArchiveCmd.Bool("gzip", false, "Compress the output (enabled automatically for *.gz output files)")
+	exportChunkSize := exportArchiveCmd.Int("chunk-size", 1000, "How many records to load from the database at once")
+	logToConsole4 := exportArchiveCmd.Bool("console-log", false, "Log to console (even if a file is specified in config json)")
+	forceRestart4 := exportArchiveCmd.Bool("force-restart", false, "Remove state of a previous (possibly unfinished) export and start from scratch")
+
+	importArchiveCmd := flag.NewFlagSet("import-archive", flag.ExitOnError)
+	importArchiveCmd.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Camus - import archive records from a JSONL file created by export-archive (existing IDs are skipped)\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] import-archive [config.json]\n", filepath.Base(os.Args[0]))
+		importArchiveCmd.PrintDefaults()
+	}
+	importIn := importArchiveCmd.String("in", "", "Input file (required, gzipped files are detected automatically)")
+	importChunkSize := importArchiveCmd.Int("chunk-size", 500, "How many records to insert within a single transaction")
+	dryRunImport := importArchiveCmd.Bool(
+		"dry-run", false, "If set, then instead of writing to database, Camus will just report operations to the log")
+	logToConsole5 := importArchiveCmd.Bool("console-log", false, "Log to console (even if a file is specified in config json)")
 
 	versionCmd := flag.NewFlagSet("version", flag.ExitOnError)
 	versionCmd.Usage = func() {
@@ -156,6 +225,35 @@ func main() {
 		}
 		logging.SetupLogging(conf.Logging)
 		cnf.ValidateAndDefaults(conf)
+	case "index-stats":
+		indexStatsCmd.Parse(os.Args[2:])
+		conf = cnf.LoadConfig(indexStatsCmd.Arg(0))
+		logging.SetupLogging(conf.Logging)
+		cnf.ValidateAndDefaults(conf)
+	case "migrate-index":
+		migrateIndexCmd.Parse(os.Args[2:])
+		conf = cnf.LoadConfig(migrateIndexCmd.Arg(0))
+		if *logToConsole3 {
+			conf.Logging.Path = ""
+		}
+		logging.SetupLogging(conf.Logging)
+		cnf.ValidateAndDefaults(conf)
+	case "export-archive":
+		exportArchiveCmd.Parse(os.Args[2:])
+		conf = cnf.LoadConfig(exportArchiveCmd.Arg(0))
+		if *logToConsole4 {
+			conf.Logging.Path = ""
+		}
+		logging.SetupLogging(conf.Logging)
+		cnf.ValidateAndDefaults(conf)
+	case "import-archive":
+		importArchiveCmd.Parse(os.Args[2:])
+		conf = cnf.LoadConfig(importArchiveCmd.Arg(0))
+		if *logToConsole5 {
+			conf.Logging.Path = ""
+		}
+		logging.SetupLogging(conf.Logging)
+		cnf.ValidateAndDefaults(conf)
 	default:
 		flag.Usage()
 		fmt.Fprintf(
@@ -180,28 +278,47 @@ func main() {
 		rdb := archiver.NewRedisAdapter(ctx, conf.Redis)
 
 		var reportingService reporting.IReporting
-		if conf.Reporting.Host != "" {
+		switch conf.Reporting.Type {
+		case reporting.TypeTimescale:
 			reportingService, err = reporting.NewStatusWriter(
-				conf.Reporting,
+				conf.Reporting.PgConf,
+				conf.Reporting.QueueSize,
 				conf.TimezoneLocation(),
 				func(err error) {},
 			)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to initialize reporting")
-				os.Exit(1)
-				return
-			}
-
-		} else {
+		case reporting.TypeFile:
+			reportingService, err = reporting.NewFileReporting(
+				conf.Reporting.File,
+				conf.TimezoneLocation(),
+			)
+		default:
 			reportingService = &reporting.DummyWriter{}
 		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to initialize reporting")
+			os.Exit(1)
+			return
+		}
+		recentStats := reporting.NewInMemoryReporting(
+			reportingService, conf.Reporting.NumRetainRecent, conf.TimezoneLocation())
+		reportingService = recentStats
 
 		// ---------- prepare db operations providers for services  ---------------------------
 
 		var dbArchOps cncdb.IConcArchOps
 		var dbQHistOps cncdb.IQHistArchOps
 
-		dbArchOpsRaw, dbQHistOpsRaw := cncdb.NewMySQLOps(ctx, db, conf.TimezoneLocation())
+		dbArchOpsRaw, dbQHistOpsRaw := cncdb.NewMySQLOps(ctx, db, conf.MySQL, conf.TimezoneLocation())
+		if conf.MySQL.ReadReplica != nil {
+			replicaDB, err := cncdb.DBOpen(conf.MySQL.ReadReplica)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to open SQL read replica")
+				os.Exit(1)
+				return
+			}
+			dbArchOpsRaw.SetReadReplica(replicaDB)
+			log.Info().Msgf("using read replica %s@%s", conf.MySQL.ReadReplica.Name, conf.MySQL.ReadReplica.Host)
+		}
 		if *dryRun {
 			dbArchOps, dbQHistOps = cncdb.NewMySQLDryRun(dbArchOpsRaw, dbQHistOpsRaw)
 
@@ -220,6 +337,24 @@ func main() {
 			archCleanerDbOps = dbArchOpsRaw
 		}
 
+		// the indexer only reads records (it never merges them)
+		// so it can use the read replica
+		var indexerDbOps cncdb.IConcArchOps
+		if *dryRunCleaner {
+			indexerDbOps, _ = cncdb.NewMySQLDryRun(dbArchOpsRaw.WithReplicaRecordReads(), dbQHistOpsRaw)
+
+		} else {
+			indexerDbOps = dbArchOpsRaw.WithReplicaRecordReads()
+		}
+
+		var mergeAudits *cncdb.MergeAuditLog
+		if conf.Archiver.MergeAuditNumRetain > 0 {
+			mergeAudits = cncdb.NewMergeAuditLog(conf.Archiver.MergeAuditNumRetain, conf.TimezoneLocation())
+			dbArchOps = cncdb.NewAuditedConcArchOps(dbArchOps, mergeAudits)
+			archCleanerDbOps = cncdb.NewAuditedConcArchOps(archCleanerDbOps, mergeAudits)
+			log.Info().Int("numRetain", conf.Archiver.MergeAuditNumRetain).Msg("merge audit enabled")
+		}
+
 		// -------
 
 		recsToIndex := make(chan cncdb.HistoryRecord)
@@ -233,20 +368,26 @@ func main() {
 
 		// query history fulltext service:
 
-		ftIndexer, err := indexer.NewIndexer(conf.Indexer, archCleanerDbOps, dbQHistOps, rdb, recsToIndex)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to initialize index")
-			os.Exit(1)
-			return
-		}
-
-		fulltext := indexer.NewService(conf.Indexer, ftIndexer, rdb)
+		fulltext := indexer.NewService(conf.Indexer, nil, rdb)
 
 		as := &apiServer{
 			arch:            arch,
 			conf:            conf,
 			fulltextService: fulltext,
 			rdb:             rdb,
+			db:              db,
+			recentStats:     recentStats,
+			mergeAudits:     mergeAudits,
+		}
+		// the API server is started before the (possibly slow) index
+		// opening so the readiness check can report the progress
+		as.Start(ctx)
+
+		ftIndexer, err := fulltext.OpenIndex(indexerDbOps, dbQHistOps, recsToIndex)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to initialize index")
+			os.Exit(1)
+			return
 		}
 
 		// query history garbage collector service
@@ -261,23 +402,37 @@ func main() {
 
 		// -------
 
-		services := []service{ftIndexer, arch, cln, fulltext, as, reportingService, qHistGC}
+		services := []namedService{
+			{cnf.ServiceIndexer, ftIndexer},
+			{cnf.ServiceArchiver, arch},
+			{cnf.ServiceCleaner, cln},
+			{cnf.ServiceFulltext, fulltext},
+			{cnf.ServiceAPIServer, as},
+			{cnf.ServiceReporting, reportingService},
+			{cnf.ServiceQueryHistoryGC, qHistGC},
+		}
 		for _, m := range services {
-			m.Start(ctx)
+			if m.name == cnf.ServiceAPIServer {
+				continue // already started
+			}
+			m.srv.Start(ctx)
 		}
+		as.SetReady()
 		<-ctx.Done()
 		log.Warn().Msg("shutdown signal received")
 
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
+		// each service has its own deadline so a slow one
+		// cannot consume shutdown time of the others
 		var wg sync.WaitGroup
 		for _, s := range services {
 			wg.Add(1)
-			go func(srv service) {
+			go func(ns namedService) {
 				defer wg.Done()
-				if err := srv.Stop(shutdownCtx); err != nil {
-					log.Error().Err(err).Type("service", srv).Msg("Error shutting down service")
+				shutdownCtx, cancel := context.WithTimeout(
+					context.Background(), conf.ShutdownTimeout(ns.name))
+				defer cancel()
+				if err := ns.srv.Stop(shutdownCtx); err != nil {
+					log.Error().Err(err).Str("service", ns.name).Msg("Error shutting down service")
 				}
 			}(s)
 		}
@@ -291,7 +446,7 @@ func main() {
 		select {
 		case <-done:
 			log.Info().Msg("Graceful shutdown completed")
-		case <-shutdownCtx.Done():
+		case <-time.After(conf.MaxShutdownTimeout()):
 			log.Warn().Msg("Shutdown timed out")
 		}
 	case "init-query-history":
@@ -304,13 +459,13 @@ func main() {
 			return
 		}
 		log.Info().Msgf("using database %s@%s", conf.MySQL.Name, conf.MySQL.Host)
-		dbConcArchOps, dbQHistOps := cncdb.NewMySQLOps(ctx, db, conf.TimezoneLocation())
+		dbConcArchOps, dbQHistOps := cncdb.NewMySQLOps(ctx, db, conf.MySQL, conf.TimezoneLocation())
 		exec := history.NewDataInitializer(
 			dbConcArchOps,
 			dbQHistOps,
 			archiver.NewRedisAdapter(ctx, conf.Redis),
 		)
-		exec.Run(ctx, conf, *initChunkSize)
+		exec.Run(ctx, conf, *initChunkSize, *forceRestart)
 	case "gc-query-history": // aka garbage-collect-query-history
 		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 		defer stop()
@@ -323,7 +478,7 @@ func main() {
 		log.Info().Msgf("using database %s@%s", conf.MySQL.Name, conf.MySQL.Host)
 
 		rdb := archiver.NewRedisAdapter(ctx, conf.Redis)
-		dbConcArchOps, dbQHistOps := cncdb.NewMySQLOps(ctx, db, conf.TimezoneLocation())
+		dbConcArchOps, dbQHistOps := cncdb.NewMySQLOps(ctx, db, conf.MySQL, conf.TimezoneLocation())
 
 		recsToIndex := make(chan cncdb.HistoryRecord)
 		ftIndexer, err := indexer.NewIndexer(conf.Indexer, dbConcArchOps, dbQHistOps, rdb, recsToIndex)
@@ -340,9 +495,141 @@ func main() {
 			&reporting.DummyWriter{},
 			conf.Indexer,
 		)
-		exec.RunAdHoc(ctx, dbConcArchOps, conf, *initChunkSize2)
+		exec.RunAdHoc(ctx, dbConcArchOps, conf, *initChunkSize2, *forceRestart2)
 		close(recsToIndex)
 
+	case "index-stats":
+		ftIndexer, err := indexer.OpenReadOnly(conf.Indexer, *lockTimeout)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open index")
+			os.Exit(1)
+			return
+		}
+		stats, err := ftIndexer.Stats()
+		ftIndexer.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get index stats")
+			os.Exit(1)
+			return
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(stats); err != nil {
+			log.Error().Err(err).Msg("Failed to write index stats")
+			os.Exit(1)
+			return
+		}
+
+	case "migrate-index":
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		db, err := cncdb.DBOpen(conf.MySQL)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open SQL database")
+			os.Exit(1)
+			return
+		}
+		log.Info().Msgf("using database %s@%s", conf.MySQL.Name, conf.MySQL.Host)
+		rdb := archiver.NewRedisAdapter(ctx, conf.Redis)
+		dbConcArchOps, dbQHistOps := cncdb.NewMySQLOps(ctx, db, conf.MySQL, conf.TimezoneLocation())
+		summary, err := indexer.MigrateIndex(
+			ctx, conf.Indexer, dbConcArchOps, dbQHistOps, rdb, *lockTimeout2, *forceRestart3)
+		if err != nil {
+			log.Error().Err(err).Any("summary", summary).Msg("Failed to migrate index (run again to resume)")
+			os.Exit(1)
+			return
+		}
+		log.Info().Any("summary", summary).Msg("index migration finished")
+
+	case "export-archive":
+		if *exportOut == "" {
+			log.Error().Msg("Missing output file (-out)")
+			os.Exit(1)
+			return
+		}
+		exportConf := cncdb.ExportConf{
+			OutPath:      *exportOut,
+			Gzip:         *exportGzip || strings.HasSuffix(*exportOut, ".gz"),
+			ChunkSize:    *exportChunkSize,
+			ForceRestart: *forceRestart4,
+		}
+		var err error
+		exportConf.From, err = parseExportDate(*exportFrom, conf.TimezoneLocation())
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid -from date")
+			os.Exit(1)
+			return
+		}
+		if *exportTo != "" {
+			exportConf.To, err = parseExportDate(*exportTo, conf.TimezoneLocation())
+			if err != nil {
+				log.Error().Err(err).Msg("Invalid -to date")
+				os.Exit(1)
+				return
+			}
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		db, err := cncdb.DBOpen(conf.MySQL)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open SQL database")
+			os.Exit(1)
+			return
+		}
+		log.Info().Msgf("using database %s@%s", conf.MySQL.Name, conf.MySQL.Host)
+		dbConcArchOps, _ := cncdb.NewMySQLOps(ctx, db, conf.MySQL, conf.TimezoneLocation())
+		if conf.MySQL.ReadReplica != nil {
+			replicaDB, err := cncdb.DBOpen(conf.MySQL.ReadReplica)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to open SQL read replica")
+				os.Exit(1)
+				return
+			}
+			dbConcArchOps.SetReadReplica(replicaDB)
+			dbConcArchOps = dbConcArchOps.WithReplicaRecordReads()
+			log.Info().Msgf("exporting from read replica %s@%s", conf.MySQL.ReadReplica.Name, conf.MySQL.ReadReplica.Host)
+		}
+		summary, err := cncdb.ExportRecords(ctx, dbConcArchOps, exportConf)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Int("numExported", summary.NumExported).
+				Msg("Failed to export archive records (run again to resume)")
+			os.Exit(1)
+			return
+		}
+		log.Info().
+			Int("numExported", summary.NumExported).
+			Str("output", exportConf.OutPath).
+			Msg("archive export finished")
+
+	case "import-archive":
+		if *importIn == "" {
+			log.Error().Msg("Missing input file (-in)")
+			os.Exit(1)
+			return
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		db, err := cncdb.DBOpen(conf.MySQL)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open SQL database")
+			os.Exit(1)
+			return
+		}
+		log.Info().Msgf("using database %s@%s", conf.MySQL.Name, conf.MySQL.Host)
+		dbArchOpsRaw, dbQHistOpsRaw := cncdb.NewMySQLOps(ctx, db, conf.MySQL, conf.TimezoneLocation())
+		var dbArchOps cncdb.IConcArchOps = dbArchOpsRaw
+		if *dryRunImport {
+			dbArchOps, _ = cncdb.NewMySQLDryRun(dbArchOpsRaw, dbQHistOpsRaw)
+		}
+		summary, err := cncdb.ImportRecords(
+			ctx, dbArchOps, cncdb.ImportConf{InPath: *importIn, ChunkSize: *importChunkSize})
+		if err != nil {
+			log.Error().Err(err).Any("summary", summary).Msg("Failed to import archive records")
+			os.Exit(1)
+			return
+		}
+		log.Info().Any("summary", summary).Msg("archive import finished")
+
 	default:
 		log.Fatal().Msgf("Unknown action %s", action)
 	}
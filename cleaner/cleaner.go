@@ -22,6 +22,7 @@ import (
 	"camus/reporting"
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/czcorpus/cnc-gokit/collections"
@@ -29,7 +30,10 @@ import (
 )
 
 const (
-	dtFormat = "2006-01-02T15:04:05"
+	// legacyDtFormat is a format of the status key value
+	// used by older versions. It does not contain timezone info.
+	legacyDtFormat = "2006-01-02T15:04:05"
+	dtFormat       = time.RFC3339
 )
 
 type Service struct {
@@ -54,11 +58,7 @@ func (job *Service) Start(ctx context.Context) {
 					log.Warn().Msg("cannot run next cleanup - the previous not finished yet")
 
 				} else {
-					numProc := job.conf.NumProcessItemsPerTick
-					if cncdb.TimeIsAtNight(t) {
-						numProc = job.conf.NumProcessItemsPerTickNight
-					}
-					err := job.performCleanup(numProc)
+					err := job.performCleanup(job.numItemsToProcess(t))
 					if err != nil {
 						log.Error().Err(err).Msg("failed to perform cleanup")
 					}
@@ -68,73 +68,89 @@ func (job *Service) Start(ctx context.Context) {
 	}()
 }
 
+// numItemsToProcess returns number of records to be processed
+// in a cleanup run started at time t. At night (evaluated in
+// the configured timezone), the increased value is used.
+func (job *Service) numItemsToProcess(t time.Time) int {
+	if cncdb.TimeIsAtNight(t.In(job.tz)) {
+		return job.conf.NumProcessItemsPerTickNight
+	}
+	return job.conf.NumProcessItemsPerTick
+}
+
 func (job *Service) Stop(ctx context.Context) error {
 	log.Warn().Msg("stopping Cleaner")
 	return nil
 }
 
-func (job *Service) performCleanup(itemsToProc int) error {
-	job.cleanupRunning = true
-	defer func() { job.cleanupRunning = false }()
-	t0 := time.Now()
+// parseStatusDate parses the cleanup cursor stored in Redis.
+// Values without timezone (written by older versions) are interpreted
+// in the provided timezone.
+func parseStatusDate(v string, tz *time.Location) (time.Time, error) {
+	t, err := time.Parse(dtFormat, v)
+	if err == nil {
+		return t, nil
+	}
+	t, err2 := time.ParseInLocation(legacyDtFormat, v, tz)
+	if err2 != nil {
+		return time.Time{}, err
+	}
+	log.Warn().
+		Str("value", v).
+		Time("interpretedAs", t).
+		Msg("found legacy cleanup status date without timezone, migrating")
+	return t, nil
+}
 
-	birthLimit := time.Now().In(job.tz).Add(-job.conf.MinAgeUnvisited())
+func formatStatusDate(t time.Time, tz *time.Location) string {
+	return t.In(tz).Format(dtFormat)
+}
+
+// processItem resolves duplicities of a single archive record
+// and removes the record in case it is old and never accessed.
+// The method can be called concurrently for different records.
+func (job *Service) processItem(item cncdb.ArchRecord, birthLimit time.Time) reporting.CleanupStats {
 	var stats reporting.CleanupStats
-	lastDateRaw, err := job.rdb.Get(job.conf.StatusKey)
+	stats.NumFetched++
+	variants, err := job.db.LoadRecordsByID(item.ID)
 	if err != nil {
-		return fmt.Errorf("failed to fetch last check date from Redis (key %s): %w", job.conf.StatusKey, err)
-	}
-	var lastDate time.Time
-	if lastDateRaw != "" {
-		lastDate, err = time.Parse(dtFormat, lastDateRaw)
-		if err != nil {
-			return fmt.Errorf("failed to parse last check date in Redis (key %s): %w", job.conf.StatusKey, err)
+		log.Warn().
+			Err(err).
+			Str("recordId", item.ID).
+			Msg("failed to load variants for, setting err flag and skipping")
+		if err := job.db.UpdateRecordStatus(item.ID, -1); err != nil {
+			log.Error().
+				Err(err).
+				Str("recordId", item.ID).
+				Msg("failed to set error status")
 		}
+		stats.NumErrors++
+		return stats
 	}
-	log.Info().
-		Time("lastCheck", lastDate).
-		Int("itemsToLoad", itemsToProc).
-		Msg("preparing for archive cleanup")
-	items, err := job.db.LoadRecordsFromDate(lastDate, itemsToProc)
+
+	err = cncdb.ValidateQueryInstances(variants)
 	if err != nil {
-		return fmt.Errorf("failed to load requested items for cleanup from database: %w", err)
-	}
-	if len(items) == 0 {
-		log.Warn().Time("srchTo", lastDate).Msg("no more records found for cleanup")
-		return nil
-	}
-	visitedIDs := collections.NewSet[string]()
-	for _, item := range items {
-		if visitedIDs.Contains(item.ID) {
-			continue // already resolved duplicity
-		}
-		visitedIDs.Add(item.ID)
-		if item.Permanent == 1 {
-			continue
-		}
-		stats.NumFetched++
-		variants, err := job.db.LoadRecordsByID(item.ID)
-		if err != nil {
-			log.Warn().
+		log.Warn().
+			Err(err).
+			Str("recordId", variants[0].ID).
+			Msg("archive record variants failed to validate, setting err flag and skipping")
+		if err := job.db.UpdateRecordStatus(variants[0].ID, -1); err != nil {
+			log.Error().
 				Err(err).
 				Str("recordId", variants[0].ID).
-				Msg("failed to load variants for, setting err flag and skipping")
-			if err := job.db.UpdateRecordStatus(variants[0].ID, -1); err != nil {
-				log.Error().
-					Err(err).
-					Str("recordId", variants[0].ID).
-					Msg("failed to set error status")
-			}
-			stats.NumErrors++
-			continue
+				Msg("failed to set error status")
 		}
+		stats.NumErrors++
+		return stats
+	}
 
-		err = cncdb.ValidateQueryInstances(variants)
+	if len(variants) > 1 {
+		mergedItem, err := job.db.DeduplicateInArchive(variants, variants[0])
 		if err != nil {
 			log.Warn().
 				Err(err).
 				Str("recordId", variants[0].ID).
-				Msg("archive record variants failed to validate, setting err flag and skipping")
+				Msg("failed to deduplicate items in database, setting err flag and skipping")
 			if err := job.db.UpdateRecordStatus(variants[0].ID, -1); err != nil {
 				log.Error().
 					Err(err).
@@ -142,16 +158,15 @@ func (job *Service) performCleanup(itemsToProc int) error {
 					Msg("failed to set error status")
 			}
 			stats.NumErrors++
-			continue
+			return stats
 		}
-
-		if len(variants) > 1 {
-			mergedItem, err := job.db.DeduplicateInArchive(variants, variants[0])
-			if err != nil {
-				log.Warn().
-					Err(err).
-					Str("recordId", variants[0].ID).
-					Msg("failed to deduplicate items in database, setting err flag and skipping")
+		stats.NumMerged++
+		if mergedItem.NumAccess == 0 && mergedItem.Created.Before(birthLimit) {
+			log.Debug().
+				Str("recordId", mergedItem.ID).
+				Time("limitBirth", birthLimit).
+				Msg("record will be removed due to no access and high age")
+			if err := job.db.RemoveRecordsByID(variants[0].ID); err != nil {
 				if err := job.db.UpdateRecordStatus(variants[0].ID, -1); err != nil {
 					log.Error().
 						Err(err).
@@ -159,48 +174,146 @@ func (job *Service) performCleanup(itemsToProc int) error {
 						Msg("failed to set error status")
 				}
 				stats.NumErrors++
-				continue
+				return stats
 			}
-			stats.NumMerged++
-			if mergedItem.NumAccess == 0 && mergedItem.Created.Before(birthLimit) {
-				log.Debug().
-					Str("recordId", mergedItem.ID).
-					Time("limitBirth", birthLimit).
-					Msg("record will be removed due to no access and high age")
-				if err := job.db.RemoveRecordsByID(variants[0].ID); err != nil {
-					if err := job.db.UpdateRecordStatus(variants[0].ID, -1); err != nil {
-						log.Error().
-							Err(err).
-							Str("recordId", variants[0].ID).
-							Msg("failed to set error status")
-					}
-					stats.NumErrors++
-					continue
+			stats.NumDeleted++
+		}
+
+	} else {
+		if variants[0].NumAccess == 0 && variants[0].Created.Before(birthLimit) {
+			log.Debug().
+				Str("recordId", variants[0].ID).
+				Time("limitBirth", birthLimit).
+				Msg("record will be removed due to no access and high age")
+			if err := job.db.RemoveRecordsByID(variants[0].ID); err != nil {
+				if err := job.db.UpdateRecordStatus(variants[0].ID, -1); err != nil {
+					log.Error().
+						Err(err).
+						Str("recordId", variants[0].ID).
+						Msg("failed to set error status")
 				}
-				stats.NumDeleted++
+				stats.NumErrors++
+				return stats
 			}
+			stats.NumDeleted++
+		}
+	}
+	return stats
+}
 
-		} else {
-			if variants[0].NumAccess == 0 && variants[0].Created.Before(birthLimit) {
-				log.Debug().
-					Str("recordId", variants[0].ID).
-					Time("limitBirth", birthLimit).
-					Msg("record will be removed due to no access and high age")
-				if err := job.db.RemoveRecordsByID(variants[0].ID); err != nil {
-					if err := job.db.UpdateRecordStatus(variants[0].ID, -1); err != nil {
-						log.Error().
-							Err(err).
-							Str("recordId", variants[0].ID).
-							Msg("failed to set error status")
-					}
-					stats.NumErrors++
-					continue
-				}
-				stats.NumDeleted++
+// loadItems loads next items to be processed based on the stored
+// cursor and the configured process order. In the newest-first
+// mode with no cursor, items are loaded starting with now.
+func (job *Service) loadItems(cursor time.Time, itemsToProc int) ([]cncdb.ArchRecord, error) {
+	if job.conf.IsNewestFirst() {
+		if cursor.IsZero() {
+			cursor = time.Now().In(job.tz)
+		}
+		return job.db.LoadRecordsToDate(cursor, itemsToProc)
+	}
+	return job.db.LoadRecordsFromDate(cursor, itemsToProc)
+}
+
+// nextCursor returns a cursor value for the next cleanup run based
+// on the loaded items (which must not be empty). As the record loading
+// is inclusive, the newest-first mode would keep loading the oldest
+// record forever once it is reached. So in case fewer items than
+// requested were loaded (i.e. there are no older records), zero time is
+// returned and the next run starts with the newest records again.
+func (job *Service) nextCursor(items []cncdb.ArchRecord, itemsToProc int) time.Time {
+	if job.conf.IsNewestFirst() && len(items) < itemsToProc {
+		return time.Time{}
+	}
+	return items[len(items)-1].Created
+}
+
+// storeCursor stores the cursor for the next cleanup run.
+// Zero time removes the cursor.
+func (job *Service) storeCursor(cursor time.Time) {
+	cursorKey := job.conf.CursorKey()
+	if cursor.IsZero() {
+		log.Info().Msg("reached the oldest archive record, cleanup will continue with the newest ones")
+		if err := job.rdb.Delete(cursorKey); err != nil {
+			log.Error().Err(err).Msg("failed to reset cleanup cursor")
+		}
+		return
+	}
+	if err := job.rdb.Set(cursorKey, formatStatusDate(cursor, job.tz)); err != nil {
+		log.Error().Err(err).Msg("failed to store cleanup cursor")
+	}
+}
+
+func (job *Service) performCleanup(itemsToProc int) error {
+	job.cleanupRunning = true
+	defer func() { job.cleanupRunning = false }()
+	t0 := time.Now()
+
+	birthLimit := time.Now().In(job.tz).Add(-job.conf.MinAgeUnvisited())
+	var stats reporting.CleanupStats
+	cursorKey := job.conf.CursorKey()
+	lastDateRaw, err := job.rdb.Get(cursorKey)
+	if err != nil {
+		return fmt.Errorf("failed to fetch last check date from Redis (key %s): %w", cursorKey, err)
+	}
+	var lastDate time.Time
+	if lastDateRaw != "" {
+		lastDate, err = parseStatusDate(lastDateRaw, job.tz)
+		if err != nil {
+			return fmt.Errorf("failed to parse last check date in Redis (key %s): %w", cursorKey, err)
+		}
+	}
+	log.Info().
+		Time("lastCheck", lastDate).
+		Int("itemsToLoad", itemsToProc).
+		Str("processOrder", job.conf.ProcessOrder).
+		Msg("preparing for archive cleanup")
+	items, err := job.loadItems(lastDate, itemsToProc)
+	if err != nil {
+		return fmt.Errorf("failed to load requested items for cleanup from database: %w", err)
+	}
+	if len(items) == 0 {
+		log.Warn().Time("srchTo", lastDate).Msg("no more records found for cleanup")
+		if job.conf.IsNewestFirst() && !lastDate.IsZero() {
+			job.storeCursor(time.Time{})
+		}
+		return nil
+	}
+	visitedIDs := collections.NewSet[string]()
+	jobs := make(chan cncdb.ArchRecord)
+	var statsMutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < job.conf.NumWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				itemStats := job.processItem(item, birthLimit)
+				statsMutex.Lock()
+				stats.NumFetched += itemStats.NumFetched
+				stats.NumMerged += itemStats.NumMerged
+				stats.NumErrors += itemStats.NumErrors
+				stats.NumDeleted += itemStats.NumDeleted
+				statsMutex.Unlock()
 			}
+		}()
+	}
+	for _, item := range items {
+		if visitedIDs.Contains(item.ID) {
+			continue // already resolved duplicity
+		}
+		visitedIDs.Add(item.ID)
+		if item.Permanent == 1 {
+			continue
 		}
+		jobs <- item
 	}
-	job.rdb.Set(job.conf.StatusKey, items[len(items)-1].Created.Format(dtFormat))
+	close(jobs)
+	wg.Wait()
+	// Note: all the loaded items are processed at this point so it does not
+	// matter in which order the workers finished them. The last item
+	// is the newest one (oldest-first order) or the oldest one (newest-first
+	// order) which is exactly the next cursor value in both cases.
+	job.storeCursor(job.nextCursor(items, itemsToProc))
 	log.Info().
 		Any("stats", stats).
 		Float64("procTime", time.Since(t0).Seconds()).
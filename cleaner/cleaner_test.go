@@ -0,0 +1,147 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cleaner
+
+import (
+	"camus/cncdb"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatusDateLegacyValue(t *testing.T) {
+	tz, err := time.LoadLocation("Europe/Prague")
+	assert.NoError(t, err)
+	v, err := parseStatusDate("2024-01-15T10:30:00", tz)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 15, 9, 30, 0, 0, time.UTC), v.UTC())
+}
+
+func TestParseStatusDateRFC3339(t *testing.T) {
+	tz, err := time.LoadLocation("Europe/Prague")
+	assert.NoError(t, err)
+	v, err := parseStatusDate("2024-07-15T10:30:00-04:00", tz)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 7, 15, 14, 30, 0, 0, time.UTC), v.UTC())
+}
+
+func TestParseStatusDateInvalid(t *testing.T) {
+	_, err := parseStatusDate("15.1.2024", time.UTC)
+	assert.Error(t, err)
+}
+
+func TestStatusDateRoundTrip(t *testing.T) {
+	tz, err := time.LoadLocation("Europe/Prague")
+	assert.NoError(t, err)
+	orig := time.Date(2024, 3, 31, 1, 59, 30, 0, time.UTC) // around DST change
+	v, err := parseStatusDate(formatStatusDate(orig, tz), time.UTC)
+	assert.NoError(t, err)
+	assert.True(t, orig.Equal(v))
+}
+
+func TestNumItemsToProcess(t *testing.T) {
+	tz, err := time.LoadLocation("Europe/Prague")
+	assert.NoError(t, err)
+	job := NewService(nil, nil, nil, Conf{NumProcessItemsPerTick: 10, NumProcessItemsPerTickNight: 50}, tz)
+	assert.Equal(t, 10, job.numItemsToProcess(time.Date(2024, 5, 10, 14, 0, 0, 0, tz)))
+	assert.Equal(t, 50, job.numItemsToProcess(time.Date(2024, 5, 10, 23, 30, 0, 0, tz)))
+	assert.Equal(t, 50, job.numItemsToProcess(time.Date(2024, 5, 10, 3, 0, 0, 0, tz)))
+	// 20:30 UTC is 22:30 in Prague (summer time)
+	assert.Equal(t, 50, job.numItemsToProcess(time.Date(2024, 5, 10, 20, 30, 0, 0, time.UTC)))
+}
+
+type cursorRecordingDB struct {
+	cncdb.IConcArchOps
+	fromDate time.Time
+	toDate   time.Time
+}
+
+func (db *cursorRecordingDB) LoadRecordsFromDate(fromDate time.Time, maxItems int) ([]cncdb.ArchRecord, error) {
+	db.fromDate = fromDate
+	return []cncdb.ArchRecord{}, nil
+}
+
+func (db *cursorRecordingDB) LoadRecordsToDate(toDate time.Time, maxItems int) ([]cncdb.ArchRecord, error) {
+	db.toDate = toDate
+	return []cncdb.ArchRecord{}, nil
+}
+
+func TestLoadItemsOldestFirst(t *testing.T) {
+	db := &cursorRecordingDB{}
+	job := NewService(db, nil, nil, Conf{ProcessOrder: ProcessOrderOldest}, time.UTC)
+	cursor := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	_, err := job.loadItems(cursor, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, db.fromDate)
+	assert.True(t, db.toDate.IsZero())
+}
+
+func TestLoadItemsNewestFirst(t *testing.T) {
+	db := &cursorRecordingDB{}
+	job := NewService(db, nil, nil, Conf{ProcessOrder: ProcessOrderNewest}, time.UTC)
+	cursor := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	_, err := job.loadItems(cursor, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, db.toDate)
+	assert.True(t, db.fromDate.IsZero())
+}
+
+func TestLoadItemsNewestFirstNoCursor(t *testing.T) {
+	db := &cursorRecordingDB{}
+	job := NewService(db, nil, nil, Conf{ProcessOrder: ProcessOrderNewest}, time.UTC)
+	t0 := time.Now()
+	_, err := job.loadItems(time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.False(t, db.toDate.Before(t0))
+}
+
+func TestNextCursorNewestFirst(t *testing.T) {
+	job := NewService(nil, nil, nil, Conf{ProcessOrder: ProcessOrderNewest}, time.UTC)
+	t1 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	t2 := time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)
+	items := []cncdb.ArchRecord{{ID: "a", Created: t1}, {ID: "b", Created: t2}}
+	assert.Equal(t, t2, job.nextCursor(items, 2))
+	// there are no older records so we start from the newest ones again
+	assert.True(t, job.nextCursor(items, 10).IsZero())
+	// just the boundary record (already processed in the previous run)
+	assert.True(t, job.nextCursor(items[1:], 10).IsZero())
+}
+
+func TestNextCursorOldestFirst(t *testing.T) {
+	job := NewService(nil, nil, nil, Conf{ProcessOrder: ProcessOrderOldest}, time.UTC)
+	t1 := time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)
+	t2 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	items := []cncdb.ArchRecord{{ID: "a", Created: t1}, {ID: "b", Created: t2}}
+	assert.Equal(t, t2, job.nextCursor(items, 2))
+	assert.Equal(t, t2, job.nextCursor(items, 10))
+}
+
+func TestCursorKey(t *testing.T) {
+	conf := Conf{StatusKey: "camus_cleanup_status", ProcessOrder: ProcessOrderOldest}
+	assert.Equal(t, "camus_cleanup_status", conf.CursorKey())
+	conf.ProcessOrder = ProcessOrderNewest
+	assert.Equal(t, "camus_cleanup_status_newest", conf.CursorKey())
+}
+
+func TestValidateProcessOrder(t *testing.T) {
+	conf := Conf{CheckIntervalSecs: 200, NumProcessItemsPerTick: 5, MinAgeDaysUnvisited: 30}
+	assert.NoError(t, conf.ValidateAndDefaults(30))
+	assert.Equal(t, ProcessOrderOldest, conf.ProcessOrder)
+	conf.ProcessOrder = "random"
+	assert.Error(t, conf.ValidateAndDefaults(30))
+}
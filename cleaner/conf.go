@@ -29,6 +29,22 @@ const (
 	minAllowedCheckInterval  = 10
 	minAgeDaysUnvisitedLimit = 30 //365
 	dfltNightItemsIncrease   = 2
+	dfltNumWorkers           = 1
+	maxNumWorkers            = 32
+
+	// ProcessOrderOldest processes archive records starting with the
+	// oldest ones. The cursor stored in Conf.StatusKey is a lower bound
+	// of creation date of records to be processed in the next run. Once
+	// the newest records are reached, each run just checks for new ones.
+	ProcessOrderOldest = "oldest"
+
+	// ProcessOrderNewest processes archive records starting with the
+	// newest ones. The cursor (stored in a separate key, see CursorKey)
+	// is an upper bound of creation date of records to be processed
+	// in the next run. With no cursor stored, the processing starts
+	// at the current time. Once the oldest records are reached, the cursor
+	// is removed and the cleanup starts with the newest records again.
+	ProcessOrderNewest = "newest"
 )
 
 type Conf struct {
@@ -37,6 +53,17 @@ type Conf struct {
 	NumProcessItemsPerTickNight int    `json:"numProcessItemsPerTickNight"`
 	StatusKey                   string `json:"statusKey"`
 	MinAgeDaysUnvisited         int    `json:"minAgeDaysUnvisited"`
+
+	// NumWorkers specifies how many records are processed concurrently
+	// during a single cleanup run. Each record (along with its variants)
+	// is processed independently so this can speed up processing
+	// of large backlogs.
+	NumWorkers int `json:"numWorkers"`
+
+	// ProcessOrder specifies whether the cleanup goes from the oldest
+	// records to the newest ones (`oldest`, default) or vice versa
+	// (`newest`, useful e.g. for fixing a recent bad batch of records).
+	ProcessOrder string `json:"processOrder"`
 }
 
 func (conf Conf) CheckInterval() time.Duration {
@@ -47,6 +74,20 @@ func (conf Conf) MinAgeUnvisited() time.Duration {
 	return time.Duration(conf.MinAgeDaysUnvisited) * time.Hour * 24
 }
 
+func (conf Conf) IsNewestFirst() bool {
+	return conf.ProcessOrder == ProcessOrderNewest
+}
+
+// CursorKey returns a Redis key where the cleanup cursor is stored.
+// Each process order uses its own key so switching between them
+// does not break the other order's progress.
+func (conf Conf) CursorKey() string {
+	if conf.IsNewestFirst() {
+		return conf.StatusKey + "_" + ProcessOrderNewest
+	}
+	return conf.StatusKey
+}
+
 func (conf *Conf) ValidateAndDefaults(opsCheckIntervalSecs int) error {
 	if conf == nil {
 		return fmt.Errorf("missing `cleaner` section")
@@ -83,10 +124,31 @@ func (conf *Conf) ValidateAndDefaults(opsCheckIntervalSecs int) error {
 			Int("value", conf.NumProcessItemsPerTickNight).
 			Msg("cleanup configuration `numProcessItemsPerTickNight` not defined - using calculated default")
 	}
+	if conf.NumWorkers < 0 || conf.NumWorkers > maxNumWorkers {
+		return fmt.Errorf("invalid value for numWorkers (must be between 1 and %d)", maxNumWorkers)
+	}
+	if conf.NumWorkers == 0 {
+		conf.NumWorkers = dfltNumWorkers
+		log.Warn().
+			Int("value", conf.NumWorkers).
+			Msg("cleanup configuration `numWorkers` not defined - using default")
+	}
 	if conf.StatusKey == "" {
 		log.Warn().Str("value", dfltStatusKey).Msg("cleanup configuration `statusKey` missing, using default")
 		conf.StatusKey = dfltStatusKey
 	}
+	if conf.ProcessOrder == "" {
+		conf.ProcessOrder = ProcessOrderOldest
+		log.Warn().
+			Str("value", conf.ProcessOrder).
+			Msg("cleanup configuration `processOrder` not defined - using default")
+	}
+	if conf.ProcessOrder != ProcessOrderOldest && conf.ProcessOrder != ProcessOrderNewest {
+		return fmt.Errorf(
+			"invalid value `%s` for processOrder (must be either `%s` or `%s`)",
+			conf.ProcessOrder, ProcessOrderOldest, ProcessOrderNewest,
+		)
+	}
 	if conf.MinAgeDaysUnvisited < minAgeDaysUnvisitedLimit {
 		return fmt.Errorf("cleanup configuration `minAgeDaysUnvisited` invalid (must be >= %d)", minAgeDaysUnvisitedLimit)
 	}
@@ -19,16 +19,30 @@ package cncdb
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	// MaxDaytimeDailyCountsRange is the longest interval we allow
+	// to be processed by GetArchCountsByDay outside night time
+	MaxDaytimeDailyCountsRange = 31 * 24 * time.Hour
+)
+
 var (
 	ErrTooDemandingQuery = errors.New("too demanding query")
 )
 
+// IsTooDemandingDailyCountsRange tests whether counts by day within
+// [from, to) cannot be calculated at the time `now` (i.e. the interval
+// is longer than MaxDaytimeDailyCountsRange and it is not a night time).
+func IsTooDemandingDailyCountsRange(from, to, now time.Time) bool {
+	return to.Sub(from) > MaxDaytimeDailyCountsRange && !TimeIsAtNight(now)
+}
+
 func TimeIsAtNight(t time.Time) bool {
 	return t.Hour() >= 22 || t.Hour() <= 5
 }
@@ -55,19 +69,25 @@ func MergeRecords(recs []ArchRecord, newRec ArchRecord, tz *time.Location) ArchR
 	return ans
 }
 
+// ValidateQueryInstances tests whether all the variants of an archive
+// record (i.e. rows sharing the same ID) represent the same query, i.e.
+// they share both the query and the list of corpora.
 func ValidateQueryInstances(variants []ArchRecord) error {
 	if len(variants) < 2 {
 		return nil
 	}
 	queryVariants := make(map[string]int)
+	corporaVariants := make(map[string]int)
 	for _, vr := range variants {
 		data, err := vr.FetchData()
 		if err != nil {
 			// failed to parse data => still a variant of data
 			u := uuid.New()
 			queryVariants[u.String()]++
+			continue
 		}
 		queryVariants[strings.Join(data.GetQuery(), " ")]++
+		corporaVariants[strings.Join(data.GetCorpora(), ", ")]++
 	}
 	if len(queryVariants) > 1 {
 		return fmt.Errorf(
@@ -76,5 +96,17 @@ func ValidateQueryInstances(variants []ArchRecord) error {
 			len(queryVariants),
 		)
 	}
+	if len(corporaVariants) > 1 {
+		conflicting := make([]string, 0, len(corporaVariants))
+		for k := range corporaVariants {
+			conflicting = append(conflicting, "["+k+"]")
+		}
+		sort.Strings(conflicting)
+		return fmt.Errorf(
+			"inconsistent variants of corpora between instances (id %s) - found %s",
+			variants[0].ID,
+			strings.Join(conflicting, ", "),
+		)
+	}
 	return nil
 }
@@ -0,0 +1,59 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateQueryInstancesConsistent(t *testing.T) {
+	variants := []ArchRecord{
+		{ID: "abc", Data: `{"q": ["aword,[word=\"x\"]"], "corpora": ["syn2020", "intercorp_en"]}`},
+		{ID: "abc", Data: `{"q": ["aword,[word=\"x\"]"], "corpora": ["syn2020", "intercorp_en"]}`},
+	}
+	assert.NoError(t, ValidateQueryInstances(variants))
+}
+
+func TestValidateQueryInstancesInconsistentCorpora(t *testing.T) {
+	variants := []ArchRecord{
+		{ID: "abc", Data: `{"q": ["aword,[word=\"x\"]"], "corpora": ["syn2020"]}`},
+		{ID: "abc", Data: `{"q": ["aword,[word=\"x\"]"], "corpora": ["syn2015"]}`},
+	}
+	err := ValidateQueryInstances(variants)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "[syn2015], [syn2020]")
+}
+
+func TestValidateQueryInstancesInconsistentQuery(t *testing.T) {
+	variants := []ArchRecord{
+		{ID: "abc", Data: `{"q": ["aword,[word=\"x\"]"], "corpora": ["syn2020"]}`},
+		{ID: "abc", Data: `{"q": ["aword,[word=\"y\"]"], "corpora": ["syn2020"]}`},
+	}
+	assert.Error(t, ValidateQueryInstances(variants))
+}
+
+func TestIsTooDemandingDailyCountsRange(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+	assert.False(t, IsTooDemandingDailyCountsRange(from, from.AddDate(0, 0, 31), day))
+	assert.True(t, IsTooDemandingDailyCountsRange(from, from.AddDate(0, 0, 32), day))
+	assert.False(t, IsTooDemandingDailyCountsRange(from, from.AddDate(0, 0, 32), night))
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	DataCompressionNone = ""
+	DataCompressionGzip = "gzip"
+
+	// gzipDataPrefix marks compressed values of the archive data column.
+	// As the column is a text one, the compressed data are base64 encoded.
+	// A valid JSON record can never start with the prefix so plain
+	// and compressed records can be mixed in the table.
+	gzipDataPrefix = "camus:gzip:"
+)
+
+// dataCodec handles (optional) compression of the archive data column
+type dataCodec struct {
+	compression string
+	minSize     int
+}
+
+// encode compresses data in case the compression is enabled and the data
+// are at least minSize bytes long. Otherwise, data are returned as they are.
+func (dc dataCodec) encode(data string) (string, error) {
+	if dc.compression != DataCompressionGzip || len(data) < dc.minSize {
+		return data, nil
+	}
+	var buff bytes.Buffer
+	zw := gzip.NewWriter(&buff)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		return "", fmt.Errorf("failed to compress record data: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return "", fmt.Errorf("failed to compress record data: %w", err)
+	}
+	return gzipDataPrefix + base64.StdEncoding.EncodeToString(buff.Bytes()), nil
+}
+
+// decodeData decompresses data encoded by dataCodec.encode. Data without
+// the compression prefix are returned as they are. Decoding does not depend
+// on the current configuration as previously compressed records may
+// remain in the database even if the compression is disabled.
+func decodeData(data string) (string, error) {
+	encoded, ok := strings.CutPrefix(data, gzipDataPrefix)
+	if !ok {
+		return data, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress record data: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress record data: %w", err)
+	}
+	defer zr.Close()
+	ans, err := io.ReadAll(zr)
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress record data: %w", err)
+	}
+	return string(ans), nil
+}
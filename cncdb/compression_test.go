@@ -0,0 +1,67 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataCodecRoundTrip(t *testing.T) {
+	codec := dataCodec{compression: DataCompressionGzip, minSize: 100}
+	data := `{"q": ["` + strings.Repeat("aword,", 200) + `"]}`
+	enc, err := codec.encode(data)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(enc, gzipDataPrefix))
+	assert.Less(t, len(enc), len(data))
+	dec, err := decodeData(enc)
+	assert.NoError(t, err)
+	assert.Equal(t, data, dec)
+}
+
+func TestDataCodecSkipsSmallData(t *testing.T) {
+	codec := dataCodec{compression: DataCompressionGzip, minSize: 100}
+	enc, err := codec.encode(`{"q": []}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"q": []}`, enc)
+}
+
+func TestDataCodecDisabled(t *testing.T) {
+	data := `{"q": ["` + strings.Repeat("aword,", 200) + `"]}`
+	enc, err := dataCodec{}.encode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, data, enc)
+}
+
+func TestDecodePlainData(t *testing.T) {
+	dec, err := decodeData(`{"q": []}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"q": []}`, dec)
+
+	_, err = decodeData(gzipDataPrefix + "not-base64!")
+	assert.Error(t, err)
+}
+
+func TestDBConfDataCompression(t *testing.T) {
+	conf := DBConf{DataCompression: "zstd"}
+	assert.Error(t, conf.ValidateAndDefaults())
+	conf = DBConf{DataCompression: DataCompressionGzip}
+	assert.NoError(t, conf.ValidateAndDefaults())
+	assert.Equal(t, dfltDataCompressionMinSize, conf.DataCompressionMinSize)
+}
@@ -17,6 +17,7 @@
 package cncdb
 
 import (
+	"context"
 	"database/sql"
 	"time"
 )
@@ -37,6 +38,10 @@ func (dsql *DummyConcArchSQL) LoadRecordsFromDate(fromDate time.Time, maxItems i
 	return []ArchRecord{}, nil
 }
 
+func (dsql *DummyConcArchSQL) LoadRecordsToDate(toDate time.Time, maxItems int) ([]ArchRecord, error) {
+	return []ArchRecord{}, nil
+}
+
 func (dsql *DummyConcArchSQL) ContainsRecord(concID string) (bool, error) {
 	return false, nil
 }
@@ -45,10 +50,34 @@ func (dsql *DummyConcArchSQL) LoadRecordsByID(concID string) ([]ArchRecord, erro
 	return []ArchRecord{}, nil
 }
 
+func (dsql *DummyConcArchSQL) LoadRecordsByIDCtx(ctx context.Context, concID string) ([]ArchRecord, error) {
+	return []ArchRecord{}, nil
+}
+
+func (dsql *DummyConcArchSQL) LoadRecordsByIDs(ids []string) (map[string][]ArchRecord, error) {
+	return map[string][]ArchRecord{}, nil
+}
+
 func (dsql *DummyConcArchSQL) InsertRecord(rec ArchRecord) error {
 	return nil
 }
 
+func (dsql *DummyConcArchSQL) InsertRecordCtx(ctx context.Context, rec ArchRecord) error {
+	return nil
+}
+
+func (dsql *DummyConcArchSQL) InsertRecordIfMissing(rec ArchRecord) (bool, error) {
+	return true, nil
+}
+
+func (dsql *DummyConcArchSQL) InsertRecordIfMissingCtx(ctx context.Context, rec ArchRecord) (bool, error) {
+	return true, nil
+}
+
+func (dsql *DummyConcArchSQL) InsertRecordsIfMissing(recs []ArchRecord) (int, error) {
+	return len(recs), nil
+}
+
 func (dsql *DummyConcArchSQL) UpdateRecordStatus(id string, status int) error {
 	return nil
 }
@@ -61,10 +90,23 @@ func (dsql *DummyConcArchSQL) DeduplicateInArchive(curr []ArchRecord, rec ArchRe
 	return ArchRecord{}, nil
 }
 
+func (dsql *DummyConcArchSQL) DeduplicateInArchiveCtx(
+	ctx context.Context, curr []ArchRecord, rec ArchRecord) (ArchRecord, error) {
+	return ArchRecord{}, nil
+}
+
 func (dsql *DummyConcArchSQL) GetArchSizesByYears(forceLoad bool) ([][2]int, error) {
 	return [][2]int{}, nil
 }
 
+func (dsql *DummyConcArchSQL) GetArchCountsByDay(from, to time.Time) ([]DayCount, error) {
+	return []DayCount{}, nil
+}
+
+func (dsql *DummyConcArchSQL) EstimateRecordCount() (int64, error) {
+	return 0, nil
+}
+
 func (dsql *DummyConcArchSQL) GetSubcorpusProps(subcID string) (SubcProps, error) {
 	return SubcProps{}, nil
 }
@@ -91,6 +133,10 @@ func (dsql *DummyQHistSQL) LoadRecentNHistory(num int) ([]HistoryRecord, error)
 	return []HistoryRecord{}, nil
 }
 
+func (dsql *DummyQHistSQL) LoadHistoryBetween(from, to int64, num int) ([]HistoryRecord, error) {
+	return []HistoryRecord{}, nil
+}
+
 func (dsql *DummyQHistSQL) GarbageCollectRecords(userID int) (int64, error) {
 	return 0, nil
 }
@@ -109,3 +155,7 @@ func (dsql *DummyQHistSQL) GetPendingDeletionRecords(tx *sql.Tx, maxItems int) (
 func (dsql *DummyQHistSQL) TableSize() (int64, error) {
 	return 0, nil
 }
+
+func (dsql *DummyQHistSQL) CountPendingDeletion() (int64, error) {
+	return 0, nil
+}
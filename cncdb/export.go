@@ -0,0 +1,335 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dfltExportChunkSize = 1000
+
+	// maxExportChunkSize limits how much we can grow a chunk in case
+	// there are too many records with the same creation time
+	maxExportChunkSize = 1000000
+)
+
+var ErrExportOutputExists = errors.New("export output file already exists")
+
+// ExportedRecord is a portable representation of an archive record
+// as written by ExportRecords (one JSON object per line).
+type ExportedRecord struct {
+	ID         string    `json:"id"`
+	Data       string    `json:"data"`
+	Created    time.Time `json:"created"`
+	NumAccess  int       `json:"numAccess"`
+	LastAccess time.Time `json:"lastAccess"`
+	Permanent  int       `json:"permanent"`
+}
+
+func newExportedRecord(rec ArchRecord) ExportedRecord {
+	return ExportedRecord{
+		ID:         rec.ID,
+		Data:       rec.Data,
+		Created:    rec.Created,
+		NumAccess:  rec.NumAccess,
+		LastAccess: rec.LastAccess,
+		Permanent:  rec.Permanent,
+	}
+}
+
+// ExportConf specifies an export of archive records
+// created within [From, To). A zero To means "now" (or the
+// original range end in case an export is resumed).
+type ExportConf struct {
+	From time.Time
+	To   time.Time
+
+	// OutPath is the output JSONL file. The export state is stored
+	// in a file with the same path and the `.checkpoint` suffix.
+	OutPath string
+
+	// Gzip enables compression of the output. The file is written
+	// as a sequence of gzip members (one per chunk) which is a valid
+	// gzip stream for common tools (zcat, Go's gzip.Reader).
+	Gzip bool
+
+	// ChunkSize specifies how many records are loaded from
+	// the database at once
+	ChunkSize int
+
+	// ForceRestart removes the state of a previous (possibly
+	// unfinished) export and starts from scratch
+	ForceRestart bool
+}
+
+func (conf ExportConf) CheckpointPath() string {
+	return conf.OutPath + ".checkpoint"
+}
+
+// ExportCheckpoint is the state of a running export. It is stored
+// after each chunk so an interrupted export can be resumed.
+type ExportCheckpoint struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	Gzip bool      `json:"gzip"`
+
+	// Cursor is the creation time of the last exported record
+	Cursor time.Time `json:"cursor"`
+
+	// IDsAtCursor contains IDs of already exported records created
+	// exactly at Cursor (there may be more records with the same time)
+	IDsAtCursor []string `json:"idsAtCursor"`
+
+	// OutputSize is the size of the output file after the last
+	// finished chunk. Anything written after that is discarded
+	// on resume.
+	OutputSize int64 `json:"outputSize"`
+
+	NumExported int `json:"numExported"`
+}
+
+func (cp *ExportCheckpoint) matches(conf ExportConf) bool {
+	return cp.From.Equal(conf.From) && cp.To.Equal(conf.To) && cp.Gzip == conf.Gzip
+}
+
+// update moves the cursor after the provided (already written) records
+func (cp *ExportCheckpoint) update(recs []ArchRecord) {
+	for _, rec := range recs {
+		if !rec.Created.Equal(cp.Cursor) {
+			cp.Cursor = rec.Created
+			cp.IDsAtCursor = cp.IDsAtCursor[:0]
+		}
+		cp.IDsAtCursor = append(cp.IDsAtCursor, rec.ID)
+	}
+	cp.NumExported += len(recs)
+}
+
+func loadExportCheckpoint(path string) (*ExportCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load export checkpoint: %w", err)
+	}
+	var ans ExportCheckpoint
+	if err := json.Unmarshal(data, &ans); err != nil {
+		return nil, fmt.Errorf("failed to load export checkpoint: %w", err)
+	}
+	return &ans, nil
+}
+
+func storeExportCheckpoint(path string, cp *ExportCheckpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("failed to store export checkpoint: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to store export checkpoint: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to store export checkpoint: %w", err)
+	}
+	return nil
+}
+
+// nextExportChunk loads records following the checkpoint cursor.
+// As LoadRecordsFromDate is inclusive, already exported records
+// created at the cursor time are skipped. If a whole chunk consists
+// of such records, the chunk is enlarged so the export cannot get
+// stuck. The returned flag is true once there are no more records
+// within the exported range.
+func nextExportChunk(
+	db IConcArchOps,
+	cp *ExportCheckpoint,
+	chunkSize int,
+) ([]ArchRecord, bool, error) {
+	for {
+		recs, err := db.LoadRecordsFromDate(cp.Cursor, chunkSize)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to load records to export: %w", err)
+		}
+		ans := make([]ArchRecord, 0, len(recs))
+		finished := len(recs) < chunkSize
+		for _, rec := range recs {
+			if !rec.Created.Before(cp.To) {
+				finished = true
+				break
+			}
+			if rec.Created.Equal(cp.Cursor) && slices.Contains(cp.IDsAtCursor, rec.ID) {
+				continue
+			}
+			ans = append(ans, rec)
+		}
+		if len(ans) > 0 || finished {
+			return ans, finished, nil
+		}
+		if chunkSize >= maxExportChunkSize {
+			return nil, false, fmt.Errorf(
+				"failed to load records to export: more than %d records created at %s",
+				maxExportChunkSize, cp.Cursor)
+		}
+		chunkSize = min(chunkSize*2, maxExportChunkSize)
+	}
+}
+
+// writeExportChunk writes records to w as JSON lines
+// (compressed as a single gzip member if requested)
+func writeExportChunk(w io.Writer, recs []ArchRecord, useGzip bool) error {
+	bw := bufio.NewWriter(w)
+	var out io.Writer = bw
+	var gz *gzip.Writer
+	if useGzip {
+		gz = gzip.NewWriter(bw)
+		out = gz
+	}
+	enc := json.NewEncoder(out)
+	for _, rec := range recs {
+		if err := enc.Encode(newExportedRecord(rec)); err != nil {
+			return fmt.Errorf("failed to write record %s: %w", rec.ID, err)
+		}
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("failed to write chunk: %w", err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	return nil
+}
+
+// ExportRecords streams archive records created within the configured
+// range to a JSONL file. Records are loaded in chunks so the memory
+// usage is bounded by the chunk size. After each chunk, a checkpoint is
+// stored and in case the export is interrupted (including Ctrl+C),
+// a repeated call with the same configuration resumes it. Once the
+// export is finished, the checkpoint is removed.
+func ExportRecords(ctx context.Context, db IConcArchOps, conf ExportConf) (ExportCheckpoint, error) {
+	if conf.ChunkSize <= 0 {
+		conf.ChunkSize = dfltExportChunkSize
+	}
+	if conf.ForceRestart {
+		if err := os.Remove(conf.CheckpointPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return ExportCheckpoint{}, fmt.Errorf("failed to remove export checkpoint: %w", err)
+		}
+	}
+	cp, err := loadExportCheckpoint(conf.CheckpointPath())
+	if err != nil {
+		return ExportCheckpoint{}, err
+	}
+	if conf.To.IsZero() {
+		if cp != nil {
+			conf.To = cp.To
+
+		} else {
+			conf.To = time.Now()
+		}
+	}
+	if !conf.To.After(conf.From) {
+		return ExportCheckpoint{}, fmt.Errorf("invalid export range: `to` must be after `from`")
+	}
+	if cp != nil {
+		if !cp.matches(conf) {
+			return *cp, fmt.Errorf(
+				"existing export checkpoint does not match the requested range or compression (use force restart)")
+		}
+		log.Info().
+			Time("cursor", cp.Cursor).
+			Int("numExported", cp.NumExported).
+			Msg("resuming archive export")
+
+	} else {
+		if _, err := os.Stat(conf.OutPath); err == nil && !conf.ForceRestart {
+			return ExportCheckpoint{}, fmt.Errorf("%w: %s", ErrExportOutputExists, conf.OutPath)
+		}
+		cp = &ExportCheckpoint{
+			From:        conf.From,
+			To:          conf.To,
+			Gzip:        conf.Gzip,
+			Cursor:      conf.From,
+			IDsAtCursor: []string{},
+		}
+	}
+
+	f, err := os.OpenFile(conf.OutPath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return *cp, fmt.Errorf("failed to open export output: %w", err)
+	}
+	defer f.Close()
+	// remove possible data written after the last stored checkpoint
+	if err := f.Truncate(cp.OutputSize); err != nil {
+		return *cp, fmt.Errorf("failed to prepare export output: %w", err)
+	}
+	if _, err := f.Seek(cp.OutputSize, io.SeekStart); err != nil {
+		return *cp, fmt.Errorf("failed to prepare export output: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return *cp, ctx.Err()
+		default:
+		}
+		recs, finished, err := nextExportChunk(db, cp, conf.ChunkSize)
+		if err != nil {
+			return *cp, err
+		}
+		if len(recs) > 0 {
+			if err := writeExportChunk(f, recs, conf.Gzip); err != nil {
+				return *cp, err
+			}
+			if err := f.Sync(); err != nil {
+				return *cp, fmt.Errorf("failed to write chunk: %w", err)
+			}
+			pos, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return *cp, fmt.Errorf("failed to write chunk: %w", err)
+			}
+			cp.OutputSize = pos
+			cp.update(recs)
+			if err := storeExportCheckpoint(conf.CheckpointPath(), cp); err != nil {
+				return *cp, err
+			}
+			log.Info().
+				Time("cursor", cp.Cursor).
+				Int("numExported", cp.NumExported).
+				Msg("exported chunk of archive records")
+		}
+		if finished {
+			break
+		}
+	}
+	if err := os.Remove(conf.CheckpointPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return *cp, fmt.Errorf("failed to remove export checkpoint: %w", err)
+	}
+	return *cp, nil
+}
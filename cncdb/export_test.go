@@ -0,0 +1,186 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sortedRecsConcArch mimics LoadRecordsFromDate of the MySQL
+// implementation over a list of records sorted by creation time
+type sortedRecsConcArch struct {
+	DummyConcArchSQL
+	recs     []ArchRecord
+	numCalls int
+
+	// failAtCall makes the n-th call (1-based) fail
+	failAtCall int
+}
+
+func (db *sortedRecsConcArch) LoadRecordsFromDate(fromDate time.Time, maxItems int) ([]ArchRecord, error) {
+	db.numCalls++
+	if db.numCalls == db.failAtCall {
+		return nil, errors.New("connection lost")
+	}
+	ans := make([]ArchRecord, 0, maxItems)
+	for _, rec := range db.recs {
+		if len(ans) == maxItems {
+			break
+		}
+		if !rec.Created.Before(fromDate) {
+			ans = append(ans, rec)
+		}
+	}
+	return ans, nil
+}
+
+func newSortedRecsConcArch(t0 time.Time) *sortedRecsConcArch {
+	ans := &sortedRecsConcArch{}
+	for i := 0; i < 20; i++ {
+		ans.recs = append(ans.recs, ArchRecord{
+			ID:      fmt.Sprintf("rec%02d", i),
+			Data:    fmt.Sprintf(`{"id":"rec%02d"}`, i),
+			Created: t0.Add(time.Duration(i/5) * time.Hour), // 5 records per each time
+		})
+	}
+	return ans
+}
+
+func readExported(t *testing.T, path string, useGzip bool) []ExportedRecord {
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	var src io.Reader = f
+	if useGzip {
+		gz, err := gzip.NewReader(f)
+		assert.NoError(t, err)
+		src = gz
+	}
+	ans := make([]ExportedRecord, 0, 20)
+	scanner := bufio.NewScanner(src)
+	for scanner.Scan() {
+		var rec ExportedRecord
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		ans = append(ans, rec)
+	}
+	assert.NoError(t, scanner.Err())
+	return ans
+}
+
+func TestExportRecordsRange(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	db := newSortedRecsConcArch(t0)
+	conf := ExportConf{
+		From:      t0.Add(time.Hour),
+		To:        t0.Add(3 * time.Hour),
+		OutPath:   filepath.Join(t.TempDir(), "export.jsonl"),
+		ChunkSize: 3,
+	}
+	summary, err := ExportRecords(context.Background(), db, conf)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, summary.NumExported)
+	recs := readExported(t, conf.OutPath, false)
+	if assert.Len(t, recs, 10) {
+		for i, rec := range recs {
+			assert.Equal(t, fmt.Sprintf("rec%02d", i+5), rec.ID)
+			assert.Equal(t, fmt.Sprintf(`{"id":"rec%02d"}`, i+5), rec.Data)
+		}
+	}
+	_, err = os.Stat(conf.CheckpointPath())
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// a finished export is not overwritten by accident
+	_, err = ExportRecords(context.Background(), db, conf)
+	assert.ErrorIs(t, err, ErrExportOutputExists)
+}
+
+func TestExportRecordsSameTimeExceedsChunk(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	db := newSortedRecsConcArch(t0)
+	conf := ExportConf{
+		From:      t0,
+		To:        t0.Add(24 * time.Hour),
+		OutPath:   filepath.Join(t.TempDir(), "export.jsonl"),
+		ChunkSize: 2,
+	}
+	summary, err := ExportRecords(context.Background(), db, conf)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, summary.NumExported)
+	assert.Len(t, readExported(t, conf.OutPath, false), 20)
+}
+
+func TestExportRecordsResumeGzip(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	db := newSortedRecsConcArch(t0)
+	db.failAtCall = 4
+	conf := ExportConf{
+		From:      t0,
+		To:        t0.Add(24 * time.Hour),
+		OutPath:   filepath.Join(t.TempDir(), "export.jsonl.gz"),
+		Gzip:      true,
+		ChunkSize: 4,
+	}
+	summary, err := ExportRecords(context.Background(), db, conf)
+	assert.Error(t, err)
+	assert.Equal(t, 8, summary.NumExported)
+	_, err = os.Stat(conf.CheckpointPath())
+	assert.NoError(t, err)
+
+	// simulate garbage written by an interrupted chunk
+	f, err := os.OpenFile(conf.OutPath, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	f.Write([]byte("garbage"))
+	f.Close()
+
+	_, err = ExportRecords(context.Background(), db, ExportConf{
+		From: conf.From, To: conf.To, OutPath: conf.OutPath, ChunkSize: 4})
+	assert.Error(t, err, "checkpoint must not be used with different settings")
+
+	// the original range end is used when resuming without one
+	conf.To = time.Time{}
+	summary, err = ExportRecords(context.Background(), db, conf)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, summary.NumExported)
+	recs := readExported(t, conf.OutPath, true)
+	if assert.Len(t, recs, 20) {
+		for i, rec := range recs {
+			assert.Equal(t, fmt.Sprintf("rec%02d", i), rec.ID)
+		}
+	}
+}
+
+func TestExportRecordsInvalidRange(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	_, err := ExportRecords(context.Background(), &sortedRecsConcArch{}, ExportConf{
+		From:    t0,
+		To:      t0,
+		OutPath: filepath.Join(t.TempDir(), "export.jsonl"),
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dfltImportChunkSize = 500
+
+	// maxImportLineSize is the maximum size of a single
+	// exported record (i.e. a line of the input file)
+	maxImportLineSize = 64 * 1024 * 1024
+)
+
+var errInvalidImportedRecord = errors.New("invalid record")
+
+// ImportConf specifies an import of archive records
+// previously exported by ExportRecords.
+type ImportConf struct {
+
+	// InPath is the input JSONL file. Gzipped files are
+	// detected automatically.
+	InPath string
+
+	// ChunkSize specifies how many records are inserted
+	// within a single transaction
+	ChunkSize int
+}
+
+// ImportSummary provides numbers of processed records
+// by ImportRecords
+type ImportSummary struct {
+	NumInserted int `json:"numInserted"`
+
+	// NumSkipped is the number of records with an ID
+	// already present in the archive
+	NumSkipped int `json:"numSkipped"`
+
+	// NumInvalid is the number of lines which could not be
+	// imported (invalid JSON, missing ID etc.)
+	NumInvalid int `json:"numInvalid"`
+}
+
+func (rec ExportedRecord) toArchRecord() (ArchRecord, error) {
+	if rec.ID == "" {
+		return ArchRecord{}, fmt.Errorf("%w: missing id", errInvalidImportedRecord)
+	}
+	if rec.Created.IsZero() {
+		return ArchRecord{}, fmt.Errorf("%w: missing creation time", errInvalidImportedRecord)
+	}
+	if !json.Valid([]byte(rec.Data)) {
+		return ArchRecord{}, fmt.Errorf("%w: data is not a valid JSON", errInvalidImportedRecord)
+	}
+	return ArchRecord{
+		ID:         rec.ID,
+		Data:       rec.Data,
+		Created:    rec.Created,
+		NumAccess:  rec.NumAccess,
+		LastAccess: rec.LastAccess,
+		Permanent:  rec.Permanent,
+	}, nil
+}
+
+func parseImportedRecord(line []byte) (ArchRecord, error) {
+	var exported ExportedRecord
+	if err := json.Unmarshal(line, &exported); err != nil {
+		return ArchRecord{}, fmt.Errorf("%w: %s", errInvalidImportedRecord, err)
+	}
+	return exported.toArchRecord()
+}
+
+// openImportInput opens an input file, transparently
+// decompressing gzipped data
+func openImportInput(f *os.File) (io.Reader, error) {
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read import input: %w", err)
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read import input: %w", err)
+		}
+		return gz, nil
+	}
+	return br, nil
+}
+
+// ImportRecords reads archive records from a JSONL file (see
+// ExportRecords) and inserts them in chunks, each within a single
+// transaction. Records with an ID already present in the archive
+// are skipped so the import can be safely repeated (e.g. after
+// an interruption). Invalid lines are logged and skipped.
+func ImportRecords(ctx context.Context, db IConcArchOps, conf ImportConf) (ImportSummary, error) {
+	var ans ImportSummary
+	if conf.ChunkSize <= 0 {
+		conf.ChunkSize = dfltImportChunkSize
+	}
+	f, err := os.Open(conf.InPath)
+	if err != nil {
+		return ans, fmt.Errorf("failed to open import input: %w", err)
+	}
+	defer f.Close()
+	src, err := openImportInput(f)
+	if err != nil {
+		return ans, err
+	}
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineSize)
+
+	chunk := make([]ArchRecord, 0, conf.ChunkSize)
+	insertChunk := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		numInserted, err := db.InsertRecordsIfMissing(chunk)
+		if err != nil {
+			return fmt.Errorf("failed to import records: %w", err)
+		}
+		ans.NumInserted += numInserted
+		ans.NumSkipped += len(chunk) - numInserted
+		chunk = chunk[:0]
+		log.Info().
+			Int("numInserted", ans.NumInserted).
+			Int("numSkipped", ans.NumSkipped).
+			Msg("imported chunk of archive records")
+		return nil
+	}
+
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		rec, err := parseImportedRecord(scanner.Bytes())
+		if err != nil {
+			log.Error().Err(err).Int("line", lineNum).Msg("skipping invalid record")
+			ans.NumInvalid++
+			continue
+		}
+		chunk = append(chunk, rec)
+		if len(chunk) < conf.ChunkSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ans, ctx.Err()
+		default:
+		}
+		if err := insertChunk(); err != nil {
+			return ans, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return ans, fmt.Errorf("failed to read import input (line %d): %w", lineNum+1, err)
+	}
+	if err := insertChunk(); err != nil {
+		return ans, err
+	}
+	return ans, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type insertingConcArch struct {
+	DummyConcArchSQL
+	recs      map[string]ArchRecord
+	numChunks int
+}
+
+func (db *insertingConcArch) InsertRecordsIfMissing(recs []ArchRecord) (int, error) {
+	db.numChunks++
+	var ans int
+	for _, rec := range recs {
+		if _, ok := db.recs[rec.ID]; ok {
+			continue
+		}
+		db.recs[rec.ID] = rec
+		ans++
+	}
+	return ans, nil
+}
+
+func TestImportExportedRecords(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, useGzip := range []bool{false, true} {
+		outPath := filepath.Join(t.TempDir(), "export.jsonl")
+		_, err := ExportRecords(context.Background(), newSortedRecsConcArch(t0), ExportConf{
+			From:      t0,
+			To:        t0.Add(24 * time.Hour),
+			OutPath:   outPath,
+			Gzip:      useGzip,
+			ChunkSize: 7,
+		})
+		assert.NoError(t, err)
+
+		db := &insertingConcArch{recs: map[string]ArchRecord{"rec03": {ID: "rec03"}}}
+		summary, err := ImportRecords(context.Background(), db, ImportConf{InPath: outPath, ChunkSize: 6})
+		assert.NoError(t, err)
+		assert.Equal(t, ImportSummary{NumInserted: 19, NumSkipped: 1}, summary)
+		assert.Equal(t, 4, db.numChunks)
+		assert.Len(t, db.recs, 20)
+		assert.Equal(t, `{"id":"rec05"}`, db.recs["rec05"].Data)
+		assert.True(t, t0.Add(time.Hour).Equal(db.recs["rec05"].Created))
+	}
+}
+
+func TestImportInvalidRecords(t *testing.T) {
+	inPath := filepath.Join(t.TempDir(), "import.jsonl")
+	err := os.WriteFile(inPath, []byte(
+		`{"id":"a","data":"{\"q\":1}","created":"2024-03-01T10:00:00Z"}`+"\n"+
+			`{"id":"b","data":"{invalid","created":"2024-03-01T10:00:00Z"}`+"\n"+
+			`{"data":"{}","created":"2024-03-01T10:00:00Z"}`+"\n"+
+			`{"id":"c","data":"{}"}`+"\n"+
+			"not a json\n"+
+			"\n"+
+			`{"id":"d","data":"{}","created":"2024-03-01T10:00:00Z"}`+"\n",
+	), 0644)
+	assert.NoError(t, err)
+	db := &insertingConcArch{recs: map[string]ArchRecord{}}
+	summary, err := ImportRecords(context.Background(), db, ImportConf{InPath: inPath})
+	assert.NoError(t, err)
+	assert.Equal(t, ImportSummary{NumInserted: 2, NumInvalid: 4}, summary)
+	assert.Contains(t, db.recs, "a")
+	assert.Contains(t, db.recs, "d")
+}
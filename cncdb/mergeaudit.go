@@ -0,0 +1,149 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// MergeAudit describes a single merge of archive record variants
+// (see DeduplicateInArchive and MergeRecords)
+type MergeAudit struct {
+	Time     time.Time `json:"time"`
+	RecordID string    `json:"recordId"`
+
+	// NumVariants is the number of existing variants the base was merged with
+	NumVariants int `json:"numVariants"`
+
+	// BaseCreated and BaseNumAccess describe the variant used
+	// as the base of the merge
+	BaseCreated   time.Time `json:"baseCreated"`
+	BaseNumAccess int       `json:"baseNumAccess"`
+
+	ResultCreated    time.Time `json:"resultCreated"`
+	ResultNumAccess  int       `json:"resultNumAccess"`
+	ResultLastAccess time.Time `json:"resultLastAccess"`
+	ResultPermanent  int       `json:"resultPermanent"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// MergeAuditLog keeps the last N merge audits in memory
+type MergeAuditLog struct {
+	items []MergeAudit
+	next  int
+	full  bool
+	tz    *time.Location
+	mu    sync.Mutex
+}
+
+func (mal *MergeAuditLog) add(item MergeAudit) {
+	mal.mu.Lock()
+	defer mal.mu.Unlock()
+	mal.items[mal.next] = item
+	mal.next = (mal.next + 1) % len(mal.items)
+	if mal.next == 0 {
+		mal.full = true
+	}
+}
+
+// Recent returns up to `limit` most recent audits (newest first).
+// For a nil log (i.e. with the audit disabled), an empty slice
+// is returned.
+func (mal *MergeAuditLog) Recent(limit int) []MergeAudit {
+	if mal == nil {
+		return []MergeAudit{}
+	}
+	mal.mu.Lock()
+	defer mal.mu.Unlock()
+	size := mal.next
+	if mal.full {
+		size = len(mal.items)
+	}
+	size = min(size, limit)
+	ans := make([]MergeAudit, 0, size)
+	for i := 1; i <= size; i++ {
+		ans = append(ans, mal.items[(mal.next-i+len(mal.items))%len(mal.items)])
+	}
+	return ans
+}
+
+func NewMergeAuditLog(numRetain int, tz *time.Location) *MergeAuditLog {
+	return &MergeAuditLog{items: make([]MergeAudit, max(numRetain, 1)), tz: tz}
+}
+
+// AuditedConcArchOps wraps an IConcArchOps instance and logs all
+// the merges performed by DeduplicateInArchive so it is possible
+// to explain resulting values of merged records.
+type AuditedConcArchOps struct {
+	IConcArchOps
+	auditLog *MergeAuditLog
+}
+
+func (db *AuditedConcArchOps) DeduplicateInArchive(curr []ArchRecord, rec ArchRecord) (ArchRecord, error) {
+	ans, err := db.IConcArchOps.DeduplicateInArchive(curr, rec)
+	return db.audit(curr, rec, ans, err)
+}
+
+func (db *AuditedConcArchOps) DeduplicateInArchiveCtx(
+	ctx context.Context, curr []ArchRecord, rec ArchRecord) (ArchRecord, error) {
+	ans, err := db.IConcArchOps.DeduplicateInArchiveCtx(ctx, curr, rec)
+	return db.audit(curr, rec, ans, err)
+}
+
+// audit stores information about a finished merge and passes
+// the merge result through
+func (db *AuditedConcArchOps) audit(
+	curr []ArchRecord, rec, ans ArchRecord, err error) (ArchRecord, error) {
+	if err == nil && ans.ID == "" {
+		return ans, err // nothing actually merged (e.g. a dry run)
+	}
+	audit := MergeAudit{
+		Time:             time.Now().In(db.auditLog.tz),
+		RecordID:         rec.ID,
+		NumVariants:      len(curr),
+		BaseCreated:      rec.Created,
+		BaseNumAccess:    rec.NumAccess,
+		ResultCreated:    ans.Created,
+		ResultNumAccess:  ans.NumAccess,
+		ResultLastAccess: ans.LastAccess,
+		ResultPermanent:  ans.Permanent,
+	}
+	if err != nil {
+		audit.Error = err.Error()
+	}
+	db.auditLog.add(audit)
+	log.Info().
+		Str("recordId", audit.RecordID).
+		Int("numVariants", audit.NumVariants).
+		Time("baseCreated", audit.BaseCreated).
+		Int("baseNumAccess", audit.BaseNumAccess).
+		Int("resultNumAccess", audit.ResultNumAccess).
+		Time("resultLastAccess", audit.ResultLastAccess).
+		Str("error", audit.Error).
+		Msg("merge audit")
+	return ans, err
+}
+
+// NewAuditedConcArchOps creates a wrapper around db writing merge
+// audits to auditLog. Multiple wrappers may share a single log.
+func NewAuditedConcArchOps(db IConcArchOps, auditLog *MergeAuditLog) *AuditedConcArchOps {
+	return &AuditedConcArchOps{IConcArchOps: db, auditLog: auditLog}
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mergingConcArch struct {
+	IConcArchOps
+	err error
+}
+
+func (db *mergingConcArch) DeduplicateInArchive(curr []ArchRecord, rec ArchRecord) (ArchRecord, error) {
+	if db.err != nil {
+		return ArchRecord{}, db.err
+	}
+	return MergeRecords(curr, rec, time.UTC), nil
+}
+
+func TestAuditedConcArchOpsRecordsMerge(t *testing.T) {
+	auditLog := NewMergeAuditLog(10, time.UTC)
+	db := NewAuditedConcArchOps(&mergingConcArch{}, auditLog)
+	base := ArchRecord{ID: "abc", NumAccess: 1, Created: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}
+	curr := []ArchRecord{
+		{ID: "abc", NumAccess: 3, Created: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: "abc", NumAccess: 2, Created: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	_, err := db.DeduplicateInArchive(curr, base)
+	assert.NoError(t, err)
+	audits := auditLog.Recent(10)
+	assert.Len(t, audits, 1)
+	assert.Equal(t, "abc", audits[0].RecordID)
+	assert.Equal(t, 2, audits[0].NumVariants)
+	assert.Equal(t, base.Created, audits[0].BaseCreated)
+	assert.Equal(t, 1, audits[0].BaseNumAccess)
+	assert.Equal(t, 7, audits[0].ResultNumAccess)
+	assert.Equal(t, curr[0].Created, audits[0].ResultCreated)
+	assert.Empty(t, audits[0].Error)
+}
+
+func TestAuditedConcArchOpsRecordsError(t *testing.T) {
+	auditLog := NewMergeAuditLog(10, time.UTC)
+	db := NewAuditedConcArchOps(&mergingConcArch{err: errors.New("db error")}, auditLog)
+	_, err := db.DeduplicateInArchive([]ArchRecord{{ID: "abc"}}, ArchRecord{ID: "abc"})
+	assert.Error(t, err)
+	audits := auditLog.Recent(10)
+	assert.Len(t, audits, 1)
+	assert.Equal(t, "db error", audits[0].Error)
+}
+
+func TestMergeAuditLogRecent(t *testing.T) {
+	auditLog := NewMergeAuditLog(3, time.UTC)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		auditLog.add(MergeAudit{RecordID: id})
+	}
+	ids := make([]string, 0, 3)
+	for _, item := range auditLog.Recent(10) {
+		ids = append(ids, item.RecordID)
+	}
+	assert.Equal(t, []string{"d", "c", "b"}, ids)
+	assert.Len(t, auditLog.Recent(2), 2)
+}
+
+func TestMergeAuditLogNil(t *testing.T) {
+	var auditLog *MergeAuditLog
+	assert.Equal(t, []MergeAudit{}, auditLog.Recent(10))
+}
@@ -21,6 +21,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -28,7 +30,26 @@ import (
 )
 
 const (
-	maxRecentRecords = 1000
+	// MaxRecentRecords is the maximum number of records
+	// LoadRecentNRecords (and similar methods) can load at once
+	MaxRecentRecords = 1000
+
+	// maxIDsPerQuery limits number of placeholders
+	// in `WHERE id IN (...)` queries
+	maxIDsPerQuery = 500
+
+	dfltPoolSize            = 10
+	dfltConnMaxLifetimeSecs = 300
+
+	dfltArchTableName     = "kontext_conc_persistence"
+	dfltArchDataColumn    = "data"
+	dfltArchCreatedColumn = "created"
+
+	dfltDataCompressionMinSize = 4096
+)
+
+var (
+	validSQLIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
 )
 
 type DBConf struct {
@@ -38,6 +59,105 @@ type DBConf struct {
 	User     string `json:"user"`
 	Password string `json:"password"`
 	PoolSize int    `json:"poolSize"`
+
+	// ConnMaxLifetimeSecs specifies max. time a connection may
+	// be reused. It should be lower than MySQL's `wait_timeout`
+	// to prevent "stale connection" errors. Default is 300 seconds.
+	ConnMaxLifetimeSecs int `json:"connMaxLifetimeSecs"`
+
+	// ArchTableName, ArchDataColumn and ArchCreatedColumn allow
+	// for schema variations of the concordance archive table.
+	// When omitted, the standard KonText names are used.
+	ArchTableName     string `json:"archTableName"`
+	ArchDataColumn    string `json:"archDataColumn"`
+	ArchCreatedColumn string `json:"archCreatedColumn"`
+
+	// DataCompression specifies how Camus compresses the archive data
+	// column of newly inserted records. Currently, only `gzip` is supported.
+	// Empty value (default) means no compression. Compressed records are always
+	// decompressed transparently when loaded.
+	// Please note that KonText reads the same table and it must be able
+	// to decode such records too (they are stored as base64 encoded gzip data
+	// with the `camus:gzip:` prefix). Do not enable the option otherwise.
+	DataCompression string `json:"dataCompression"`
+
+	// DataCompressionMinSize specifies min. size (in bytes) of a record
+	// data to be compressed. Smaller records are stored as they are.
+	DataCompressionMinSize int `json:"dataCompressionMinSize"`
+
+	// ReadReplica optionally specifies a read replica of the database.
+	// If set, archive stats (sizes by years, daily counts, record count
+	// estimates) are always read from the replica. Records themselves are
+	// read from the replica only by components which do not write them
+	// back (the fulltext indexer when (re)indexing, the archive export).
+	// The archiver, the cleaner and record fixes always read from
+	// the primary database as they merge the loaded variants and replace
+	// them with the result - a stale read (due to the replication lag)
+	// would permanently delete variants written in the meantime.
+	// All the writes go to the primary database.
+	ReadReplica *DBConf `json:"readReplica"`
+}
+
+func (conf *DBConf) ValidateAndDefaults() error {
+	if conf == nil {
+		return fmt.Errorf("missing `db` section")
+	}
+	if conf.PoolSize < 0 {
+		return fmt.Errorf("invalid `db.poolSize` (must be > 0)")
+	}
+	if conf.PoolSize == 0 {
+		conf.PoolSize = dfltPoolSize
+		log.Warn().
+			Int("value", dfltPoolSize).
+			Msg("database configuration `poolSize` not specified, using default")
+	}
+	if conf.ConnMaxLifetimeSecs < 0 {
+		return fmt.Errorf("invalid `db.connMaxLifetimeSecs` (must be > 0)")
+	}
+	if conf.ConnMaxLifetimeSecs == 0 {
+		conf.ConnMaxLifetimeSecs = dfltConnMaxLifetimeSecs
+		log.Warn().
+			Int("value", dfltConnMaxLifetimeSecs).
+			Msg("database configuration `connMaxLifetimeSecs` not specified, using default")
+	}
+	if conf.ArchTableName == "" {
+		conf.ArchTableName = dfltArchTableName
+	}
+	if conf.ArchDataColumn == "" {
+		conf.ArchDataColumn = dfltArchDataColumn
+	}
+	if conf.ArchCreatedColumn == "" {
+		conf.ArchCreatedColumn = dfltArchCreatedColumn
+	}
+	if conf.DataCompression != DataCompressionNone && conf.DataCompression != DataCompressionGzip {
+		return fmt.Errorf("unsupported `db.dataCompression` value `%s`", conf.DataCompression)
+	}
+	if conf.DataCompressionMinSize < 0 {
+		return fmt.Errorf("invalid `db.dataCompressionMinSize` (must be >= 0)")
+	}
+	if conf.DataCompression != DataCompressionNone && conf.DataCompressionMinSize == 0 {
+		conf.DataCompressionMinSize = dfltDataCompressionMinSize
+		log.Warn().
+			Int("value", dfltDataCompressionMinSize).
+			Msg("database configuration `dataCompressionMinSize` not specified, using default")
+	}
+	for _, ident := range []string{conf.ArchTableName, conf.ArchDataColumn, conf.ArchCreatedColumn} {
+		if !validSQLIdentifier.MatchString(ident) {
+			return fmt.Errorf("invalid SQL identifier `%s` in `db` section", ident)
+		}
+	}
+	if conf.ReadReplica != nil {
+		if conf.ReadReplica.ReadReplica != nil {
+			return fmt.Errorf("`db.readReplica` cannot have its own `readReplica`")
+		}
+		if conf.ReadReplica.Host == "" {
+			return fmt.Errorf("missing `db.readReplica.host`")
+		}
+		if err := conf.ReadReplica.ValidateAndDefaults(); err != nil {
+			return fmt.Errorf("invalid `db.readReplica`: %w", err)
+		}
+	}
+	return nil
 }
 
 func DBOpen(conf *DBConf) (*sql.DB, error) {
@@ -54,6 +174,9 @@ func DBOpen(conf *DBConf) (*sql.DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to open sql database: %w", err)
 	}
+	db.SetMaxOpenConns(conf.PoolSize)
+	db.SetMaxIdleConns(conf.PoolSize)
+	db.SetConnMaxLifetime(time.Duration(conf.ConnMaxLifetimeSecs) * time.Second)
 	return db, nil
 }
 
@@ -65,6 +188,10 @@ func generateRows(sqlRows *sql.Rows, expectedSize int) ([]ArchRecord, error) {
 		if err != nil {
 			return []ArchRecord{}, fmt.Errorf("failed to load recent records: %w", err)
 		}
+		item.Data, err = decodeData(item.Data)
+		if err != nil {
+			return []ArchRecord{}, fmt.Errorf("failed to load record %s: %w", item.ID, err)
+		}
 		ans = append(ans, item)
 	}
 	return ans, nil
@@ -73,9 +200,67 @@ func generateRows(sqlRows *sql.Rows, expectedSize int) ([]ArchRecord, error) {
 // -----------------------------------------
 
 type MySQLConcArch struct {
-	db  *sql.DB
-	tz  *time.Location
+	db *sql.DB
+	tz *time.Location
+
+	// replicaDB is an optional read replica (see DBConf.ReadReplica)
+	replicaDB *sql.DB
+
+	// replicaRecordReads specifies whether also records (and not just
+	// stats) are read from replicaDB (see WithReplicaRecordReads)
+	replicaRecordReads bool
+
 	ctx context.Context
+
+	// table, dataCol and createdCol are validated
+	// identifiers (see DBConf.ValidateAndDefaults)
+	table      string
+	dataCol    string
+	createdCol string
+
+	codec dataCodec
+}
+
+// recCols returns a list of columns matching the order
+// expected by generateRows
+func (ops *MySQLConcArch) recCols() string {
+	return fmt.Sprintf(
+		"id, %s, %s, num_access, last_access, permanent", ops.dataCol, ops.createdCol)
+}
+
+// SetReadReplica sets a database used for read-heavy stats
+// operations instead of the primary one.
+func (ops *MySQLConcArch) SetReadReplica(db *sql.DB) {
+	ops.replicaDB = db
+}
+
+// WithReplicaRecordReads returns a copy of the adapter which reads
+// also the archive records from the read replica (if configured).
+// Never use it for operations which store modified records
+// back (e.g. deduplication), see DBConf.ReadReplica.
+func (ops *MySQLConcArch) WithReplicaRecordReads() *MySQLConcArch {
+	ans := *ops
+	ans.replicaRecordReads = true
+	return &ans
+}
+
+// readDB returns the read replica or, in case no replica
+// is configured, the primary database
+func (ops *MySQLConcArch) readDB() *sql.DB {
+	if ops.replicaDB != nil {
+		return ops.replicaDB
+	}
+	return ops.db
+}
+
+// recordReadDB returns a database for reading archive records.
+// Unless enabled by WithReplicaRecordReads, this is always
+// the primary database.
+func (ops *MySQLConcArch) recordReadDB() *sql.DB {
+	if ops.replicaRecordReads {
+		return ops.readDB()
+	}
+	return ops.db
 }
 
 func (ops *MySQLConcArch) NewTransaction() (*sql.Tx, error) {
@@ -87,15 +272,15 @@ func (ops *MySQLConcArch) LoadRecentNRecords(num int) ([]ArchRecord, error) {
 	// to avoid going through all the partitions (or is the query planner
 	// able to determine it from `order by created DESC limit X` ?)
 	helperLimit := time.Now().In(ops.tz).Add(-180 * 24 * time.Hour)
-	if num > maxRecentRecords {
-		panic(fmt.Sprintf("cannot load more than %d records at a time", maxRecentRecords))
+	if num > MaxRecentRecords {
+		panic(fmt.Sprintf("cannot load more than %d records at a time", MaxRecentRecords))
 	}
-	rows, err := ops.db.QueryContext(
+	rows, err := ops.recordReadDB().QueryContext(
 		ops.ctx,
-		"SELECT id, data, created, num_access, last_access, permanent "+
-			"FROM kontext_conc_persistence "+
-			"WHERE created >= ? "+
-			"ORDER BY created DESC LIMIT ?", helperLimit, num)
+		"SELECT "+ops.recCols()+" "+
+			"FROM "+ops.table+" "+
+			"WHERE "+ops.createdCol+" >= ? "+
+			"ORDER BY "+ops.createdCol+" DESC LIMIT ?", helperLimit, num)
 	if err != nil {
 		return []ArchRecord{}, fmt.Errorf("failed to load recent records: %w", err)
 	}
@@ -103,12 +288,25 @@ func (ops *MySQLConcArch) LoadRecentNRecords(num int) ([]ArchRecord, error) {
 }
 
 func (ops *MySQLConcArch) LoadRecordsFromDate(fromDate time.Time, maxItems int) ([]ArchRecord, error) {
-	rows, err := ops.db.QueryContext(
+	rows, err := ops.recordReadDB().QueryContext(
 		ops.ctx,
-		"SELECT id, data, created, num_access, last_access, permanent "+
-			"FROM kontext_conc_persistence "+
-			"WHERE created >= ? "+
-			"ORDER BY created LIMIT ?", fromDate, maxItems)
+		"SELECT "+ops.recCols()+" "+
+			"FROM "+ops.table+" "+
+			"WHERE "+ops.createdCol+" >= ? "+
+			"ORDER BY "+ops.createdCol+" LIMIT ?", fromDate, maxItems)
+	if err != nil {
+		return []ArchRecord{}, fmt.Errorf("failed to load records: %w", err)
+	}
+	return generateRows(rows, maxItems)
+}
+
+func (ops *MySQLConcArch) LoadRecordsToDate(toDate time.Time, maxItems int) ([]ArchRecord, error) {
+	rows, err := ops.recordReadDB().QueryContext(
+		ops.ctx,
+		"SELECT "+ops.recCols()+" "+
+			"FROM "+ops.table+" "+
+			"WHERE "+ops.createdCol+" <= ? "+
+			"ORDER BY "+ops.createdCol+" DESC LIMIT ?", toDate, maxItems)
 	if err != nil {
 		return []ArchRecord{}, fmt.Errorf("failed to load records: %w", err)
 	}
@@ -118,7 +316,7 @@ func (ops *MySQLConcArch) LoadRecordsFromDate(fromDate time.Time, maxItems int)
 func (ops *MySQLConcArch) ContainsRecord(concID string) (bool, error) {
 	row := ops.db.QueryRowContext(
 		ops.ctx,
-		"SELECT COUNT(*) FROM kontext_conc_persistence "+
+		"SELECT COUNT(*) FROM "+ops.table+" "+
 			"WHERE id = ? LIMIT 1", concID)
 	if row.Err() != nil {
 		return false, fmt.Errorf("failed to test existence of record %s: %w", concID, row.Err())
@@ -129,10 +327,14 @@ func (ops *MySQLConcArch) ContainsRecord(concID string) (bool, error) {
 }
 
 func (ops *MySQLConcArch) LoadRecordsByID(concID string) ([]ArchRecord, error) {
-	rows, err := ops.db.QueryContext(
-		ops.ctx,
-		"SELECT data, created, num_access, last_access, permanent "+
-			"FROM kontext_conc_persistence WHERE id = ?", concID)
+	return ops.LoadRecordsByIDCtx(ops.ctx, concID)
+}
+
+func (ops *MySQLConcArch) LoadRecordsByIDCtx(ctx context.Context, concID string) ([]ArchRecord, error) {
+	rows, err := ops.recordReadDB().QueryContext(
+		ctx,
+		"SELECT "+ops.dataCol+", "+ops.createdCol+", num_access, last_access, permanent "+
+			"FROM "+ops.table+" WHERE id = ?", concID)
 	if err != nil {
 		return []ArchRecord{}, fmt.Errorf("failed to get records with id %s: %w", concID, err)
 	}
@@ -145,17 +347,67 @@ func (ops *MySQLConcArch) LoadRecordsByID(concID string) ([]ArchRecord, error) {
 		if err != nil {
 			return []ArchRecord{}, fmt.Errorf("failed to get records with id %s: %w", concID, err)
 		}
+		item.Data, err = decodeData(item.Data)
+		if err != nil {
+			return []ArchRecord{}, fmt.Errorf("failed to get records with id %s: %w", concID, err)
+		}
 		ans = append(ans, item)
 	}
 	return ans, nil
 }
 
+func (ops *MySQLConcArch) LoadRecordsByIDs(ids []string) (map[string][]ArchRecord, error) {
+	ans := make(map[string][]ArchRecord)
+	for i := 0; i < len(ids); i += maxIDsPerQuery {
+		chunk := ids[i:min(i+maxIDsPerQuery, len(ids))]
+		args := make([]any, len(chunk))
+		for j, id := range chunk {
+			args[j] = id
+		}
+		rows, err := ops.recordReadDB().QueryContext(
+			ops.ctx,
+			"SELECT "+ops.recCols()+" "+
+				"FROM "+ops.table+" "+
+				"WHERE id IN ("+strings.Repeat("?, ", len(chunk)-1)+"?)", args...)
+		if err != nil {
+			return map[string][]ArchRecord{}, fmt.Errorf("failed to get records by IDs: %w", err)
+		}
+		recs, err := generateRows(rows, len(chunk))
+		if err != nil {
+			return map[string][]ArchRecord{}, fmt.Errorf("failed to get records by IDs: %w", err)
+		}
+		for _, rec := range recs {
+			ans[rec.ID] = append(ans[rec.ID], rec)
+		}
+	}
+	return ans, nil
+}
+
 func (ops *MySQLConcArch) InsertRecord(rec ArchRecord) error {
-	_, err := ops.db.ExecContext(
-		ops.ctx,
-		"INSERT INTO kontext_conc_persistence (id, data, created, num_access, last_access, permanent) "+
+	return ops.InsertRecordCtx(ops.ctx, rec)
+}
+
+func (ops *MySQLConcArch) InsertRecordCtx(ctx context.Context, rec ArchRecord) error {
+	return ops.insertRecord(ctx, ops.db, rec)
+}
+
+// insertRecord inserts rec using either the database or a transaction
+func (ops *MySQLConcArch) insertRecord(
+	ctx context.Context,
+	db interface {
+		ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	},
+	rec ArchRecord,
+) error {
+	data, err := ops.codec.encode(rec.Data)
+	if err != nil {
+		return fmt.Errorf("failed to insert archive record: %w", err)
+	}
+	_, err = db.ExecContext(
+		ctx,
+		"INSERT INTO "+ops.table+" ("+ops.recCols()+") "+
 			"VALUES (?, ?, ?, ?, ?, ?)",
-		rec.ID, rec.Data, rec.Created, rec.NumAccess, rec.LastAccess, rec.Permanent,
+		rec.ID, data, rec.Created, rec.NumAccess, rec.LastAccess, rec.Permanent,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to insert archive record: %w", err)
@@ -163,10 +415,83 @@ func (ops *MySQLConcArch) InsertRecord(rec ArchRecord) error {
 	return nil
 }
 
+func (ops *MySQLConcArch) InsertRecordIfMissing(rec ArchRecord) (bool, error) {
+	return ops.InsertRecordIfMissingCtx(ops.ctx, rec)
+}
+
+func (ops *MySQLConcArch) InsertRecordIfMissingCtx(ctx context.Context, rec ArchRecord) (bool, error) {
+	// Note: the archive table allows multiple rows with the same ID
+	// (variants resolved by deduplication) so we cannot rely on
+	// `ON DUPLICATE KEY UPDATE` here
+	data, err := ops.codec.encode(rec.Data)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert archive record: %w", err)
+	}
+	res, err := ops.db.ExecContext(
+		ctx,
+		"INSERT INTO "+ops.table+" ("+ops.recCols()+") "+
+			"SELECT ?, ?, ?, ?, ?, ? FROM DUAL "+
+			"WHERE NOT EXISTS (SELECT 1 FROM "+ops.table+" WHERE id = ?)",
+		rec.ID, data, rec.Created, rec.NumAccess, rec.LastAccess, rec.Permanent, rec.ID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert archive record: %w", err)
+	}
+	aff, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to insert archive record: %w", err)
+	}
+	return aff > 0, nil
+}
+
+func (ops *MySQLConcArch) InsertRecordsIfMissing(recs []ArchRecord) (int, error) {
+	tx, err := ops.NewTransaction()
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert archive records: %w", err)
+	}
+	stmt, err := tx.PrepareContext(
+		ops.ctx,
+		"INSERT INTO "+ops.table+" ("+ops.recCols()+") "+
+			"SELECT ?, ?, ?, ?, ?, ? FROM DUAL "+
+			"WHERE NOT EXISTS (SELECT 1 FROM "+ops.table+" WHERE id = ?)",
+	)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to insert archive records: %w", err)
+	}
+	defer stmt.Close()
+	var ans int
+	for _, rec := range recs {
+		data, err := ops.codec.encode(rec.Data)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("failed to insert archive record %s: %w", rec.ID, err)
+		}
+		res, err := stmt.ExecContext(
+			ops.ctx,
+			rec.ID, data, rec.Created, rec.NumAccess, rec.LastAccess, rec.Permanent, rec.ID,
+		)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("failed to insert archive record %s: %w", rec.ID, err)
+		}
+		aff, err := res.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("failed to insert archive record %s: %w", rec.ID, err)
+		}
+		ans += int(aff)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to insert archive records: %w", err)
+	}
+	return ans, nil
+}
+
 func (ops *MySQLConcArch) UpdateRecordStatus(id string, status int) error {
 	res, err := ops.db.ExecContext(
 		ops.ctx,
-		"UPDATE kontext_conc_persistence SET permanent = ? WHERE id = ?", status, id)
+		"UPDATE "+ops.table+" SET permanent = ? WHERE id = ?", status, id)
 	if err != nil {
 		return fmt.Errorf("failed to update status of %s: %w", id, err)
 	}
@@ -183,7 +508,7 @@ func (ops *MySQLConcArch) UpdateRecordStatus(id string, status int) error {
 func (ops *MySQLConcArch) RemoveRecordsByID(concID string) error {
 	_, err := ops.db.ExecContext(
 		ops.ctx,
-		"DELETE FROM kontext_conc_persistence WHERE id = ?", concID)
+		"DELETE FROM "+ops.table+" WHERE id = ?", concID)
 	if err != nil {
 		return fmt.Errorf("failed to remove records with id %s: %w", concID, err)
 	}
@@ -191,13 +516,27 @@ func (ops *MySQLConcArch) RemoveRecordsByID(concID string) error {
 }
 
 func (ops *MySQLConcArch) DeduplicateInArchive(curr []ArchRecord, rec ArchRecord) (ArchRecord, error) {
-	err := ops.RemoveRecordsByID(rec.ID)
+	return ops.DeduplicateInArchiveCtx(ops.ctx, curr, rec)
+}
+
+// DeduplicateInArchiveCtx replaces all the variants of rec with a single
+// merged record. The removal and the insertion are performed within
+// a transaction so an interrupted merge cannot lose the variants.
+func (ops *MySQLConcArch) DeduplicateInArchiveCtx(
+	ctx context.Context, curr []ArchRecord, rec ArchRecord) (ArchRecord, error) {
+	tx, err := ops.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
 	if err != nil {
 		return ArchRecord{}, fmt.Errorf("failed to finish deduplication for %s: %w", rec.ID, err)
 	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM "+ops.table+" WHERE id = ?", rec.ID)
+	if err != nil {
+		tx.Rollback()
+		return ArchRecord{}, fmt.Errorf("failed to finish deduplication for %s: %w", rec.ID, err)
+	}
 	ans := MergeRecords(curr, rec, ops.tz)
-	err = ops.InsertRecord(ans)
+	err = ops.insertRecord(ctx, tx, ans)
 	if err != nil {
+		tx.Rollback()
 		log.Error().
 			Err(err).
 			Str("concId", rec.ID).
@@ -205,6 +544,9 @@ func (ops *MySQLConcArch) DeduplicateInArchive(curr []ArchRecord, rec ArchRecord
 			Msg("failed to insert merged record")
 		return ans, fmt.Errorf("failed to store merged record %s: %w", rec.ID, err)
 	}
+	if err := tx.Commit(); err != nil {
+		return ans, fmt.Errorf("failed to store merged record %s: %w", rec.ID, err)
+	}
 	return ans, nil
 }
 
@@ -212,11 +554,11 @@ func (ops *MySQLConcArch) GetArchSizesByYears(forceLoad bool) ([][2]int, error)
 	if !forceLoad && !TimeIsAtNight(time.Now().In(ops.tz)) {
 		return [][2]int{}, ErrTooDemandingQuery
 	}
-	rows, err := ops.db.QueryContext(
+	rows, err := ops.readDB().QueryContext(
 		ops.ctx,
-		"SELECT COUNT(*), YEAR(created) AS yc "+
-			"FROM kontext_conc_persistence "+
-			"GROUP BY YEAR(created) ORDER BY yc")
+		"SELECT COUNT(*), YEAR("+ops.createdCol+") AS yc "+
+			"FROM "+ops.table+" "+
+			"GROUP BY YEAR("+ops.createdCol+") ORDER BY yc")
 	if err != nil {
 		return [][2]int{}, fmt.Errorf("failed to fetch arch. sizes: %w", err)
 	}
@@ -231,6 +573,48 @@ func (ops *MySQLConcArch) GetArchSizesByYears(forceLoad bool) ([][2]int, error)
 	return ans, nil
 }
 
+func (ops *MySQLConcArch) EstimateRecordCount() (int64, error) {
+	row := ops.readDB().QueryRowContext(
+		ops.ctx,
+		"SELECT TABLE_ROWS FROM information_schema.TABLES "+
+			"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?",
+		ops.table,
+	)
+	var count sql.NullInt64
+	if err := row.Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to estimate number of arch. records: %w", err)
+	}
+	return count.Int64, nil
+}
+
+func (ops *MySQLConcArch) GetArchCountsByDay(from, to time.Time) ([]DayCount, error) {
+	if IsTooDemandingDailyCountsRange(from, to, time.Now().In(ops.tz)) {
+		return []DayCount{}, ErrTooDemandingQuery
+	}
+	rows, err := ops.readDB().QueryContext(
+		ops.ctx,
+		"SELECT DATE("+ops.createdCol+") AS dc, COUNT(*) "+
+			"FROM "+ops.table+" "+
+			"WHERE "+ops.createdCol+" >= ? AND "+ops.createdCol+" < ? "+
+			"GROUP BY DATE("+ops.createdCol+") ORDER BY dc",
+		from, to,
+	)
+	if err != nil {
+		return []DayCount{}, fmt.Errorf("failed to fetch arch. counts by day: %w", err)
+	}
+	defer rows.Close()
+	ans := make([]DayCount, 0, 100)
+	for rows.Next() {
+		var day time.Time
+		var count int
+		if err := rows.Scan(&day, &count); err != nil {
+			return []DayCount{}, fmt.Errorf("failed to get values from arch. counts row: %w", err)
+		}
+		ans = append(ans, DayCount{Date: day.Format(time.DateOnly), Count: count})
+	}
+	return ans, nil
+}
+
 func (ops *MySQLConcArch) GetSubcorpusProps(subcID string) (SubcProps, error) {
 	if subcID == "" {
 		return SubcProps{}, nil
@@ -428,8 +812,8 @@ func (ops *MySQLQueryHist) LoadRecentNHistory(num int) ([]HistoryRecord, error)
 	// to avoid going through all the partitions (or is the query planner
 	// able to determine it from `order by created DESC limit X` ?)
 	helperLimit := time.Now().In(ops.tz).Add(-180 * 24 * time.Hour)
-	if num > maxRecentRecords {
-		panic(fmt.Sprintf("cannot load more than %d records at a time", maxRecentRecords))
+	if num > MaxRecentRecords {
+		panic(fmt.Sprintf("cannot load more than %d records at a time", MaxRecentRecords))
 	}
 
 	rows, err := ops.db.QueryContext(
@@ -456,6 +840,37 @@ func (ops *MySQLQueryHist) LoadRecentNHistory(num int) ([]HistoryRecord, error)
 	return ans, nil
 }
 
+func (ops *MySQLQueryHist) LoadHistoryBetween(from, to int64, num int) ([]HistoryRecord, error) {
+	if num > MaxRecentRecords {
+		panic(fmt.Sprintf("cannot load more than %d records at a time", MaxRecentRecords))
+	}
+	rows, err := ops.db.QueryContext(
+		ops.ctx,
+		"SELECT user_id, query_id, created, name FROM kontext_query_history "+
+			"WHERE created >= ? AND created <= ? "+
+			"ORDER BY created LIMIT ?",
+		from, to, num,
+	)
+	if err != nil {
+		return []HistoryRecord{}, fmt.Errorf("failed to get query history: %w", err)
+	}
+	ans := make([]HistoryRecord, 0, num)
+	for rows.Next() {
+		var hRec HistoryRecord
+		var name sql.NullString
+		err := rows.Scan(&hRec.UserID, &hRec.QueryID, &hRec.Created, &name)
+		if err != nil {
+			return []HistoryRecord{}, fmt.Errorf("failed to get query history: %w", err)
+		}
+		hRec.Name = name.String
+		ans = append(ans, hRec)
+	}
+	if err := rows.Err(); err != nil {
+		return []HistoryRecord{}, fmt.Errorf("failed to get query history: %w", err)
+	}
+	return ans, nil
+}
+
 func (ops *MySQLQueryHist) GetPendingDeletionRecords(tx *sql.Tx, maxItems int) ([]HistoryRecord, error) {
 	rows, err := tx.QueryContext(
 		ops.ctx,
@@ -491,16 +906,41 @@ func (ops *MySQLQueryHist) TableSize() (int64, error) {
 	return count, nil
 }
 
+func (ops *MySQLQueryHist) CountPendingDeletion() (int64, error) {
+	row := ops.db.QueryRowContext(
+		ops.ctx,
+		"SELECT COUNT(*) FROM kontext_query_history WHERE pending_deletion_from IS NOT NULL")
+	var count int64
+	if err := row.Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count records pending deletion: %w", err)
+	}
+	return count, nil
+}
+
 // --------------------------
 
-func NewMySQLOps(ctx context.Context, db *sql.DB, tz *time.Location) (*MySQLConcArch, *MySQLQueryHist) {
+// NewMySQLOps creates database operations providers. The conf
+// argument is expected to be validated via DBConf.ValidateAndDefaults.
+func NewMySQLOps(
+	ctx context.Context,
+	db *sql.DB,
+	conf *DBConf,
+	tz *time.Location,
+) (*MySQLConcArch, *MySQLQueryHist) {
 	return &MySQLConcArch{
-			ctx: ctx,
-			db:  db,
-			tz:  tz,
-		}, &MySQLQueryHist{
-			ctx: ctx,
-			db:  db,
-			tz:  tz,
-		}
+		ctx:        ctx,
+		db:         db,
+		tz:         tz,
+		table:      conf.ArchTableName,
+		dataCol:    conf.ArchDataColumn,
+		createdCol: conf.ArchCreatedColumn,
+		codec: dataCodec{
+			compression: conf.DataCompression,
+			minSize:     conf.DataCompressionMinSize,
+		},
+	}, &MySQLQueryHist{
+		ctx: ctx,
+		db:  db,
+		tz:  tz,
+	}
 }
@@ -0,0 +1,169 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBConfDefaultSchemaNames(t *testing.T) {
+	conf := DBConf{}
+	assert.NoError(t, conf.ValidateAndDefaults())
+	assert.Equal(t, "kontext_conc_persistence", conf.ArchTableName)
+	assert.Equal(t, "data", conf.ArchDataColumn)
+	assert.Equal(t, "created", conf.ArchCreatedColumn)
+}
+
+func TestDBConfRejectsInvalidIdentifiers(t *testing.T) {
+	conf := DBConf{ArchTableName: "conc_archive; DROP TABLE users"}
+	assert.Error(t, conf.ValidateAndDefaults())
+	conf = DBConf{ArchDataColumn: "`data`"}
+	assert.Error(t, conf.ValidateAndDefaults())
+	conf = DBConf{ArchTableName: "my_conc_archive2", ArchCreatedColumn: "dt_created"}
+	assert.NoError(t, conf.ValidateAndDefaults())
+}
+
+func TestDBConfPoolDefaults(t *testing.T) {
+	conf := DBConf{}
+	assert.NoError(t, conf.ValidateAndDefaults())
+	assert.Equal(t, dfltPoolSize, conf.PoolSize)
+	assert.Equal(t, dfltConnMaxLifetimeSecs, conf.ConnMaxLifetimeSecs)
+	conf = DBConf{PoolSize: -1}
+	assert.Error(t, conf.ValidateAndDefaults())
+}
+
+func TestDBConfReadReplica(t *testing.T) {
+	conf := DBConf{ReadReplica: &DBConf{Host: "replica:3306"}}
+	assert.NoError(t, conf.ValidateAndDefaults())
+	assert.Equal(t, dfltPoolSize, conf.ReadReplica.PoolSize)
+	conf = DBConf{ReadReplica: &DBConf{}}
+	assert.Error(t, conf.ValidateAndDefaults())
+	conf = DBConf{ReadReplica: &DBConf{Host: "replica:3306", ReadReplica: &DBConf{Host: "other:3306"}}}
+	assert.Error(t, conf.ValidateAndDefaults())
+}
+
+func TestMySQLConcArchReadDB(t *testing.T) {
+	primary, replica := &sql.DB{}, &sql.DB{}
+	ops := &MySQLConcArch{db: primary}
+	assert.Same(t, primary, ops.readDB())
+	ops.SetReadReplica(replica)
+	assert.Same(t, replica, ops.readDB())
+}
+
+func TestMySQLConcArchRecordReadDB(t *testing.T) {
+	primary, replica := &sql.DB{}, &sql.DB{}
+	ops := &MySQLConcArch{db: primary}
+	assert.Same(t, primary, ops.WithReplicaRecordReads().recordReadDB())
+	ops.SetReadReplica(replica)
+	assert.Same(t, primary, ops.recordReadDB())
+	replicaOps := ops.WithReplicaRecordReads()
+	assert.Same(t, replica, replicaOps.recordReadDB())
+	assert.Same(t, primary, replicaOps.db)
+	assert.Same(t, primary, ops.recordReadDB())
+}
+
+func newMockedConcArch(t *testing.T) (*MySQLConcArch, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	conf := DBConf{}
+	assert.NoError(t, conf.ValidateAndDefaults())
+	ops, _ := NewMySQLOps(context.Background(), db, &conf, time.UTC)
+	return ops, mock
+}
+
+func TestInsertRecordIfMissing(t *testing.T) {
+	expectedSQL := regexp.QuoteMeta(
+		"INSERT INTO kontext_conc_persistence " +
+			"(id, data, created, num_access, last_access, permanent) " +
+			"SELECT ?, ?, ?, ?, ?, ? FROM DUAL " +
+			"WHERE NOT EXISTS (SELECT 1 FROM kontext_conc_persistence WHERE id = ?)")
+	created := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	rec := ArchRecord{ID: "abc", Data: `{"q":["aword,x"]}`, Created: created, NumAccess: 2}
+
+	ops, mock := newMockedConcArch(t)
+	mock.ExpectExec(expectedSQL).
+		WithArgs("abc", rec.Data, created, 2, sqlmock.AnyArg(), 0, "abc").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	inserted, err := ops.InsertRecordIfMissing(rec)
+	assert.NoError(t, err)
+	assert.True(t, inserted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	ops, mock = newMockedConcArch(t)
+	mock.ExpectExec(expectedSQL).
+		WithArgs("abc", rec.Data, created, 2, sqlmock.AnyArg(), 0, "abc").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	inserted, err = ops.InsertRecordIfMissing(rec)
+	assert.NoError(t, err)
+	assert.False(t, inserted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func newMockedQueryHist(t *testing.T) (*MySQLQueryHist, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	conf := DBConf{}
+	assert.NoError(t, conf.ValidateAndDefaults())
+	_, ops := NewMySQLOps(context.Background(), db, &conf, time.UTC)
+	return ops, mock
+}
+
+func TestLoadHistoryBetween(t *testing.T) {
+	ops, mock := newMockedQueryHist(t)
+	mock.ExpectQuery("SELECT user_id, query_id, created, name FROM kontext_query_history").
+		WithArgs(int64(100), int64(200), 10).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"user_id", "query_id", "created", "name"}).
+				AddRow(1, "q1", 100, nil).
+				AddRow(2, "q2", 150, "my query"),
+		)
+	ans, err := ops.LoadHistoryBetween(100, 200, 10)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		[]HistoryRecord{
+			{UserID: 1, QueryID: "q1", Created: 100},
+			{UserID: 2, QueryID: "q2", Created: 150, Name: "my query"},
+		},
+		ans,
+	)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoadHistoryBetweenTruncatedResult(t *testing.T) {
+	ops, mock := newMockedQueryHist(t)
+	mock.ExpectQuery("SELECT user_id, query_id, created, name FROM kontext_query_history").
+		WithArgs(int64(100), int64(200), 10).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"user_id", "query_id", "created", "name"}).
+				AddRow(1, "q1", 100, nil).
+				AddRow(2, "q2", 150, nil).
+				RowError(1, sql.ErrConnDone),
+		)
+	ans, err := ops.LoadHistoryBetween(100, 200, 10)
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.Empty(t, ans)
+}
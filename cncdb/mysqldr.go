@@ -17,6 +17,7 @@
 package cncdb
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
@@ -42,6 +43,10 @@ func (db *MySQLConcArchDryRun) LoadRecordsFromDate(fromDate time.Time, maxItems
 	return db.db.LoadRecordsFromDate(fromDate, maxItems)
 }
 
+func (db *MySQLConcArchDryRun) LoadRecordsToDate(toDate time.Time, maxItems int) ([]ArchRecord, error) {
+	return db.db.LoadRecordsToDate(toDate, maxItems)
+}
+
 func (db *MySQLConcArchDryRun) ContainsRecord(concID string) (bool, error) {
 	return db.db.ContainsRecord(concID)
 }
@@ -50,11 +55,60 @@ func (db *MySQLConcArchDryRun) LoadRecordsByID(concID string) ([]ArchRecord, err
 	return db.db.LoadRecordsByID(concID)
 }
 
+func (db *MySQLConcArchDryRun) LoadRecordsByIDCtx(ctx context.Context, concID string) ([]ArchRecord, error) {
+	return db.db.LoadRecordsByIDCtx(ctx, concID)
+}
+
+func (db *MySQLConcArchDryRun) LoadRecordsByIDs(ids []string) (map[string][]ArchRecord, error) {
+	return db.db.LoadRecordsByIDs(ids)
+}
+
 func (db *MySQLConcArchDryRun) InsertRecord(rec ArchRecord) error {
 	log.Info().Msgf("DRY-RUN>>> InsertRecord(ArchRecord{ID: %s})", rec.ID)
 	return nil
 }
 
+func (db *MySQLConcArchDryRun) InsertRecordCtx(ctx context.Context, rec ArchRecord) error {
+	return db.InsertRecord(rec)
+}
+
+func (db *MySQLConcArchDryRun) InsertRecordIfMissing(rec ArchRecord) (bool, error) {
+	exists, err := db.db.ContainsRecord(rec.ID)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+	log.Info().Msgf("DRY-RUN>>> InsertRecordIfMissing(ArchRecord{ID: %s})", rec.ID)
+	return true, nil
+}
+
+func (db *MySQLConcArchDryRun) InsertRecordIfMissingCtx(ctx context.Context, rec ArchRecord) (bool, error) {
+	return db.InsertRecordIfMissing(rec)
+}
+
+func (db *MySQLConcArchDryRun) InsertRecordsIfMissing(recs []ArchRecord) (int, error) {
+	// records with the same ID within recs would be skipped
+	// by the real insert so we have to track them here
+	inserted := make(map[string]bool)
+	for _, rec := range recs {
+		if inserted[rec.ID] {
+			continue
+		}
+		exists, err := db.db.ContainsRecord(rec.ID)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			continue
+		}
+		log.Info().Msgf("DRY-RUN>>> InsertRecordsIfMissing(ArchRecord{ID: %s})", rec.ID)
+		inserted[rec.ID] = true
+	}
+	return len(inserted), nil
+}
+
 func (db *MySQLConcArchDryRun) UpdateRecordStatus(id string, status int) error {
 	log.Info().Msgf("DRY-RUN>>> UpdateRecordStatus(%s, %d)", id, status)
 	return nil
@@ -70,10 +124,23 @@ func (db *MySQLConcArchDryRun) DeduplicateInArchive(curr []ArchRecord, rec ArchR
 	return ArchRecord{}, nil
 }
 
+func (db *MySQLConcArchDryRun) DeduplicateInArchiveCtx(
+	ctx context.Context, curr []ArchRecord, rec ArchRecord) (ArchRecord, error) {
+	return db.DeduplicateInArchive(curr, rec)
+}
+
 func (ops *MySQLConcArchDryRun) GetArchSizesByYears(forceLoad bool) ([][2]int, error) {
 	return ops.db.GetArchSizesByYears(forceLoad)
 }
 
+func (ops *MySQLConcArchDryRun) GetArchCountsByDay(from, to time.Time) ([]DayCount, error) {
+	return ops.db.GetArchCountsByDay(from, to)
+}
+
+func (ops *MySQLConcArchDryRun) EstimateRecordCount() (int64, error) {
+	return ops.db.EstimateRecordCount()
+}
+
 func (ops *MySQLConcArchDryRun) GetSubcorpusProps(subcID string) (SubcProps, error) {
 	return ops.db.GetSubcorpusProps(subcID)
 }
@@ -108,6 +175,10 @@ func (db *MySQLQueryHistDryRun) LoadRecentNHistory(num int) ([]HistoryRecord, er
 	return db.db.LoadRecentNHistory(num)
 }
 
+func (db *MySQLQueryHistDryRun) LoadHistoryBetween(from, to int64, num int) ([]HistoryRecord, error) {
+	return db.db.LoadHistoryBetween(from, to, num)
+}
+
 func (db *MySQLQueryHistDryRun) GarbageCollectRecords(userID int) (int64, error) {
 	log.Info().Msgf("DRY-RUN>>> GarbageCollectRecords(%d)", userID)
 	return 0, nil
@@ -130,6 +201,10 @@ func (db *MySQLQueryHistDryRun) TableSize() (int64, error) {
 	return db.db.TableSize()
 }
 
+func (db *MySQLQueryHistDryRun) CountPendingDeletion() (int64, error) {
+	return db.db.CountPendingDeletion()
+}
+
 func NewMySQLDryRun(opsArch *MySQLConcArch, opsHist *MySQLQueryHist) (*MySQLConcArchDryRun, *MySQLQueryHistDryRun) {
 	return &MySQLConcArchDryRun{db: opsArch}, &MySQLQueryHistDryRun{db: opsHist}
 }
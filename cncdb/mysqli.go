@@ -17,6 +17,7 @@
 package cncdb
 
 import (
+	"context"
 	"database/sql"
 	"time"
 )
@@ -26,25 +27,84 @@ type SubcProps struct {
 	TextTypes map[string][]string
 }
 
+// DayCount is a number of archived records created on a specific day
+// (encoded as YYYY-MM-DD)
+type DayCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
 // IConcArchOps is an abstract interface for high level
 // database operations for concordance archive.
 type IConcArchOps interface {
 	NewTransaction() (*sql.Tx, error)
 	LoadRecentNRecords(num int) ([]ArchRecord, error)
 	LoadRecordsFromDate(fromDate time.Time, maxItems int) ([]ArchRecord, error)
+
+	// LoadRecordsToDate loads records created at or before toDate,
+	// newest first
+	LoadRecordsToDate(toDate time.Time, maxItems int) ([]ArchRecord, error)
 	ContainsRecord(concID string) (bool, error)
 	LoadRecordsByID(concID string) ([]ArchRecord, error)
+
+	// LoadRecordsByIDCtx is a variant of LoadRecordsByID bound
+	// to the provided context
+	LoadRecordsByIDCtx(ctx context.Context, concID string) ([]ArchRecord, error)
+
+	// LoadRecordsByIDs loads records for multiple IDs at once.
+	// The result is grouped by record IDs. IDs with no records
+	// are not present in the result.
+	LoadRecordsByIDs(ids []string) (map[string][]ArchRecord, error)
 	InsertRecord(rec ArchRecord) error
+
+	// InsertRecordCtx is a variant of InsertRecord bound
+	// to the provided context
+	InsertRecordCtx(ctx context.Context, rec ArchRecord) error
+
+	// InsertRecordIfMissing inserts a record only if there is no
+	// record with the same ID yet. The existence test and the insert
+	// are performed within a single statement. The returned bool
+	// specifies whether the record has been inserted.
+	InsertRecordIfMissing(rec ArchRecord) (bool, error)
+
+	// InsertRecordIfMissingCtx is a variant of InsertRecordIfMissing
+	// bound to the provided context
+	InsertRecordIfMissingCtx(ctx context.Context, rec ArchRecord) (bool, error)
+
+	// InsertRecordsIfMissing inserts multiple records within a single
+	// transaction. Just like with InsertRecordIfMissing, records with
+	// an already existing ID are skipped. The returned value is the
+	// number of actually inserted records.
+	InsertRecordsIfMissing(recs []ArchRecord) (int, error)
 	UpdateRecordStatus(id string, status int) error
 	RemoveRecordsByID(concID string) error
 	DeduplicateInArchive(curr []ArchRecord, rec ArchRecord) (ArchRecord, error)
 
+	// DeduplicateInArchiveCtx is a variant of DeduplicateInArchive
+	// bound to the provided context. In case the context is cancelled
+	// before the merged record is stored, the original variants
+	// must be preserved.
+	DeduplicateInArchiveCtx(ctx context.Context, curr []ArchRecord, rec ArchRecord) (ArchRecord, error)
+
 	// GetArchSizesByYears
 	// Without forceReload, the function refuses to perform actual query outside
 	// defined night time.
 	// Returns list of pairs where FIRST item is always YEAR, the SECOND one is COUNT
 	GetArchSizesByYears(forceLoad bool) ([][2]int, error)
 
+	// GetArchCountsByDay returns numbers of records created within
+	// the [from, to) interval grouped by days. Days without records
+	// are not present in the result. For intervals longer than
+	// MaxDaytimeDailyCountsRange, the function refuses to perform
+	// actual query outside defined night time.
+	GetArchCountsByDay(from, to time.Time) ([]DayCount, error)
+
+	// EstimateRecordCount returns an approximate number of records
+	// based on table statistics. Unlike COUNT(*), this is cheap even
+	// for large partitioned tables, but the value may differ from
+	// the actual count (for InnoDB, typically by tens of percent).
+	EstimateRecordCount() (int64, error)
+
 	// GetSubcorpusProps takes a subcorpus "hash" ID and returns
 	// a corresponding name defined by the author.
 	// The method should accept empty value by responding
@@ -69,5 +129,13 @@ type IQHistArchOps interface {
 	// pending deletion time.
 	GetPendingDeletionRecords(tx *sql.Tx, maxItems int) ([]HistoryRecord, error)
 	LoadRecentNHistory(num int) ([]HistoryRecord, error)
+
+	// LoadHistoryBetween loads at most `num` records created within
+	// the [from, to] interval (UNIX timestamps), oldest first.
+	LoadHistoryBetween(from, to int64, num int) ([]HistoryRecord, error)
 	TableSize() (int64, error)
+
+	// CountPendingDeletion returns number of records marked
+	// for deletion (see MarkOldRecords) and not deleted yet.
+	CountPendingDeletion() (int64, error)
 }
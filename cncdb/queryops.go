@@ -0,0 +1,85 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+// QueryOpType is a type of a concordance operation
+// within the `q` chain of a conc. record
+type QueryOpType string
+
+const (
+	QueryOpQuery      QueryOpType = "query"
+	QueryOpFilter     QueryOpType = "filter"
+	QueryOpSample     QueryOpType = "sample"
+	QueryOpShuffle    QueryOpType = "shuffle"
+	QueryOpSort       QueryOpType = "sort"
+	QueryOpFirstHits  QueryOpType = "firstHits"
+	QueryOpStoredConc QueryOpType = "storedConc"
+	QueryOpOther      QueryOpType = "other"
+)
+
+// ClassifyQueryOp determines the operation type of a `q` chain
+// element based on its leading operation character (e.g. `p` in
+// `p0 0 1 [lemma="dům"]`).
+func ClassifyQueryOp(op string) QueryOpType {
+	if op == "" {
+		return QueryOpOther
+	}
+	switch op[0] {
+	case 'q', 'a':
+		return QueryOpQuery
+	case 'p', 'P', 'n', 'N':
+		return QueryOpFilter
+	case 'r':
+		return QueryOpSample
+	case 'f':
+		return QueryOpShuffle
+	case 's':
+		return QueryOpSort
+	case 'F':
+		return QueryOpFirstHits
+	case '~':
+		// a reference to a stored concordance (the chain continues there)
+		return QueryOpStoredConc
+	default:
+		return QueryOpOther
+	}
+}
+
+// QueryOps describes operations (filters, samples etc.) applied
+// to the base query of a concordance
+type QueryOps struct {
+
+	// NumOps is the number of operations following the base query
+	NumOps int `json:"numOps"`
+
+	OpTypes []QueryOpType `json:"opTypes"`
+}
+
+// GetQueryOps classifies the operations of the `q` chain
+// following its first (i.e. base query) element.
+func (rec GeneralDataRecord) GetQueryOps() QueryOps {
+	chain := rec.GetQuery()
+	ans := QueryOps{OpTypes: []QueryOpType{}}
+	if len(chain) < 2 {
+		return ans
+	}
+	for _, op := range chain[1:] {
+		ans.OpTypes = append(ans.OpTypes, ClassifyQueryOp(op))
+	}
+	ans.NumOps = len(ans.OpTypes)
+	return ans
+}
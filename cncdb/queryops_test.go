@@ -0,0 +1,60 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyQueryOp(t *testing.T) {
+	for op, expected := range map[string]QueryOpType{
+		"aword,[word=\"dům\"]":   QueryOpQuery,
+		"q[lemma=\"dům\"]":       QueryOpQuery,
+		"p0 0 1 [lemma=\"pes\"]": QueryOpFilter,
+		"P0 0 1 [lemma=\"pes\"]": QueryOpFilter,
+		"n-5 5 1 [tag=\"N.*\"]":  QueryOpFilter,
+		"N-5 5 1 [tag=\"N.*\"]":  QueryOpFilter,
+		"r250":                   QueryOpSample,
+		"f":                      QueryOpShuffle,
+		"s*word/ 0":              QueryOpSort,
+		"F<doc/>":                QueryOpFirstHits,
+		"~Xh9lEfqP3Ywp":          QueryOpStoredConc,
+		"x-intercorp_v16ud_en":   QueryOpOther,
+		"":                       QueryOpOther,
+	} {
+		assert.Equal(t, expected, ClassifyQueryOp(op), op)
+	}
+}
+
+func TestGetQueryOps(t *testing.T) {
+	rec := GeneralDataRecord{
+		"q": []any{"aword,[word=\"dům\"]", "p0 0 1 [lemma=\"pes\"]", "r250", "f"},
+	}
+	ops := rec.GetQueryOps()
+	assert.Equal(t, 3, ops.NumOps)
+	assert.Equal(t, []QueryOpType{QueryOpFilter, QueryOpSample, QueryOpShuffle}, ops.OpTypes)
+
+	// a plain query has no operations
+	ops = GeneralDataRecord{"q": []any{"aword,[word=\"dům\"]"}}.GetQueryOps()
+	assert.Equal(t, 0, ops.NumOps)
+	assert.Empty(t, ops.OpTypes)
+
+	ops = GeneralDataRecord{}.GetQueryOps()
+	assert.Equal(t, 0, ops.NumOps)
+}
@@ -19,6 +19,7 @@ package cncdb
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 )
@@ -56,14 +57,23 @@ func FormTypeToSupertype(ft string) QuerySupertype {
 type RawQuery struct {
 	Value string `json:"value"`
 	Type  string `json:"type"`
+
+	// Corpus specifies a corpus the query was applied to
+	// (relevant mainly for aligned corpora)
+	Corpus string `json:"corpus,omitempty"`
 }
 
 type concForm struct {
 	FormType          string              `json:"form_type"`
 	CurrQueryTypes    map[string]string   `json:"curr_query_types"`
 	CurrQueries       map[string]string   `json:"curr_queries"`
+	CurrDefaultAttrs  map[string]string   `json:"curr_default_attr_values"`
 	SelectedTextTypes map[string][]string `json:"selected_text_types"`
 
+	// CurrUseRegexpValues specifies (per corpus) whether a simple
+	// query has been evaluated in the regexp mode
+	CurrUseRegexpValues map[string]bool `json:"curr_use_regexp_values"`
+
 	// CurrParsedQueries encodes KonText's TypeScript type:
 	// {[k:string]:Array<[Array<[string|Array<string>, string]>, boolean]>};
 	CurrParsedQueries map[string][]any `json:"curr_parsed_queries"`
@@ -88,6 +98,13 @@ type kwordsForm struct {
 type pqueryForm struct {
 	FormType string   `json:"form_type"`
 	ConcIDs  []string `json:"conc_ids"`
+
+	// frequency constraints (may be missing in older records)
+
+	MinFreq    *int     `json:"min_fq"`
+	MaxFreq    *int     `json:"max_fq"`
+	MinRelFreq *float64 `json:"min_rel_fq"`
+	MaxRelFreq *float64 `json:"max_rel_fq"`
 }
 
 type ConcFormRecord struct {
@@ -107,6 +124,73 @@ func (cr *ConcFormRecord) GetDefaultAttr() string {
 	return ""
 }
 
+// HasFilters tests whether the query chain (`q`) contains any
+// filtering operation following the initial query. In KonText,
+// the following operation prefixes are considered filters:
+//   - `p` - positive filter (matching tokens)
+//   - `n` - negative filter (matching tokens)
+//   - `P` - positive filter (all the tokens)
+//   - `N` - negative filter (all the tokens)
+//   - `D` - "remove nested matches" (subhits)
+//   - `F` - "first hits in document"
+//
+// Other operations (e.g. `r` - sample, `f` - shuffle, `s` - sort)
+// do not change the set of matching positions and thus are not
+// considered filters.
+func (cr *ConcFormRecord) HasFilters() bool {
+	if len(cr.Q) < 2 {
+		return false
+	}
+	for _, op := range cr.Q[1:] {
+		if len(op) == 0 {
+			continue
+		}
+		switch op[0] {
+		case 'p', 'n', 'P', 'N', 'D', 'F':
+			return true
+		}
+	}
+	return false
+}
+
+// GetDefaultAttrs returns a union of default attributes of all the
+// searched corpora (in case of a parallel corpus, the defaults may
+// differ). If no per-corpus values are available, the default attribute
+// encoded in the first query operation is used (see GetDefaultAttr).
+func (cr *ConcFormRecord) GetDefaultAttrs() []string {
+	ans := make([]string, 0, 2)
+	if cr.LastopForm != nil {
+		for _, attr := range cr.LastopForm.CurrDefaultAttrs {
+			if attr != "" && !slices.Contains(ans, attr) {
+				ans = append(ans, attr)
+			}
+		}
+	}
+	if len(ans) == 0 {
+		if attr := cr.GetDefaultAttr(); attr != "" {
+			ans = append(ans, attr)
+		}
+	}
+	slices.Sort(ans)
+	return ans
+}
+
+// UsesRegexp tests whether a simple query of any of the searched
+// corpora has been evaluated in the regexp mode. Missing values
+// are considered false. For other query types (e.g. CQL), the flag
+// does not affect query interpretation and is ignored.
+func (cr *ConcFormRecord) UsesRegexp() bool {
+	if cr.LastopForm == nil {
+		return false
+	}
+	for corp, useRegexp := range cr.LastopForm.CurrUseRegexpValues {
+		if useRegexp && cr.LastopForm.CurrQueryTypes[corp] == "simple" {
+			return true
+		}
+	}
+	return false
+}
+
 type WlistFormRecord struct {
 	Form wlistForm `json:"form"`
 }
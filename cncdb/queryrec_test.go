@@ -0,0 +1,65 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcFormRecordHasFilters(t *testing.T) {
+	rec := ConcFormRecord{Q: []string{"aword,[word=\"x\"]"}}
+	assert.False(t, rec.HasFilters())
+	rec = ConcFormRecord{Q: []string{"aword,[word=\"x\"]", "r250", "f"}}
+	assert.False(t, rec.HasFilters())
+	rec = ConcFormRecord{Q: []string{"aword,[word=\"x\"]", "f", "p0 0 1 [lemma=\"y\"]"}}
+	assert.True(t, rec.HasFilters())
+	// the first operation (query itself) is never considered a filter
+	rec = ConcFormRecord{Q: []string{"P0 0 1 [lemma=\"y\"]"}}
+	assert.False(t, rec.HasFilters())
+}
+
+func TestConcFormRecordGetDefaultAttrs(t *testing.T) {
+	rec := ConcFormRecord{
+		Q: []string{"aword,[word=\"x\"]"},
+		LastopForm: &concForm{
+			CurrDefaultAttrs: map[string]string{"intercorp_cs": "word", "intercorp_en": "lemma"},
+		},
+	}
+	assert.Equal(t, []string{"lemma", "word"}, rec.GetDefaultAttrs())
+	rec = ConcFormRecord{Q: []string{"alemma,[lemma=\"x\"]"}, LastopForm: &concForm{}}
+	assert.Equal(t, []string{"lemma"}, rec.GetDefaultAttrs())
+	rec = ConcFormRecord{}
+	assert.Empty(t, rec.GetDefaultAttrs())
+}
+
+func TestConcFormRecordUsesRegexp(t *testing.T) {
+	rec := ConcFormRecord{
+		LastopForm: &concForm{
+			CurrQueryTypes:      map[string]string{"intercorp_cs": "simple", "intercorp_en": "advanced"},
+			CurrUseRegexpValues: map[string]bool{"intercorp_cs": true, "intercorp_en": false},
+		},
+	}
+	assert.True(t, rec.UsesRegexp())
+	rec.LastopForm.CurrUseRegexpValues = map[string]bool{"intercorp_cs": false, "intercorp_en": true}
+	assert.False(t, rec.UsesRegexp())
+	rec.LastopForm.CurrUseRegexpValues = nil
+	assert.False(t, rec.UsesRegexp())
+	rec = ConcFormRecord{}
+	assert.False(t, rec.UsesRegexp())
+}
@@ -0,0 +1,156 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SubcPropsCacheStats provides basic info about subcorpus props cache efficiency
+type SubcPropsCacheStats struct {
+	Enabled bool    `json:"enabled"`
+	Size    int     `json:"size"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hitRate"`
+}
+
+// clone creates a deep copy of the props so callers can modify
+// (e.g. extend) text types without affecting cached values
+func (sp SubcProps) clone() SubcProps {
+	if sp.TextTypes == nil {
+		return sp
+	}
+	tt := make(map[string][]string, len(sp.TextTypes))
+	for k, v := range sp.TextTypes {
+		tt[k] = slices.Clone(v)
+	}
+	sp.TextTypes = tt
+	return sp
+}
+
+type subcPropsCacheEntry struct {
+	subcID  string
+	created time.Time
+	props   SubcProps
+}
+
+// CachingSubcConcArchOps wraps an IConcArchOps instance and caches
+// results of GetSubcorpusProps in an LRU cache with a limited time
+// of validity of its items. This is useful mainly for bulk (re)indexing
+// where many queries share the same subcorpus. Errors are not cached.
+type CachingSubcConcArchOps struct {
+	IConcArchOps
+	capacity int
+	ttl      time.Duration
+	items    map[string]*list.Element
+	lru      *list.List
+	hits     int64
+	misses   int64
+	mu       sync.Mutex
+}
+
+func (db *CachingSubcConcArchOps) get(subcID string) (SubcProps, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	elm, ok := db.items[subcID]
+	if !ok {
+		db.misses++
+		return SubcProps{}, false
+	}
+	entry := elm.Value.(*subcPropsCacheEntry)
+	if time.Since(entry.created) > db.ttl {
+		db.lru.Remove(elm)
+		delete(db.items, subcID)
+		db.misses++
+		return SubcProps{}, false
+	}
+	db.lru.MoveToFront(elm)
+	db.hits++
+	return entry.props.clone(), true
+}
+
+func (db *CachingSubcConcArchOps) set(subcID string, props SubcProps) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if elm, ok := db.items[subcID]; ok {
+		db.lru.Remove(elm)
+		delete(db.items, subcID)
+	}
+	for db.lru.Len() >= db.capacity {
+		oldest := db.lru.Back()
+		db.lru.Remove(oldest)
+		delete(db.items, oldest.Value.(*subcPropsCacheEntry).subcID)
+	}
+	db.items[subcID] = db.lru.PushFront(&subcPropsCacheEntry{
+		subcID:  subcID,
+		created: time.Now(),
+		props:   props.clone(),
+	})
+}
+
+// GetSubcorpusProps returns cached subcorpus properties or loads
+// them from the wrapped instance.
+func (db *CachingSubcConcArchOps) GetSubcorpusProps(subcID string) (SubcProps, error) {
+	if subcID == "" {
+		return db.IConcArchOps.GetSubcorpusProps(subcID)
+	}
+	if props, ok := db.get(subcID); ok {
+		return props, nil
+	}
+	props, err := db.IConcArchOps.GetSubcorpusProps(subcID)
+	if err != nil {
+		return props, err
+	}
+	db.set(subcID, props)
+	return props, nil
+}
+
+// SubcPropsCacheStats returns info about the cache usage including
+// the hit rate (i.e. hits / (hits + misses)).
+func (db *CachingSubcConcArchOps) SubcPropsCacheStats() SubcPropsCacheStats {
+	if db == nil {
+		return SubcPropsCacheStats{}
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	ans := SubcPropsCacheStats{
+		Enabled: true,
+		Size:    db.lru.Len(),
+		Hits:    db.hits,
+		Misses:  db.misses,
+	}
+	if db.hits+db.misses > 0 {
+		ans.HitRate = float64(db.hits) / float64(db.hits+db.misses)
+	}
+	return ans
+}
+
+// NewCachingSubcConcArchOps creates a caching wrapper around db
+// with max. `capacity` cached subcorpora.
+func NewCachingSubcConcArchOps(db IConcArchOps, capacity int, ttl time.Duration) *CachingSubcConcArchOps {
+	return &CachingSubcConcArchOps{
+		IConcArchOps: db,
+		capacity:     max(capacity, 1),
+		ttl:          ttl,
+		items:        make(map[string]*list.Element),
+		lru:          list.New(),
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingSubcConcArch struct {
+	IConcArchOps
+	numCalls int
+	err      error
+}
+
+func (db *countingSubcConcArch) GetSubcorpusProps(subcID string) (SubcProps, error) {
+	db.numCalls++
+	if db.err != nil {
+		return SubcProps{}, db.err
+	}
+	return SubcProps{Name: "subc-" + subcID}, nil
+}
+
+func TestCachingSubcConcArchOpsHits(t *testing.T) {
+	wrapped := &countingSubcConcArch{}
+	db := NewCachingSubcConcArchOps(wrapped, 10, time.Minute)
+	for i := 0; i < 3; i++ {
+		props, err := db.GetSubcorpusProps("a")
+		assert.NoError(t, err)
+		assert.Equal(t, "subc-a", props.Name)
+	}
+	assert.Equal(t, 1, wrapped.numCalls)
+	stats := db.SubcPropsCacheStats()
+	assert.Equal(t, int64(2), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses)
+	assert.InDelta(t, 2.0/3.0, stats.HitRate, 0.001)
+}
+
+func TestCachingSubcConcArchOpsEvictsOldest(t *testing.T) {
+	wrapped := &countingSubcConcArch{}
+	db := NewCachingSubcConcArchOps(wrapped, 2, time.Minute)
+	db.GetSubcorpusProps("a")
+	db.GetSubcorpusProps("b")
+	db.GetSubcorpusProps("a")
+	db.GetSubcorpusProps("c") // evicts "b"
+	assert.Equal(t, 3, wrapped.numCalls)
+	db.GetSubcorpusProps("a")
+	assert.Equal(t, 3, wrapped.numCalls)
+	db.GetSubcorpusProps("b")
+	assert.Equal(t, 4, wrapped.numCalls)
+	assert.Equal(t, 2, db.SubcPropsCacheStats().Size)
+}
+
+func TestCachingSubcConcArchOpsTTL(t *testing.T) {
+	wrapped := &countingSubcConcArch{}
+	db := NewCachingSubcConcArchOps(wrapped, 10, time.Millisecond)
+	db.GetSubcorpusProps("a")
+	time.Sleep(5 * time.Millisecond)
+	db.GetSubcorpusProps("a")
+	assert.Equal(t, 2, wrapped.numCalls)
+}
+
+func TestCachingSubcConcArchOpsSkipsErrors(t *testing.T) {
+	wrapped := &countingSubcConcArch{err: errors.New("db down")}
+	db := NewCachingSubcConcArchOps(wrapped, 10, time.Minute)
+	_, err := db.GetSubcorpusProps("a")
+	assert.Error(t, err)
+	_, err = db.GetSubcorpusProps("a")
+	assert.Error(t, err)
+	assert.Equal(t, 2, wrapped.numCalls)
+	assert.Equal(t, 0, db.SubcPropsCacheStats().Size)
+}
+
+type textTypesSubcConcArch struct {
+	IConcArchOps
+}
+
+func (db *textTypesSubcConcArch) GetSubcorpusProps(subcID string) (SubcProps, error) {
+	return SubcProps{Name: subcID, TextTypes: map[string][]string{"doc.genre": {"fiction"}}}, nil
+}
+
+func TestCachingSubcConcArchOpsReturnsCopies(t *testing.T) {
+	db := NewCachingSubcConcArchOps(&textTypesSubcConcArch{}, 10, time.Minute)
+	props, err := db.GetSubcorpusProps("a")
+	assert.NoError(t, err)
+	props.TextTypes["doc.genre"] = append(props.TextTypes["doc.genre"], "poetry")
+	props.TextTypes["doc.year"] = []string{"2020"}
+	props, err = db.GetSubcorpusProps("a")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"doc.genre": {"fiction"}}, props.TextTypes)
+}
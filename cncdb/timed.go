@@ -0,0 +1,134 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxTimedSamples = 10000
+)
+
+// TimedConcArchOps wraps an IConcArchOps instance and measures
+// duration of the write operations (InsertRecord, InsertRecordIfMissing,
+// DeduplicateInArchive and their context-bound variants).
+// Operations exceeding the configured threshold are logged. The collected
+// durations can be used to calculate latency percentiles (see
+// PopWriteLatencyP95).
+type TimedConcArchOps struct {
+	IConcArchOps
+	threshold time.Duration
+	samples   []time.Duration
+	mu        sync.Mutex
+}
+
+func (db *TimedConcArchOps) observe(op, recID string, dur time.Duration) {
+	if db.threshold > 0 && dur > db.threshold {
+		log.Warn().
+			Str("operation", op).
+			Str("recordId", recID).
+			Dur("duration", dur).
+			Dur("threshold", db.threshold).
+			Msg("slow archive write operation")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if len(db.samples) < maxTimedSamples {
+		db.samples = append(db.samples, dur)
+	}
+}
+
+func (db *TimedConcArchOps) InsertRecord(rec ArchRecord) error {
+	t0 := time.Now()
+	err := db.IConcArchOps.InsertRecord(rec)
+	db.observe("InsertRecord", rec.ID, time.Since(t0))
+	return err
+}
+
+func (db *TimedConcArchOps) InsertRecordCtx(ctx context.Context, rec ArchRecord) error {
+	t0 := time.Now()
+	err := db.IConcArchOps.InsertRecordCtx(ctx, rec)
+	db.observe("InsertRecord", rec.ID, time.Since(t0))
+	return err
+}
+
+func (db *TimedConcArchOps) InsertRecordIfMissing(rec ArchRecord) (bool, error) {
+	t0 := time.Now()
+	ans, err := db.IConcArchOps.InsertRecordIfMissing(rec)
+	db.observe("InsertRecordIfMissing", rec.ID, time.Since(t0))
+	return ans, err
+}
+
+func (db *TimedConcArchOps) InsertRecordIfMissingCtx(ctx context.Context, rec ArchRecord) (bool, error) {
+	t0 := time.Now()
+	ans, err := db.IConcArchOps.InsertRecordIfMissingCtx(ctx, rec)
+	db.observe("InsertRecordIfMissing", rec.ID, time.Since(t0))
+	return ans, err
+}
+
+func (db *TimedConcArchOps) DeduplicateInArchive(curr []ArchRecord, rec ArchRecord) (ArchRecord, error) {
+	t0 := time.Now()
+	ans, err := db.IConcArchOps.DeduplicateInArchive(curr, rec)
+	db.observe("DeduplicateInArchive", rec.ID, time.Since(t0))
+	return ans, err
+}
+
+func (db *TimedConcArchOps) DeduplicateInArchiveCtx(
+	ctx context.Context, curr []ArchRecord, rec ArchRecord) (ArchRecord, error) {
+	t0 := time.Now()
+	ans, err := db.IConcArchOps.DeduplicateInArchiveCtx(ctx, curr, rec)
+	db.observe("DeduplicateInArchive", rec.ID, time.Since(t0))
+	return ans, err
+}
+
+// PopWriteLatencyP95 returns 95th percentile of write operations
+// durations measured since the last call of the method.
+// With no measured operations, zero is returned.
+func (db *TimedConcArchOps) PopWriteLatencyP95() time.Duration {
+	db.mu.Lock()
+	samples := db.samples
+	db.samples = make([]time.Duration, 0, len(samples))
+	db.mu.Unlock()
+	return percentile(samples, 95)
+}
+
+// percentile calculates p-th percentile (nearest-rank method)
+// of provided durations. The slice is sorted in place.
+func percentile(samples []time.Duration, p int) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	slices.Sort(samples)
+	rank := (p*len(samples) + 99) / 100
+	return samples[max(rank, 1)-1]
+}
+
+// NewTimedConcArchOps creates a timing wrapper around db.
+// Zero threshold disables logging of slow operations.
+func NewTimedConcArchOps(db IConcArchOps, threshold time.Duration) *TimedConcArchOps {
+	return &TimedConcArchOps{
+		IConcArchOps: db,
+		threshold:    threshold,
+		samples:      make([]time.Duration, 0, 100),
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type slowConcArch struct {
+	IConcArchOps
+	delay time.Duration
+}
+
+func (db *slowConcArch) InsertRecord(rec ArchRecord) error {
+	time.Sleep(db.delay)
+	return nil
+}
+
+func TestPercentile(t *testing.T) {
+	assert.Equal(t, time.Duration(0), percentile([]time.Duration{}, 95))
+	assert.Equal(t, 3*time.Millisecond, percentile([]time.Duration{3 * time.Millisecond}, 95))
+	samples := make([]time.Duration, 100)
+	for i := range samples {
+		samples[i] = time.Duration(100-i) * time.Millisecond
+	}
+	assert.Equal(t, 95*time.Millisecond, percentile(samples, 95))
+}
+
+func TestTimedConcArchOps(t *testing.T) {
+	db := NewTimedConcArchOps(&slowConcArch{delay: 5 * time.Millisecond}, time.Millisecond)
+	assert.NoError(t, db.InsertRecord(ArchRecord{ID: "foo"}))
+	assert.NoError(t, db.InsertRecord(ArchRecord{ID: "bar"}))
+	assert.GreaterOrEqual(t, db.PopWriteLatencyP95(), 5*time.Millisecond)
+	assert.Equal(t, time.Duration(0), db.PopWriteLatencyP95())
+}
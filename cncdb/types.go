@@ -20,6 +20,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -46,11 +48,7 @@ func (rec GeneralDataRecord) GetCorpora() []string {
 	if !ok {
 		return []string{}
 	}
-	typedV, ok := v.([]string)
-	if !ok {
-		return []string{}
-	}
-	return typedV
+	return anyToStrings(v)
 }
 
 func (rec GeneralDataRecord) GetQuery() []string {
@@ -58,11 +56,37 @@ func (rec GeneralDataRecord) GetQuery() []string {
 	if !ok {
 		return []string{}
 	}
-	typedV, ok := v.([]string)
+	return anyToStrings(v)
+}
+
+// IsFlaggedAsSlow tests whether KonText marked the query
+// with the `treat_as_slow_query` flag
+func (rec GeneralDataRecord) IsFlaggedAsSlow() bool {
+	v, ok := rec["treat_as_slow_query"]
 	if !ok {
-		return []string{}
+		return false
 	}
-	return typedV
+	typedV, ok := v.(bool)
+	return ok && typedV
+}
+
+// anyToStrings converts a JSON-decoded list (which is always []any
+// when unmarshaled into an untyped map) to a list of strings.
+// Non-string items are skipped.
+func anyToStrings(v any) []string {
+	switch tv := v.(type) {
+	case []string:
+		return tv
+	case []any:
+		ans := make([]string, 0, len(tv))
+		for _, item := range tv {
+			if s, ok := item.(string); ok {
+				ans = append(ans, s)
+			}
+		}
+		return ans
+	}
+	return []string{}
 }
 
 // ----------------------------------
@@ -98,3 +122,21 @@ type HistoryRecord struct {
 func (qh *HistoryRecord) CreateIndexID() string {
 	return fmt.Sprintf("%d/%d/%s", qh.UserID, qh.Created, qh.QueryID)
 }
+
+// ParseIndexID creates a history record (without name and data)
+// from an index document ID (see HistoryRecord.CreateIndexID).
+func ParseIndexID(docID string) (HistoryRecord, error) {
+	items := strings.SplitN(docID, "/", 3)
+	if len(items) != 3 || items[2] == "" {
+		return HistoryRecord{}, fmt.Errorf("invalid index document ID `%s`", docID)
+	}
+	userID, err := strconv.Atoi(items[0])
+	if err != nil {
+		return HistoryRecord{}, fmt.Errorf("invalid index document ID `%s`: %w", docID, err)
+	}
+	created, err := strconv.ParseInt(items[1], 10, 64)
+	if err != nil {
+		return HistoryRecord{}, fmt.Errorf("invalid index document ID `%s`: %w", docID, err)
+	}
+	return HistoryRecord{QueryID: items[2], UserID: userID, Created: created}, nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cncdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnyToStrings(t *testing.T) {
+	for name, tc := range map[string]struct {
+		input    any
+		expected []string
+	}{
+		"list of any":     {input: []any{"a", "b"}, expected: []string{"a", "b"}},
+		"list of strings": {input: []string{"a", "b"}, expected: []string{"a", "b"}},
+		"plain string":    {input: "a", expected: []string{}},
+		"mixed":           {input: []any{"a", 1, nil, "b", true}, expected: []string{"a", "b"}},
+		"nil":             {input: nil, expected: []string{}},
+		"empty list":      {input: []any{}, expected: []string{}},
+	} {
+		assert.Equal(t, tc.expected, anyToStrings(tc.input), name)
+	}
+}
+
+func TestGetQueryFromJSON(t *testing.T) {
+	var rec GeneralDataRecord
+	err := json.Unmarshal(
+		[]byte(`{"q": ["aword,[word=\"dům\"]", "r250"], "corpora": ["syn2020", "intercorp_en"]}`),
+		&rec,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"aword,[word=\"dům\"]", "r250"}, rec.GetQuery())
+	assert.Equal(t, []string{"syn2020", "intercorp_en"}, rec.GetCorpora())
+	assert.Equal(t, []string{}, GeneralDataRecord{}.GetQuery())
+	assert.Equal(t, []string{}, GeneralDataRecord{"corpora": "syn2020"}.GetCorpora())
+}
@@ -21,13 +21,14 @@ import (
 	"camus/cleaner"
 	"camus/cncdb"
 	"camus/indexer"
+	"camus/reporting"
 	"encoding/json"
 	"fmt"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/czcorpus/cnc-gokit/logging"
-	"github.com/czcorpus/hltscl"
 	"github.com/rs/zerolog/log"
 )
 
@@ -35,8 +36,54 @@ const (
 	dfltServerWriteTimeoutSecs = 30
 	dfltLanguage               = "en"
 	dfltTimeZone               = "Europe/Prague"
+	dfltShutdownTimeoutSecs    = 10
+	dfltSearchRateLimit        = 5
+	dfltSearchRateLimitBurst   = 20
+	dfltAuthHeaderName         = "X-Api-Key"
 )
 
+// names of services as used in `serviceShutdownTimeoutSecs`
+const (
+	ServiceIndexer        = "indexer"
+	ServiceArchiver       = "archiver"
+	ServiceCleaner        = "cleaner"
+	ServiceFulltext       = "fulltext"
+	ServiceAPIServer      = "apiServer"
+	ServiceReporting      = "reporting"
+	ServiceQueryHistoryGC = "queryHistoryGC"
+)
+
+var knownServices = []string{
+	ServiceIndexer, ServiceArchiver, ServiceCleaner, ServiceFulltext,
+	ServiceAPIServer, ServiceReporting, ServiceQueryHistoryGC,
+}
+
+const (
+	RateLimitKeyUser = "user"
+	RateLimitKeyIP   = "ip"
+)
+
+// RateLimitConf configures a token bucket rate limiter. Tokens are
+// refilled at RequestsPerSec rate up to Burst tokens. A negative
+// RequestsPerSec disables the limiting.
+type RateLimitConf struct {
+	RequestsPerSec float64 `json:"requestsPerSec"`
+	Burst          int     `json:"burst"`
+
+	// KeyBy specifies how clients are distinguished:
+	//   * `user` (default) - by the `userId` URL parameter; as the ID is
+	//     supplied by the client, this works only for trusted clients
+	//     (e.g. KonText backend calling Camus on behalf of its users) -
+	//     a client rotating user IDs is not limited,
+	//   * `ip` - by the client IP address (please note that all the users
+	//     of a proxying backend share a single limit in this mode).
+	KeyBy string `json:"keyBy"`
+}
+
+func (conf *RateLimitConf) IsEnabled() bool {
+	return conf.RequestsPerSec > 0
+}
+
 type Conf struct {
 	srcPath                string
 	ListenAddress          string              `json:"listenAddress"`
@@ -54,7 +101,28 @@ type Conf struct {
 	Archiver               *archiver.Conf      `json:"archiver"`
 	Indexer                *indexer.Conf       `json:"indexer"`
 	Cleaner                cleaner.Conf        `json:"cleaner"`
-	Reporting              hltscl.PgConf       `json:"reporting"`
+	Reporting              reporting.Conf      `json:"reporting"`
+
+	// ShutdownTimeoutSecs specifies how long each service is given
+	// to stop once a shutdown signal is received.
+	ShutdownTimeoutSecs int `json:"shutdownTimeoutSecs"`
+
+	// ServiceShutdownTimeoutSecs allows for overriding ShutdownTimeoutSecs
+	// for individual services (e.g. the indexer may need more time to
+	// flush its data). Keys are service names (see Service* constants).
+	ServiceShutdownTimeoutSecs map[string]int `json:"serviceShutdownTimeoutSecs"`
+
+	// StrictTimers makes Camus refuse to start in case intervals
+	// of database-heavy periodic actions of different services
+	// (archiver, cleaner, query history GC) are multiples of each
+	// other and thus would repeatedly fire at the same time.
+	// By default, only a warning is logged.
+	StrictTimers bool `json:"strictTimers"`
+
+	// SearchRateLimit limits the user query history search
+	// and suggest endpoints (per user ID). Admin endpoints
+	// are not limited.
+	SearchRateLimit RateLimitConf `json:"searchRateLimit"`
 }
 
 func (conf *Conf) TimezoneLocation() *time.Location {
@@ -65,6 +133,24 @@ func (conf *Conf) TimezoneLocation() *time.Location {
 	return loc
 }
 
+// ShutdownTimeout returns a shutdown timeout for a service
+func (conf *Conf) ShutdownTimeout(service string) time.Duration {
+	if v, ok := conf.ServiceShutdownTimeoutSecs[service]; ok {
+		return time.Duration(v) * time.Second
+	}
+	return time.Duration(conf.ShutdownTimeoutSecs) * time.Second
+}
+
+// MaxShutdownTimeout returns the longest of all the configured
+// shutdown timeouts.
+func (conf *Conf) MaxShutdownTimeout() time.Duration {
+	ans := time.Duration(conf.ShutdownTimeoutSecs) * time.Second
+	for _, v := range conf.ServiceShutdownTimeoutSecs {
+		ans = max(ans, time.Duration(v)*time.Second)
+	}
+	return ans
+}
+
 func LoadConfig(path string) *Conf {
 	if path == "" {
 		log.Fatal().Msg("Cannot load cnfig - path not specified")
@@ -90,6 +176,68 @@ func ValidateAndDefaults(conf *Conf) {
 			dfltServerWriteTimeoutSecs,
 		)
 	}
+	if conf.ShutdownTimeoutSecs == 0 {
+		conf.ShutdownTimeoutSecs = dfltShutdownTimeoutSecs
+		log.Warn().Msgf(
+			"shutdownTimeoutSecs not specified, using default: %d",
+			dfltShutdownTimeoutSecs,
+		)
+	}
+	if conf.ShutdownTimeoutSecs < 0 {
+		log.Fatal().Msg("invalid shutdownTimeoutSecs (must be > 0)")
+	}
+	for name, v := range conf.ServiceShutdownTimeoutSecs {
+		if !slices.Contains(knownServices, name) {
+			log.Fatal().
+				Str("service", name).
+				Strs("knownServices", knownServices).
+				Msg("invalid serviceShutdownTimeoutSecs - unknown service")
+		}
+		if v <= 0 {
+			log.Fatal().
+				Str("service", name).
+				Msg("invalid serviceShutdownTimeoutSecs (must be > 0)")
+		}
+	}
+	if conf.SearchRateLimit.RequestsPerSec == 0 {
+		conf.SearchRateLimit.RequestsPerSec = dfltSearchRateLimit
+		log.Warn().Msgf(
+			"searchRateLimit.requestsPerSec not specified, using default: %d",
+			dfltSearchRateLimit,
+		)
+	}
+	if conf.SearchRateLimit.Burst == 0 {
+		conf.SearchRateLimit.Burst = dfltSearchRateLimitBurst
+		log.Warn().Msgf(
+			"searchRateLimit.burst not specified, using default: %d",
+			dfltSearchRateLimitBurst,
+		)
+	}
+	if conf.SearchRateLimit.Burst < 0 {
+		log.Fatal().Msg("invalid searchRateLimit.burst (must be > 0)")
+	}
+	if conf.SearchRateLimit.KeyBy == "" {
+		conf.SearchRateLimit.KeyBy = RateLimitKeyUser
+		log.Warn().Msgf(
+			"searchRateLimit.keyBy not specified, using default: %s",
+			RateLimitKeyUser,
+		)
+	}
+	if conf.SearchRateLimit.KeyBy != RateLimitKeyUser && conf.SearchRateLimit.KeyBy != RateLimitKeyIP {
+		log.Fatal().
+			Str("value", conf.SearchRateLimit.KeyBy).
+			Msg("invalid searchRateLimit.keyBy (must be `user` or `ip`)")
+	}
+	if conf.AuthHeaderName == "" {
+		conf.AuthHeaderName = dfltAuthHeaderName
+		log.Warn().Msgf(
+			"authHeaderName not specified, using default: %s",
+			dfltAuthHeaderName,
+		)
+	}
+	if len(conf.AuthTokens) == 0 {
+		log.Warn().Msg("authTokens not specified, admin-only endpoints will be inaccessible")
+	}
 	if conf.PublicURL == "" {
 		conf.PublicURL = fmt.Sprintf("http://%s", conf.ListenAddress)
 		log.Warn().Str("address", conf.PublicURL).Msg("publicUrl not set, using listenAddress")
@@ -108,6 +256,10 @@ func ValidateAndDefaults(conf *Conf) {
 		log.Fatal().Err(err).Msg("invalid Redis configuration")
 	}
 
+	if err := conf.MySQL.ValidateAndDefaults(); err != nil {
+		log.Fatal().Err(err).Msg("invalid database configuration")
+	}
+
 	if err := conf.Archiver.ValidateAndDefaults(); err != nil {
 		log.Fatal().Err(err).Msg("invalid archiver configuration")
 	}
@@ -119,4 +271,19 @@ func ValidateAndDefaults(conf *Conf) {
 	if err := conf.Indexer.ValidateAndDefaults(); err != nil {
 		log.Fatal().Err(err).Msg("invalid indexer configuration")
 	}
+
+	if err := conf.Reporting.ValidateAndDefaults(); err != nil {
+		log.Fatal().Err(err).Msg("invalid reporting configuration")
+	}
+
+	for _, conflict := range findTimerConflicts(conf.dbTimers()) {
+		if conf.StrictTimers {
+			log.Fatal().
+				Str("conflict", conflict.String()).
+				Msg("aligned service timers (strictTimers is on)")
+		}
+		log.Warn().
+			Str("conflict", conflict.String()).
+			Msg("aligned service timers may overload the database")
+	}
 }
@@ -0,0 +1,76 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnf
+
+import "camus/cncdb"
+
+// redactedValue replaces values of sensitive configuration
+// items in Redacted
+const redactedValue = "********"
+
+func redact(v string) string {
+	if v == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+// redactedDBConf returns a redacted copy of a database configuration
+// including its (optional) read replica
+func redactedDBConf(conf *cncdb.DBConf) *cncdb.DBConf {
+	if conf == nil {
+		return nil
+	}
+	ans := *conf
+	ans.Password = redact(conf.Password)
+	ans.ReadReplica = redactedDBConf(conf.ReadReplica)
+	return &ans
+}
+
+// Redacted returns a copy of the configuration with all the known
+// sensitive values (passwords, tokens, API keys) replaced by a placeholder
+// so it can be exposed e.g. via the API. Empty values are kept empty
+// so it is still visible what is not configured.
+// Please note that any new sensitive configuration item must be
+// added here explicitly.
+func (conf *Conf) Redacted() *Conf {
+	ans := *conf
+	if conf.AuthTokens != nil {
+		ans.AuthTokens = make([]string, len(conf.AuthTokens))
+		for i, token := range conf.AuthTokens {
+			ans.AuthTokens[i] = redact(token)
+		}
+	}
+	if conf.Redis != nil {
+		redis := *conf.Redis
+		redis.Password = redact(redis.Password)
+		ans.Redis = &redis
+	}
+	ans.MySQL = redactedDBConf(conf.MySQL)
+	if conf.Indexer != nil {
+		idx := *conf.Indexer
+		if idx.Elasticsearch != nil {
+			es := *idx.Elasticsearch
+			es.Password = redact(es.Password)
+			es.APIKey = redact(es.APIKey)
+			idx.Elasticsearch = &es
+		}
+		ans.Indexer = &idx
+	}
+	ans.Reporting.Passwd = redact(conf.Reporting.Passwd)
+	return &ans
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnf
+
+import (
+	"camus/archiver"
+	"camus/cncdb"
+	"camus/indexer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactedHidesSensitiveValues(t *testing.T) {
+	conf := &Conf{
+		AuthTokens: []string{"token1", "", "token2"},
+		Redis:      &archiver.RedisConf{Password: "redis-secret"},
+		MySQL: &cncdb.DBConf{
+			Host:     "primary",
+			Password: "mysql-secret",
+			ReadReplica: &cncdb.DBConf{
+				Host:     "replica",
+				Password: "replica-secret",
+			},
+		},
+		Indexer: &indexer.Conf{
+			Elasticsearch: &indexer.ElasticConf{
+				Password: "es-secret",
+				APIKey:   "es-api-key",
+			},
+		},
+	}
+	conf.Reporting.Passwd = "reporting-secret"
+
+	ans := conf.Redacted()
+	assert.Equal(t, []string{redactedValue, "", redactedValue}, ans.AuthTokens)
+	assert.Equal(t, redactedValue, ans.Redis.Password)
+	assert.Equal(t, redactedValue, ans.MySQL.Password)
+	assert.Equal(t, "primary", ans.MySQL.Host)
+	assert.Equal(t, redactedValue, ans.MySQL.ReadReplica.Password)
+	assert.Equal(t, "replica", ans.MySQL.ReadReplica.Host)
+	assert.Equal(t, redactedValue, ans.Indexer.Elasticsearch.Password)
+	assert.Equal(t, redactedValue, ans.Indexer.Elasticsearch.APIKey)
+	assert.Equal(t, redactedValue, ans.Reporting.Passwd)
+}
+
+func TestRedactedKeepsOriginalIntact(t *testing.T) {
+	conf := &Conf{
+		AuthTokens: []string{"token1"},
+		Redis:      &archiver.RedisConf{Password: "redis-secret"},
+		MySQL: &cncdb.DBConf{
+			Password:    "mysql-secret",
+			ReadReplica: &cncdb.DBConf{Password: "replica-secret"},
+		},
+		Indexer: &indexer.Conf{
+			Elasticsearch: &indexer.ElasticConf{Password: "es-secret", APIKey: "es-api-key"},
+		},
+	}
+	conf.Reporting.Passwd = "reporting-secret"
+
+	conf.Redacted()
+	assert.Equal(t, []string{"token1"}, conf.AuthTokens)
+	assert.Equal(t, "redis-secret", conf.Redis.Password)
+	assert.Equal(t, "mysql-secret", conf.MySQL.Password)
+	assert.Equal(t, "replica-secret", conf.MySQL.ReadReplica.Password)
+	assert.Equal(t, "es-secret", conf.Indexer.Elasticsearch.Password)
+	assert.Equal(t, "es-api-key", conf.Indexer.Elasticsearch.APIKey)
+	assert.Equal(t, "reporting-secret", conf.Reporting.Passwd)
+}
+
+func TestRedactedWithoutReplica(t *testing.T) {
+	conf := &Conf{MySQL: &cncdb.DBConf{Password: ""}}
+	ans := conf.Redacted()
+	assert.Equal(t, "", ans.MySQL.Password)
+	assert.Nil(t, ans.MySQL.ReadReplica)
+}
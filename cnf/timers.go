@@ -0,0 +1,90 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnf
+
+import (
+	"camus/util"
+	"fmt"
+)
+
+// namedTimer is a periodic database-heavy action of a service
+type namedTimer struct {
+	service string
+	name    string
+	secs    int
+}
+
+// timerConflict describes two timers of different services
+// where each run of the longer one coincides with a run
+// of the shorter one.
+type timerConflict struct {
+	shorter   namedTimer
+	longer    namedTimer
+	suggested int
+}
+
+func (tc timerConflict) String() string {
+	return fmt.Sprintf(
+		"`%s` (%ds) is a multiple of `%s` (%ds), consider using %ds",
+		tc.longer.name, tc.longer.secs, tc.shorter.name, tc.shorter.secs, tc.suggested,
+	)
+}
+
+func (conf *Conf) dbTimers() []namedTimer {
+	return []namedTimer{
+		{service: ServiceArchiver, name: "archiver.checkIntervalSecs", secs: conf.Archiver.CheckIntervalSecs},
+		{service: ServiceCleaner, name: "cleaner.checkIntervalSecs", secs: conf.Cleaner.CheckIntervalSecs},
+		{
+			service: ServiceQueryHistoryGC,
+			name:    "indexer.queryHistoryCleanupInterval",
+			secs:    int(conf.Indexer.QueryHistoryCleanupIntervalDur().Seconds()),
+		},
+		{
+			service: ServiceQueryHistoryGC,
+			name:    "indexer.queryHistoryMarkPendingInterval",
+			secs:    int(conf.Indexer.QueryHistoryMarkPendingIntervalDur().Seconds()),
+		},
+	}
+}
+
+// findTimerConflicts searches for harmonically aligned timers
+// of different services. Timers of the same service are not
+// compared as they run within a single loop and cannot overlap.
+func findTimerConflicts(timers []namedTimer) []timerConflict {
+	ans := make([]timerConflict, 0, len(timers))
+	for i, t1 := range timers {
+		for _, t2 := range timers[i+1:] {
+			if t1.service == t2.service || t1.secs < 2 || t2.secs < 2 {
+				continue
+			}
+			shorter, longer := t1, t2
+			if shorter.secs > longer.secs {
+				shorter, longer = longer, shorter
+			}
+			if longer.secs%shorter.secs != 0 {
+				continue
+			}
+			// a prime larger than the shorter interval cannot be its multiple
+			suggested, err := util.NearestPrime(longer.secs + 1)
+			if err != nil {
+				suggested = longer.secs + 1
+			}
+			ans = append(ans, timerConflict{shorter: shorter, longer: longer, suggested: suggested})
+		}
+	}
+	return ans
+}
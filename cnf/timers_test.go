@@ -0,0 +1,105 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cnf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindTimerConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		timers   []namedTimer
+		expected []string
+	}{
+		{
+			name: "overlapping (multiple)",
+			timers: []namedTimer{
+				{service: "a", name: "a.timer", secs: 60},
+				{service: "b", name: "b.timer", secs: 120},
+			},
+			expected: []string{"`b.timer` (120s) is a multiple of `a.timer` (60s), consider using 127s"},
+		},
+		{
+			name: "overlapping (same interval)",
+			timers: []namedTimer{
+				{service: "a", name: "a.timer", secs: 30},
+				{service: "b", name: "b.timer", secs: 30},
+			},
+			expected: []string{"`b.timer` (30s) is a multiple of `a.timer` (30s), consider using 31s"},
+		},
+		{
+			name: "adjacent",
+			timers: []namedTimer{
+				{service: "a", name: "a.timer", secs: 60},
+				{service: "b", name: "b.timer", secs: 61},
+			},
+			expected: []string{},
+		},
+		{
+			name: "disjoint",
+			timers: []namedTimer{
+				{service: "a", name: "a.timer", secs: 7},
+				{service: "b", name: "b.timer", secs: 11},
+				{service: "c", name: "c.timer", secs: 13},
+			},
+			expected: []string{},
+		},
+		{
+			name: "same service",
+			timers: []namedTimer{
+				{service: "a", name: "a.timer1", secs: 60},
+				{service: "a", name: "a.timer2", secs: 120},
+			},
+			expected: []string{},
+		},
+		{
+			name: "disabled or too short timer",
+			timers: []namedTimer{
+				{service: "a", name: "a.timer", secs: 0},
+				{service: "b", name: "b.timer", secs: 1},
+				{service: "c", name: "c.timer", secs: 60},
+			},
+			expected: []string{},
+		},
+		{
+			name: "multiple conflicts",
+			timers: []namedTimer{
+				{service: "a", name: "a.timer", secs: 10},
+				{service: "b", name: "b.timer", secs: 20},
+				{service: "c", name: "c.timer", secs: 23},
+				{service: "d", name: "d.timer", secs: 40},
+			},
+			expected: []string{
+				"`b.timer` (20s) is a multiple of `a.timer` (10s), consider using 23s",
+				"`d.timer` (40s) is a multiple of `a.timer` (10s), consider using 41s",
+				"`d.timer` (40s) is a multiple of `b.timer` (20s), consider using 41s",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ans := make([]string, 0, len(tt.expected))
+			for _, c := range findTimerConflicts(tt.timers) {
+				ans = append(ans, c.String())
+			}
+			assert.Equal(t, tt.expected, ans)
+		})
+	}
+}
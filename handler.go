@@ -17,15 +17,37 @@
 package main
 
 import (
+	"camus/apierr"
 	"camus/archiver"
 	"camus/cncdb"
+	"camus/reporting"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/czcorpus/cnc-gokit/uniresp"
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxChainLength           = 100
+	defaultNumListedErrors   = 20
+	defaultNumPeekedItems    = 20
+	defaultNumMergeAudits    = 20
+	maxValidateBatchSize     = 10000
+	validateBatchNumWorkers  = 8
+	defaultNumDailyStatsDays = 30
+	maxNumDailyStatsDays     = 3660
+	maxNumRequeuedItems      = 10000
+	defaultNumRecentItems    = 20
 )
 
 var (
@@ -51,10 +73,64 @@ func (v visitedIds) IDList() []string {
 	return ans
 }
 
+// writeJSONArray encodes items one by one directly to
+// the writer instead of marshaling the whole slice at once.
+func writeJSONArray[T any](w io.Writer, items []T) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for i, item := range items {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+// ------
+
+type recentItem struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Corpora []string  `json:"corpora,omitempty"`
+}
+
+// ------
+
+type chainItem struct {
+	ID      string   `json:"id"`
+	Query   string   `json:"query"`
+	Corpora []string `json:"corpora"`
+	cncdb.QueryOps
+}
+
 // ------
 
+// knownErrors maps errors produced by the archiver
+// to API error codes (see apierr.Respond)
+var knownErrors = []apierr.KnownError{
+	{Err: cncdb.ErrRecordNotFound, Code: apierr.CodeRecordNotFound, Status: http.StatusNotFound},
+	{Err: cncdb.ErrTooDemandingQuery, Code: apierr.CodeTooDemandingQuery, Status: http.StatusUnprocessableEntity},
+}
+
+func respondWithError(ctx *gin.Context, err error, status int) {
+	apierr.Respond(ctx, err, status, knownErrors)
+}
+
 type Actions struct {
-	ArchKeeper *archiver.ArchKeeper
+	ArchKeeper  *archiver.ArchKeeper
+	RecentStats *reporting.InMemoryReporting
+	TZ          *time.Location
+
+	// MergeAuditLog is nil in case the merge audit is disabled
+	MergeAuditLog *cncdb.MergeAuditLog
 }
 
 func (a *Actions) Overview(ctx *gin.Context) {
@@ -66,64 +142,357 @@ func (a *Actions) Overview(ctx *gin.Context) {
 	}
 	totals, err := a.ArchKeeper.YearsStats(forceTotalsReload)
 	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		respondWithError(ctx, err, http.StatusInternalServerError)
 		return
 	}
 	ans["totals"] = totals
+	totalRecords, err := a.ArchKeeper.TotalRecords()
+	if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	ans["totalRecords"] = totalRecords
+	ans["dedup"] = a.ArchKeeper.DedupStateInfo()
+	if a.RecentStats != nil {
+		recent := a.RecentStats.Recent()
+		ans["cleanup"] = recent.Cleanup
+		ans["queryHistoryDeletion"] = recent.QueryHistoryDeletion
+		ans["reportingNumDroppedEntries"] = a.RecentStats.NumDroppedEntries()
+	}
 	uniresp.WriteJSONResponse(ctx.Writer, ans)
 }
 
+// dailyStatsInterval converts `from` and `to` arguments of DailyStats
+// (both inclusive, YYYY-MM-DD, possibly empty) into a right-open
+// interval. The default interval (the last defaultNumDailyStatsDays days)
+// always fits within cncdb.MaxDaytimeDailyCountsRange.
+func dailyStatsInterval(now time.Time, fromArg, toArg string, tz *time.Location) (time.Time, time.Time, error) {
+	now = now.In(tz)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, tz)
+	if toArg != "" {
+		var err error
+		to, err = time.ParseInLocation(time.DateOnly, toArg, tz)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid `to` date")
+		}
+	}
+	from := to.AddDate(0, 0, -(defaultNumDailyStatsDays - 1))
+	if fromArg != "" {
+		var err error
+		from, err = time.ParseInLocation(time.DateOnly, fromArg, tz)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid `from` date")
+		}
+	}
+	to = to.AddDate(0, 0, 1) // make the interval right-open
+	if !from.Before(to) || to.Sub(from) > maxNumDailyStatsDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid interval (max. %d days)", maxNumDailyStatsDays)
+	}
+	return from, to, nil
+}
+
+// DailyStats returns numbers of archived records per day.
+// Optional URL arguments `from` and `to` (both inclusive, YYYY-MM-DD)
+// default to the last 30 days. Intervals longer than
+// cncdb.MaxDaytimeDailyCountsRange are refused during daytime.
+func (a *Actions) DailyStats(ctx *gin.Context) {
+	from, to, err := dailyStatsInterval(time.Now(), ctx.Query("from"), ctx.Query("to"), a.TZ)
+	if err != nil {
+		respondWithError(ctx, err, http.StatusBadRequest)
+		return
+	}
+	ans, err := a.ArchKeeper.DailyStats(from, to)
+	if errors.Is(err, cncdb.ErrTooDemandingQuery) {
+		respondWithError(
+			ctx,
+			fmt.Errorf("interval too long to be processed outside night time: %w", err),
+			http.StatusUnprocessableEntity,
+		)
+		return
+
+	} else if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, ans)
+}
+
+// RequeueHistory pushes query history items (a JSON array of
+// `{queryId, userId, created}` objects) back to the archive queue
+// so they are re-indexed.
+func (a *Actions) RequeueHistory(ctx *gin.Context) {
+	var items []archiver.HistoryQueueItem
+	if err := ctx.BindJSON(&items); err != nil {
+		respondWithError(ctx, err, http.StatusBadRequest)
+		return
+	}
+	if len(items) == 0 || len(items) > maxNumRequeuedItems {
+		respondWithError(
+			ctx,
+			fmt.Errorf("expected 1 to %d items", maxNumRequeuedItems),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	for i, item := range items {
+		if item.QueryID == "" || item.Created <= 0 {
+			respondWithError(
+				ctx,
+				fmt.Errorf("invalid item %d: both `queryId` and `created` must be set", i),
+				http.StatusBadRequest,
+			)
+			return
+		}
+	}
+	numEnqueued, err := a.ArchKeeper.RequeueHistory(items)
+	if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"numEnqueued": numEnqueued})
+}
+
+// Flush runs a single archive check immediately and returns
+// the respective operation stats.
+func (a *Actions) Flush(ctx *gin.Context) {
+	stats, err := a.ArchKeeper.Flush()
+	if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, stats)
+}
+
+// GetRecord returns all the archived variants of a record.
+// Optional URL arguments:
+//   - `variant` - return just the N-th variant (as a single object)
+//   - `limit` - return at most N variants
+//
+// The variants are streamed to the client one by one so even
+// large records do not require the whole response to be built
+// in memory.
 func (a *Actions) GetRecord(ctx *gin.Context) {
-	rec, err := a.ArchKeeper.LoadRecordsByID(ctx.Param("id"))
+	recs, err := a.ArchKeeper.LoadRecordsByID(ctx.Param("id"))
 	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError) // TODO
+		respondWithError(ctx, err, http.StatusInternalServerError) // TODO
+		return
+	}
+	ctx.Header("X-Total-Variants", strconv.Itoa(len(recs)))
+	if variantArg := ctx.Query("variant"); variantArg != "" {
+		variant, err := strconv.Atoi(variantArg)
+		if err != nil || variant < 0 {
+			respondWithError(
+				ctx, fmt.Errorf("invalid variant argument"), http.StatusBadRequest)
+			return
+		}
+		if variant >= len(recs) {
+			respondWithError(
+				ctx, fmt.Errorf("variant %d not found", variant), http.StatusNotFound)
+			return
+		}
+		uniresp.WriteJSONResponse(ctx.Writer, recs[variant])
 		return
 	}
-	uniresp.WriteJSONResponse(ctx.Writer, rec)
+	if limitArg := ctx.Query("limit"); limitArg != "" {
+		limit, err := strconv.Atoi(limitArg)
+		if err != nil || limit < 0 {
+			respondWithError(
+				ctx, fmt.Errorf("invalid limit argument"), http.StatusBadRequest)
+			return
+		}
+		if limit < len(recs) {
+			recs = recs[:limit]
+		}
+	}
+	if err := writeJSONArray(ctx.Writer, recs); err != nil {
+		log.Error().Err(err).Str("id", ctx.Param("id")).Msg("failed to stream record variants")
+	}
 }
 
-func (a *Actions) Validate(ctx *gin.Context) {
-	currID := ctx.Param("id")
+// validation statuses as reported by validateChain
+const (
+	validationStatusOK                = "ok"
+	validationStatusCycle             = "cycle"
+	validationStatusInconsistentQuery = "inconsistentQuery"
+	validationStatusError             = "error"
+)
+
+type validationResult struct {
+	ID         string   `json:"id"`
+	Status     string   `json:"status"`
+	Message    string   `json:"message,omitempty"`
+	VisitedIDs []string `json:"visitedIds,omitempty"`
+}
+
+// validateChain follows the `prev_id` chain of the record `id`
+// and tests for cycles and for inconsistent queries across
+// the instances of the involved records.
+func (a *Actions) validateChain(id string) (validationResult, error) {
+	currID := id
 	visitedIDs := make(visitedIds)
 	for currID != "" {
 		visitedIDs[currID]++
 		if visitedIDs.containsCycle() {
-			uniresp.WriteJSONResponse(
-				ctx.Writer,
-				map[string]any{"message": fmt.Sprintf("Possible cycle in %s", currID)},
-			)
-			return
+			return validationResult{
+				ID:      id,
+				Status:  validationStatusCycle,
+				Message: fmt.Sprintf("Possible cycle in %s", currID),
+			}, nil
 		}
 		recs, err := a.ArchKeeper.LoadRecordsByID(currID)
 		if err != nil {
-			uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError) // TODO
-			return
+			return validationResult{}, err
 		}
 		queryVariants := make(map[string]int)
 		var reprData cncdb.GeneralDataRecord
 		for _, rec := range recs {
 			data, err := rec.FetchData()
 			if err != nil {
-				uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError) // TODO
-				return
+				return validationResult{}, err
 			}
 			queryVariants[strings.Join(data.GetQuery(), " ")]++
 			reprData = data
 		}
 		if len(queryVariants) > 1 {
-			uniresp.WriteJSONResponse(
-				ctx.Writer,
-				map[string]any{"message": "Inconsistent query across instances"},
-			)
-			return
+			return validationResult{
+				ID:      id,
+				Status:  validationStatusInconsistentQuery,
+				Message: "Inconsistent query across instances",
+			}, nil
 		}
 		currID = reprData.GetPrevID()
 	}
+	return validationResult{
+		ID:         id,
+		Status:     validationStatusOK,
+		VisitedIDs: visitedIDs.IDList(),
+	}, nil
+}
+
+func (a *Actions) Validate(ctx *gin.Context) {
+	res, err := a.validateChain(ctx.Param("id"))
+	if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError) // TODO
+		return
+	}
+	if res.Status != validationStatusOK {
+		uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"message": res.Message})
+		return
+	}
 	uniresp.WriteJSONResponse(
 		ctx.Writer,
 		map[string]any{
 			"ok":         true,
-			"visitedIds": visitedIDs.IDList(),
+			"visitedIds": res.VisitedIDs,
+		},
+	)
+}
+
+// ValidateBatch validates chains of multiple records (provided
+// as a JSON array of IDs) concurrently. Results are returned
+// in the order of the provided IDs.
+func (a *Actions) ValidateBatch(ctx *gin.Context) {
+	var ids []string
+	if err := ctx.BindJSON(&ids); err != nil {
+		respondWithError(ctx, err, http.StatusBadRequest)
+		return
+	}
+	if len(ids) > maxValidateBatchSize {
+		respondWithError(
+			ctx,
+			fmt.Errorf("too many IDs (max. %d)", maxValidateBatchSize),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	results := make([]validationResult, len(ids))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(validateBatchNumWorkers, len(ids)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				res, err := a.validateChain(ids[idx])
+				if err != nil {
+					res = validationResult{
+						ID:      ids[idx],
+						Status:  validationStatusError,
+						Message: err.Error(),
+					}
+				}
+				results[idx] = res
+			}
+		}()
+	}
+	for i := range ids {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	stats := make(map[string]int)
+	for _, res := range results {
+		stats[res.Status]++
+	}
+	uniresp.WriteJSONResponse(
+		ctx.Writer,
+		map[string]any{
+			"results": results,
+			"summary": stats,
+		},
+	)
+}
+
+// Chain returns the list of records obtained by following the `prev_id`
+// chain from the record specified by the `id` URL parameter back
+// to the root record (i.e. the first query in a sequence of operations
+// like filter, shuffle, sample etc.).
+func (a *Actions) Chain(ctx *gin.Context) {
+	currID := ctx.Param("id")
+	visitedIDs := make(visitedIds)
+	chain := make([]chainItem, 0, 10)
+	var hasCycle, truncated bool
+	for currID != "" {
+		if len(chain) >= maxChainLength {
+			truncated = true
+			break
+		}
+		visitedIDs[currID]++
+		if visitedIDs.containsCycle() {
+			hasCycle = true
+			break
+		}
+		recs, err := a.ArchKeeper.LoadRecordsByID(currID)
+		if err != nil {
+			respondWithError(ctx, err, http.StatusInternalServerError)
+			return
+		}
+		if len(recs) == 0 {
+			if len(chain) == 0 {
+				respondWithError(ctx, cncdb.ErrRecordNotFound, http.StatusNotFound)
+				return
+			}
+			break
+		}
+		data, err := recs[0].FetchData()
+		if err != nil {
+			respondWithError(ctx, err, http.StatusInternalServerError)
+			return
+		}
+		chain = append(chain, chainItem{
+			ID:       currID,
+			Query:    strings.Join(data.GetQuery(), " "),
+			Corpora:  data.GetCorpora(),
+			QueryOps: data.GetQueryOps(),
+		})
+		currID = data.GetPrevID()
+	}
+	uniresp.WriteJSONResponse(
+		ctx.Writer,
+		map[string]any{
+			"chain":     chain,
+			"cycle":     hasCycle,
+			"truncated": truncated,
 		},
 	)
 }
@@ -131,7 +500,7 @@ func (a *Actions) Validate(ctx *gin.Context) {
 func (a *Actions) Fix(ctx *gin.Context) {
 	recs, err := a.ArchKeeper.LoadRecordsByID(ctx.Param("id"))
 	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError) // TODO
+		respondWithError(ctx, err, http.StatusInternalServerError) // TODO
 		return
 	}
 	fixedRecs := make([]cncdb.ArchRecord, len(recs))
@@ -141,7 +510,7 @@ func (a *Actions) Fix(ctx *gin.Context) {
 	}
 	newRec, err := a.ArchKeeper.DeduplicateInArchive(fixedRecs, fixedRecs[0])
 	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError) // TODO
+		respondWithError(ctx, err, http.StatusInternalServerError) // TODO
 		return
 	}
 	ans := make(map[string]any)
@@ -150,10 +519,121 @@ func (a *Actions) Fix(ctx *gin.Context) {
 	uniresp.WriteJSONResponse(ctx.Writer, ans)
 }
 
+func (a *Actions) ListErrors(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultNumListedErrors)))
+	if err != nil || limit <= 0 {
+		respondWithError(ctx, fmt.Errorf("invalid limit argument"), http.StatusBadRequest)
+		return
+	}
+	items, err := a.ArchKeeper.ListErrors(limit, ctx.Query("withData") == "1")
+	if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"errors": items})
+}
+
+// MergeAudits lists recent merges of archive record variants
+// (see archiver.Conf.MergeAuditNumRetain)
+func (a *Actions) MergeAudits(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultNumMergeAudits)))
+	if err != nil || limit <= 0 {
+		respondWithError(ctx, fmt.Errorf("invalid limit argument"), http.StatusBadRequest)
+		return
+	}
+	uniresp.WriteJSONResponse(
+		ctx.Writer,
+		map[string]any{
+			"enabled": a.MergeAuditLog != nil,
+			"audits":  a.MergeAuditLog.Recent(limit),
+		},
+	)
+}
+
+// PeekQueue shows items waiting in the archiver queue (in the order
+// they will be processed) without consuming them.
+func (a *Actions) PeekQueue(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultNumPeekedItems)))
+	if err != nil || limit <= 0 {
+		respondWithError(ctx, fmt.Errorf("invalid limit argument"), http.StatusBadRequest)
+		return
+	}
+	items, err := a.ArchKeeper.PeekQueue(limit)
+	if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"items": items})
+}
+
+// RecentRecords lists IDs and creation times of the most recently
+// archived records (without their data) so operators can quickly
+// check archiving works. With `withCorpora=1`, corpora parsed from
+// each record are attached too.
+func (a *Actions) RecentRecords(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultNumRecentItems)))
+	if err != nil || limit <= 0 || limit > cncdb.MaxRecentRecords {
+		respondWithError(
+			ctx,
+			fmt.Errorf("invalid limit argument (max. %d)", cncdb.MaxRecentRecords),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	recs, err := a.ArchKeeper.LoadRecentRecords(limit)
+	if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	withCorpora := ctx.Query("withCorpora") == "1"
+	items := make([]recentItem, len(recs))
+	for i, rec := range recs {
+		items[i] = recentItem{ID: rec.ID, Created: rec.Created}
+		if withCorpora {
+			data, err := rec.FetchData()
+			if err != nil {
+				log.Warn().Err(err).Str("id", rec.ID).Msg("failed to parse recent record data")
+				continue
+			}
+			items[i].Corpora = data.GetCorpora()
+		}
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"items": items})
+}
+
 func (a *Actions) DedupReset(ctx *gin.Context) {
 	if err := a.ArchKeeper.Reset(); err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		respondWithError(ctx, err, http.StatusInternalServerError)
 		return
 	}
 	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"ok": true})
 }
+
+// DedupSave stores the deduplicator state to disk immediately
+// (e.g. before a planned restart). The /overview endpoint
+// can be used to verify the save.
+func (a *Actions) DedupSave(ctx *gin.Context) {
+	if err := a.ArchKeeper.StoreToDisk(); err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	info := a.ArchKeeper.DedupStateInfo()
+	uniresp.WriteJSONResponse(
+		ctx.Writer,
+		map[string]any{
+			"ok":          true,
+			"numKnownIds": info.NumKnownIDs,
+			"filePath":    info.StateFilePath,
+			"lastStored":  info.LastStored,
+		},
+	)
+}
+
+func (a *Actions) DedupRebuild(ctx *gin.Context) {
+	numItems, err := a.ArchKeeper.RebuildDedup()
+	if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"ok": true, "numItems": numItems})
+}
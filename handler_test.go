@@ -0,0 +1,80 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"camus/cncdb"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDailyStatsIntervalDefault(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 30, 0, 0, time.UTC)
+	from, to, err := dailyStatsInterval(now, "", "", time.UTC)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 4, 11, 0, 0, 0, 0, time.UTC), from)
+	assert.Equal(t, time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC), to)
+}
+
+func TestDailyStatsIntervalDefaultFitsDaytimeCap(t *testing.T) {
+	tz, err := time.LoadLocation("Europe/Prague")
+	assert.NoError(t, err)
+	// the intervals include DST changes (which make some days longer)
+	for _, now := range []time.Time{
+		time.Date(2024, 4, 15, 12, 0, 0, 0, tz),
+		time.Date(2024, 11, 15, 12, 0, 0, 0, tz),
+		time.Date(2024, 7, 15, 12, 0, 0, 0, tz),
+	} {
+		from, to, err := dailyStatsInterval(now, "", "", tz)
+		assert.NoError(t, err)
+		assert.False(t, cncdb.IsTooDemandingDailyCountsRange(from, to, now), now.String())
+	}
+}
+
+func TestDailyStatsIntervalExplicit(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 30, 0, 0, time.UTC)
+	from, to, err := dailyStatsInterval(now, "2024-05-01", "2024-05-01", time.UTC)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), from)
+	assert.Equal(t, time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), to)
+}
+
+func TestDailyStatsIntervalFromAfterTo(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 30, 0, 0, time.UTC)
+	_, _, err := dailyStatsInterval(now, "2024-05-03", "2024-05-01", time.UTC)
+	assert.Error(t, err)
+}
+
+func TestDailyStatsIntervalTooLong(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 30, 0, 0, time.UTC)
+	_, _, err := dailyStatsInterval(now, "2010-01-01", "2024-05-01", time.UTC)
+	assert.Error(t, err)
+
+	from, to, err := dailyStatsInterval(now, "2024-01-01", "2024-05-01", time.UTC)
+	assert.NoError(t, err)
+	assert.True(t, cncdb.IsTooDemandingDailyCountsRange(from, to, now))
+}
+
+func TestDailyStatsIntervalInvalidDates(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 30, 0, 0, time.UTC)
+	_, _, err := dailyStatsInterval(now, "2024-13-01", "", time.UTC)
+	assert.Error(t, err)
+	_, _, err = dailyStatsInterval(now, "", "yesterday", time.UTC)
+	assert.Error(t, err)
+}
@@ -23,6 +23,7 @@ import (
 	"camus/indexer"
 	"camus/reporting"
 	"context"
+	"errors"
 	"os"
 	"time"
 
@@ -34,15 +35,36 @@ const (
 	timeWaitAfterDelErrors = 5 * time.Minute
 )
 
+// GarbageCollector removes excessive query history records from
+// both the database and the fulltext index.
+// The database is considered the primary source of truth. In case
+// a document cannot be removed from the index, the database record
+// is still deleted and the index document ID is stored in a Redis
+// retry queue (see indexer.Conf.IndexDeleteRetryKey). Until the retry
+// succeeds, the index may contain an orphaned document. Documents which
+// cannot be deleted even after the configured number of attempts are
+// moved to a dead-letter set and must be removed manually.
+// In case even the retry queue cannot be written, the whole database
+// transaction is rolled back so no index document is lost track of.
 type GarbageCollector struct {
-	db            cncdb.IQHistArchOps
-	rdb           *archiver.RedisAdapter
-	checkInterval time.Duration
-	markInterval  time.Duration
-	numPreserve   int
-	maxNumDelete  int
-	indexer       *indexer.Indexer
-	statusWriter  reporting.IReporting
+	db               cncdb.IQHistArchOps
+	rdb              *archiver.RedisAdapter
+	checkInterval    time.Duration
+	markInterval     time.Duration
+	numPreserve      int
+	maxNumDelete     int
+	indexer          *indexer.Indexer
+	statusWriter     reporting.IReporting
+	delRetryKey      string
+	delDeadLetterKey string
+	delMaxRetries    int
+
+	// orphanSweepMaxDocs is the max. number of documents checked
+	// by a single orphan sweep (zero means the sweep is disabled)
+	orphanSweepMaxDocs  int
+	orphanSweepInterval time.Duration
+	orphanSweepCursor   string
+	lastOrphanSweep     time.Time
 }
 
 func (gc *GarbageCollector) Start(ctx context.Context) {
@@ -74,11 +96,23 @@ func (gc *GarbageCollector) Start(ctx context.Context) {
 					log.Error().Err(err).Msg("failed to obtain table kontext_query_history size")
 				}
 
+				numErr += gc.retryFailedIndexDeletes()
 				delStats := gc.processDeletionPendingRecords()
+				numOrphans, numOrphanErr := gc.sweepOrphansIfDue()
+				delStats.NumOrphansRemoved = numOrphans
+				numErr += numOrphanErr
+
+				numPending, err := gc.db.CountPendingDeletion()
+				if err != nil {
+					numErr++
+					log.Error().Err(err).Msg("failed to count records pending deletion")
+				}
+
 				delStats.NumErrors += numErr
 				if delStats.NumErrors == 0 {
 					delStats.IndexSize = int64(indexSize)
 					delStats.SQLTableSize = tableSize
+					delStats.NumPendingDeletion = numPending
 				}
 				gc.statusWriter.WriteQueryHistoryDeletionStatus(delStats)
 
@@ -136,6 +170,7 @@ func (gc *GarbageCollector) processDeletionPendingRecords() reporting.QueryHisto
 		}
 		return reporting.QueryHistoryDelStats{NumErrors: 1}
 	}
+	var numErrors int
 	for _, rec := range recs {
 		if err := gc.db.RemoveRecord(tx, rec.Created, rec.UserID, rec.QueryID); err != nil {
 			log.Error().
@@ -155,11 +190,18 @@ func (gc *GarbageCollector) processDeletionPendingRecords() reporting.QueryHisto
 				Int("userId", rec.UserID).
 				Str("queryId", rec.QueryID).
 				Err(err).
-				Msg("failed to delete item from Bleve index")
-			if err := tx.Rollback(); err != nil {
-				log.Error().Err(err).Msg("failed to rollback transaction")
+				Msg("failed to delete item from Bleve index, will retry later")
+			if err := gc.rdb.HSetNXInt(gc.delRetryKey, rec.CreateIndexID(), 0); err != nil {
+				log.Error().
+					Err(err).
+					Str("docId", rec.CreateIndexID()).
+					Msg("failed to schedule index delete retry")
+				if err := tx.Rollback(); err != nil {
+					log.Error().Err(err).Msg("failed to rollback transaction")
+				}
+				return reporting.QueryHistoryDelStats{NumErrors: 1}
 			}
-			return reporting.QueryHistoryDelStats{NumErrors: 1}
+			numErrors++
 		}
 	}
 	if err := tx.Commit(); err != nil {
@@ -169,7 +211,116 @@ func (gc *GarbageCollector) processDeletionPendingRecords() reporting.QueryHisto
 		return reporting.QueryHistoryDelStats{NumErrors: 1}
 	}
 
-	return reporting.QueryHistoryDelStats{NumDeleted: len(recs)}
+	return reporting.QueryHistoryDelStats{NumDeleted: len(recs), NumErrors: numErrors}
+}
+
+// retryFailedIndexDeletes tries again to delete index documents
+// the collector failed to delete before. Documents exceeding
+// the max. number of attempts are moved to the dead-letter set.
+// The method returns number of errors encountered.
+func (gc *GarbageCollector) retryFailedIndexDeletes() int {
+	docIDs, err := gc.rdb.HKeys(gc.delRetryKey)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load index delete retry queue")
+		return 1
+	}
+	var numErrors int
+	for _, docID := range docIDs {
+		if err := gc.indexer.Delete(docID); err != nil {
+			log.Error().Err(err).Str("docId", docID).Msg("failed to delete document from index (retry)")
+
+		} else {
+			if err := gc.rdb.HDel(gc.delRetryKey, docID); err != nil {
+				log.Error().Err(err).Str("docId", docID).Msg("failed to remove item from index delete retry queue")
+				numErrors++
+				continue
+			}
+			log.Info().Str("docId", docID).Msg("deleted previously failed document from index")
+			continue
+		}
+		numErrors++
+		numRetries, err := gc.rdb.HIncrBy(gc.delRetryKey, docID, 1)
+		if err != nil {
+			log.Error().Err(err).Str("docId", docID).Msg("failed to update index delete retry count")
+			continue
+		}
+		if numRetries >= gc.delMaxRetries {
+			if err := gc.rdb.SAdd(gc.delDeadLetterKey, docID); err != nil {
+				log.Error().Err(err).Str("docId", docID).Msg("failed to move document to index delete dead-letter set")
+				continue
+			}
+			if err := gc.rdb.HDel(gc.delRetryKey, docID); err != nil {
+				log.Error().Err(err).Str("docId", docID).Msg("failed to remove item from index delete retry queue")
+				continue
+			}
+			log.Error().
+				Str("docId", docID).
+				Int("numRetries", numRetries).
+				Str("deadLetterKey", gc.delDeadLetterKey).
+				Msg("giving up deleting document from index, manual removal required")
+		}
+	}
+	return numErrors
+}
+
+// sweepOrphansIfDue removes a bounded number of index documents
+// whose archive records no longer exist. The sweep runs at most once
+// per orphanSweepInterval and continues where the previous run stopped.
+// The method returns numbers of removed documents and encountered errors.
+func (gc *GarbageCollector) sweepOrphansIfDue() (int, int) {
+	if gc.orphanSweepMaxDocs == 0 || time.Since(gc.lastOrphanSweep) < gc.orphanSweepInterval {
+		return 0, 0
+	}
+	gc.lastOrphanSweep = time.Now()
+	res, err := gc.indexer.SweepOrphans(gc.orphanSweepCursor, gc.orphanSweepMaxDocs)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to sweep orphaned index documents")
+		return 0, 1
+	}
+	gc.orphanSweepCursor = res.NextCursor
+	log.Info().
+		Int("numChecked", res.NumChecked).
+		Int("numRemoved", res.NumRemoved).
+		Bool("finishedPass", res.NextCursor == "").
+		Msg("swept orphaned index documents")
+	return res.NumRemoved, res.NumErrors
+}
+
+// processUser removes garbage records of a single user both from the
+// index and from the database. Errors are only logged so other users
+// can be processed.
+func (gc *GarbageCollector) processUser(ftIndexer *indexer.Indexer, userID int) {
+	rmFromIndex, err := gc.db.GetUserGarbageRecords(userID)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Int("userId", userID).
+			Msg("failed to garbage-collect queries for a user")
+		return
+	}
+	for _, v := range rmFromIndex {
+		if err := ftIndexer.Delete(v.CreateIndexID()); err != nil {
+			log.Error().
+				Err(err).
+				Int("userId", userID).
+				Str("fulltextId", v.CreateIndexID()).
+				Msg("failed to garbage-collect queries for a user")
+			continue
+		}
+	}
+
+	numRemoved, err := gc.db.GarbageCollectRecords(userID)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Int("userId", userID).
+			Msg("failed to garbage-collect queries for a user")
+		return
+	}
+	log.Info().
+		Int("userId", userID).
+		Int64("numRemoved", numRemoved).
+		Msg("gargage-collected queries for user")
 }
 
 func (gc *GarbageCollector) Stop(ctx context.Context) error {
@@ -181,7 +332,17 @@ func (gc *GarbageCollector) RunAdHoc(
 	concArchDb cncdb.IConcArchOps,
 	conf *cnf.Conf,
 	chunkSize int,
+	forceRestart bool,
 ) {
+	stateTTL := conf.Indexer.QueryHistoryProcStateTTLDur()
+	if forceRestart {
+		log.Warn().Str("key", gcUsersProcSetKey).Msg("forced restart - removing previous processing state")
+		if err := gc.rdb.Delete(gcUsersProcSetKey); err != nil {
+			log.Error().Err(err).Msg("failed to garbage collect query history")
+			os.Exit(1)
+			return
+		}
+	}
 
 	cacheExists, err := gc.rdb.Exists(gcUsersProcSetKey)
 	if err != nil {
@@ -200,7 +361,15 @@ func (gc *GarbageCollector) RunAdHoc(
 		for _, uid := range users {
 			gc.rdb.UintZAdd(gcUsersProcSetKey, uid)
 		}
-		log.Info().Int("numberOfUsers", len(users)).Msg("added users to process")
+		if err := gc.rdb.Expire(gcUsersProcSetKey, stateTTL); err != nil {
+			log.Error().Err(err).Msg("failed to garbage collect query history")
+			os.Exit(2)
+			return
+		}
+		log.Info().
+			Int("numberOfUsers", len(users)).
+			Dur("stateTTL", stateTTL).
+			Msg("added users to process")
 	}
 	recsToIndex := make(chan cncdb.HistoryRecord)
 	defer func() { close(recsToIndex) }()
@@ -211,60 +380,34 @@ func (gc *GarbageCollector) RunAdHoc(
 		os.Exit(3)
 		return
 	}
-	log.Info().Int("chunkSize", chunkSize).Msg("processing next chunk of users")
-	for i := 0; i < chunkSize; i++ {
-		nextUserID, err := gc.rdb.UintZRemLowest(gcUsersProcSetKey)
-		if err != nil {
-			log.Error().Err(err).Msg("failed to garbage collect query history")
-			os.Exit(4)
-			return
-		}
-		if nextUserID < 0 {
-			// will fill-in users again in the next call of Run()
-			break
-		}
-
-		rmFromIndex, err := gc.db.GetUserGarbageRecords(nextUserID)
-		if err != nil {
-			log.Error().
-				Err(err).
-				Int("userId", nextUserID).
-				Msg("failed to garbage-collect queries for a user")
-			continue
-		}
-		for _, v := range rmFromIndex {
-			if err := ftIndexer.Delete(v.CreateIndexID()); err != nil {
-				log.Error().
-					Err(err).
-					Int("userId", nextUserID).
-					Str("fulltextId", v.CreateIndexID()).
-					Msg("failed to garbage-collect queries for a user")
-				continue
-			}
-		}
-
-		numRemoved, err := gc.db.GarbageCollectRecords(nextUserID)
-		if err != nil {
-			log.Error().
-				Err(err).
-				Int("userId", nextUserID).
-				Msg("failed to garbage-collect queries for a user")
-			continue
-
-		} else {
-			log.Info().
-				Int("userId", nextUserID).
-				Int64("numRemoved", numRemoved).
-				Msg("gargage-collected queries for user")
-		}
-		select {
-		case <-ctx.Done():
-			log.Info().Msg("interrupted by user")
-			return
-		default:
-		}
+	log.Info().
+		Int("chunkSize", chunkSize).
+		Int("numWorkers", conf.Indexer.QueryHistoryProcNumWorkers).
+		Msg("processing next chunk of users")
+	_, err = processUsers(
+		ctx,
+		chunkSize,
+		conf.Indexer.QueryHistoryProcNumWorkers,
+		func() (int, error) {
+			// for an empty set, the users will be filled-in
+			// again in the next call of Run()
+			return gc.rdb.UintZRemLowest(gcUsersProcSetKey)
+		},
+		func(ctx context.Context, userID int) error {
+			gc.processUser(ftIndexer, userID)
+			return nil
+		},
+	)
+	if errors.Is(err, context.Canceled) {
+		log.Info().Msg("interrupted by user")
+		return
 
+	} else if err != nil {
+		log.Error().Err(err).Msg("failed to garbage collect query history")
+		os.Exit(4)
+		return
 	}
+
 	remainingUsers, err := gc.rdb.ZCard(gcUsersProcSetKey)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to determine remaining num. of users to process")
@@ -285,13 +428,19 @@ func NewGarbageCollector(
 	conf *indexer.Conf,
 ) *GarbageCollector {
 	return &GarbageCollector{
-		db:            db,
-		rdb:           rdb,
-		indexer:       fulltext,
-		statusWriter:  statusWriter,
-		checkInterval: conf.QueryHistoryCleanupIntervalDur(),
-		markInterval:  conf.QueryHistoryMarkPendingIntervalDur(),
-		maxNumDelete:  conf.QueryHistoryMaxNumDeleteAtOnce,
-		numPreserve:   conf.QueryHistoryNumPreserve,
+		db:               db,
+		rdb:              rdb,
+		indexer:          fulltext,
+		statusWriter:     statusWriter,
+		checkInterval:    conf.QueryHistoryCleanupIntervalDur(),
+		markInterval:     conf.QueryHistoryMarkPendingIntervalDur(),
+		maxNumDelete:     conf.QueryHistoryMaxNumDeleteAtOnce,
+		numPreserve:      conf.QueryHistoryNumPreserve,
+		delRetryKey:      conf.IndexDeleteRetryKey,
+		delDeadLetterKey: conf.IndexDeleteDeadLetterKey,
+		delMaxRetries:    conf.IndexDeleteMaxRetries,
+
+		orphanSweepMaxDocs:  conf.QueryHistoryOrphanSweepMaxDocs,
+		orphanSweepInterval: conf.QueryHistoryOrphanSweepIntervalDur(),
 	}
 }
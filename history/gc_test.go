@@ -0,0 +1,118 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"camus/archiver"
+	"camus/cncdb"
+	"camus/indexer"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testDelRetryKey      = "camus_test_del_retry"
+	testDelDeadLetterKey = "camus_test_del_dead_letter"
+)
+
+// prepareGCWithFailingDeletes creates a garbage collector with an index
+// backend (a fake Elasticsearch server) failing the first numFailures
+// document deletions.
+func prepareGCWithFailingDeletes(
+	t *testing.T,
+	numFailures int32,
+	maxRetries int,
+) (*GarbageCollector, *miniredis.Miniredis) {
+	var numDeletes atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			if numDeletes.Add(1) <= numFailures {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"error": "test failure"}`))
+				return
+			}
+			w.Write([]byte(`{"result": "deleted"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	mr := miniredis.RunT(t)
+	rdb := archiver.NewRedisAdapter(
+		context.Background(),
+		&archiver.RedisConf{Host: mr.Host(), Port: mr.Server().Addr().Port},
+	)
+	conf := &indexer.Conf{
+		IndexDirPath: t.TempDir(),
+		Backend:      indexer.BackendElasticsearch,
+		Elasticsearch: &indexer.ElasticConf{
+			Addresses:          []string{srv.URL},
+			IndexName:          "camus_test",
+			RequestTimeoutSecs: 5,
+		},
+	}
+	idxer, err := indexer.NewIndexer(conf, &cncdb.DummyConcArchSQL{}, &cncdb.MySQLQueryHistDryRun{}, nil, nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { idxer.Close() })
+	gc := &GarbageCollector{
+		rdb:              rdb,
+		indexer:          idxer,
+		delRetryKey:      testDelRetryKey,
+		delDeadLetterKey: testDelDeadLetterKey,
+		delMaxRetries:    maxRetries,
+	}
+	return gc, mr
+}
+
+func TestRetryFailedIndexDeletes(t *testing.T) {
+	gc, mr := prepareGCWithFailingDeletes(t, 1, 3)
+	docID := "1/1700000000/abc"
+	assert.NoError(t, gc.rdb.HSetNXInt(testDelRetryKey, docID, 0))
+
+	// the first retry fails, the document stays queued
+	assert.Equal(t, 1, gc.retryFailedIndexDeletes())
+	assert.Equal(t, "1", mr.HGet(testDelRetryKey, docID))
+
+	// the second retry succeeds and the queue entry is removed
+	assert.Equal(t, 0, gc.retryFailedIndexDeletes())
+	assert.False(t, mr.Exists(testDelRetryKey))
+	assert.False(t, mr.Exists(testDelDeadLetterKey))
+}
+
+func TestRetryFailedIndexDeletesGivesUp(t *testing.T) {
+	gc, mr := prepareGCWithFailingDeletes(t, 10, 2)
+	docID := "1/1700000000/abc"
+	assert.NoError(t, gc.rdb.HSetNXInt(testDelRetryKey, docID, 0))
+
+	assert.Equal(t, 1, gc.retryFailedIndexDeletes())
+	assert.Equal(t, 1, gc.retryFailedIndexDeletes())
+	assert.False(t, mr.Exists(testDelRetryKey))
+	isMember, err := mr.SIsMember(testDelDeadLetterKey, docID)
+	assert.NoError(t, err)
+	assert.True(t, isMember)
+}
@@ -22,6 +22,7 @@ import (
 	"camus/cnf"
 	"camus/indexer"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -65,11 +66,25 @@ func (di *DataInitializer) processQuery(hRec cncdb.HistoryRecord, ftIndexer *ind
 	return nil
 }
 
+// Run processes next chunkSize users. The processing state is stored
+// in Redis so the method can be called repeatedly until all the users
+// are processed. With forceRestart, any previous state (including
+// the "finished" record) is removed first.
 func (di *DataInitializer) Run(
 	ctx context.Context,
 	conf *cnf.Conf,
 	chunkSize int,
+	forceRestart bool,
 ) {
+	stateTTL := conf.Indexer.QueryHistoryProcStateTTLDur()
+	if forceRestart {
+		log.Warn().Str("key", usersProcSetKey).Msg("forced restart - removing previous processing state")
+		if err := di.rdb.Delete(usersProcSetKey); err != nil {
+			log.Error().Err(err).Msg("failed to init query history")
+			os.Exit(1)
+			return
+		}
+	}
 	// check for status of possible previous run first
 	keyType, err := di.rdb.Type(usersProcSetKey)
 	if err != nil {
@@ -80,7 +95,7 @@ func (di *DataInitializer) Run(
 	if keyType == "string" {
 		log.Error().
 			Str("key", usersProcSetKey).
-			Msg("it appears that a previous import was performed - to override, you must remove the key from Redis")
+			Msg("it appears that a previous import was performed - to override, use --force-restart (or wait for the key to expire)")
 		os.Exit(1)
 		return
 	}
@@ -104,7 +119,15 @@ func (di *DataInitializer) Run(
 		for _, uid := range users {
 			di.rdb.UintZAdd(usersProcSetKey, uid)
 		}
-		log.Info().Int("numberOfUsers", len(users)).Msg("added users to process")
+		if err := di.rdb.Expire(usersProcSetKey, stateTTL); err != nil {
+			log.Error().Err(err).Msg("failed to init query history")
+			os.Exit(2)
+			return
+		}
+		log.Info().
+			Int("numberOfUsers", len(users)).
+			Dur("stateTTL", stateTTL).
+			Msg("added users to process")
 	}
 	recsToIndex := make(chan cncdb.HistoryRecord)
 	defer func() { close(recsToIndex) }()
@@ -115,49 +138,54 @@ func (di *DataInitializer) Run(
 		os.Exit(3)
 		return
 	}
-	log.Info().Int("chunkSize", chunkSize).Msg("processing next chunk of users")
-	for i := 0; i < chunkSize; i++ {
-		nextUserID, err := di.rdb.UintZRemLowest(usersProcSetKey)
-		if err != nil {
-			log.Error().Err(err).Msg("failed to init query history")
-			os.Exit(4)
-			return
-		}
-		if nextUserID < 0 {
-			finishedAllChunks = true
-			break
-		}
-		qIDs, err := di.queryHistDb.GetUserRecords(nextUserID, conf.Indexer.QueryHistoryNumPreserve)
-		log.Info().
-			Int("userId", nextUserID).
-			Err(err).
-			Int("numRecords", len(qIDs)).Msg("processing next user")
-		if err != nil {
-			log.Error().Err(err).Msg("failed to init query history")
-			os.Exit(5)
-			return
-		}
-		for _, hRec := range qIDs {
-			if err := di.processQuery(hRec, ftIndexer); err != nil {
-				log.Error().
-					Err(err).
-					Int("userId", nextUserID).
-					Str("queryId", hRec.QueryID).
-					Msg("failed to process record, skipping")
+	log.Info().
+		Int("chunkSize", chunkSize).
+		Int("numWorkers", conf.Indexer.QueryHistoryProcNumWorkers).
+		Msg("processing next chunk of users")
+	finishedAllChunks, err = processUsers(
+		ctx,
+		chunkSize,
+		conf.Indexer.QueryHistoryProcNumWorkers,
+		func() (int, error) {
+			return di.rdb.UintZRemLowest(usersProcSetKey)
+		},
+		func(ctx context.Context, userID int) error {
+			qIDs, err := di.queryHistDb.GetUserRecords(userID, conf.Indexer.QueryHistoryNumPreserve)
+			log.Info().
+				Int("userId", userID).
+				Err(err).
+				Int("numRecords", len(qIDs)).Msg("processing next user")
+			if err != nil {
+				return err
 			}
-			select {
-			case <-ctx.Done():
-				log.Info().Msg("interrupted by user")
-				return
-			default:
+			for _, hRec := range qIDs {
+				if err := di.processQuery(hRec, ftIndexer); err != nil {
+					log.Error().
+						Err(err).
+						Int("userId", userID).
+						Str("queryId", hRec.QueryID).
+						Msg("failed to process record, skipping")
+				}
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
 			}
-		}
-		select {
-		case <-ctx.Done():
-			log.Info().Msg("interrupted by user")
-			return
-		default:
-		}
+			return nil
+		},
+	)
+	if errors.Is(err, context.Canceled) {
+		log.Info().Msg("interrupted by user")
+		return
+
+	} else if errors.Is(err, errFailedToPopUser) {
+		log.Error().Err(err).Msg("failed to init query history")
+		os.Exit(4)
+		return
+
+	} else if err != nil {
+		log.Error().Err(err).Msg("failed to init query history")
+		os.Exit(5)
+		return
 	}
 	remainingUsers, err := di.rdb.ZCard(usersProcSetKey)
 	if err != nil {
@@ -172,7 +200,7 @@ func (di *DataInitializer) Run(
 	if finishedAllChunks {
 		rec := fmt.Sprintf("finished-%s", time.Now().In(conf.TimezoneLocation()))
 		log.Info().Msgf("no more items - writing '%s' to Redis and ending", rec)
-		if err := di.rdb.Set(usersProcSetKey, rec); err != nil {
+		if err := di.rdb.SetWithTTL(usersProcSetKey, rec, stateTTL); err != nil {
 			log.Error().Err(err).Msg("failed to write 'finished' record to Redis")
 			os.Exit(5)
 		}
@@ -0,0 +1,87 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+var errFailedToPopUser = errors.New("failed to obtain next user to process")
+
+// processUsers takes users from a queue (popUser) and processes them
+// (procUser) using numWorkers concurrent workers. At most maxUsers
+// users are taken from the queue which preserves the chunk semantics
+// of the sequential processing. The popUser function must be safe for
+// concurrent use and it must never return the same user twice (e.g.
+// by using Redis ZPOPMIN). A negative user ID means that the queue
+// is empty.
+// The returned bool specifies whether the queue has been exhausted.
+// Any error returned by popUser or procUser stops the processing.
+// In case the context is cancelled, the workers finish their current
+// users and ctx.Err() is returned.
+func processUsers(
+	ctx context.Context,
+	maxUsers int,
+	numWorkers int,
+	popUser func() (int, error),
+	procUser func(ctx context.Context, userID int) error,
+) (bool, error) {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var numClaimed atomic.Int64
+	var finished atomic.Bool
+	var firstErr error
+	var errOnce sync.Once
+	setErr := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(numWorkers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for wctx.Err() == nil && numClaimed.Add(1) <= int64(maxUsers) {
+				userID, err := popUser()
+				if err != nil {
+					setErr(fmt.Errorf("%w: %s", errFailedToPopUser, err))
+					return
+				}
+				if userID < 0 {
+					finished.Store(true)
+					return
+				}
+				if err := procUser(wctx, userID); err != nil {
+					setErr(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return finished.Load(), firstErr
+	}
+	return finished.Load(), ctx.Err()
+}
@@ -0,0 +1,179 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// userQueue mimics the Redis sorted set of users to process
+type userQueue struct {
+	users []int
+	mu    sync.Mutex
+}
+
+func (q *userQueue) pop() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.users) == 0 {
+		return -1, nil
+	}
+	ans := q.users[0]
+	q.users = q.users[1:]
+	return ans, nil
+}
+
+func newUserQueue(n int) *userQueue {
+	ans := &userQueue{users: make([]int, n)}
+	for i := range ans.users {
+		ans.users[i] = i + 1
+	}
+	return ans
+}
+
+type procLog struct {
+	users map[int]int
+	mu    sync.Mutex
+}
+
+func (pl *procLog) add(userID int) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.users[userID]++
+}
+
+func TestProcessUsersRespectsChunkSize(t *testing.T) {
+	queue := newUserQueue(100)
+	pl := &procLog{users: make(map[int]int)}
+	finished, err := processUsers(
+		context.Background(),
+		30,
+		8,
+		queue.pop,
+		func(ctx context.Context, userID int) error {
+			pl.add(userID)
+			return nil
+		},
+	)
+	assert.NoError(t, err)
+	assert.False(t, finished)
+	assert.Len(t, pl.users, 30)
+	assert.Len(t, queue.users, 70)
+	for userID, n := range pl.users {
+		assert.Equal(t, 1, n, fmt.Sprintf("user %d processed more than once", userID))
+	}
+}
+
+func TestProcessUsersExhaustsQueue(t *testing.T) {
+	queue := newUserQueue(20)
+	pl := &procLog{users: make(map[int]int)}
+	finished, err := processUsers(
+		context.Background(),
+		50,
+		4,
+		queue.pop,
+		func(ctx context.Context, userID int) error {
+			pl.add(userID)
+			return nil
+		},
+	)
+	assert.NoError(t, err)
+	assert.True(t, finished)
+	assert.Len(t, pl.users, 20)
+}
+
+func TestProcessUsersStopsOnError(t *testing.T) {
+	queue := newUserQueue(100)
+	procErr := errors.New("proc error")
+	_, err := processUsers(
+		context.Background(),
+		100,
+		4,
+		queue.pop,
+		func(ctx context.Context, userID int) error {
+			if userID == 10 {
+				return procErr
+			}
+			return nil
+		},
+	)
+	assert.ErrorIs(t, err, procErr)
+	assert.NotEmpty(t, queue.users)
+}
+
+func TestProcessUsersPopError(t *testing.T) {
+	_, err := processUsers(
+		context.Background(),
+		10,
+		2,
+		func() (int, error) {
+			return 0, errors.New("connection refused")
+		},
+		func(ctx context.Context, userID int) error {
+			return nil
+		},
+	)
+	assert.ErrorIs(t, err, errFailedToPopUser)
+}
+
+func TestProcessUsersCancelled(t *testing.T) {
+	queue := newUserQueue(100)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := processUsers(
+		ctx,
+		100,
+		4,
+		queue.pop,
+		func(ctx context.Context, userID int) error {
+			return nil
+		},
+	)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, queue.users, 100)
+}
+
+func benchmarkProcessUsers(b *testing.B, numWorkers int) {
+	for i := 0; i < b.N; i++ {
+		queue := newUserQueue(64)
+		processUsers(
+			context.Background(),
+			64,
+			numWorkers,
+			queue.pop,
+			func(ctx context.Context, userID int) error {
+				time.Sleep(time.Millisecond)
+				return nil
+			},
+		)
+	}
+}
+
+func BenchmarkProcessUsers1Worker(b *testing.B) {
+	benchmarkProcessUsers(b, 1)
+}
+
+func BenchmarkProcessUsers8Workers(b *testing.B) {
+	benchmarkProcessUsers(b, 8)
+}
@@ -0,0 +1,132 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"camus/indexer/documents"
+	"fmt"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	BackendBleve         = "bleve"
+	BackendElasticsearch = "elasticsearch"
+
+	// BackendBleveWithElasticsearch uses Bleve as the primary backend
+	// (i.e. for searching) and mirrors all the writes to Elasticsearch
+	BackendBleveWithElasticsearch = "bleve+elasticsearch"
+)
+
+// Backend is a storage and search engine of indexed documents.
+// Searches are described using Bleve requests so the rest of the
+// indexer does not depend on a concrete backend. Please note that
+// non-Bleve backends support only a subset of Bleve query types
+// (the ones used by Camus itself).
+type Backend interface {
+	Index(id string, doc documents.IndexableDoc) error
+	Delete(id string) error
+	Search(req *bleve.SearchRequest) (*bleve.SearchResult, error)
+	DocCount() (uint64, error)
+}
+
+// ---------------------
+
+type bleveBackend struct {
+	bleveIdx bleve.Index
+}
+
+func (bb *bleveBackend) Index(id string, doc documents.IndexableDoc) error {
+	return bb.bleveIdx.Index(id, doc)
+}
+
+func (bb *bleveBackend) Delete(id string) error {
+	return bb.bleveIdx.Delete(id)
+}
+
+func (bb *bleveBackend) Search(req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	return bb.bleveIdx.Search(req)
+}
+
+func (bb *bleveBackend) DocCount() (uint64, error) {
+	return bb.bleveIdx.DocCount()
+}
+
+func newBleveBackend(bleveIdx bleve.Index) *bleveBackend {
+	return &bleveBackend{bleveIdx: bleveIdx}
+}
+
+// ---------------------
+
+// mirroredBackend reads from the primary backend and writes
+// to both backends. Failed writes to the mirror are only logged
+// so the mirror cannot affect the primary indexing.
+type mirroredBackend struct {
+	primary Backend
+	mirror  Backend
+}
+
+func (mb *mirroredBackend) Index(id string, doc documents.IndexableDoc) error {
+	if err := mb.primary.Index(id, doc); err != nil {
+		return err
+	}
+	if err := mb.mirror.Index(id, doc); err != nil {
+		log.Error().Err(err).Str("docId", id).Msg("failed to index document in mirror backend")
+	}
+	return nil
+}
+
+func (mb *mirroredBackend) Delete(id string) error {
+	if err := mb.primary.Delete(id); err != nil {
+		return err
+	}
+	if err := mb.mirror.Delete(id); err != nil {
+		log.Error().Err(err).Str("docId", id).Msg("failed to delete document from mirror backend")
+	}
+	return nil
+}
+
+func (mb *mirroredBackend) Search(req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	return mb.primary.Search(req)
+}
+
+func (mb *mirroredBackend) DocCount() (uint64, error) {
+	return mb.primary.DocCount()
+}
+
+// ---------------------
+
+// newBackend creates a backend based on the configuration.
+// The Bleve index is always required as it is used by the Bleve-only
+// features (storage stats, optimization, migrations) too.
+func newBackend(conf *Conf, bleveIdx bleve.Index) (Backend, error) {
+	switch conf.Backend {
+	case BackendBleve, "":
+		return newBleveBackend(bleveIdx), nil
+	case BackendElasticsearch:
+		return newESBackend(conf.Elasticsearch, conf.LabelStopWords)
+	case BackendBleveWithElasticsearch:
+		es, err := newESBackend(conf.Elasticsearch, conf.LabelStopWords)
+		if err != nil {
+			return nil, err
+		}
+		return &mirroredBackend{primary: newBleveBackend(bleveIdx), mirror: es}, nil
+	default:
+		return nil, fmt.Errorf("unknown indexer backend `%s`", conf.Backend)
+	}
+}
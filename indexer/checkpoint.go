@@ -0,0 +1,80 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"camus/cncdb"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// reindexCheckpointInterval specifies after how many processed
+// records the reindexing checkpoint is updated
+const reindexCheckpointInterval = 100
+
+var ErrNoReindexCheckpoint = errors.New("no reindex checkpoint found")
+
+// storeCheckpoint writes the `created` value of the last processed
+// query history record so an interrupted reindexing can be resumed.
+// Errors are only logged as the checkpoint is not essential for
+// the reindexing itself.
+func (idx *Indexer) storeCheckpoint(created int64) {
+	if idx.rdb == nil {
+		return
+	}
+	if err := idx.rdb.Set(idx.conf.ReindexCheckpointKey, created); err != nil {
+		log.Error().Err(err).Int64("created", created).Msg("failed to store reindex checkpoint")
+	}
+}
+
+func (idx *Indexer) clearCheckpoint() {
+	if idx.rdb == nil {
+		return
+	}
+	if err := idx.rdb.Delete(idx.conf.ReindexCheckpointKey); err != nil {
+		log.Error().Err(err).Msg("failed to clear reindex checkpoint")
+	}
+}
+
+// loadHistoryFromCheckpoint loads at most `num` query history records
+// created between the stored checkpoint and now. Records with
+// the checkpoint timestamp itself are included as the checkpoint
+// may be shared by multiple records (re-indexing a record is harmless).
+func (idx *Indexer) loadHistoryFromCheckpoint(num int) ([]cncdb.HistoryRecord, error) {
+	if idx.rdb == nil {
+		return nil, ErrNoReindexCheckpoint
+	}
+	v, err := idx.rdb.Get(idx.conf.ReindexCheckpointKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load reindex checkpoint: %w", err)
+	}
+	if v == "" {
+		return nil, ErrNoReindexCheckpoint
+	}
+	from, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load reindex checkpoint: %w", err)
+	}
+	log.Info().
+		Time("checkpoint", time.Unix(from, 0)).
+		Msg("resuming reindexing from a checkpoint")
+	return idx.queryHistDb.LoadHistoryBetween(from, time.Now().Unix(), num)
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"camus/cncdb"
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const testLoadHistorySQL = "SELECT user_id, query_id, created, name FROM kontext_query_history"
+
+func newMockedQueryHist(t *testing.T) (*cncdb.MySQLQueryHist, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	conf := cncdb.DBConf{}
+	assert.NoError(t, conf.ValidateAndDefaults())
+	_, ops := cncdb.NewMySQLOps(context.Background(), db, &conf, time.UTC)
+	return ops, mock
+}
+
+func TestIndexRecentRecordsResumeFromCheckpoint(t *testing.T) {
+	idxer, mr := prepareIndexerWithRedis(t)
+	defer cleanData(idxer.DataPath())
+	defer idxer.Close()
+	idxer.conf.ReindexCheckpointKey = dfltReindexCheckpointKey
+	qHist, mock := newMockedQueryHist(t)
+	idxer.queryHistDb = qHist
+
+	for _, id := range []string{"wl2", "wl3"} {
+		hRec := corpusPolicyTestRecord(t, id, "syn2020")
+		assert.NoError(t, mr.Set("concordance:"+id, hRec.Rec.Data))
+	}
+	assert.NoError(t, mr.Set(dfltReindexCheckpointKey, "1700000150"))
+	mock.ExpectQuery(testLoadHistorySQL).
+		WithArgs(int64(1700000150), sqlmock.AnyArg(), 10).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"user_id", "query_id", "created", "name"}).
+				AddRow(1, "wl2", 1700000150, nil).
+				AddRow(1, "wl3", 1700000160, nil),
+		)
+
+	numIndexed, err := idxer.IndexRecentRecords(10, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, numIndexed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	count, err := idxer.DocCount()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+	assert.False(t, mr.Exists(dfltReindexCheckpointKey))
+}
+
+func TestIndexRecentRecordsResumeTruncatedHistory(t *testing.T) {
+	idxer, mr := prepareIndexerWithRedis(t)
+	defer cleanData(idxer.DataPath())
+	defer idxer.Close()
+	idxer.conf.ReindexCheckpointKey = dfltReindexCheckpointKey
+	qHist, mock := newMockedQueryHist(t)
+	idxer.queryHistDb = qHist
+
+	assert.NoError(t, mr.Set(dfltReindexCheckpointKey, "1700000150"))
+	mock.ExpectQuery(testLoadHistorySQL).
+		WithArgs(int64(1700000150), sqlmock.AnyArg(), 10).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"user_id", "query_id", "created", "name"}).
+				AddRow(1, "wl2", 1700000150, nil).
+				AddRow(1, "wl3", 1700000160, nil).
+				RowError(1, sql.ErrConnDone),
+		)
+
+	_, err := idxer.IndexRecentRecords(10, true)
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	// the checkpoint is kept so the reindexing can be resumed again
+	v, err := mr.Get(dfltReindexCheckpointKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "1700000150", v)
+}
@@ -17,13 +17,54 @@
 package indexer
 
 import (
+	"camus/indexer/documents"
 	"fmt"
+	"regexp"
+	"slices"
 	"time"
 
 	"github.com/czcorpus/cnc-gokit/datetime"
 	"github.com/czcorpus/cnc-gokit/fs"
+	"github.com/rs/zerolog/log"
 )
 
+const (
+	dfltFailedIndexingKey     = "camus_failed_indexing"
+	dfltFailedIndexingMaxSize = 10000
+
+	dfltReindexCheckpointKey = "camus_reindex_checkpoint"
+
+	dfltQueryHistoryProcStateTTL = "7d"
+
+	dfltIndexOpenTimeout = "10s"
+
+	dfltQueryHistoryProcNumWorkers = 1
+	maxQueryHistoryProcNumWorkers  = 32
+
+	dfltQueryHistoryOrphanSweepInterval = "1h"
+
+	dfltIndexDeleteRetryKey      = "camus_qh_index_delete_retry"
+	dfltIndexDeleteDeadLetterKey = "camus_qh_index_delete_dead"
+	dfltIndexDeleteMaxRetries    = 10
+
+	dfltPendingDeletesKey = "camus_purge_pending_deletes"
+
+	dfltSearchCacheTTL = "30s"
+
+	dfltSubcPropsCacheTTL = "10m"
+
+	dfltPqueryMaxSubconc = 50
+
+	CreatedUnitAuto         = "auto"
+	CreatedUnitSeconds      = "s"
+	CreatedUnitMilliseconds = "ms"
+
+	SubcPropsLookupStrict  = "strict"
+	SubcPropsLookupLenient = "lenient"
+)
+
+var analyzerGroupNameRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+
 // Conf contains indexer's configuration as obtained
 // from a JSON file (or chunk). Please note that the
 // instance should be treated as ready only after
@@ -35,6 +76,29 @@ type Conf struct {
 	// its fulltext index data
 	IndexDirPath string `json:"indexDirPath"`
 
+	// IndexOpenTimeout specifies how long CLI actions wait for the index
+	// to be opened before they give up (default is 10s). The service keeps
+	// waiting, but once the timeout expires, the readiness check reports
+	// the timeout instead of the "opening" state. Large indexes
+	// may need more time.
+	IndexOpenTimeout string `json:"indexOpenTimeout"`
+
+	// Backend specifies where documents are indexed and searched:
+	//   * `bleve` (default) - the local Bleve index,
+	//   * `elasticsearch` - an external Elasticsearch index (see Elasticsearch),
+	//   * `bleve+elasticsearch` - Bleve is used for searching and all
+	//      the writes are mirrored to Elasticsearch.
+	// Please note that the local Bleve index is always opened as the features
+	// like storage stats, optimization and migrations are Bleve-specific.
+	// With the `elasticsearch` backend, searching via the Bleve query
+	// language (SearchWithQuery) relies on Elasticsearch's `query_string`
+	// syntax which is similar but not identical.
+	Backend string `json:"backend"`
+
+	// Elasticsearch configures the Elasticsearch backend
+	// (required only if Backend refers to it)
+	Elasticsearch *ElasticConf `json:"elasticsearch"`
+
 	// QueryHistoryNumPreserve specifies how many items we allow
 	// in query history for an individual user. Anything above that
 	// level is then considered deletable at any time
@@ -56,6 +120,203 @@ type Conf struct {
 	QueryHistoryMarkPendingInterval string `json:"queryHistoryMarkPendingInterval"`
 
 	QueryHistoryMaxNumDeleteAtOnce int `json:"queryHistoryMaxNumDeleteAtOnce"`
+
+	// QueryHistoryProcStateTTL specifies (using the same format as
+	// QueryHistoryCleanupInterval) how long the processing state
+	// of `init-query-history` and `gc-query-history` actions (i.e. sets
+	// of users to process and the "finished" record) is preserved
+	// in Redis. This prevents an abandoned run from blocking
+	// subsequent runs forever.
+	QueryHistoryProcStateTTL string `json:"queryHistoryProcStateTTL"`
+
+	// QueryHistoryProcNumWorkers specifies how many users are processed
+	// concurrently by the `init-query-history` and `gc-query-history`
+	// actions. The chunk size still limits the total number of users
+	// processed within a single run.
+	QueryHistoryProcNumWorkers int `json:"queryHistoryProcNumWorkers"`
+
+	// QueryHistoryOrphanSweepMaxDocs enables a periodic check of index
+	// documents whose archive records no longer exist (e.g. after a manual
+	// SQL delete). It specifies how many documents are checked within
+	// a single run. Zero (default) disables the sweep.
+	QueryHistoryOrphanSweepMaxDocs int `json:"queryHistoryOrphanSweepMaxDocs"`
+
+	// QueryHistoryOrphanSweepInterval specifies the minimum time between
+	// two runs of the orphan sweep (default is 1 hour). The sweep is
+	// performed along with the query history cleanup so the actual
+	// interval is aligned with QueryHistoryCleanupInterval.
+	QueryHistoryOrphanSweepInterval string `json:"queryHistoryOrphanSweepInterval"`
+
+	// IndexDeleteRetryKey is a Redis key of a hash where the query history
+	// garbage collector stores IDs of index documents it failed to delete
+	// (along with numbers of performed retries). The deletion is then
+	// retried independently of the database deletion.
+	IndexDeleteRetryKey string `json:"indexDeleteRetryKey"`
+
+	// IndexDeleteDeadLetterKey is a Redis key of a set where IDs of index
+	// documents are moved once their deletion fails IndexDeleteMaxRetries
+	// times. Such documents must be removed manually.
+	IndexDeleteDeadLetterKey string `json:"indexDeleteDeadLetterKey"`
+
+	// IndexDeleteMaxRetries specifies max. number of attempts to delete
+	// a document from the index (see IndexDeleteRetryKey).
+	IndexDeleteMaxRetries int `json:"indexDeleteMaxRetries"`
+
+	// PendingDeletesKey is a Redis key of a set where IDs of index
+	// documents which could not be deleted during a purge are stored
+	// (see Indexer.Purge). The deletion is retried periodically and
+	// the IDs are reloaded after a restart.
+	PendingDeletesKey string `json:"pendingDeletesKey"`
+
+	// FallbackToRecordCreated specifies whether the archive record's
+	// creation time should be used as the indexed `created` value in case
+	// the query history timestamp is zero or otherwise implausible
+	// (this typically happens with backfilled data). Please note that
+	// document IDs are always derived from the query history timestamp.
+	FallbackToRecordCreated bool `json:"fallbackToRecordCreated"`
+
+	// CreatedTimestampUnit specifies the unit of query history
+	// `created` timestamps. Supported values are `s`, `ms` and `auto`
+	// (default). With `auto`, implausible values which make sense
+	// as milliseconds (or microseconds) are converted to seconds.
+	CreatedTimestampUnit string `json:"createdTimestampUnit"`
+
+	// SkipCQLExtraction disables parsing of CQL queries of conc and pquery
+	// records (i.e. positional and structural attributes are not extracted
+	// from queries). This speeds up indexing e.g. when recovering from
+	// a large backlog. Such documents are flagged with `cql_pending` so
+	// they can be found and re-indexed later with the option disabled.
+	SkipCQLExtraction bool `json:"skipCQLExtraction"`
+
+	// AnonymousUserID specifies ID of the shared anonymous user.
+	// Queries of the user are not indexed as they would mix
+	// records of many actual users and the respective
+	// history would grow indefinitely.
+	// If not set, no special handling is applied.
+	AnonymousUserID *int `json:"anonymousUserId"`
+
+	// MaxRawQueryLen specifies max. number of characters of a raw query
+	// stored in the index. Longer queries (typically auto-generated ones)
+	// are truncated. The full query is still available via the archive
+	// record. Zero means no limit.
+	MaxRawQueryLen int `json:"maxRawQueryLen"`
+
+	// MaxIndexDocBytes specifies max. estimated size (in bytes, JSON
+	// serialized) of an indexed document. Larger documents (e.g. merged
+	// pqueries or queries with huge text type selections) are indexed
+	// without their heaviest multi-valued fields (see
+	// documents.ReducibleFields). Zero means no limit.
+	MaxIndexDocBytes int `json:"maxIndexDocBytes"`
+
+	// PqueryMaxSubconc limits the number of concordances a pquery
+	// record is expanded into when indexed. Only the first
+	// PqueryMaxSubconc concordances are processed and the document
+	// is flagged as truncated. Zero means the default (50), negative
+	// value disables the limit.
+	PqueryMaxSubconc int `json:"pqueryMaxSubconc"`
+
+	// FailedIndexingKey is a Redis key of a set where IDs of records
+	// which failed to be indexed or converted (ErrRecordNotIndexable)
+	// are stored so they can be re-processed later (e.g. once a conversion
+	// issue is fixed). Records which are not indexable by design (additional
+	// query stages, corpora excluded by the indexing policy) are not stored.
+	FailedIndexingKey string `json:"failedIndexingKey"`
+
+	// FailedIndexingMaxSize limits the number of records stored
+	// in the FailedIndexingKey set.
+	FailedIndexingMaxSize int `json:"failedIndexingMaxSize"`
+
+	// ReindexCheckpointKey is a Redis key where the creation time
+	// of the last processed record of a running reindexing is stored
+	// so the reindexing can be resumed after an interruption.
+	ReindexCheckpointKey string `json:"reindexCheckpointKey"`
+
+	// SearchCacheSize specifies max. number of cached search results
+	// (see the `/user-query-history/:userId` search endpoint).
+	// Zero (default) disables the cache.
+	SearchCacheSize int `json:"searchCacheSize"`
+
+	// SearchCacheTTL specifies (using the same format as
+	// QueryHistoryCleanupInterval) how long a cached search result
+	// stays valid. Please note that user's cached results are also
+	// invalidated once their documents are indexed or deleted.
+	SearchCacheTTL string `json:"searchCacheTTL"`
+
+	// SubcPropsCacheSize specifies max. number of subcorpora whose
+	// properties (as loaded from the database during indexing)
+	// are cached in memory. Zero (default) disables the cache.
+	SubcPropsCacheSize int `json:"subcPropsCacheSize"`
+
+	// SubcPropsCacheTTL specifies how long cached subcorpus
+	// properties stay valid (default is 10 minutes).
+	SubcPropsCacheTTL string `json:"subcPropsCacheTTL"`
+
+	// SubcPropsLookup specifies how database errors of subcorpus
+	// props lookups are handled during import. With `lenient` (default),
+	// the error is logged and the record is indexed without the subcorpus
+	// name. With `strict`, the record is not indexed at all.
+	SubcPropsLookup string `json:"subcPropsLookup"`
+
+	// LabelStopWords lists tokens removed from label fields (corpora,
+	// subcorpus, structures etc.) during indexing and search. Please
+	// note that the list is applied only when a new index is created.
+	LabelStopWords []string `json:"labelStopWords"`
+
+	// CorpusAliases maps alternative corpus names (aliases) to their
+	// canonical names (e.g. {"syn2020_v2": "syn2020"}). The mapping is
+	// applied to documents' corpora before indexing. Corpora not present
+	// in the map are kept as they are.
+	CorpusAliases map[string]string `json:"corpusAliases"`
+
+	// IndexedCorpora is an optional allowlist of corpora whose queries
+	// are indexed. The primary (i.e. the first) corpus of a record
+	// is tested, after aliases are resolved. Empty list means all
+	// the corpora are allowed.
+	IndexedCorpora []string `json:"indexedCorpora"`
+
+	// NonIndexedCorpora is an optional denylist of corpora whose queries
+	// are never indexed (e.g. test or private corpora). It takes
+	// precedence over IndexedCorpora.
+	NonIndexedCorpora []string `json:"nonIndexedCorpora"`
+
+	// AnalyzerGroups optionally maps corpora (by their name prefixes)
+	// to language-specific analyzers of query fields (e.g. English stemming
+	// for `brown`). Corpora not matching any group use the default analyzers.
+	// Groups are part of the index mapping so they apply only to a newly
+	// created index (see documents.AnalyzerGroup for details and trade-offs).
+	// The Elasticsearch backend does not support the groups.
+	AnalyzerGroups []documents.AnalyzerGroup `json:"analyzerGroups"`
+}
+
+// IsLenientSubcPropsLookup tests whether subcorpus props lookup
+// errors should be ignored during import (see SubcPropsLookup).
+func (conf *Conf) IsLenientSubcPropsLookup() bool {
+	return conf.SubcPropsLookup == SubcPropsLookupLenient
+}
+
+// IsAnonymousUser tests whether the userID belongs to the configured
+// anonymous user.
+func (conf *Conf) IsAnonymousUser(userID int) bool {
+	return conf.AnonymousUserID != nil && *conf.AnonymousUserID == userID
+}
+
+// CanonicalCorpus returns a canonical name of a corpus
+// (see CorpusAliases).
+func (conf *Conf) CanonicalCorpus(corpname string) string {
+	if canonical, ok := conf.CorpusAliases[corpname]; ok {
+		return canonical
+	}
+	return corpname
+}
+
+// IsIndexedCorpus tests whether queries with the primary corpus
+// corpname can be indexed (see IndexedCorpora, NonIndexedCorpora).
+func (conf *Conf) IsIndexedCorpus(corpname string) bool {
+	corpname = conf.CanonicalCorpus(corpname)
+	if slices.Contains(conf.NonIndexedCorpora, corpname) {
+		return false
+	}
+	return len(conf.IndexedCorpora) == 0 || slices.Contains(conf.IndexedCorpora, corpname)
 }
 
 func (conf *Conf) QueryHistoryCleanupIntervalDur() time.Duration {
@@ -78,6 +339,59 @@ func (conf *Conf) QueryHistoryMarkPendingIntervalDur() time.Duration {
 	return dur
 }
 
+func (conf *Conf) QueryHistoryOrphanSweepIntervalDur() time.Duration {
+	if conf.QueryHistoryOrphanSweepMaxDocs == 0 {
+		return 0
+	}
+	dur, err := datetime.ParseDuration(conf.QueryHistoryOrphanSweepInterval)
+	if err != nil {
+		panic(err) // we expect users to call ValidateAndDefaults() which
+		// checks for this too in a more graceful way so we can afford
+		// to panic here
+	}
+	return dur
+}
+
+func (conf *Conf) QueryHistoryProcStateTTLDur() time.Duration {
+	dur, err := datetime.ParseDuration(conf.QueryHistoryProcStateTTL)
+	if err != nil {
+		panic(err) // we expect users to call ValidateAndDefaults() which
+		// checks for this too in a more graceful way so we can afford
+		// to panic here
+	}
+	return dur
+}
+
+func (conf *Conf) SearchCacheTTLDur() time.Duration {
+	dur, err := datetime.ParseDuration(conf.SearchCacheTTL)
+	if err != nil {
+		panic(err) // we expect users to call ValidateAndDefaults() which
+		// checks for this too in a more graceful way so we can afford
+		// to panic here
+	}
+	return dur
+}
+
+func (conf *Conf) IndexOpenTimeoutDur() time.Duration {
+	dur, err := datetime.ParseDuration(conf.IndexOpenTimeout)
+	if err != nil {
+		panic(err) // we expect users to call ValidateAndDefaults() which
+		// checks for this too in a more graceful way so we can afford
+		// to panic here
+	}
+	return dur
+}
+
+func (conf *Conf) SubcPropsCacheTTLDur() time.Duration {
+	dur, err := datetime.ParseDuration(conf.SubcPropsCacheTTL)
+	if err != nil {
+		panic(err) // we expect users to call ValidateAndDefaults() which
+		// checks for this too in a more graceful way so we can afford
+		// to panic here
+	}
+	return dur
+}
+
 func (conf *Conf) ValidateAndDefaults() error {
 	if conf == nil {
 		return fmt.Errorf("missing `indexer` section")
@@ -85,12 +399,41 @@ func (conf *Conf) ValidateAndDefaults() error {
 	if conf.IndexDirPath == "" {
 		return fmt.Errorf("missing path to index dir (indexDirPath)")
 	}
+	switch conf.Backend {
+	case "":
+		conf.Backend = BackendBleve
+		log.Warn().
+			Str("value", conf.Backend).
+			Msg("indexer configuration `backend` not specified, using default")
+	case BackendBleve:
+	case BackendElasticsearch, BackendBleveWithElasticsearch:
+		if err := conf.Elasticsearch.ValidateAndDefaults(); err != nil {
+			return fmt.Errorf("failed to validate indexer configuration: %w", err)
+		}
+	default:
+		return fmt.Errorf(
+			"invalid indexer backend `%s` (supported: %s, %s, %s)",
+			conf.Backend, BackendBleve, BackendElasticsearch, BackendBleveWithElasticsearch,
+		)
+	}
 	isDir, err := fs.IsDir(conf.IndexDirPath)
 	if err != nil {
 		return err
 	} else if !isDir {
 		return fmt.Errorf("index dir does not exist (indexDirPath)")
 	}
+	if conf.IndexOpenTimeout == "" {
+		conf.IndexOpenTimeout = dfltIndexOpenTimeout
+		log.Warn().
+			Str("value", dfltIndexOpenTimeout).
+			Msg("indexer configuration `indexOpenTimeout` not specified, using default")
+	}
+	if dur, err := datetime.ParseDuration(conf.IndexOpenTimeout); err != nil {
+		return fmt.Errorf("failed to validate indexOpenTimeout: %w", err)
+
+	} else if dur == 0 {
+		return fmt.Errorf("indexOpenTimeout must be > 0")
+	}
 	if conf.QueryHistoryNumPreserve <= 0 {
 		return fmt.Errorf("queryHistoryNumPreserve not specified (recommended > 100)")
 	}
@@ -113,5 +456,206 @@ func (conf *Conf) ValidateAndDefaults() error {
 	if conf.QueryHistoryMaxNumDeleteAtOnce <= 0 {
 		return fmt.Errorf("queryHistoryMaxNumDeleteAtOnce must be > 0")
 	}
+	if conf.QueryHistoryProcStateTTL == "" {
+		conf.QueryHistoryProcStateTTL = dfltQueryHistoryProcStateTTL
+		log.Warn().
+			Str("value", dfltQueryHistoryProcStateTTL).
+			Msg("indexer configuration `queryHistoryProcStateTTL` not specified, using default")
+	}
+	if dur, err := datetime.ParseDuration(conf.QueryHistoryProcStateTTL); err != nil || dur == 0 {
+		if err != nil {
+			return fmt.Errorf("failed to validate queryHistoryProcStateTTL: %w", err)
+		}
+		if dur == 0 {
+			return fmt.Errorf("queryHistoryProcStateTTL must be > 0")
+		}
+	}
+	if conf.QueryHistoryProcNumWorkers < 0 || conf.QueryHistoryProcNumWorkers > maxQueryHistoryProcNumWorkers {
+		return fmt.Errorf(
+			"invalid value for queryHistoryProcNumWorkers (must be between 1 and %d)",
+			maxQueryHistoryProcNumWorkers,
+		)
+	}
+	if conf.QueryHistoryProcNumWorkers == 0 {
+		conf.QueryHistoryProcNumWorkers = dfltQueryHistoryProcNumWorkers
+		log.Warn().
+			Int("value", dfltQueryHistoryProcNumWorkers).
+			Msg("indexer configuration `queryHistoryProcNumWorkers` not specified, using default")
+	}
+	if conf.QueryHistoryOrphanSweepMaxDocs < 0 {
+		return fmt.Errorf("queryHistoryOrphanSweepMaxDocs must be >= 0")
+	}
+	if conf.QueryHistoryOrphanSweepMaxDocs > 0 {
+		if conf.QueryHistoryOrphanSweepInterval == "" {
+			conf.QueryHistoryOrphanSweepInterval = dfltQueryHistoryOrphanSweepInterval
+			log.Warn().
+				Str("value", dfltQueryHistoryOrphanSweepInterval).
+				Msg("indexer configuration `queryHistoryOrphanSweepInterval` not specified, using default")
+		}
+		if dur, err := datetime.ParseDuration(conf.QueryHistoryOrphanSweepInterval); err != nil {
+			return fmt.Errorf("failed to validate queryHistoryOrphanSweepInterval: %w", err)
+
+		} else if dur == 0 {
+			return fmt.Errorf("queryHistoryOrphanSweepInterval must be > 0")
+		}
+	}
+	if conf.IndexDeleteRetryKey == "" {
+		conf.IndexDeleteRetryKey = dfltIndexDeleteRetryKey
+		log.Warn().
+			Str("value", dfltIndexDeleteRetryKey).
+			Msg("indexer configuration `indexDeleteRetryKey` not specified, using default")
+	}
+	if conf.PendingDeletesKey == "" {
+		conf.PendingDeletesKey = dfltPendingDeletesKey
+		log.Warn().
+			Str("value", dfltPendingDeletesKey).
+			Msg("indexer configuration `pendingDeletesKey` not specified, using default")
+	}
+	if conf.IndexDeleteDeadLetterKey == "" {
+		conf.IndexDeleteDeadLetterKey = dfltIndexDeleteDeadLetterKey
+		log.Warn().
+			Str("value", dfltIndexDeleteDeadLetterKey).
+			Msg("indexer configuration `indexDeleteDeadLetterKey` not specified, using default")
+	}
+	if conf.IndexDeleteMaxRetries < 0 {
+		return fmt.Errorf("indexDeleteMaxRetries must be >= 0")
+	}
+	if conf.IndexDeleteMaxRetries == 0 {
+		conf.IndexDeleteMaxRetries = dfltIndexDeleteMaxRetries
+		log.Warn().
+			Int("value", dfltIndexDeleteMaxRetries).
+			Msg("indexer configuration `indexDeleteMaxRetries` not specified, using default")
+	}
+	if conf.MaxRawQueryLen < 0 {
+		return fmt.Errorf("maxRawQueryLen must be >= 0")
+	}
+	if conf.MaxIndexDocBytes < 0 {
+		return fmt.Errorf("maxIndexDocBytes must be >= 0")
+	}
+	if conf.CreatedTimestampUnit == "" {
+		conf.CreatedTimestampUnit = CreatedUnitAuto
+		log.Warn().
+			Str("value", CreatedUnitAuto).
+			Msg("indexer configuration `createdTimestampUnit` not specified, using default")
+	}
+	if !slices.Contains(
+		[]string{CreatedUnitAuto, CreatedUnitSeconds, CreatedUnitMilliseconds}, conf.CreatedTimestampUnit) {
+		return fmt.Errorf("invalid createdTimestampUnit `%s`", conf.CreatedTimestampUnit)
+	}
+	if conf.PqueryMaxSubconc < 0 {
+		log.Info().Msg("indexer configuration `pqueryMaxSubconc` is negative, pquery concordances are not limited")
+
+	} else if conf.PqueryMaxSubconc == 0 {
+		conf.PqueryMaxSubconc = dfltPqueryMaxSubconc
+		log.Warn().
+			Int("value", dfltPqueryMaxSubconc).
+			Msg("indexer configuration `pqueryMaxSubconc` not specified, using default")
+	}
+	if conf.FailedIndexingKey == "" {
+		conf.FailedIndexingKey = dfltFailedIndexingKey
+		log.Warn().
+			Str("value", dfltFailedIndexingKey).
+			Msg("indexer configuration `failedIndexingKey` not specified, using default")
+	}
+	if conf.FailedIndexingMaxSize < 0 {
+		return fmt.Errorf("failedIndexingMaxSize must be >= 0")
+	}
+	if conf.FailedIndexingMaxSize == 0 {
+		conf.FailedIndexingMaxSize = dfltFailedIndexingMaxSize
+		log.Warn().
+			Int("value", dfltFailedIndexingMaxSize).
+			Msg("indexer configuration `failedIndexingMaxSize` not specified, using default")
+	}
+	if conf.ReindexCheckpointKey == "" {
+		conf.ReindexCheckpointKey = dfltReindexCheckpointKey
+		log.Warn().
+			Str("value", dfltReindexCheckpointKey).
+			Msg("indexer configuration `reindexCheckpointKey` not specified, using default")
+	}
+	if conf.SearchCacheSize < 0 {
+		return fmt.Errorf("searchCacheSize must be >= 0")
+	}
+	if conf.SearchCacheSize > 0 {
+		if conf.SearchCacheTTL == "" {
+			conf.SearchCacheTTL = dfltSearchCacheTTL
+			log.Warn().
+				Str("value", dfltSearchCacheTTL).
+				Msg("indexer configuration `searchCacheTTL` not specified, using default")
+		}
+		if dur, err := datetime.ParseDuration(conf.SearchCacheTTL); err != nil || dur == 0 {
+			if err != nil {
+				return fmt.Errorf("failed to validate searchCacheTTL: %w", err)
+			}
+			if dur == 0 {
+				return fmt.Errorf("searchCacheTTL must be > 0")
+			}
+		}
+	}
+	if conf.SubcPropsCacheSize < 0 {
+		return fmt.Errorf("subcPropsCacheSize must be >= 0")
+	}
+	if conf.SubcPropsCacheSize > 0 {
+		if conf.SubcPropsCacheTTL == "" {
+			conf.SubcPropsCacheTTL = dfltSubcPropsCacheTTL
+			log.Warn().
+				Str("value", dfltSubcPropsCacheTTL).
+				Msg("indexer configuration `subcPropsCacheTTL` not specified, using default")
+		}
+		if dur, err := datetime.ParseDuration(conf.SubcPropsCacheTTL); err != nil {
+			return fmt.Errorf("failed to validate subcPropsCacheTTL: %w", err)
+
+		} else if dur == 0 {
+			return fmt.Errorf("subcPropsCacheTTL must be > 0")
+		}
+	}
+	if conf.SubcPropsLookup == "" {
+		conf.SubcPropsLookup = SubcPropsLookupLenient
+		log.Warn().
+			Str("value", SubcPropsLookupLenient).
+			Msg("indexer configuration `subcPropsLookup` not specified, using default")
+	}
+	if conf.SubcPropsLookup != SubcPropsLookupStrict && conf.SubcPropsLookup != SubcPropsLookupLenient {
+		return fmt.Errorf(
+			"invalid subcPropsLookup `%s` (supported: %s, %s)",
+			conf.SubcPropsLookup, SubcPropsLookupStrict, SubcPropsLookupLenient,
+		)
+	}
+	for alias, canonical := range conf.CorpusAliases {
+		if alias == "" || canonical == "" {
+			return fmt.Errorf("corpusAliases must not contain empty corpus names")
+		}
+		if next, ok := conf.CorpusAliases[canonical]; ok && next != canonical {
+			return fmt.Errorf(
+				"invalid corpusAliases: `%s` is mapped to an alias `%s` (chained aliases are not supported)",
+				alias, canonical,
+			)
+		}
+	}
+	if slices.Contains(conf.IndexedCorpora, "") || slices.Contains(conf.NonIndexedCorpora, "") {
+		return fmt.Errorf("indexedCorpora and nonIndexedCorpora must not contain empty corpus names")
+	}
+	if err := conf.validateAnalyzerGroups(); err != nil {
+		return fmt.Errorf("failed to validate analyzerGroups: %w", err)
+	}
+	return nil
+}
+
+func (conf *Conf) validateAnalyzerGroups() error {
+	if len(conf.AnalyzerGroups) > 0 && conf.Backend == BackendElasticsearch {
+		return fmt.Errorf("analyzer groups are not supported by the `%s` backend", conf.Backend)
+	}
+	names := make(map[string]bool)
+	for _, group := range conf.AnalyzerGroups {
+		if !analyzerGroupNameRegexp.MatchString(group.Name) {
+			return fmt.Errorf("invalid group name `%s` (allowed characters: a-z, 0-9, _)", group.Name)
+		}
+		if names[group.Name] {
+			return fmt.Errorf("duplicate group name `%s`", group.Name)
+		}
+		names[group.Name] = true
+		if len(group.CorpusPrefixes) == 0 || slices.Contains(group.CorpusPrefixes, "") {
+			return fmt.Errorf("group `%s` must have non-empty corpusPrefixes", group.Name)
+		}
+	}
 	return nil
 }
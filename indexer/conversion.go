@@ -30,9 +30,97 @@ import (
 )
 
 var (
-	ErrRecordNotIndexable = errors.New("record is not indexable")
+	ErrRecordNotIndexable     = errors.New("record is not indexable")
+	ErrOptimizationInProgress = errors.New("index optimization already in progress")
+	ErrFieldNotFacetable      = errors.New("field is not facetable")
+	ErrInvalidSortField       = errors.New("invalid sort field")
+	ErrInvalidSearchTerm      = errors.New("invalid search term")
+	ErrMissingRecordData      = errors.New("missing archive record data")
+	ErrInvalidSupertype       = errors.New("invalid supertype")
+
+	// ErrUnsupportedSupertype and ErrCorpusNotIndexed are the causes
+	// of ErrRecordNotIndexable which no later indexing attempt can fix
+	// (see IsPermanentlyNotIndexable)
+	ErrUnsupportedSupertype = fmt.Errorf("%w: unsupported query supertype", ErrRecordNotIndexable)
+	ErrCorpusNotIndexed     = fmt.Errorf("%w: corpus excluded by indexing policy", ErrRecordNotIndexable)
+
+	// ErrIndexLocked is returned in case the index is (typically
+	// temporarily) unavailable for writing.
+	ErrIndexLocked = errors.New("index is locked")
+
+	// ErrIndexOpening is returned by handlers in case the index
+	// is still being opened (see Service.OpenIndex)
+	ErrIndexOpening = errors.New("index is being opened")
 )
 
+// IsPermanentlyNotIndexable tests whether err means a record can never
+// be indexed (e.g. an additional query stage like shuffle, filter, ...)
+func IsPermanentlyNotIndexable(err error) bool {
+	return errors.Is(err, ErrUnsupportedSupertype) || errors.Is(err, ErrCorpusNotIndexed)
+}
+
+// recordImporter converts a parsed archive record of a specific
+// query supertype into an indexable document
+type recordImporter func(
+	idx *Indexer, rec *cncdb.UntypedQueryRecord, hRec *cncdb.HistoryRecord) (IndexableMidDoc, error)
+
+// recordImporters maps indexable query supertypes to their converters.
+// Records of an indexable supertype without a converter produce (retryable)
+// ErrRecordNotIndexable.
+var recordImporters = map[cncdb.QuerySupertype]recordImporter{
+	cncdb.QuerySupertypeConc: func(
+		idx *Indexer, rec *cncdb.UntypedQueryRecord, hRec *cncdb.HistoryRecord) (IndexableMidDoc, error) {
+		return importConc(
+			rec, cncdb.QuerySupertypeConc, hRec, idx.concArchDb, idx.conf.SkipCQLExtraction)
+	},
+	cncdb.QuerySupertypeWlist: func(
+		idx *Indexer, rec *cncdb.UntypedQueryRecord, hRec *cncdb.HistoryRecord) (IndexableMidDoc, error) {
+		return importWlist(rec, cncdb.QuerySupertypeWlist, hRec, idx.concArchDb)
+	},
+	cncdb.QuerySupertypeKwords: func(
+		idx *Indexer, rec *cncdb.UntypedQueryRecord, hRec *cncdb.HistoryRecord) (IndexableMidDoc, error) {
+		return importKwords(rec, cncdb.QuerySupertypeKwords, hRec, idx.concArchDb)
+	},
+	cncdb.QuerySupertypePquery: func(
+		idx *Indexer, rec *cncdb.UntypedQueryRecord, hRec *cncdb.HistoryRecord) (IndexableMidDoc, error) {
+		return importPquery(
+			rec, cncdb.QuerySupertypePquery, hRec, idx.concArchDb, idx.rdb,
+			idx.conf.PqueryMaxSubconc, idx.conf.SkipCQLExtraction)
+	},
+}
+
+// minPlausibleCreated is the oldest query history timestamp
+// we consider valid (older values are most likely missing data)
+var minPlausibleCreated = time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// isPlausibleCreated tests whether a unix timestamp is within
+// a reasonable range for a query history record.
+func isPlausibleCreated(ts int64) bool {
+	return ts >= minPlausibleCreated.Unix() && ts <= time.Now().Add(24*time.Hour).Unix()
+}
+
+// normalizeTimestampUnit converts a `created` timestamp of the specified
+// unit (see CreatedUnit* constants) to seconds. In the `auto` mode, values
+// implausible as seconds but plausible as milliseconds (or microseconds)
+// are converted. The returned bool tells whether the value has been changed.
+func normalizeTimestampUnit(ts int64, unit string) (int64, bool) {
+	switch unit {
+	case CreatedUnitSeconds:
+		return ts, false
+	case CreatedUnitMilliseconds:
+		return ts / 1000, true
+	}
+	if isPlausibleCreated(ts) {
+		return ts, false
+	}
+	for _, div := range []int64{1000, 1000000} {
+		if isPlausibleCreated(ts / div) {
+			return ts / div, true
+		}
+	}
+	return ts, false
+}
+
 // IndexableMidDoc is an intermediate format
 // extracted from KonText query records with attributes
 // arranged and typed in an "ideal way" - i.e. in a way
@@ -44,8 +132,29 @@ type IndexableMidDoc interface {
 
 	// AsIndexableDoc converts the "ideal" intermediate
 	// format into the format acceptable by Bleve fulltext
-	// indexing backend.
-	AsIndexableDoc() documents.IndexableDoc
+	// indexing backend. The maxRawQueryLen argument limits
+	// the length of the indexed raw query (<= 0 means no limit).
+	AsIndexableDoc(maxRawQueryLen int) documents.IndexableDoc
+}
+
+// lenientSubcConcArchOps makes subcorpus props lookups non-fatal.
+// In case of a database error, the error is logged and empty props
+// are returned so the record is still indexed (just without
+// the subcorpus name). See Conf.SubcPropsLookup.
+type lenientSubcConcArchOps struct {
+	cncdb.IConcArchOps
+}
+
+func (db *lenientSubcConcArchOps) GetSubcorpusProps(subcID string) (cncdb.SubcProps, error) {
+	props, err := db.IConcArchOps.GetSubcorpusProps(subcID)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("subcorpusId", subcID).
+			Msg("failed to load subcorpus properties, indexing without subcorpus name")
+		return cncdb.SubcProps{}, nil
+	}
+	return props, nil
 }
 
 // concDB describes an object capable of retrieving
@@ -61,12 +170,17 @@ func importConc(
 	stype cncdb.QuerySupertype,
 	hRec *cncdb.HistoryRecord,
 	db cncdb.IConcArchOps,
+	skipCQL bool,
 ) (IndexableMidDoc, error) {
 
 	var form cncdb.ConcFormRecord
 	if err := json.Unmarshal([]byte(hRec.Rec.Data), &form); err != nil {
 		return nil, err
 	}
+	data, err := hRec.Rec.FetchData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert rec. to doc.: %w", err)
+	}
 	subcProps, err := rec.GetSubcorpus(db)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert rec. to doc.: %w", err)
@@ -74,22 +188,53 @@ func importConc(
 	ans := &documents.MidConc{
 		ID:             rec.ID,
 		Name:           hRec.Name,
-		Created:        time.Unix(hRec.Created, 0),
+		Created:        documents.CreatedTime(hRec.Created),
 		UserID:         hRec.UserID,
 		Corpora:        rec.Corpora,
 		Subcorpus:      subcProps.Name,
+		SubcorpusID:    rec.SubcorpusID,
 		QuerySupertype: stype,
+		HasFilters:     form.HasFilters(),
+		IsSlow:         data.IsFlaggedAsSlow(),
+		CQLPending:     skipCQL,
+		UseRegexp:      form.UsesRegexp(),
+		DefaultAttrs:   form.GetDefaultAttrs(),
 		RawQueries:     make([]cncdb.RawQuery, 0, len(form.LastopForm.CurrQueries)),
 	}
 
 	for corp, query := range form.LastopForm.CurrQueries {
 		ans.RawQueries = append(ans.RawQueries, cncdb.RawQuery{
-			Value: query,
-			Type:  form.LastopForm.CurrQueryTypes[corp],
+			Value:  query,
+			Type:   form.LastopForm.CurrQueryTypes[corp],
+			Corpus: corp,
+		})
+	}
+	// older records may miss raw queries so we try to reconstruct
+	// them from the parsed form
+	for corp, parsed := range form.LastopForm.CurrParsedQueries {
+		if form.LastopForm.CurrQueries[corp] != "" {
+			continue
+		}
+		query, err := documents.ReconstructQuery(parsed)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("id", rec.ID).
+				Str("corpus", corp).
+				Msg("failed to reconstruct query from its parsed form")
+			continue
+		}
+		ans.RawQueries = append(ans.RawQueries, cncdb.RawQuery{
+			Value:  query,
+			Type:   form.LastopForm.CurrQueryTypes[corp],
+			Corpus: corp,
 		})
 	}
 
-	if err := documents.ExtractQueryProps(&form, ans); err != nil {
+	if skipCQL {
+		// attributes will be extracted once the record is re-indexed
+
+	} else if err := documents.ExtractQueryProps(&form, ans); err != nil {
 		rqs := make([]string, len(ans.GetRawQueries()))
 		for i, rq := range ans.GetRawQueries() {
 			rqs[i] = rq.Value
@@ -153,10 +298,11 @@ func importWlist(
 		ID:             rec.ID,
 		Name:           hRec.Name,
 		QuerySupertype: stype,
-		Created:        time.Unix(hRec.Created, 0),
+		Created:        documents.CreatedTime(hRec.Created),
 		UserID:         hRec.UserID,
 		Corpora:        rec.Corpora,
 		Subcorpus:      subcProps.Name,
+		SubcorpusID:    rec.SubcorpusID,
 		RawQuery:       form.Form.WLPattern,
 		PosAttrNames:   []string{form.Form.WLAttr},
 		PFilterWords:   form.Form.PFilterWords,
@@ -177,6 +323,7 @@ func importKwords(
 	}
 
 	subcorpora := make([]string, 0, 2)
+	subcorpusIDs := make([]string, 0, 2)
 	subcProps1, err := rec.GetSubcorpus(db)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert rec. to doc.: %w", err)
@@ -184,6 +331,9 @@ func importKwords(
 	if subcProps1.Name != "" {
 		subcorpora = append(subcorpora, subcProps1.Name)
 	}
+	if rec.SubcorpusID != "" {
+		subcorpusIDs = append(subcorpusIDs, rec.SubcorpusID)
+	}
 	subcProps2, err := db.GetSubcorpusProps(form.Form.RefUsesubcorp)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert rec. to doc.: %w", err)
@@ -191,28 +341,71 @@ func importKwords(
 	if subcProps2.Name != "" {
 		subcorpora = append(subcorpora, subcProps2.Name)
 	}
+	if form.Form.RefUsesubcorp != "" {
+		subcorpusIDs = append(subcorpusIDs, form.Form.RefUsesubcorp)
+	}
 	corpora := append(rec.Corpora, form.Form.RefCorpname)
 
 	ans := &documents.MidKwords{
 		ID:             rec.ID,
 		Name:           hRec.Name,
 		QuerySupertype: stype,
-		Created:        time.Unix(hRec.Created, 0),
+		Created:        documents.CreatedTime(hRec.Created),
 		UserID:         hRec.UserID,
 		Corpora:        corpora,
 		Subcorpora:     subcorpora,
+		SubcorpusIDs:   subcorpusIDs,
 		RawQuery:       form.Form.WLPattern,
 		PosAttrNames:   []string{form.Form.WLAttr},
 	}
 	return ans, nil
 }
 
+// fetchPqueryConcs loads all the concordances a pquery is based on.
+// Records are first searched in cdb (typically Redis) and the
+// missing ones are then loaded from the archive in a single batch.
+func fetchPqueryConcs(
+	concIDs []string,
+	db cncdb.IConcArchOps,
+	cdb concDB,
+) (map[string]cncdb.ArchRecord, error) {
+	ans := make(map[string]cncdb.ArchRecord)
+	missing := make([]string, 0, len(concIDs))
+	for i, id := range concIDs {
+		data, err := cdb.GetConcRecord(id)
+		if err == cncdb.ErrRecordNotFound {
+			missing = append(missing, id)
+			continue
+
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to fetch pquery concordance #%d: %w", i, err)
+		}
+		ans[id] = data
+	}
+	if len(missing) > 0 {
+		archived, err := db.LoadRecordsByIDs(missing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch pquery concordances: %w", err)
+		}
+		for _, id := range missing {
+			recs, ok := archived[id]
+			if !ok || len(recs) == 0 {
+				return nil, fmt.Errorf("failed to fetch pquery concordance %s: %w", id, cncdb.ErrRecordNotFound)
+			}
+			ans[id] = recs[0]
+		}
+	}
+	return ans, nil
+}
+
 func importPquery(
 	rec *cncdb.UntypedQueryRecord,
 	stype cncdb.QuerySupertype,
 	hRec *cncdb.HistoryRecord,
 	db cncdb.IConcArchOps,
 	cdb concDB,
+	maxSubconc int,
+	skipCQL bool,
 ) (IndexableMidDoc, error) {
 	var form cncdb.PQueryFormRecord
 	if err := json.Unmarshal([]byte(hRec.Rec.Data), &form); err != nil {
@@ -226,13 +419,26 @@ func importPquery(
 	mergedStructures := make([]string, 0, 10)
 	mergedStructAttrs := make(map[string][]string)
 	mergedPosAttrs := make(map[string][]string)
-	mergedRawQueries := make([]cncdb.RawQuery, 0, len(form.Form.ConcIDs))
+	concIDs := form.Form.ConcIDs
+	var truncated bool
+	if maxSubconc > 0 && len(concIDs) > maxSubconc {
+		log.Warn().
+			Str("queryId", rec.ID).
+			Int("numConcs", len(concIDs)).
+			Int("maxSubconc", maxSubconc).
+			Msg("too many pquery concordances, indexing only the first ones")
+		concIDs = concIDs[:maxSubconc]
+		truncated = true
+	}
+	mergedRawQueries := make([]cncdb.RawQuery, 0, len(concIDs))
 
-	for i, id := range form.Form.ConcIDs {
-		data, err := cdb.GetConcRecord(id)
-		if err != nil {
-			return nil, fmt.Errorf("failed to fetch pquery concordance #%d: %w", i, err)
-		}
+	concRecs, err := fetchPqueryConcs(concIDs, db, cdb)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, id := range concIDs {
+		data := concRecs[id]
 		var crec cncdb.UntypedQueryRecord
 		if err := json.Unmarshal([]byte(data.Data), &crec); err != nil {
 			return nil, fmt.Errorf("failed to process pquery conc #%d: %w", i, err)
@@ -251,7 +457,7 @@ func importPquery(
 			Name:    hRec.Name,
 			Rec:     &data,
 		}
-		conc, err := importConc(&crec, cqstype, &h, db)
+		conc, err := importConc(&crec, cqstype, &h, db, skipCQL)
 
 		if err != nil {
 			return nil, fmt.Errorf("failed to process pquery conc #%d: %w", i, err)
@@ -271,17 +477,24 @@ func importPquery(
 
 	}
 	ans := &documents.MidPQuery{
-		ID:             rec.ID,
-		Name:           hRec.Name,
-		Created:        time.Unix(hRec.Created, 0),
-		UserID:         hRec.UserID,
-		Corpora:        rec.Corpora,
-		Subcorpus:      subcProps.Name,
-		QuerySupertype: stype,
-		RawQueries:     mergedRawQueries,
-		PosAttrs:       mergedPosAttrs,
-		StructAttrs:    mergedStructAttrs,
-		Structures:     mergedStructures,
+		ID:               rec.ID,
+		Name:             hRec.Name,
+		Created:          documents.CreatedTime(hRec.Created),
+		UserID:           hRec.UserID,
+		Corpora:          rec.Corpora,
+		Subcorpus:        subcProps.Name,
+		SubcorpusID:      rec.SubcorpusID,
+		QuerySupertype:   stype,
+		RawQueries:       mergedRawQueries,
+		PosAttrs:         mergedPosAttrs,
+		StructAttrs:      mergedStructAttrs,
+		Structures:       mergedStructures,
+		MinFreq:          form.Form.MinFreq,
+		MaxFreq:          form.Form.MaxFreq,
+		MinRelFreq:       form.Form.MinRelFreq,
+		MaxRelFreq:       form.Form.MaxRelFreq,
+		SubconcTruncated: truncated,
+		CQLPending:       skipCQL,
 	}
 	return ans, nil
 }
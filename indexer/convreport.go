@@ -0,0 +1,204 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"camus/cncdb"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// MaxConversionReportSample limits number of history records
+	// processed by a single conversion report
+	MaxConversionReportSample = 1000
+
+	numConversionReportExamples = 5
+
+	// numConversionReportStrata is the number of equally long time
+	// intervals the report sample is drawn from
+	numConversionReportStrata = 10
+
+	ConversionClassOK           = "ok"
+	ConversionClassNotIndexable = "notIndexable"
+	ConversionClassParseError   = "parseError"
+	ConversionClassMissingData  = "missingData"
+)
+
+// ConversionClassStats describes records of a single conversion
+// result class (see ConversionClass* constants)
+type ConversionClassStats struct {
+	Count      int      `json:"count"`
+	ExampleIDs []string `json:"exampleIds"`
+}
+
+// ConversionReport summarizes results of RecToDoc applied
+// to a sample of query history records.
+type ConversionReport struct {
+	From       int64                            `json:"from"`
+	To         int64                            `json:"to"`
+	NumStrata  int                              `json:"numStrata"`
+	NumSampled int                              `json:"numSampled"`
+	Classes    map[string]*ConversionClassStats `json:"classes"`
+}
+
+func (cr *ConversionReport) add(class, queryID string) {
+	stats, ok := cr.Classes[class]
+	if !ok {
+		stats = &ConversionClassStats{ExampleIDs: []string{}}
+		cr.Classes[class] = stats
+	}
+	stats.Count++
+	if len(stats.ExampleIDs) < numConversionReportExamples {
+		stats.ExampleIDs = append(stats.ExampleIDs, queryID)
+	}
+}
+
+// classifyConversion returns a conversion class based
+// on an error returned by RecToDoc
+func classifyConversion(err error) string {
+	switch {
+	case err == nil:
+		return ConversionClassOK
+	case errors.Is(err, ErrRecordNotIndexable):
+		return ConversionClassNotIndexable
+	case errors.Is(err, ErrMissingRecordData):
+		return ConversionClassMissingData
+	default:
+		return ConversionClassParseError
+	}
+}
+
+// conversionReport converts the provided records (with archive
+// data already attached) and aggregates the results. Nothing
+// is written to the index.
+func (idx *Indexer) conversionReport(history []cncdb.HistoryRecord) ConversionReport {
+	ans := ConversionReport{
+		NumSampled: len(history),
+		Classes:    make(map[string]*ConversionClassStats),
+	}
+	for _, hRec := range history {
+		_, err := idx.RecToDoc(&hRec)
+		ans.add(classifyConversion(err), hRec.QueryID)
+	}
+	return ans
+}
+
+// stratifyInterval splits the [from, to] interval (in seconds) into
+// at most n non-overlapping intervals of (nearly) the same length.
+func stratifyInterval(from, to int64, n int) [][2]int64 {
+	span := to - from + 1
+	if span < int64(n) {
+		n = int(span)
+	}
+	ans := make([][2]int64, n)
+	for i := range ans {
+		ans[i] = [2]int64{
+			from + span*int64(i)/int64(n),
+			from + span*int64(i+1)/int64(n) - 1,
+		}
+	}
+	return ans
+}
+
+// loadStratifiedSample loads up to `sample` query history records
+// evenly distributed among the provided time strata so records
+// of different age (and thus of different data format versions)
+// are sampled. Within a stratum, the oldest records are taken.
+// A quota not used by a sparse stratum is redistributed among
+// the strata with more records available.
+func (idx *Indexer) loadStratifiedSample(strata [][2]int64, sample int) ([]cncdb.HistoryRecord, error) {
+	recs := make([][]cncdb.HistoryRecord, len(strata))
+	exhausted := make([]bool, len(strata))
+	for {
+		remaining, numActive := sample, 0
+		for i := range strata {
+			if exhausted[i] {
+				remaining -= len(recs[i])
+
+			} else {
+				numActive++
+			}
+		}
+		var numNewlyExhausted, j int
+		for i, stratum := range strata {
+			if exhausted[i] {
+				continue
+			}
+			quota := remaining / numActive
+			if j < remaining%numActive {
+				quota++
+			}
+			j++
+			if quota <= len(recs[i]) {
+				recs[i] = recs[i][:quota]
+				continue
+			}
+			loaded, err := idx.queryHistDb.LoadHistoryBetween(stratum[0], stratum[1], quota)
+			if err != nil {
+				return []cncdb.HistoryRecord{}, err
+			}
+			recs[i] = loaded
+			if len(loaded) < quota {
+				exhausted[i] = true
+				numNewlyExhausted++
+			}
+		}
+		if numNewlyExhausted == 0 || numNewlyExhausted == numActive {
+			break
+		}
+	}
+	ans := make([]cncdb.HistoryRecord, 0, sample)
+	for _, stratumRecs := range recs {
+		ans = append(ans, stratumRecs...)
+	}
+	return ans, nil
+}
+
+// ConversionReport loads up to `sample` query history records
+// created within the [from, to] interval (sampled evenly across
+// the interval, see loadStratifiedSample), runs RecToDoc on them
+// and reports numbers of successful conversions and of each
+// error class.
+func (idx *Indexer) ConversionReport(from, to int64, sample int) (ConversionReport, error) {
+	if sample <= 0 || sample > MaxConversionReportSample {
+		return ConversionReport{}, fmt.Errorf(
+			"invalid sample size %d (max. %d)", sample, MaxConversionReportSample)
+	}
+	if from > to {
+		return ConversionReport{}, fmt.Errorf("invalid interval [%d, %d]", from, to)
+	}
+	strata := stratifyInterval(from, to, min(sample, numConversionReportStrata))
+	history, err := idx.loadStratifiedSample(strata, sample)
+	if err != nil {
+		return ConversionReport{}, fmt.Errorf("failed to create conversion report: %w", err)
+	}
+	for i, hRec := range history {
+		history[i].Rec, err = idx.GetConcRecord(hRec.QueryID)
+		if err != nil {
+			// such records will be reported as missing data
+			log.Error().Err(err).Str("queryId", hRec.QueryID).Msg("failed to load record for conversion report")
+		}
+	}
+	ans := idx.conversionReport(history)
+	ans.From = from
+	ans.To = to
+	ans.NumStrata = len(strata)
+	return ans, nil
+}
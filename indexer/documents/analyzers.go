@@ -0,0 +1,110 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package documents
+
+import (
+	"slices"
+	"strings"
+)
+
+const (
+	// QueryAnalyzer is the default analyzer of query-like fields
+	QueryAnalyzer = "kontext_query_analyzer"
+
+	// LabelAnalyzer is the analyzer of label fields (corpora, structures etc.)
+	LabelAnalyzer = "kontext_label_analyzer"
+
+	groupTypeSeparator = "@"
+)
+
+// GroupAnalyzedFields lists query fields which are searched using
+// the analyzers of all the analyzer groups (see AnalyzerGroup).
+// Label and exact fields are analyzed the same way in all the groups.
+var GroupAnalyzedFields = []string{
+	"raw_query",
+	"raw_query_by_corpus",
+	"pos_attr_values",
+	"pfilter_words",
+	"nfilter_words",
+}
+
+// AnalyzerGroup defines a language-specific analysis of query fields
+// for documents whose primary corpus matches one of the CorpusPrefixes.
+//
+// Bleve sets field analyzers at mapping time so each group is
+// implemented as a separate set of document type mappings (e.g. `conc@en`)
+// sharing field names with the default ones. This keeps queries simple
+// (the same field names are searched for all the documents) but it has
+// some consequences:
+//
+//   - match queries on group analyzed fields must be run with each of the
+//     analyzers (see GroupAnalyzedFields) which makes them slightly slower
+//     and a stemmed query may also match unstemmed documents of other groups
+//   - prefix and wildcard queries are not analyzed so they are matched against
+//     indexed (e.g. stemmed) tokens
+//   - query string searches (see Indexer.SearchWithQuery) rely on Bleve
+//     picking a field analyzer which becomes ambiguous with multiple groups
+//   - the groups are part of the index mapping so any change
+//     requires the index to be re-created (see MigrateIndex)
+type AnalyzerGroup struct {
+
+	// Name identifies the group. It is used in type and analyzer names.
+	Name string `json:"name"`
+
+	// CorpusPrefixes lists prefixes of corpora belonging to the group
+	// (e.g. "syn" for all the SYN corpora).
+	CorpusPrefixes []string `json:"corpusPrefixes"`
+
+	// TokenFilters are Bleve token filters applied after lowercasing
+	// (e.g. ["stop_en", "stemmer_en_snowball"])
+	TokenFilters []string `json:"tokenFilters"`
+}
+
+// MatchesCorpus tests whether the corpus belongs to the group
+func (ag AnalyzerGroup) MatchesCorpus(corpname string) bool {
+	return slices.ContainsFunc(ag.CorpusPrefixes, func(prefix string) bool {
+		return strings.HasPrefix(corpname, prefix)
+	})
+}
+
+// GroupQueryAnalyzer returns the name of a query analyzer of an analyzer group
+func GroupQueryAnalyzer(group string) string {
+	return QueryAnalyzer + "_" + group
+}
+
+// GroupedType returns a document type name for an analyzer group.
+// An empty group means the default type mappings.
+func GroupedType(docType, group string) string {
+	if group == "" {
+		return docType
+	}
+	return docType + groupTypeSeparator + group
+}
+
+// AnalyzerGroupDoc is implemented by documents which can be
+// indexed using an analyzer group.
+type AnalyzerGroupDoc interface {
+	PrimaryCorpus() string
+	SetAnalyzerGroup(group string)
+}
+
+func primaryCorpus(corpora []string) string {
+	if len(corpora) > 0 {
+		return corpora[0]
+	}
+	return ""
+}
@@ -35,27 +35,62 @@ type Concordance struct {
 
 	UserID string `json:"user_id"`
 
+	UserIDNum int `json:"user_id_num"`
+
 	IsSimpleQuery bool `json:"is_simple_query"`
 
+	HasFilters bool `json:"has_filters"`
+
+	IsSlow bool `json:"is_slow"`
+
+	CQLPending bool `json:"cql_pending"`
+
+	UseRegexp bool `json:"use_regexp"`
+
+	DefaultAttr string `json:"default_attr"`
+
 	Corpora string `json:"corpora"`
 
+	CorporaExact []string `json:"corpora_exact"`
+
 	Subcorpus string `json:"subcorpus"`
 
+	SubcorpusID string `json:"subcorpus_id"`
+
 	RawQuery string `json:"raw_query"`
 
+	RawQueryByCorpus string `json:"raw_query_by_corpus"`
+
 	Structures string `json:"structures"`
 
 	StructAttrNames string `json:"struct_attr_names"`
 
 	StructAttrValues string `json:"struct_attr_values"`
 
+	// StructAttrPairs contains `attr=value` tokens preserving
+	// the association between structural attributes and their values
+	// (e.g. `doc.txtype=fiction`)
+	StructAttrPairs []string `json:"struct_attr_pairs"`
+
 	PosAttrNames string `json:"pos_attr_names"`
 
 	PosAttrValues string `json:"pos_attr_values"`
+
+	// AnalyzerGroup is not indexed, it only selects
+	// the type mapping (see AnalyzerGroup)
+	AnalyzerGroup string `json:"-"`
 }
 
 func (bdoc *Concordance) Type() string {
-	return "conc"
+	return GroupedType("conc", bdoc.AnalyzerGroup)
+}
+
+func (bdoc *Concordance) PrimaryCorpus() string {
+	return primaryCorpus(bdoc.CorporaExact)
+}
+
+func (bdoc *Concordance) SetAnalyzerGroup(group string) {
+	bdoc.AnalyzerGroup = group
 }
 
 func (bdoc *Concordance) GetID() string {
@@ -83,6 +118,32 @@ type MidConc struct {
 
 	Subcorpus string `json:"subcorpus"`
 
+	// SubcorpusID is the original subcorpus identifier (hash)
+	// the Subcorpus name is resolved from
+	SubcorpusID string `json:"subcorpusId"`
+
+	// HasFilters specifies whether the query was later refined
+	// by a filter operation (see cncdb.ConcFormRecord.HasFilters)
+	HasFilters bool `json:"hasFilters"`
+
+	// IsSlow specifies whether the query has been flagged as slow
+	// (see cncdb.GeneralDataRecord.IsFlaggedAsSlow)
+	IsSlow bool `json:"isSlow"`
+
+	// CQLPending specifies that the CQL query has not been parsed
+	// (see indexer.Conf.SkipCQLExtraction) and the document should
+	// be re-indexed to get the attributes extracted from the query
+	CQLPending bool `json:"cqlPending"`
+
+	// UseRegexp specifies whether a simple query has been evaluated
+	// in the regexp mode (see cncdb.ConcFormRecord.UsesRegexp)
+	UseRegexp bool `json:"useRegexp"`
+
+	// DefaultAttrs contains default attributes (e.g. `word`, `lemma`)
+	// targeted by the query. For parallel corpora, this is a union
+	// of the attributes of all the corpora.
+	DefaultAttrs []string `json:"defaultAttrs"`
+
 	// RawQuery is the original query written by a user
 	// (multiple queries = aligned corpora)
 	RawQueries []cncdb.RawQuery `json:"rawQueries"`
@@ -147,6 +208,23 @@ func (doc *MidConc) GetRawQueriesAsString() string {
 	return ans.String()
 }
 
+// GetRawQueriesByCorpus returns raw queries with each query token
+// prefixed by the respective corpus name (e.g. `syn2020:[word="x"]`)
+// so it is possible to search for queries applied to a specific corpus
+// (typically one of aligned corpora).
+func (doc *MidConc) GetRawQueriesByCorpus() string {
+	ans := make([]string, 0, len(doc.RawQueries)*3)
+	for _, v := range doc.RawQueries {
+		if v.Corpus == "" {
+			continue
+		}
+		for _, tok := range strings.Fields(v.Value) {
+			ans = append(ans, v.Corpus+":"+tok)
+		}
+	}
+	return strings.Join(ans, " ")
+}
+
 // IsValidCQLQuery tests for indexability of a query at position idx
 // (when considering a possible query to aligned corpora; for single-corpus
 // queries, idx==0 is the only option)
@@ -154,7 +232,7 @@ func (doc *MidConc) IsValidCQLQuery(idx int) bool {
 	return len(doc.RawQueries) > idx && doc.RawQueries[idx].Type == "advanced"
 }
 
-func (doc *MidConc) AsIndexableDoc() IndexableDoc {
+func (doc *MidConc) AsIndexableDoc(maxRawQueryLen int) IndexableDoc {
 	posAttrNames := make([]string, 0, 5)
 	posAttrValues := make([]string, 0, 5)
 	for name, values := range doc.PosAttrs {
@@ -164,9 +242,13 @@ func (doc *MidConc) AsIndexableDoc() IndexableDoc {
 
 	structAttrNames := make([]string, 0, 5)
 	structAttrValues := make([]string, 0, 5)
+	structAttrPairs := make([]string, 0, 5)
 	for name, values := range doc.StructAttrs {
 		structAttrNames = append(structAttrNames, name)
 		structAttrValues = append(structAttrValues, values...)
+		for _, v := range values {
+			structAttrPairs = append(structAttrPairs, name+"="+v)
+		}
 	}
 	bDoc := &Concordance{
 		ID:               doc.ID,
@@ -174,12 +256,22 @@ func (doc *MidConc) AsIndexableDoc() IndexableDoc {
 		Created:          doc.Created,
 		QuerySupertype:   string(doc.QuerySupertype),
 		UserID:           strconv.Itoa(doc.UserID),
+		UserIDNum:        doc.UserID,
 		Corpora:          strings.Join(doc.Corpora, " "),
+		CorporaExact:     doc.Corpora,
 		Subcorpus:        doc.Subcorpus,
-		RawQuery:         doc.GetRawQueriesAsString(),
+		SubcorpusID:      doc.SubcorpusID,
+		HasFilters:       doc.HasFilters,
+		IsSlow:           doc.IsSlow,
+		CQLPending:       doc.CQLPending,
+		UseRegexp:        doc.UseRegexp,
+		DefaultAttr:      strings.Join(doc.DefaultAttrs, " "),
+		RawQuery:         truncateRawQuery(doc.ID, doc.GetRawQueriesAsString(), maxRawQueryLen),
+		RawQueryByCorpus: truncateRawQuery(doc.ID, doc.GetRawQueriesByCorpus(), maxRawQueryLen),
 		Structures:       strings.Join(doc.Structures, " "),
 		StructAttrNames:  strings.Join(structAttrNames, " "),
 		StructAttrValues: strings.Join(structAttrValues, " "),
+		StructAttrPairs:  structAttrPairs,
 		PosAttrNames:     strings.Join(posAttrNames, " "),
 		PosAttrValues:    strings.Join(posAttrValues, " "),
 	}
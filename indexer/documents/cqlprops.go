@@ -21,6 +21,7 @@ import (
 	"camus/cncdb"
 	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/czcorpus/cqlizer/cql"
 	"github.com/rs/zerolog/log"
@@ -88,6 +89,64 @@ func extractSimpleQueryProps(form *cncdb.ConcFormRecord, doc CQLMidDoc) error {
 	return nil
 }
 
+// ReconstructQuery creates an approximate CQL representation of a simple
+// query as encoded in KonText's `curr_parsed_queries` (for a single corpus).
+// Each item represents a single query position matching the value in any
+// of the listed attributes. This is intended as a fallback for records
+// with missing `curr_queries`.
+func ReconstructQuery(parsedQuery []any) (string, error) {
+	positions := make([]string, 0, len(parsedQuery))
+	for _, conjRec := range parsedQuery {
+		tuple, ok := conjRec.([]any)
+		if !ok || len(tuple) == 0 {
+			return "", fmt.Errorf("query reconstruction error: failed to unpack conjuction record")
+		}
+		queryTokens, ok := tuple[0].([]any)
+		if !ok {
+			return "", fmt.Errorf(
+				"query reconstruction error: failed to unpack properties part of a conjuction record item")
+		}
+		conj := make([]string, 0, len(queryTokens))
+		for _, token := range queryTokens {
+			tokenProps, ok := token.([]any)
+			if !ok || len(tokenProps) < 2 {
+				return "", fmt.Errorf("query reconstruction error: failed to parse token props")
+			}
+			var attrs []string
+			switch tAttrs := tokenProps[0].(type) {
+			case string:
+				attrs = []string{tAttrs}
+			case []any:
+				for _, v := range tAttrs {
+					if tv, ok := v.(string); ok {
+						attrs = append(attrs, tv)
+					}
+				}
+			default:
+				return "", fmt.Errorf(
+					"query reconstruction error: failed to determine attribute list or a single attribute")
+			}
+			value, ok := tokenProps[1].(string)
+			if !ok {
+				return "", fmt.Errorf("query reconstruction error: failed to determine query value")
+			}
+			value = strings.ReplaceAll(value, `"`, `\"`)
+			disj := make([]string, len(attrs))
+			for i, attr := range attrs {
+				disj[i] = fmt.Sprintf(`%s="%s"`, attr, value)
+			}
+			if len(disj) > 1 && len(queryTokens) > 1 {
+				conj = append(conj, "("+strings.Join(disj, " | ")+")")
+
+			} else {
+				conj = append(conj, strings.Join(disj, " | "))
+			}
+		}
+		positions = append(positions, "["+strings.Join(conj, " & ")+"]")
+	}
+	return strings.Join(positions, " "), nil
+}
+
 // ExtractQueryProps parses queries stored in `doc` and
 // extracts used attributes, structures and respective values
 // into doc's properties.
@@ -127,3 +186,21 @@ func ExtractQueryProps(form *cncdb.ConcFormRecord, doc CQLMidDoc) error {
 	}
 	return nil
 }
+
+// ExtractRawCQLProps extracts properties of a standalone (advanced) CQL
+// query and returns them as a concordance document with only query-related
+// properties set. Positional attributes without an explicit name
+// are assigned to `defaultAttr`. This is mostly intended for tuning
+// and debugging of the extraction.
+func ExtractRawCQLProps(query, defaultAttr string) (*MidConc, error) {
+	// we mimic KonText's encoding of the initial operation (`a` + default attr. + query)
+	form := &cncdb.ConcFormRecord{Q: []string{fmt.Sprintf("a%s,%s", defaultAttr, query)}}
+	doc := &MidConc{
+		RawQueries:   []cncdb.RawQuery{{Value: query, Type: "advanced"}},
+		DefaultAttrs: form.GetDefaultAttrs(),
+	}
+	if err := ExtractQueryProps(form, doc); err != nil {
+		return doc, err
+	}
+	return doc, nil
+}
@@ -19,6 +19,7 @@ package documents
 
 import (
 	"camus/cncdb"
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -57,3 +58,30 @@ func TestExtractCQLPropsWithDefaultAttr(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"party"}, doc.PosAttrs["word"])
 }
+
+func TestExtractRawCQLProps(t *testing.T) {
+	doc, err := ExtractRawCQLProps(`"party" [tag="N.*"] within <s/>`, "lemma")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"party"}, doc.PosAttrs["lemma"])
+	assert.Equal(t, []string{"N.*"}, doc.PosAttrs["tag"])
+	assert.Equal(t, []string{"s"}, doc.Structures)
+	assert.Equal(t, []string{"lemma"}, doc.DefaultAttrs)
+
+	_, err = ExtractRawCQLProps(`[word="foo"`, "word")
+	assert.Error(t, err)
+}
+
+func TestReconstructQuery(t *testing.T) {
+	var parsed map[string][]any
+	err := json.Unmarshal(
+		[]byte(`{"syn2020": [[[[["lemma", "sublemma", "word"], "poklad"]], false], [[["word", "velk\u00fd"]], false]]}`),
+		&parsed,
+	)
+	assert.NoError(t, err)
+	q, err := ReconstructQuery(parsed["syn2020"])
+	assert.NoError(t, err)
+	assert.Equal(t, `[lemma="poklad" | sublemma="poklad" | word="poklad"] [word="velký"]`, q)
+
+	_, err = ReconstructQuery([]any{"foo"})
+	assert.Error(t, err)
+}
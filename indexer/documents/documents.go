@@ -16,7 +16,49 @@
 
 package documents
 
-import "github.com/blevesearch/bleve/v2/mapping"
+import (
+	"fmt"
+	"time"
+
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// TruncatedQueryMarker is appended to raw queries
+	// shortened due to the configured maximum length
+	TruncatedQueryMarker = " [...]"
+
+	// CreatedDateTimeParser is the name of the parser used for
+	// date values of the `created` field within searches
+	CreatedDateTimeParser = "kontext_created_parser"
+)
+
+// CreatedLayouts are layouts accepted by CreatedDateTimeParser.
+// Values without a timezone are interpreted as UTC. Please note
+// that documents are indexed with a precision of seconds.
+var CreatedLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// ParseCreated parses a `created` value using one of CreatedLayouts
+func ParseCreated(value string) (time.Time, error) {
+	for _, layout := range CreatedLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date `%s` (supported layouts: %v)", value, CreatedLayouts)
+}
+
+// CreatedTime converts a unix timestamp into a `created`
+// value. All the documents use UTC so their stored values
+// are consistent regardless of the server timezone.
+func CreatedTime(ts int64) time.Time {
+	return time.Unix(ts, 0).UTC()
+}
 
 // IndexableDoc is a generalization of a document
 // which can be added to a Bleve index. Please note
@@ -33,3 +75,23 @@ type IndexableDoc interface {
 	mapping.Classifier
 	GetID() string
 }
+
+// truncateRawQuery shortens the query to at most maxLen characters
+// (plus TruncatedQueryMarker). Values maxLen <= 0 mean no limit.
+// Please note that this affects only the indexed document, the original
+// query is always available via the source archive record.
+func truncateRawQuery(docID, rawQuery string, maxLen int) string {
+	if maxLen <= 0 || len(rawQuery) <= maxLen {
+		return rawQuery
+	}
+	runes := []rune(rawQuery)
+	if len(runes) <= maxLen {
+		return rawQuery
+	}
+	log.Debug().
+		Str("id", docID).
+		Int("origLength", len(runes)).
+		Int("maxLength", maxLen).
+		Msg("truncating raw query of indexed document")
+	return string(runes[:maxLen]) + TruncatedQueryMarker
+}
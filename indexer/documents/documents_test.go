@@ -0,0 +1,138 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package documents
+
+import (
+	"camus/cncdb"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateRawQuery(t *testing.T) {
+	assert.Equal(t, "[word=\"foo\"]", truncateRawQuery("x", "[word=\"foo\"]", 0))
+	assert.Equal(t, "[word=\"foo\"]", truncateRawQuery("x", "[word=\"foo\"]", 12))
+	assert.Equal(t, "[word"+TruncatedQueryMarker, truncateRawQuery("x", "[word=\"foo\"]", 5))
+	// multi-byte characters must not be split
+	assert.Equal(t, "žluť"+TruncatedQueryMarker, truncateRawQuery("x", "žluťoučký", 4))
+	assert.Equal(t, "žluť", truncateRawQuery("x", "žluť", 4))
+}
+
+func TestConcAsIndexableDocTruncatesQuery(t *testing.T) {
+	doc := MidConc{
+		ID:         "conc1",
+		RawQueries: []cncdb.RawQuery{{Value: "[lemma=\"bar\"]", Type: "advanced"}},
+	}
+	idoc := doc.AsIndexableDoc(5).(*Concordance)
+	assert.Equal(t, " [lem"+TruncatedQueryMarker, idoc.RawQuery)
+	assert.Equal(t, "[lemma=\"bar\"]", doc.RawQueries[0].Value)
+}
+
+func TestConcRawQueryByCorpus(t *testing.T) {
+	doc := &MidConc{
+		ID:      "abc",
+		Corpora: []string{"intercorp_v16_cs", "intercorp_v16_de"},
+		RawQueries: []cncdb.RawQuery{
+			{Value: "[word=\"dům\"]", Type: "advanced", Corpus: "intercorp_v16_cs"},
+			{Value: "[word=\"Haus\"] [lemma=\"groß\"]", Type: "advanced", Corpus: "intercorp_v16_de"},
+		},
+	}
+	idoc := doc.AsIndexableDoc(0).(*Concordance)
+	assert.Equal(t, " [word=\"dům\"] [word=\"Haus\"] [lemma=\"groß\"]", idoc.RawQuery)
+	assert.Equal(
+		t,
+		"intercorp_v16_cs:[word=\"dům\"] intercorp_v16_de:[word=\"Haus\"] intercorp_v16_de:[lemma=\"groß\"]",
+		idoc.RawQueryByCorpus,
+	)
+}
+
+func TestWordlistAsIndexableDocFilterWords(t *testing.T) {
+	doc := MidWordlist{
+		ID:           "wl1",
+		PFilterWords: []string{" Foo", "bar ", ""},
+		NFilterWords: []string{"baz"},
+	}
+	idoc := doc.AsIndexableDoc(5).(*Wordlist)
+	assert.Equal(t, "foo b"+TruncatedQueryMarker, idoc.PFilterWords)
+	assert.Equal(t, "baz", idoc.NFilterWords)
+	assert.Equal(t, 2, idoc.PFilterCount)
+	assert.Equal(t, 1, idoc.NFilterCount)
+}
+
+func TestAnalyzerGroupType(t *testing.T) {
+	group := AnalyzerGroup{Name: "cs", CorpusPrefixes: []string{"syn", "omni"}}
+	assert.True(t, group.MatchesCorpus("syn2020"))
+	assert.True(t, group.MatchesCorpus("omni_2022"))
+	assert.False(t, group.MatchesCorpus("brown"))
+
+	doc := MidKwords{ID: "kw1", Corpora: []string{"syn2020"}}
+	idoc := doc.AsIndexableDoc(0).(*Kwords)
+	assert.Equal(t, "kwords", idoc.Type())
+	assert.Equal(t, "syn2020", idoc.PrimaryCorpus())
+	idoc.SetAnalyzerGroup(group.Name)
+	assert.Equal(t, "kwords@cs", idoc.Type())
+}
+
+func TestCreateMappingWithAnalyzerGroups(t *testing.T) {
+	m, err := CreateMapping([]string{}, AnalyzerGroup{Name: "en", TokenFilters: []string{"stemmer_en_snowball"}})
+	assert.NoError(t, err)
+	assert.NotNil(t, m.AnalyzerNamed(GroupQueryAnalyzer("en")))
+	tokens := m.AnalyzerNamed(GroupQueryAnalyzer("en")).Analyze([]byte("Running dogs"))
+	assert.Len(t, tokens, 2)
+	assert.Equal(t, "run", string(tokens[0].Term))
+	assert.Equal(t, "dog", string(tokens[1].Term))
+}
+
+func TestParseCreated(t *testing.T) {
+	expected := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, v := range []string{"2024-05-01T10:00:00Z", "2024-05-01T12:00:00+02:00", "2024-05-01T10:00:00"} {
+		ans, err := ParseCreated(v)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, ans, v)
+	}
+	ans, err := ParseCreated("2024-05-01")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), ans)
+	_, err = ParseCreated("01.05.2024")
+	assert.Error(t, err)
+	assert.Equal(t, time.UTC, CreatedTime(expected.Unix()).Location())
+}
+
+func TestReduceDoc(t *testing.T) {
+	doc := &Concordance{
+		ID:               "c1",
+		RawQuery:         "[word=\"dům\"]",
+		StructAttrNames:  "doc.title",
+		StructAttrValues: strings.Repeat("title ", 100),
+		StructAttrPairs:  []string{"doc.title=a", "doc.title=b"},
+		PosAttrValues:    "dům",
+	}
+	limit := EstimateSize(doc) - 100
+	removed := ReduceDoc(doc, limit)
+	assert.Equal(t, []string{"struct_attr_values"}, removed)
+	assert.Empty(t, doc.StructAttrValues)
+	assert.Len(t, doc.StructAttrPairs, 2)
+	assert.LessOrEqual(t, EstimateSize(doc), limit)
+
+	// basic properties are never removed
+	removed = ReduceDoc(doc, 10)
+	assert.ElementsMatch(t, []string{"struct_attr_pairs", "struct_attr_names", "pos_attr_values"}, removed)
+	assert.Equal(t, "c1", doc.ID)
+	assert.Equal(t, "[word=\"dům\"]", doc.RawQuery)
+}
@@ -35,17 +35,35 @@ type Kwords struct {
 
 	UserID string `json:"user_id"`
 
+	UserIDNum int `json:"user_id_num"`
+
 	Corpora string `json:"corpora"`
 
+	CorporaExact []string `json:"corpora_exact"`
+
 	Subcorpus string `json:"subcorpus"`
 
+	SubcorpusID []string `json:"subcorpus_id"`
+
 	RawQuery string `json:"raw_query"`
 
 	PosAttrNames string `json:"pos_attr_names"`
+
+	// AnalyzerGroup is not indexed, it only selects
+	// the type mapping (see AnalyzerGroup)
+	AnalyzerGroup string `json:"-"`
 }
 
 func (kw *Kwords) Type() string {
-	return "kwords"
+	return GroupedType("kwords", kw.AnalyzerGroup)
+}
+
+func (kw *Kwords) PrimaryCorpus() string {
+	return primaryCorpus(kw.CorporaExact)
+}
+
+func (kw *Kwords) SetAnalyzerGroup(group string) {
+	kw.AnalyzerGroup = group
 }
 
 func (kw *Kwords) GetID() string {
@@ -69,6 +87,9 @@ type MidKwords struct {
 
 	Subcorpora []string `json:"subcorpora"`
 
+	// SubcorpusIDs contains identifiers (hashes) of Subcorpora
+	SubcorpusIDs []string `json:"subcorpusIds"`
+
 	RawQuery string `json:"rawQuery"`
 
 	PosAttrNames []string `json:"posAttrNames"`
@@ -82,16 +103,19 @@ func (mkw *MidKwords) GetQuerySupertype() cncdb.QuerySupertype {
 	return mkw.QuerySupertype
 }
 
-func (mkw *MidKwords) AsIndexableDoc() IndexableDoc {
+func (mkw *MidKwords) AsIndexableDoc(maxRawQueryLen int) IndexableDoc {
 	return &Kwords{
 		ID:             mkw.ID,
 		Name:           mkw.Name,
 		Created:        mkw.Created,
 		QuerySupertype: string(mkw.QuerySupertype),
 		UserID:         strconv.Itoa(mkw.UserID),
+		UserIDNum:      mkw.UserID,
 		Corpora:        strings.Join(mkw.Corpora, " "),
+		CorporaExact:   mkw.Corpora,
 		Subcorpus:      strings.Join(mkw.Subcorpora, " "),
-		RawQuery:       mkw.RawQuery,
+		SubcorpusID:    mkw.SubcorpusIDs,
+		RawQuery:       truncateRawQuery(mkw.ID, mkw.RawQuery, maxRawQueryLen),
 		PosAttrNames:   strings.Join(mkw.PosAttrNames, " "),
 	}
 }
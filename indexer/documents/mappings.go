@@ -20,27 +20,61 @@ package documents
 import (
 	"camus/indexer/lotokenizer"
 	"fmt"
+	"strings"
 
 	"github.com/blevesearch/bleve/v2"
 	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/datetime/flexible"
 	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/token/stop"
 	"github.com/blevesearch/bleve/v2/analysis/tokenizer/whitespace"
+	"github.com/blevesearch/bleve/v2/analysis/tokenmap"
 	"github.com/blevesearch/bleve/v2/mapping"
 )
 
-func CreateMapping() (mapping.IndexMapping, error) {
+// CreateMapping creates the index mapping. Tokens listed in labelStopWords
+// are removed from label fields. For each of the analyzerGroups, a separate
+// set of document types with the group's query analyzer is created.
+func CreateMapping(labelStopWords []string, analyzerGroups ...AnalyzerGroup) (mapping.IndexMapping, error) {
 
 	// whole index
 	indexMapping := bleve.NewIndexMapping()
 
+	labelFilters := []string{lowercase.Name}
+	if len(labelStopWords) > 0 {
+		tokens := make([]interface{}, len(labelStopWords))
+		for i, w := range labelStopWords {
+			tokens[i] = strings.ToLower(w)
+		}
+		err := indexMapping.AddCustomTokenMap(
+			"kontext_label_stop_words",
+			map[string]interface{}{
+				"type":   tokenmap.Name,
+				"tokens": tokens,
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize fulltext mappings: %w", err)
+		}
+		err = indexMapping.AddCustomTokenFilter(
+			"kontext_label_stop_filter",
+			map[string]interface{}{
+				"type":           stop.Name,
+				"stop_token_map": "kontext_label_stop_words",
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize fulltext mappings: %w", err)
+		}
+		labelFilters = append(labelFilters, "kontext_label_stop_filter")
+	}
+
 	err := indexMapping.AddCustomAnalyzer(
-		"kontext_label_analyzer",
+		LabelAnalyzer,
 		map[string]interface{}{
-			"type":      custom.Name,
-			"tokenizer": lotokenizer.Name,
-			"token_filters": []string{
-				lowercase.Name,
-			},
+			"type":          custom.Name,
+			"tokenizer":     lotokenizer.Name,
+			"token_filters": labelFilters,
 		},
 	)
 	if err != nil {
@@ -48,7 +82,7 @@ func CreateMapping() (mapping.IndexMapping, error) {
 	}
 
 	err = indexMapping.AddCustomAnalyzer(
-		"kontext_query_analyzer",
+		QueryAnalyzer,
 		map[string]interface{}{
 			"type":      custom.Name,
 			"tokenizer": whitespace.Name,
@@ -61,79 +95,171 @@ func CreateMapping() (mapping.IndexMapping, error) {
 		return nil, fmt.Errorf("failed to initialize fulltext mappings: %w", err)
 	}
 
-	indexMapping.DefaultAnalyzer = "kontext_query_analyzer"
+	indexMapping.DefaultAnalyzer = QueryAnalyzer
+
+	layouts := make([]interface{}, len(CreatedLayouts))
+	for i, layout := range CreatedLayouts {
+		layouts[i] = layout
+	}
+	err = indexMapping.AddCustomDateTimeParser(
+		CreatedDateTimeParser,
+		map[string]interface{}{
+			"type":    flexible.Name,
+			"layouts": layouts,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize fulltext mappings: %w", err)
+	}
 
 	// field types
 	exactStringMapping := bleve.NewKeywordFieldMapping()
 	queryMultiValMapping := bleve.NewTextFieldMapping()
-	queryMultiValMapping.Analyzer = "kontext_query_analyzer"
+	queryMultiValMapping.Analyzer = QueryAnalyzer
 	labelMultiValMapping := bleve.NewTextFieldMapping()
-	labelMultiValMapping.Analyzer = "kontext_label_analyzer"
+	labelMultiValMapping.Analyzer = LabelAnalyzer
 	dtMapping := bleve.NewDateTimeFieldMapping()
+	dtMapping.DateFormat = CreatedDateTimeParser
+	// user_id_num is a numeric variant of user_id allowing range queries
+	// (user_id itself is kept as a keyword for exact matching)
+	numMapping := bleve.NewNumericFieldMapping()
+
+	boolMapping := bleve.NewBooleanFieldMapping()
+
+	ft := fieldTypes{
+		exact:    exactStringMapping,
+		query:    queryMultiValMapping,
+		label:    labelMultiValMapping,
+		datetime: dtMapping,
+		num:      numMapping,
+		boolean:  boolMapping,
+	}
+	addDocumentMappings(indexMapping, "", ft)
 
+	for _, group := range analyzerGroups {
+		analyzer := GroupQueryAnalyzer(group.Name)
+		err := indexMapping.AddCustomAnalyzer(
+			analyzer,
+			map[string]interface{}{
+				"type":          custom.Name,
+				"tokenizer":     whitespace.Name,
+				"token_filters": append([]string{lowercase.Name}, group.TokenFilters...),
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize mappings of analyzer group %s: %w", group.Name, err)
+		}
+		groupQueryMapping := bleve.NewTextFieldMapping()
+		groupQueryMapping.Analyzer = analyzer
+		groupFt := ft
+		groupFt.query = groupQueryMapping
+		addDocumentMappings(indexMapping, group.Name, groupFt)
+	}
+
+	return indexMapping, nil
+}
+
+// fieldTypes contains field mappings shared by all the document types
+type fieldTypes struct {
+	exact    *mapping.FieldMapping
+	query    *mapping.FieldMapping
+	label    *mapping.FieldMapping
+	datetime *mapping.FieldMapping
+	num      *mapping.FieldMapping
+	boolean  *mapping.FieldMapping
+}
+
+// addDocumentMappings adds mappings of all the document types
+// for an analyzer group (empty group means default mappings)
+func addDocumentMappings(indexMapping *mapping.IndexMappingImpl, group string, ft fieldTypes) {
 	// conc type
 	concMapping := bleve.NewDocumentMapping()
-	concMapping.AddFieldMappingsAt("id", exactStringMapping)
-	concMapping.AddFieldMappingsAt("query_supertype", exactStringMapping)
-	concMapping.AddFieldMappingsAt("created", dtMapping)
-	concMapping.AddFieldMappingsAt("user_id", exactStringMapping)
-	concMapping.AddFieldMappingsAt("is_simple_query", exactStringMapping)
-	concMapping.AddFieldMappingsAt("corpora", labelMultiValMapping)
-	concMapping.AddFieldMappingsAt("subcorpus", labelMultiValMapping)
-	concMapping.AddFieldMappingsAt("raw_query", queryMultiValMapping)
-	concMapping.AddFieldMappingsAt("structures", labelMultiValMapping)
-	concMapping.AddFieldMappingsAt("struct_attr_names", labelMultiValMapping)
-	concMapping.AddFieldMappingsAt("struct_attr_values", labelMultiValMapping)
-	concMapping.AddFieldMappingsAt("pos_attr_names", labelMultiValMapping)
-	concMapping.AddFieldMappingsAt("pos_attr_values", queryMultiValMapping)
-
-	indexMapping.AddDocumentMapping("conc", concMapping)
+	concMapping.AddFieldMappingsAt("id", ft.exact)
+	concMapping.AddFieldMappingsAt("query_supertype", ft.exact)
+	concMapping.AddFieldMappingsAt("created", ft.datetime)
+	concMapping.AddFieldMappingsAt("user_id", ft.exact)
+	concMapping.AddFieldMappingsAt("user_id_num", ft.num)
+	concMapping.AddFieldMappingsAt("is_simple_query", ft.exact)
+	concMapping.AddFieldMappingsAt("has_filters", ft.boolean)
+	concMapping.AddFieldMappingsAt("is_slow", ft.boolean)
+	concMapping.AddFieldMappingsAt("cql_pending", ft.boolean)
+	concMapping.AddFieldMappingsAt("use_regexp", ft.boolean)
+	concMapping.AddFieldMappingsAt("default_attr", ft.label)
+	concMapping.AddFieldMappingsAt("corpora", ft.label)
+	concMapping.AddFieldMappingsAt("corpora_exact", ft.exact)
+	concMapping.AddFieldMappingsAt("subcorpus", ft.label)
+	concMapping.AddFieldMappingsAt("subcorpus_id", ft.exact)
+	concMapping.AddFieldMappingsAt("raw_query", ft.query)
+	concMapping.AddFieldMappingsAt("raw_query_by_corpus", ft.query)
+	concMapping.AddFieldMappingsAt("structures", ft.label)
+	concMapping.AddFieldMappingsAt("struct_attr_names", ft.label)
+	concMapping.AddFieldMappingsAt("struct_attr_values", ft.label)
+	concMapping.AddFieldMappingsAt("struct_attr_pairs", ft.exact)
+	concMapping.AddFieldMappingsAt("pos_attr_names", ft.label)
+	concMapping.AddFieldMappingsAt("pos_attr_values", ft.query)
+
+	indexMapping.AddDocumentMapping(GroupedType("conc", group), concMapping)
 
 	// wlist type
 
 	wlistMapping := bleve.NewDocumentMapping()
-	wlistMapping.AddFieldMappingsAt("id", exactStringMapping)
-	wlistMapping.AddFieldMappingsAt("query_supertype", exactStringMapping)
-	wlistMapping.AddFieldMappingsAt("created", dtMapping)
-	wlistMapping.AddFieldMappingsAt("user_id", exactStringMapping)
-	wlistMapping.AddFieldMappingsAt("corpora", labelMultiValMapping)
-	wlistMapping.AddFieldMappingsAt("subcorpus", labelMultiValMapping)
-	wlistMapping.AddFieldMappingsAt("raw_query", queryMultiValMapping)
-	wlistMapping.AddFieldMappingsAt("pos_attr_names", labelMultiValMapping)
-	wlistMapping.AddFieldMappingsAt("pfilter_words", queryMultiValMapping)
-	wlistMapping.AddFieldMappingsAt("nfilter_words", queryMultiValMapping)
-
-	indexMapping.AddDocumentMapping("wlist", wlistMapping)
+	wlistMapping.AddFieldMappingsAt("id", ft.exact)
+	wlistMapping.AddFieldMappingsAt("query_supertype", ft.exact)
+	wlistMapping.AddFieldMappingsAt("created", ft.datetime)
+	wlistMapping.AddFieldMappingsAt("user_id", ft.exact)
+	wlistMapping.AddFieldMappingsAt("user_id_num", ft.num)
+	wlistMapping.AddFieldMappingsAt("corpora", ft.label)
+	wlistMapping.AddFieldMappingsAt("corpora_exact", ft.exact)
+	wlistMapping.AddFieldMappingsAt("subcorpus", ft.label)
+	wlistMapping.AddFieldMappingsAt("subcorpus_id", ft.exact)
+	wlistMapping.AddFieldMappingsAt("raw_query", ft.query)
+	wlistMapping.AddFieldMappingsAt("pos_attr_names", ft.label)
+	wlistMapping.AddFieldMappingsAt("pfilter_words", ft.query)
+	wlistMapping.AddFieldMappingsAt("nfilter_words", ft.query)
+	wlistMapping.AddFieldMappingsAt("pfilter_count", ft.num)
+	wlistMapping.AddFieldMappingsAt("nfilter_count", ft.num)
+
+	indexMapping.AddDocumentMapping(GroupedType("wlist", group), wlistMapping)
 
 	// kwords type
 	kwordsMapping := bleve.NewDocumentMapping()
-	kwordsMapping.AddFieldMappingsAt("id", exactStringMapping)
-	kwordsMapping.AddFieldMappingsAt("query_supertype", exactStringMapping)
-	kwordsMapping.AddFieldMappingsAt("created", dtMapping)
-	kwordsMapping.AddFieldMappingsAt("user_id", exactStringMapping)
-	kwordsMapping.AddFieldMappingsAt("corpora", labelMultiValMapping)
-	kwordsMapping.AddFieldMappingsAt("subcorpus", labelMultiValMapping)
-	kwordsMapping.AddFieldMappingsAt("raw_query", queryMultiValMapping)
-	kwordsMapping.AddFieldMappingsAt("pos_attr_names", labelMultiValMapping)
+	kwordsMapping.AddFieldMappingsAt("id", ft.exact)
+	kwordsMapping.AddFieldMappingsAt("query_supertype", ft.exact)
+	kwordsMapping.AddFieldMappingsAt("created", ft.datetime)
+	kwordsMapping.AddFieldMappingsAt("user_id", ft.exact)
+	kwordsMapping.AddFieldMappingsAt("user_id_num", ft.num)
+	kwordsMapping.AddFieldMappingsAt("corpora", ft.label)
+	kwordsMapping.AddFieldMappingsAt("corpora_exact", ft.exact)
+	kwordsMapping.AddFieldMappingsAt("subcorpus", ft.label)
+	kwordsMapping.AddFieldMappingsAt("subcorpus_id", ft.exact)
+	kwordsMapping.AddFieldMappingsAt("raw_query", ft.query)
+	kwordsMapping.AddFieldMappingsAt("pos_attr_names", ft.label)
 
-	indexMapping.AddDocumentMapping("kwords", kwordsMapping)
+	indexMapping.AddDocumentMapping(GroupedType("kwords", group), kwordsMapping)
 
 	// pquery type
 	pqueryMapping := bleve.NewDocumentMapping()
-	pqueryMapping.AddFieldMappingsAt("id", exactStringMapping)
-	pqueryMapping.AddFieldMappingsAt("query_supertype", exactStringMapping)
-	pqueryMapping.AddFieldMappingsAt("created", dtMapping)
-	pqueryMapping.AddFieldMappingsAt("user_id", exactStringMapping)
-	pqueryMapping.AddFieldMappingsAt("corpora", labelMultiValMapping)
-	pqueryMapping.AddFieldMappingsAt("subcorpus", labelMultiValMapping)
-	pqueryMapping.AddFieldMappingsAt("raw_query", queryMultiValMapping)
-	pqueryMapping.AddFieldMappingsAt("structures", labelMultiValMapping)
-	pqueryMapping.AddFieldMappingsAt("struct_attr_names", labelMultiValMapping)
-	pqueryMapping.AddFieldMappingsAt("struct_attr_values", queryMultiValMapping)
-	pqueryMapping.AddFieldMappingsAt("pos_attr_names", labelMultiValMapping)
-	pqueryMapping.AddFieldMappingsAt("pos_attr_values", queryMultiValMapping)
-
-	indexMapping.AddDocumentMapping("pquery", pqueryMapping)
+	pqueryMapping.AddFieldMappingsAt("id", ft.exact)
+	pqueryMapping.AddFieldMappingsAt("query_supertype", ft.exact)
+	pqueryMapping.AddFieldMappingsAt("created", ft.datetime)
+	pqueryMapping.AddFieldMappingsAt("user_id", ft.exact)
+	pqueryMapping.AddFieldMappingsAt("user_id_num", ft.num)
+	pqueryMapping.AddFieldMappingsAt("corpora", ft.label)
+	pqueryMapping.AddFieldMappingsAt("corpora_exact", ft.exact)
+	pqueryMapping.AddFieldMappingsAt("subcorpus", ft.label)
+	pqueryMapping.AddFieldMappingsAt("subcorpus_id", ft.exact)
+	pqueryMapping.AddFieldMappingsAt("raw_query", ft.query)
+	pqueryMapping.AddFieldMappingsAt("structures", ft.label)
+	pqueryMapping.AddFieldMappingsAt("struct_attr_names", ft.label)
+	pqueryMapping.AddFieldMappingsAt("struct_attr_values", ft.query)
+	pqueryMapping.AddFieldMappingsAt("pos_attr_names", ft.label)
+	pqueryMapping.AddFieldMappingsAt("pos_attr_values", ft.query)
+	pqueryMapping.AddFieldMappingsAt("min_fq", ft.num)
+	pqueryMapping.AddFieldMappingsAt("max_fq", ft.num)
+	pqueryMapping.AddFieldMappingsAt("min_rel_fq", ft.num)
+	pqueryMapping.AddFieldMappingsAt("max_rel_fq", ft.num)
+	pqueryMapping.AddFieldMappingsAt("subconc_truncated", ft.boolean)
+	pqueryMapping.AddFieldMappingsAt("cql_pending", ft.boolean)
 
-	return indexMapping, nil
+	indexMapping.AddDocumentMapping(GroupedType("pquery", group), pqueryMapping)
 }
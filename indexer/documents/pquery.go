@@ -35,10 +35,16 @@ type PQuery struct {
 
 	UserID string `json:"user_id"`
 
+	UserIDNum int `json:"user_id_num"`
+
 	Corpora string `json:"corpora"`
 
+	CorporaExact []string `json:"corpora_exact"`
+
 	Subcorpus string `json:"subcorpus"`
 
+	SubcorpusID string `json:"subcorpus_id"`
+
 	RawQuery string `json:"raw_query"`
 
 	Structures string `json:"structures"`
@@ -50,10 +56,34 @@ type PQuery struct {
 	PosAttrNames string `json:"pos_attr_names"`
 
 	PosAttrValues string `json:"pos_attr_values"`
+
+	MinFreq *int `json:"min_fq,omitempty"`
+
+	MaxFreq *int `json:"max_fq,omitempty"`
+
+	MinRelFreq *float64 `json:"min_rel_fq,omitempty"`
+
+	MaxRelFreq *float64 `json:"max_rel_fq,omitempty"`
+
+	SubconcTruncated bool `json:"subconc_truncated"`
+
+	CQLPending bool `json:"cql_pending"`
+
+	// AnalyzerGroup is not indexed, it only selects
+	// the type mapping (see AnalyzerGroup)
+	AnalyzerGroup string `json:"-"`
 }
 
 func (pq *PQuery) Type() string {
-	return "pquery"
+	return GroupedType("pquery", pq.AnalyzerGroup)
+}
+
+func (pq *PQuery) PrimaryCorpus() string {
+	return primaryCorpus(pq.CorporaExact)
+}
+
+func (pq *PQuery) SetAnalyzerGroup(group string) {
+	pq.AnalyzerGroup = group
 }
 
 func (pq *PQuery) GetID() string {
@@ -79,6 +109,8 @@ type MidPQuery struct {
 
 	Subcorpus string `json:"subcorpus"`
 
+	SubcorpusID string `json:"subcorpusId"`
+
 	// RawQuery is the original query written by a user
 	// (multiple queries = aligned corpora)
 	RawQueries []cncdb.RawQuery `json:"rawQueries"`
@@ -96,6 +128,22 @@ type MidPQuery struct {
 	// PosAttrs contains all the positional attributes and their values
 	// in the query.
 	PosAttrs map[string][]string `json:"posAttrs"`
+
+	// MinFreq, MaxFreq, MinRelFreq and MaxRelFreq are frequency
+	// constraints of the query. Nil means the constraint is not
+	// set (or it is not available in older records).
+	MinFreq    *int     `json:"minFreq"`
+	MaxFreq    *int     `json:"maxFreq"`
+	MinRelFreq *float64 `json:"minRelFreq"`
+	MaxRelFreq *float64 `json:"maxRelFreq"`
+
+	// SubconcTruncated specifies whether only some of the pquery's
+	// concordances have been imported (see Conf.PqueryMaxSubconc)
+	SubconcTruncated bool `json:"subconcTruncated"`
+
+	// CQLPending specifies that the CQL queries of the concordances
+	// have not been parsed (see MidConc.CQLPending)
+	CQLPending bool `json:"cqlPending"`
 }
 
 func (doc *MidPQuery) AddStructAttr(name, value string) {
@@ -141,7 +189,7 @@ func (doc *MidPQuery) getRawQueriesAsString() string {
 	return ans.String()
 }
 
-func (doc *MidPQuery) AsIndexableDoc() IndexableDoc {
+func (doc *MidPQuery) AsIndexableDoc(maxRawQueryLen int) IndexableDoc {
 	posAttrNames := make([]string, 0, 5)
 	posAttrValues := make([]string, 0, 5)
 	for name, values := range doc.PosAttrs {
@@ -161,12 +209,22 @@ func (doc *MidPQuery) AsIndexableDoc() IndexableDoc {
 		QuerySupertype:   string(doc.QuerySupertype),
 		Created:          doc.Created,
 		UserID:           strconv.Itoa(doc.UserID),
+		UserIDNum:        doc.UserID,
 		Corpora:          strings.Join(doc.Corpora, " "),
-		RawQuery:         doc.getRawQueriesAsString(),
+		CorporaExact:     doc.Corpora,
+		Subcorpus:        doc.Subcorpus,
+		SubcorpusID:      doc.SubcorpusID,
+		RawQuery:         truncateRawQuery(doc.ID, doc.getRawQueriesAsString(), maxRawQueryLen),
 		Structures:       strings.Join(doc.Structures, " "),
 		PosAttrNames:     strings.Join(posAttrNames, " "),
 		PosAttrValues:    strings.Join(posAttrValues, " "),
 		StructAttrNames:  strings.Join(structAttrNames, " "),
 		StructAttrValues: strings.Join(structAttrValues, " "),
+		MinFreq:          doc.MinFreq,
+		MaxFreq:          doc.MaxFreq,
+		MinRelFreq:       doc.MinRelFreq,
+		MaxRelFreq:       doc.MaxRelFreq,
+		SubconcTruncated: doc.SubconcTruncated,
+		CQLPending:       doc.CQLPending,
 	}
 }
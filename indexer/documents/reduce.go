@@ -0,0 +1,92 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package documents
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// ReducibleFields are multi-valued fields (lists or whitespace
+// separated values) which may be removed from an oversized document.
+// The document remains searchable by its basic properties (corpora,
+// user, raw query etc.), only the detailed attribute search is lost.
+var ReducibleFields = []string{
+	"structures",
+	"struct_attr_names",
+	"struct_attr_values",
+	"struct_attr_pairs",
+	"pos_attr_names",
+	"pos_attr_values",
+	"raw_query_by_corpus",
+	"pfilter_words",
+	"nfilter_words",
+}
+
+// EstimateSize returns the size of the JSON-serialized document
+// which is a reasonable approximation of how heavy the document
+// is for the index.
+func EstimateSize(doc IndexableDoc) int {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return name
+}
+
+// ReduceDoc removes the heaviest of ReducibleFields from the document
+// (one by one) until its estimated size is within maxBytes or there is
+// nothing more to remove. The document is modified in place. Names of
+// the removed fields are returned.
+func ReduceDoc(doc IndexableDoc, maxBytes int) []string {
+	ans := make([]string, 0, 3)
+	v := reflect.ValueOf(doc)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return ans
+	}
+	v = v.Elem()
+	for EstimateSize(doc) > maxBytes {
+		heaviest := -1
+		heaviestSize := 0
+		for i := 0; i < v.NumField(); i++ {
+			if !slices.Contains(ReducibleFields, jsonFieldName(v.Type().Field(i))) || v.Field(i).IsZero() {
+				continue
+			}
+			data, err := json.Marshal(v.Field(i).Interface())
+			if err != nil {
+				continue
+			}
+			if len(data) > heaviestSize {
+				heaviest = i
+				heaviestSize = len(data)
+			}
+		}
+		if heaviest < 0 {
+			break
+		}
+		v.Field(heaviest).SetZero()
+		ans = append(ans, jsonFieldName(v.Type().Field(heaviest)))
+	}
+	return ans
+}
@@ -35,10 +35,16 @@ type Wordlist struct {
 
 	UserID string `json:"user_id"`
 
+	UserIDNum int `json:"user_id_num"`
+
 	Corpora string `json:"corpora"`
 
+	CorporaExact []string `json:"corpora_exact"`
+
 	Subcorpus string `json:"subcorpus"`
 
+	SubcorpusID string `json:"subcorpus_id"`
+
 	RawQuery string `json:"raw_query"`
 
 	PosAttrNames string `json:"pos_attr_names"`
@@ -46,10 +52,26 @@ type Wordlist struct {
 	PFilterWords string `json:"pfilter_words"`
 
 	NFilterWords string `json:"nfilter_words"`
+
+	PFilterCount int `json:"pfilter_count"`
+
+	NFilterCount int `json:"nfilter_count"`
+
+	// AnalyzerGroup is not indexed, it only selects
+	// the type mapping (see AnalyzerGroup)
+	AnalyzerGroup string `json:"-"`
 }
 
 func (wlist *Wordlist) Type() string {
-	return "wlist"
+	return GroupedType("wlist", wlist.AnalyzerGroup)
+}
+
+func (wlist *Wordlist) PrimaryCorpus() string {
+	return primaryCorpus(wlist.CorporaExact)
+}
+
+func (wlist *Wordlist) SetAnalyzerGroup(group string) {
+	wlist.AnalyzerGroup = group
 }
 
 func (wlist *Wordlist) GetID() string {
@@ -76,6 +98,8 @@ type MidWordlist struct {
 
 	Subcorpus string `json:"subcorpus"`
 
+	SubcorpusID string `json:"subcorpusId"`
+
 	RawQuery string `json:"rawQuery"`
 
 	PosAttrNames []string `json:"posAttrNames"`
@@ -93,18 +117,38 @@ func (mwl *MidWordlist) GetQuerySupertype() cncdb.QuerySupertype {
 	return mwl.QuerySupertype
 }
 
-func (mwl *MidWordlist) AsIndexableDoc() IndexableDoc {
+// normalizeFilterWords trims and lowercases filter words (in the same
+// way the query analyzer does) and removes empty items.
+func normalizeFilterWords(words []string) []string {
+	ans := make([]string, 0, len(words))
+	for _, w := range words {
+		w = strings.ToLower(strings.TrimSpace(w))
+		if w != "" {
+			ans = append(ans, w)
+		}
+	}
+	return ans
+}
+
+func (mwl *MidWordlist) AsIndexableDoc(maxRawQueryLen int) IndexableDoc {
+	pfWords := normalizeFilterWords(mwl.PFilterWords)
+	nfWords := normalizeFilterWords(mwl.NFilterWords)
 	return &Wordlist{
 		ID:             mwl.ID,
 		Name:           mwl.Name,
 		Created:        mwl.Created,
 		QuerySupertype: string(mwl.QuerySupertype),
 		UserID:         strconv.Itoa(mwl.UserID),
+		UserIDNum:      mwl.UserID,
 		Corpora:        strings.Join(mwl.Corpora, " "),
+		CorporaExact:   mwl.Corpora,
 		Subcorpus:      mwl.Subcorpus,
-		RawQuery:       mwl.RawQuery,
+		SubcorpusID:    mwl.SubcorpusID,
+		RawQuery:       truncateRawQuery(mwl.ID, mwl.RawQuery, maxRawQueryLen),
 		PosAttrNames:   strings.Join(mwl.PosAttrNames, " "),
-		PFilterWords:   strings.Join(mwl.PFilterWords, " "),
-		NFilterWords:   strings.Join(mwl.NFilterWords, " "),
+		PFilterWords:   truncateRawQuery(mwl.ID, strings.Join(pfWords, " "), maxRawQueryLen),
+		NFilterWords:   truncateRawQuery(mwl.ID, strings.Join(nfWords, " "), maxRawQueryLen),
+		PFilterCount:   len(pfWords),
+		NFilterCount:   len(nfWords),
 	}
 }
@@ -0,0 +1,642 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"camus/indexer/documents"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dfltESIndexName          = "camus_query_history"
+	dfltESRequestTimeoutSecs = 10
+
+	// esLabelTokenizerPattern mimics lotokenizer (i.e. it splits
+	// on whitespace and the same set of stop characters)
+	esLabelTokenizerPattern = `[\s:;,#?!.%$@()*\[\]"'~/|+=\-_^&><]+`
+)
+
+var (
+	// ErrUnsupportedByBackend is returned for search requests which
+	// cannot be translated for the configured backend
+	ErrUnsupportedByBackend = errors.New("operation not supported by the index backend")
+)
+
+// ElasticConf configures the Elasticsearch backend
+// (see Conf.Backend)
+type ElasticConf struct {
+	Addresses []string `json:"addresses"`
+	Username  string   `json:"username"`
+	Password  string   `json:"password"`
+	APIKey    string   `json:"apiKey"`
+
+	// IndexName is the name of the Elasticsearch index. In case
+	// the index does not exist, it is created with a mapping derived
+	// from the Bleve one.
+	IndexName string `json:"indexName"`
+
+	// RefreshOnWrite makes each write wait for the index refresh
+	// so the written documents are searchable immediately (as it
+	// is with Bleve). This slows down indexing considerably.
+	RefreshOnWrite bool `json:"refreshOnWrite"`
+
+	RequestTimeoutSecs int `json:"requestTimeoutSecs"`
+}
+
+func (conf *ElasticConf) RequestTimeout() time.Duration {
+	return time.Duration(conf.RequestTimeoutSecs) * time.Second
+}
+
+func (conf *ElasticConf) ValidateAndDefaults() error {
+	if conf == nil {
+		return fmt.Errorf("missing `elasticsearch` section")
+	}
+	if len(conf.Addresses) == 0 {
+		return fmt.Errorf("missing `elasticsearch.addresses`")
+	}
+	if conf.IndexName == "" {
+		conf.IndexName = dfltESIndexName
+		log.Warn().
+			Str("value", dfltESIndexName).
+			Msg("indexer configuration `elasticsearch.indexName` not specified, using default")
+	}
+	if conf.RequestTimeoutSecs < 0 {
+		return fmt.Errorf("elasticsearch.requestTimeoutSecs must be >= 0")
+	}
+	if conf.RequestTimeoutSecs == 0 {
+		conf.RequestTimeoutSecs = dfltESRequestTimeoutSecs
+		log.Warn().
+			Int("value", dfltESRequestTimeoutSecs).
+			Msg("indexer configuration `elasticsearch.requestTimeoutSecs` not specified, using default")
+	}
+	return nil
+}
+
+// ---------------------
+
+type esBackend struct {
+	conf   *ElasticConf
+	client *elasticsearch.Client
+}
+
+func (eb *esBackend) ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), eb.conf.RequestTimeout())
+}
+
+func (eb *esBackend) refresh() string {
+	if eb.conf.RefreshOnWrite {
+		return "wait_for"
+	}
+	return "false"
+}
+
+// esResponseError creates an error from a failed response
+// (the response body is expected to be still unread)
+func esResponseError(op string, res *esapi.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+	return fmt.Errorf("elasticsearch %s failed with status %d: %s", op, res.StatusCode, body)
+}
+
+func (eb *esBackend) Index(id string, doc documents.IndexableDoc) error {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode document %s: %w", id, err)
+	}
+	ctx, cancel := eb.ctx()
+	defer cancel()
+	res, err := eb.client.Index(
+		eb.conf.IndexName,
+		bytes.NewReader(body),
+		eb.client.Index.WithDocumentID(id),
+		eb.client.Index.WithRefresh(eb.refresh()),
+		eb.client.Index.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to index document %s: %w", id, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return esResponseError("indexing", res)
+	}
+	return nil
+}
+
+// Delete removes a document. Similarly to Bleve, deleting a missing
+// document is not considered an error.
+func (eb *esBackend) Delete(id string) error {
+	ctx, cancel := eb.ctx()
+	defer cancel()
+	res, err := eb.client.Delete(
+		eb.conf.IndexName,
+		id,
+		eb.client.Delete.WithRefresh(eb.refresh()),
+		eb.client.Delete.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete document %s: %w", id, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() && res.StatusCode != http.StatusNotFound {
+		return esResponseError("deletion", res)
+	}
+	return nil
+}
+
+func (eb *esBackend) Search(req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	esReq, err := searchRequestToES(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(esReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode search request: %w", err)
+	}
+	ctx, cancel := eb.ctx()
+	defer cancel()
+	t0 := time.Now()
+	res, err := eb.client.Search(
+		eb.client.Search.WithIndex(eb.conf.IndexName),
+		eb.client.Search.WithBody(bytes.NewReader(body)),
+		eb.client.Search.WithContext(ctx),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, esResponseError("search", res)
+	}
+	var resp esSearchResponse
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode search response: %w", err)
+	}
+	return esResponseToResult(req, &resp, time.Since(t0)), nil
+}
+
+func (eb *esBackend) DocCount() (uint64, error) {
+	ctx, cancel := eb.ctx()
+	defer cancel()
+	res, err := eb.client.Count(
+		eb.client.Count.WithIndex(eb.conf.IndexName),
+		eb.client.Count.WithContext(ctx),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count documents: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, esResponseError("count", res)
+	}
+	var resp struct {
+		Count uint64 `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return 0, fmt.Errorf("failed to decode count response: %w", err)
+	}
+	return resp.Count, nil
+}
+
+// ensureIndex creates the index in case it does not exist yet
+func (eb *esBackend) ensureIndex(labelStopWords []string) error {
+	ctx, cancel := eb.ctx()
+	defer cancel()
+	res, err := eb.client.Indices.Exists(
+		[]string{eb.conf.IndexName}, eb.client.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to test index existence: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+
+	} else if res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to test index existence (status %d)", res.StatusCode)
+	}
+	bleveMapping, err := documents.CreateMapping(labelStopWords)
+	if err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+	body, err := json.Marshal(esIndexDefinition(bleveMapping, labelStopWords))
+	if err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+	res, err = eb.client.Indices.Create(
+		eb.conf.IndexName,
+		eb.client.Indices.Create.WithBody(bytes.NewReader(body)),
+		eb.client.Indices.Create.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return esResponseError("index creation", res)
+	}
+	log.Info().Str("index", eb.conf.IndexName).Msg("created new Elasticsearch index")
+	return nil
+}
+
+func newESBackend(conf *ElasticConf, labelStopWords []string) (*esBackend, error) {
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: conf.Addresses,
+		Username:  conf.Username,
+		Password:  conf.Password,
+		APIKey:    conf.APIKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Elasticsearch client: %w", err)
+	}
+	ans := &esBackend{conf: conf, client: client}
+	if err := ans.ensureIndex(labelStopWords); err != nil {
+		return nil, fmt.Errorf("failed to initialize Elasticsearch backend: %w", err)
+	}
+	return ans, nil
+}
+
+// ---------------------
+
+// esIndexDefinition derives Elasticsearch index settings and mapping
+// from the Bleve mapping. As Elasticsearch does not support different
+// mappings of the same field within an index, the first mapping (in the
+// alphabetical order of document types) of each field is used.
+func esIndexDefinition(bleveMapping mapping.IndexMapping, labelStopWords []string) map[string]any {
+	labelFilters := []string{"lowercase"}
+	filters := map[string]any{}
+	if len(labelStopWords) > 0 {
+		words := make([]string, len(labelStopWords))
+		for i, w := range labelStopWords {
+			words[i] = strings.ToLower(w)
+		}
+		filters["kontext_label_stop_filter"] = map[string]any{"type": "stop", "stopwords": words}
+		labelFilters = append(labelFilters, "kontext_label_stop_filter")
+	}
+	settings := map[string]any{
+		"analysis": map[string]any{
+			"tokenizer": map[string]any{
+				"kontext_label_tokenizer": map[string]any{
+					"type":    "pattern",
+					"pattern": esLabelTokenizerPattern,
+				},
+			},
+			"filter": filters,
+			"analyzer": map[string]any{
+				documents.QueryAnalyzer: map[string]any{
+					"type":      "custom",
+					"tokenizer": "whitespace",
+					"filter":    []string{"lowercase"},
+				},
+				documents.LabelAnalyzer: map[string]any{
+					"type":      "custom",
+					"tokenizer": "kontext_label_tokenizer",
+					"filter":    labelFilters,
+				},
+			},
+		},
+	}
+	props := map[string]any{}
+	impl, ok := bleveMapping.(*mapping.IndexMappingImpl)
+	if ok {
+		docTypes := make([]string, 0, len(impl.TypeMapping))
+		for k := range impl.TypeMapping {
+			docTypes = append(docTypes, k)
+		}
+		sort.Strings(docTypes)
+		for _, dt := range docTypes {
+			for field, fm := range impl.TypeMapping[dt].Properties {
+				if _, ok := props[field]; ok || len(fm.Fields) == 0 {
+					continue
+				}
+				props[field] = esFieldMapping(field, fm.Fields[0])
+			}
+		}
+	}
+	return map[string]any{
+		"settings": settings,
+		"mappings": map[string]any{"properties": props},
+	}
+}
+
+func esFieldMapping(field string, fm *mapping.FieldMapping) map[string]any {
+	switch fm.Type {
+	case "datetime":
+		return map[string]any{"type": "date"}
+	case "number":
+		return map[string]any{"type": "double"}
+	case "boolean":
+		return map[string]any{"type": "boolean"}
+	}
+	if fm.Analyzer == "keyword" {
+		return map[string]any{"type": "keyword"}
+	}
+	ans := map[string]any{"type": "text"}
+	if fm.Analyzer != "" {
+		ans["analyzer"] = fm.Analyzer
+	}
+	if slices.Contains(facetableFields, field) {
+		// aggregations (i.e. facets) of text fields require fielddata
+		ans["fielddata"] = true
+	}
+	return ans
+}
+
+// ---------------------
+
+func esBoost(b *query.Boost, q map[string]any) map[string]any {
+	if b != nil {
+		q["boost"] = b.Value()
+	}
+	return q
+}
+
+func esQueries(queries []query.Query) ([]any, error) {
+	ans := make([]any, 0, len(queries))
+	for _, q := range queries {
+		eq, err := queryToES(q)
+		if err != nil {
+			return nil, err
+		}
+		ans = append(ans, eq)
+	}
+	return ans, nil
+}
+
+// queryToES translates a Bleve query into the Elasticsearch query DSL.
+// Only query types used by Camus are supported.
+func queryToES(q query.Query) (map[string]any, error) {
+	switch tq := q.(type) {
+	case *query.MatchAllQuery:
+		return map[string]any{"match_all": esBoost(tq.BoostVal, map[string]any{})}, nil
+	case *query.TermQuery:
+		return map[string]any{
+			"term": map[string]any{tq.FieldVal: esBoost(tq.BoostVal, map[string]any{"value": tq.Term})},
+		}, nil
+	case *query.MatchQuery:
+		if tq.FieldVal == "" {
+			return map[string]any{"multi_match": esBoost(tq.BoostVal, map[string]any{"query": tq.Match})}, nil
+		}
+		return map[string]any{
+			"match": map[string]any{tq.FieldVal: esBoost(tq.BoostVal, map[string]any{"query": tq.Match})},
+		}, nil
+	case *query.PrefixQuery:
+		return map[string]any{
+			"prefix": map[string]any{tq.FieldVal: esBoost(tq.BoostVal, map[string]any{"value": tq.Prefix})},
+		}, nil
+	case *query.WildcardQuery:
+		return map[string]any{
+			"wildcard": map[string]any{tq.FieldVal: esBoost(tq.BoostVal, map[string]any{"value": tq.Wildcard})},
+		}, nil
+	case *query.BoolFieldQuery:
+		return map[string]any{
+			"term": map[string]any{tq.FieldVal: esBoost(tq.BoostVal, map[string]any{"value": tq.Bool})},
+		}, nil
+	case *query.NumericRangeQuery:
+		rng := map[string]any{}
+		if tq.Min != nil {
+			if tq.InclusiveMin == nil || *tq.InclusiveMin {
+				rng["gte"] = *tq.Min
+
+			} else {
+				rng["gt"] = *tq.Min
+			}
+		}
+		if tq.Max != nil {
+			if tq.InclusiveMax != nil && *tq.InclusiveMax {
+				rng["lte"] = *tq.Max
+
+			} else {
+				rng["lt"] = *tq.Max
+			}
+		}
+		return map[string]any{"range": map[string]any{tq.FieldVal: esBoost(tq.BoostVal, rng)}}, nil
+	case *query.DateRangeQuery:
+		rng := map[string]any{}
+		if !tq.Start.IsZero() {
+			if tq.InclusiveStart == nil || *tq.InclusiveStart {
+				rng["gte"] = tq.Start.UTC().Format(time.RFC3339)
+
+			} else {
+				rng["gt"] = tq.Start.UTC().Format(time.RFC3339)
+			}
+		}
+		if !tq.End.IsZero() {
+			if tq.InclusiveEnd != nil && *tq.InclusiveEnd {
+				rng["lte"] = tq.End.UTC().Format(time.RFC3339)
+
+			} else {
+				rng["lt"] = tq.End.UTC().Format(time.RFC3339)
+			}
+		}
+		return map[string]any{"range": map[string]any{tq.FieldVal: esBoost(tq.BoostVal, rng)}}, nil
+	case *query.QueryStringQuery:
+		return map[string]any{"query_string": esBoost(tq.BoostVal, map[string]any{"query": tq.Query})}, nil
+	case *query.ConjunctionQuery:
+		must, err := esQueries(tq.Conjuncts)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"bool": esBoost(tq.BoostVal, map[string]any{"must": must})}, nil
+	case *query.DisjunctionQuery:
+		should, err := esQueries(tq.Disjuncts)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{
+			"bool": esBoost(
+				tq.BoostVal,
+				map[string]any{"should": should, "minimum_should_match": max(int(tq.Min), 1)},
+			),
+		}, nil
+	case *query.BooleanQuery:
+		return booleanQueryToES(tq)
+	}
+	return nil, fmt.Errorf("%w: query type %T", ErrUnsupportedByBackend, q)
+}
+
+func booleanQueryToES(bq *query.BooleanQuery) (map[string]any, error) {
+	ans := map[string]any{}
+	if must, ok := bq.Must.(*query.ConjunctionQuery); ok && must != nil && len(must.Conjuncts) > 0 {
+		items, err := esQueries(must.Conjuncts)
+		if err != nil {
+			return nil, err
+		}
+		ans["must"] = items
+	}
+	if should, ok := bq.Should.(*query.DisjunctionQuery); ok && should != nil && len(should.Disjuncts) > 0 {
+		items, err := esQueries(should.Disjuncts)
+		if err != nil {
+			return nil, err
+		}
+		ans["should"] = items
+		if should.Min > 0 {
+			ans["minimum_should_match"] = int(should.Min)
+		}
+	}
+	if mustNot, ok := bq.MustNot.(*query.DisjunctionQuery); ok && mustNot != nil && len(mustNot.Disjuncts) > 0 {
+		items, err := esQueries(mustNot.Disjuncts)
+		if err != nil {
+			return nil, err
+		}
+		ans["must_not"] = items
+	}
+	return map[string]any{"bool": esBoost(bq.BoostVal, ans)}, nil
+}
+
+func sortToES(order search.SortOrder) ([]any, error) {
+	ans := make([]any, 0, len(order))
+	for _, item := range order {
+		var field string
+		var desc bool
+		switch so := item.(type) {
+		case *search.SortScore:
+			field, desc = "_score", so.Desc
+		case *search.SortDocID:
+			// Elasticsearch 8 does not allow sorting by `_id`
+			// (use the indexed `id` field instead)
+			return nil, fmt.Errorf("%w: sorting by document ID", ErrUnsupportedByBackend)
+		case *search.SortField:
+			field, desc = so.Field, so.Desc
+		default:
+			return nil, fmt.Errorf("%w: sort type %T", ErrUnsupportedByBackend, item)
+		}
+		dir := "asc"
+		if desc {
+			dir = "desc"
+		}
+		ans = append(ans, map[string]any{field: dir})
+	}
+	return ans, nil
+}
+
+// searchRequestToES translates a Bleve search request into
+// an Elasticsearch search request body
+func searchRequestToES(req *bleve.SearchRequest) (map[string]any, error) {
+	q, err := queryToES(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	ans := map[string]any{
+		"query":            q,
+		"size":             req.Size,
+		"from":             req.From,
+		"track_total_hits": true,
+	}
+	if len(req.Sort) > 0 {
+		srt, err := sortToES(req.Sort)
+		if err != nil {
+			return nil, err
+		}
+		ans["sort"] = srt
+	}
+	if len(req.SearchAfter) > 0 {
+		ans["search_after"] = req.SearchAfter
+	}
+	if len(req.Fields) == 0 {
+		ans["_source"] = false
+
+	} else if !slices.Contains(req.Fields, "*") {
+		ans["_source"] = req.Fields
+	}
+	if len(req.Facets) > 0 {
+		aggs := make(map[string]any)
+		for name, fr := range req.Facets {
+			if fr.Field == "" || len(fr.NumericRanges) > 0 || len(fr.DateTimeRanges) > 0 {
+				return nil, fmt.Errorf("%w: only term facets are supported", ErrUnsupportedByBackend)
+			}
+			aggs[name] = map[string]any{"terms": map[string]any{"field": fr.Field, "size": fr.Size}}
+		}
+		ans["aggs"] = aggs
+	}
+	return ans, nil
+}
+
+type esSearchResponse struct {
+	Hits struct {
+		Total struct {
+			Value uint64 `json:"value"`
+		} `json:"total"`
+		MaxScore *float64 `json:"max_score"`
+		Hits     []struct {
+			ID     string         `json:"_id"`
+			Score  *float64       `json:"_score"`
+			Source map[string]any `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+	Aggregations map[string]struct {
+		SumOtherDocCount int `json:"sum_other_doc_count"`
+		Buckets          []struct {
+			Key      any `json:"key"`
+			DocCount int `json:"doc_count"`
+		} `json:"buckets"`
+	} `json:"aggregations"`
+}
+
+// esResponseToResult converts an Elasticsearch search response
+// into the Bleve result format so callers (and API clients) get
+// the same data regardless of the backend.
+func esResponseToResult(req *bleve.SearchRequest, resp *esSearchResponse, took time.Duration) *bleve.SearchResult {
+	ans := &bleve.SearchResult{
+		Status:   &bleve.SearchStatus{Total: 1, Successful: 1},
+		Request:  req,
+		Hits:     make(search.DocumentMatchCollection, 0, len(resp.Hits.Hits)),
+		Total:    resp.Hits.Total.Value,
+		Took:     took,
+		Facets:   make(search.FacetResults),
+		MaxScore: 0,
+	}
+	if resp.Hits.MaxScore != nil {
+		ans.MaxScore = *resp.Hits.MaxScore
+	}
+	for _, hit := range resp.Hits.Hits {
+		dm := &search.DocumentMatch{ID: hit.ID, Fields: hit.Source}
+		if hit.Score != nil {
+			dm.Score = *hit.Score
+		}
+		ans.Hits = append(ans.Hits, dm)
+	}
+	for name, fr := range req.Facets {
+		agg, ok := resp.Aggregations[name]
+		if !ok {
+			continue
+		}
+		res := &search.FacetResult{Field: fr.Field, Other: agg.SumOtherDocCount, Terms: &search.TermFacets{}}
+		for _, b := range agg.Buckets {
+			res.Terms.Add(&search.TermFacet{Term: fmt.Sprint(b.Key), Count: b.DocCount})
+			res.Total += b.DocCount
+		}
+		res.Total += agg.SumOtherDocCount
+		ans.Facets[name] = res
+	}
+	return ans
+}
@@ -0,0 +1,209 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"camus/indexer/documents"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// jsonRoundTrip makes values comparable regardless of their Go types
+func jsonRoundTrip(t *testing.T, v any) any {
+	data, err := json.Marshal(v)
+	assert.NoError(t, err)
+	var ans any
+	assert.NoError(t, json.Unmarshal(data, &ans))
+	return ans
+}
+
+func TestQueryToESBoolean(t *testing.T) {
+	q, err := termsToQuery([]searchedTerm{
+		{Field: "raw_query", Value: "lemma", Requirement: "must", Boost: 2},
+		{Field: "corpora", Value: "syn2020", Requirement: "must", IsExact: true},
+		{Field: "is_slow", Value: "true", Requirement: "must-not"},
+		{Field: "name", Value: "tes", Requirement: "should", IsPrefix: true},
+	})
+	assert.NoError(t, err)
+	ans, err := queryToES(q)
+	assert.NoError(t, err)
+	expected := map[string]any{
+		"bool": map[string]any{
+			"must": []any{
+				map[string]any{"match": map[string]any{"raw_query": map[string]any{"query": "lemma", "boost": 2.0}}},
+				map[string]any{"term": map[string]any{"corpora_exact": map[string]any{"value": "syn2020"}}},
+			},
+			"should": []any{
+				map[string]any{"prefix": map[string]any{"name": map[string]any{"value": "tes"}}},
+			},
+			"must_not": []any{
+				map[string]any{"term": map[string]any{"is_slow": map[string]any{"value": true}}},
+			},
+		},
+	}
+	assert.Equal(t, expected, jsonRoundTrip(t, ans))
+}
+
+func TestQueryToESNumericRange(t *testing.T) {
+	minV, maxV := 10.0, 20.0
+	ans, err := queryToES(termToQuery(searchedTerm{Field: "min_fq", Min: &minV, Max: &maxV}))
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		map[string]any{"range": map[string]any{"min_fq": map[string]any{"gte": 10.0, "lte": 20.0}}},
+		jsonRoundTrip(t, ans),
+	)
+}
+
+func TestQueryToESUnsupported(t *testing.T) {
+	_, err := queryToES(bleve.NewFuzzyQuery("foo"))
+	assert.ErrorIs(t, err, ErrUnsupportedByBackend)
+}
+
+func TestSearchRequestToES(t *testing.T) {
+	req := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	req.Size = 5
+	req.SortBy([]string{"-_score", "created"})
+	req.Fields = []string{"raw_query"}
+	req.AddFacet("corpora", bleve.NewFacetRequest("corpora", 3))
+	ans, err := searchRequestToES(req)
+	assert.NoError(t, err)
+	expected := map[string]any{
+		"query":            map[string]any{"match_all": map[string]any{}},
+		"size":             5.0,
+		"from":             0.0,
+		"track_total_hits": true,
+		"sort":             []any{map[string]any{"_score": "desc"}, map[string]any{"created": "asc"}},
+		"_source":          []any{"raw_query"},
+		"aggs": map[string]any{
+			"corpora": map[string]any{"terms": map[string]any{"field": "corpora", "size": 3.0}},
+		},
+	}
+	assert.Equal(t, expected, jsonRoundTrip(t, ans))
+}
+
+func TestESResponseToResult(t *testing.T) {
+	req := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	req.AddFacet("corpora", bleve.NewFacetRequest("corpora", 2))
+	var resp esSearchResponse
+	err := json.Unmarshal([]byte(`{
+		"hits": {
+			"total": {"value": 12},
+			"max_score": 1.5,
+			"hits": [
+				{"_id": "1/100/abc", "_score": 1.5, "_source": {"raw_query": "[word=\"x\"]"}},
+				{"_id": "1/90/def", "_score": null, "_source": {"raw_query": "y"}}
+			]
+		},
+		"aggregations": {
+			"corpora": {"sum_other_doc_count": 1, "buckets": [{"key": "syn2020", "doc_count": 8}, {"key": "intercorp", "doc_count": 3}]}
+		}
+	}`), &resp)
+	assert.NoError(t, err)
+	res := esResponseToResult(req, &resp, time.Millisecond)
+	assert.Equal(t, uint64(12), res.Total)
+	assert.Equal(t, 1.5, res.MaxScore)
+	assert.Len(t, res.Hits, 2)
+	assert.Equal(t, "1/100/abc", res.Hits[0].ID)
+	assert.Equal(t, "[word=\"x\"]", res.Hits[0].Fields["raw_query"])
+	assert.Equal(t, 0.0, res.Hits[1].Score)
+	terms := res.Facets["corpora"].Terms.Terms()
+	assert.Len(t, terms, 2)
+	assert.Equal(t, "syn2020", terms[0].Term)
+	assert.Equal(t, 8, terms[0].Count)
+	assert.Equal(t, 12, res.Facets["corpora"].Total)
+}
+
+func TestESIndexDefinition(t *testing.T) {
+	bleveMapping, err := documents.CreateMapping([]string{"The"})
+	assert.NoError(t, err)
+	def := jsonRoundTrip(t, esIndexDefinition(bleveMapping, []string{"The"})).(map[string]any)
+	props := def["mappings"].(map[string]any)["properties"].(map[string]any)
+	assert.Equal(t, map[string]any{"type": "keyword"}, props["corpora_exact"])
+	assert.Equal(t, map[string]any{"type": "date"}, props["created"])
+	assert.Equal(t, map[string]any{"type": "boolean"}, props["is_slow"])
+	assert.Equal(t, map[string]any{"type": "double"}, props["user_id_num"])
+	assert.Equal(
+		t,
+		map[string]any{"type": "text", "analyzer": "kontext_label_analyzer", "fielddata": true},
+		props["corpora"],
+	)
+	analysis := def["settings"].(map[string]any)["analysis"].(map[string]any)
+	stopFilter := analysis["filter"].(map[string]any)["kontext_label_stop_filter"].(map[string]any)
+	assert.Equal(t, []any{"the"}, stopFilter["stopwords"])
+}
+
+func TestESBackendSearchAndCount(t *testing.T) {
+	var searchBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodHead && r.URL.Path == "/camus_test":
+			w.WriteHeader(http.StatusOK)
+		case strings.HasSuffix(r.URL.Path, "/_count"):
+			w.Write([]byte(`{"count": 3}`))
+		case strings.HasSuffix(r.URL.Path, "/_search"):
+			data, _ := io.ReadAll(r.Body)
+			searchBody = string(data)
+			w.Write([]byte(`{"hits": {"total": {"value": 1}, "hits": [{"_id": "1/100/abc", "_score": 2.0}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	conf := &ElasticConf{Addresses: []string{srv.URL}, IndexName: "camus_test", RequestTimeoutSecs: 5}
+	backend, err := newESBackend(conf, []string{})
+	assert.NoError(t, err)
+	count, err := backend.DocCount()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+	q := bleve.NewTermQuery("1")
+	q.SetField("user_id")
+	res, err := backend.Search(bleve.NewSearchRequest(q))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), res.Total)
+	assert.Equal(t, "1/100/abc", res.Hits[0].ID)
+	assert.Contains(t, searchBody, `"term":{"user_id":{"value":"1"}}`)
+}
+
+func TestQueryToESDateRange(t *testing.T) {
+	q, err := termsToQuery([]searchedTerm{
+		{Field: "created", From: "2024-05-01T12:00:00+02:00", To: "2024-05-02", Requirement: "must"},
+	})
+	assert.NoError(t, err)
+	ans, err := queryToES(q)
+	assert.NoError(t, err)
+	rng := map[string]any{"gte": "2024-05-01T10:00:00Z", "lte": "2024-05-02T00:00:00Z"}
+	assert.Equal(
+		t,
+		map[string]any{
+			"bool": map[string]any{
+				"must": []any{map[string]any{"range": map[string]any{"created": rng}}},
+			},
+		},
+		jsonRoundTrip(t, ans),
+	)
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"camus/cncdb"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// failedRecord is a reference to a query history record
+// which failed to be indexed. It is stored (JSON encoded)
+// in the Redis set defined by Conf.FailedIndexingKey.
+type failedRecord struct {
+	QueryID string `json:"queryId"`
+	UserID  int    `json:"userId"`
+	Created int64  `json:"created"`
+	Name    string `json:"name,omitempty"`
+}
+
+// ReindexFailedStats summarizes a ReindexFailed run.
+type ReindexFailedStats struct {
+	NumProcessed    int `json:"numProcessed"`
+	NumIndexed      int `json:"numIndexed"`
+	NumGone         int `json:"numGone"`
+	NumNotIndexable int `json:"numNotIndexable"`
+	NumRemaining    int `json:"numRemaining"`
+}
+
+func (idx *Indexer) markFailed(hRec *cncdb.HistoryRecord) {
+	if idx.rdb == nil {
+		return
+	}
+	member, err := json.Marshal(failedRecord{
+		QueryID: hRec.QueryID,
+		UserID:  hRec.UserID,
+		Created: hRec.Created,
+		Name:    hRec.Name,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("queryId", hRec.QueryID).Msg("failed to store record for re-indexing")
+		return
+	}
+	stored, err := idx.rdb.SAddCapped(
+		idx.conf.FailedIndexingKey, string(member), idx.conf.FailedIndexingMaxSize)
+	if err != nil {
+		log.Error().Err(err).Str("queryId", hRec.QueryID).Msg("failed to store record for re-indexing")
+
+	} else if !stored {
+		log.Warn().
+			Str("queryId", hRec.QueryID).
+			Int("maxSize", idx.conf.FailedIndexingMaxSize).
+			Msg("set of records for re-indexing is full, ignoring record")
+	}
+}
+
+// ReindexFailed tries to index again all the records which previously
+// failed to be indexed or converted. Successfully indexed records, records
+// which no longer exist and records which turned out to be permanently
+// not indexable (see IsPermanentlyNotIndexable) are removed from the set.
+func (idx *Indexer) ReindexFailed() (ReindexFailedStats, error) {
+	var ans ReindexFailedStats
+	members, err := idx.rdb.SMembers(idx.conf.FailedIndexingKey)
+	if err != nil {
+		return ans, fmt.Errorf("failed to re-index failed records: %w", err)
+	}
+	for _, member := range members {
+		ans.NumProcessed++
+		var item failedRecord
+		if err := json.Unmarshal([]byte(member), &item); err != nil {
+			log.Error().Err(err).Str("item", member).Msg("invalid record in re-indexing set, removing")
+			if err := idx.rdb.SRem(idx.conf.FailedIndexingKey, member); err != nil {
+				return ans, fmt.Errorf("failed to re-index failed records: %w", err)
+			}
+			continue
+		}
+		rec, err := idx.GetConcRecord(item.QueryID)
+		if err != nil {
+			log.Error().Err(err).Str("queryId", item.QueryID).Msg("failed to re-index record")
+			ans.NumRemaining++
+			continue
+		}
+		if rec == nil {
+			ans.NumGone++
+			if err := idx.rdb.SRem(idx.conf.FailedIndexingKey, member); err != nil {
+				return ans, fmt.Errorf("failed to re-index failed records: %w", err)
+			}
+			continue
+		}
+		hRec := &cncdb.HistoryRecord{
+			QueryID: item.QueryID,
+			UserID:  item.UserID,
+			Created: item.Created,
+			Name:    item.Name,
+			Rec:     rec,
+		}
+		ok, err := idx.indexRecord(hRec)
+		if IsPermanentlyNotIndexable(err) {
+			log.Info().Err(err).Str("queryId", item.QueryID).Msg("removing not indexable record from re-indexing")
+			ans.NumNotIndexable++
+
+		} else if err != nil {
+			log.Error().Err(err).Str("queryId", item.QueryID).Msg("failed to re-index record")
+			ans.NumRemaining++
+			continue
+
+		} else if !ok {
+			ans.NumNotIndexable++
+		}
+		if err := idx.rdb.SRem(idx.conf.FailedIndexingKey, member); err != nil {
+			return ans, fmt.Errorf("failed to re-index failed records: %w", err)
+		}
+		if ok {
+			ans.NumIndexed++
+		}
+	}
+	return ans, nil
+}
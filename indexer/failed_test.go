@@ -0,0 +1,127 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"camus/archiver"
+	"camus/cncdb"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func prepareIndexerWithRedis(t *testing.T) (*Indexer, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	rdb := archiver.NewRedisAdapter(
+		context.Background(),
+		&archiver.RedisConf{Host: mr.Host(), Port: mr.Server().Addr().Port},
+	)
+	tempDir, err := os.MkdirTemp("", "test-index")
+	assert.NoError(t, err)
+	conf := Conf{
+		IndexDirPath:            tempDir,
+		QueryHistoryNumPreserve: 100,
+		FailedIndexingKey:       dfltFailedIndexingKey,
+		FailedIndexingMaxSize:   dfltFailedIndexingMaxSize,
+		PendingDeletesKey:       dfltPendingDeletesKey,
+	}
+	idxer, err := NewIndexer(&conf, &cncdb.DummyConcArchSQL{}, &cncdb.MySQLQueryHistDryRun{}, rdb, nil)
+	assert.NoError(t, err)
+	return idxer, mr
+}
+
+func TestReindexFailedAfterConversionChange(t *testing.T) {
+	idxer, mr := prepareIndexerWithRedis(t)
+	defer cleanData(idxer.DataPath())
+	defer idxer.Close()
+
+	hRec := corpusPolicyTestRecord(t, "wl1", "syn2020")
+	assert.NoError(t, mr.Set("concordance:wl1", hRec.Rec.Data))
+
+	// no conversion for wlist records available yet
+	wlistImporter := recordImporters[cncdb.QuerySupertypeWlist]
+	delete(recordImporters, cncdb.QuerySupertypeWlist)
+	ok, err := idxer.IndexRecord(hRec)
+	recordImporters[cncdb.QuerySupertypeWlist] = wlistImporter
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	members, err := mr.SMembers(dfltFailedIndexingKey)
+	assert.NoError(t, err)
+	assert.Len(t, members, 1)
+
+	stats, err := idxer.ReindexFailed()
+	assert.NoError(t, err)
+	assert.Equal(t, ReindexFailedStats{NumProcessed: 1, NumIndexed: 1}, stats)
+	assert.False(t, mr.Exists(dfltFailedIndexingKey))
+	count, err := idxer.DocCount()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+}
+
+func TestReindexFailedKeepsStillNotConvertible(t *testing.T) {
+	idxer, mr := prepareIndexerWithRedis(t)
+	defer cleanData(idxer.DataPath())
+	defer idxer.Close()
+
+	hRec := corpusPolicyTestRecord(t, "wl2", "syn2020")
+	assert.NoError(t, mr.Set("concordance:wl2", hRec.Rec.Data))
+	wlistImporter := recordImporters[cncdb.QuerySupertypeWlist]
+	delete(recordImporters, cncdb.QuerySupertypeWlist)
+	defer func() { recordImporters[cncdb.QuerySupertypeWlist] = wlistImporter }()
+
+	_, err := idxer.IndexRecord(hRec)
+	assert.NoError(t, err)
+	stats, err := idxer.ReindexFailed()
+	assert.NoError(t, err)
+	assert.Equal(t, ReindexFailedStats{NumProcessed: 1, NumRemaining: 1}, stats)
+	members, err := mr.SMembers(dfltFailedIndexingKey)
+	assert.NoError(t, err)
+	assert.Len(t, members, 1)
+}
+
+func TestIndexRecordSkipsPermanentlyNotIndexable(t *testing.T) {
+	idxer, mr := prepareIndexerWithRedis(t)
+	defer cleanData(idxer.DataPath())
+	defer idxer.Close()
+	idxer.conf.NonIndexedCorpora = []string{"private1"}
+
+	ok, err := idxer.IndexRecord(corpusPolicyTestRecord(t, "p1", "private1"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = idxer.IndexRecord(&cncdb.HistoryRecord{
+		QueryID: "shuffle1",
+		UserID:  1,
+		Rec: &cncdb.ArchRecord{
+			ID:   "shuffle1",
+			Data: `{"id": "shuffle1", "lastop_form": {"form_type": "shuffle"}}`,
+		},
+	})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.False(t, mr.Exists(dfltFailedIndexingKey))
+}
+
+func TestIsPermanentlyNotIndexable(t *testing.T) {
+	assert.True(t, IsPermanentlyNotIndexable(ErrUnsupportedSupertype))
+	assert.True(t, IsPermanentlyNotIndexable(ErrCorpusNotIndexed))
+	assert.False(t, IsPermanentlyNotIndexable(ErrRecordNotIndexable))
+	assert.False(t, IsPermanentlyNotIndexable(nil))
+}
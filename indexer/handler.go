@@ -17,11 +17,15 @@
 package indexer
 
 import (
+	"camus/apierr"
 	"camus/cncdb"
+	"camus/indexer/documents"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/czcorpus/cnc-gokit/uniresp"
 	"github.com/gin-gonic/gin"
@@ -29,13 +33,66 @@ import (
 )
 
 const (
-	defaultNumRecentRecs = 100
+	defaultNumRecentRecs  = 100
+	defaultNumSuggestions = 10
+	maxNumSuggestions     = 50
+	defaultNumFacets      = 10
+	maxNumFacets          = 100
+	dfltCQLDefaultAttr    = "word"
+	defaultConvReportSize = 100
+
+	indexLockedRetryAfterSecs = 30
 )
 
+// knownErrors maps errors produced by the indexer
+// to API error codes (see apierr.Respond)
+var knownErrors = []apierr.KnownError{
+	{Err: cncdb.ErrRecordNotFound, Code: apierr.CodeRecordNotFound, Status: http.StatusNotFound},
+	{Err: ErrRecordNotIndexable, Code: apierr.CodeNotIndexable, Status: http.StatusUnprocessableEntity},
+	{Err: ErrMissingRecordData, Code: apierr.CodeMissingRecordData, Status: http.StatusUnprocessableEntity},
+	{Err: ErrIndexLocked, Code: apierr.CodeIndexLocked, Status: http.StatusConflict},
+	{Err: ErrIndexOpening, Code: apierr.CodeIndexOpening, Status: http.StatusServiceUnavailable},
+	{Err: ErrOptimizationInProgress, Code: apierr.CodeOptimizationInProgress, Status: http.StatusConflict},
+	{Err: ErrInvalidSupertype, Code: apierr.CodeInvalidSupertype, Status: http.StatusBadRequest},
+	{Err: ErrInvalidSortField, Code: apierr.CodeInvalidSortField, Status: http.StatusBadRequest},
+	{Err: ErrInvalidSearchTerm, Code: apierr.CodeInvalidSearchTerm, Status: http.StatusBadRequest},
+	{Err: ErrFieldNotFacetable, Code: apierr.CodeFieldNotFacetable, Status: http.StatusBadRequest},
+	{Err: ErrNoReindexCheckpoint, Code: apierr.CodeNoReindexCheckpoint, Status: http.StatusNotFound},
+}
+
+func respondWithError(ctx *gin.Context, err error, status int) {
+	apierr.Respond(ctx, err, status, knownErrors)
+}
+
 type Actions struct {
 	idxService *Service
 }
 
+// RequireOpenIndex is a middleware responding with HTTP 503
+// in case the index is still being opened.
+func (a *Actions) RequireOpenIndex(ctx *gin.Context) {
+	if a.idxService.Indexer() == nil {
+		ctx.Header("Retry-After", strconv.Itoa(indexLockedRetryAfterSecs))
+		respondWithError(ctx, ErrIndexOpening, http.StatusServiceUnavailable)
+		ctx.Abort()
+		return
+	}
+	ctx.Next()
+}
+
+// respondWithIndexWriteError writes a proper error response for
+// a failed index write operation. In case the index is locked,
+// HTTP 409 with the `Retry-After` header is used so clients
+// can retry later.
+func respondWithIndexWriteError(ctx *gin.Context, err error) {
+	if errors.Is(err, ErrIndexLocked) {
+		ctx.Header("Retry-After", strconv.Itoa(indexLockedRetryAfterSecs))
+		respondWithError(ctx, err, http.StatusConflict)
+		return
+	}
+	respondWithError(ctx, err, http.StatusInternalServerError)
+}
+
 func (a *Actions) IndexLatestRecords(ctx *gin.Context) {
 	numRec := ctx.Query("numRec")
 	if numRec == "" {
@@ -49,18 +106,22 @@ func (a *Actions) IndexLatestRecords(ctx *gin.Context) {
 
 	iNumRec, err := strconv.Atoi(numRec)
 	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusBadRequest)
+		respondWithError(ctx, err, http.StatusBadRequest)
 		return
 	}
 
-	numProc, err := a.idxService.Indexer().IndexRecentRecords(iNumRec)
-	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+	numProc, err := a.idxService.Indexer().IndexRecentRecords(iNumRec, ctx.Query("resume") == "1")
+	if errors.Is(err, ErrNoReindexCheckpoint) {
+		respondWithError(ctx, err, http.StatusNotFound)
+		return
+
+	} else if err != nil {
+		respondWithIndexWriteError(ctx, err)
 		return
 	}
 	count, err := a.idxService.Indexer().Count()
 	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		respondWithError(ctx, err, http.StatusInternalServerError)
 		return
 	}
 	resp := map[string]any{
@@ -73,14 +134,41 @@ func (a *Actions) IndexLatestRecords(ctx *gin.Context) {
 func (a *Actions) IndexInfo(ctx *gin.Context) {
 	count, err := a.idxService.Indexer().Count()
 	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		respondWithError(ctx, err, http.StatusInternalServerError)
 		return
 	}
 	// CurOnDiskBytes
 	resp := map[string]any{
-		"name":           a.idxService.indexer.bleveIdx.Name(),
+		"name":           a.idxService.Indexer().bleveIdx.Name(),
+		"backend":        a.idxService.Indexer().conf.Backend,
 		"totalDocuments": count,
-		"stats":          a.idxService.indexer.bleveIdx.Stats(),
+		"stats":          a.idxService.Indexer().bleveIdx.Stats(),
+		"searchCache":    a.idxService.Indexer().SearchCacheStats(),
+		"subcPropsCache": a.idxService.Indexer().SubcPropsCacheStats(),
+		"oversizedDocs":  a.idxService.Indexer().NumOversizedDocs(),
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, resp)
+}
+
+// Mapping exposes the current index mapping (i.e. field names,
+// types and analyzers) so clients know what to search for.
+func (a *Actions) Mapping(ctx *gin.Context) {
+	uniresp.WriteJSONResponse(ctx.Writer, a.idxService.Indexer().Mapping())
+}
+
+func (a *Actions) Optimize(ctx *gin.Context) {
+	before, after, err := a.idxService.Indexer().Optimize(ctx.Request.Context())
+	if err == ErrOptimizationInProgress {
+		respondWithError(ctx, err, http.StatusConflict)
+		return
+
+	} else if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	resp := map[string]any{
+		"before": before,
+		"after":  after,
 	}
 	uniresp.WriteJSONResponse(ctx.Writer, resp)
 }
@@ -91,31 +179,96 @@ func (a *Actions) RecordToDoc(ctx *gin.Context) {
 	}
 	rec, err := a.idxService.GetRecord(hRec.QueryID)
 	if err == cncdb.ErrRecordNotFound {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusNotFound)
+		respondWithError(ctx, err, http.StatusNotFound)
 		return
 	}
 	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		respondWithError(ctx, err, http.StatusInternalServerError)
 		return
 	}
 	hRec.Rec = &rec
 	doc, err := a.idxService.Indexer().RecToDoc(&hRec)
-	if err == ErrRecordNotIndexable {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusUnprocessableEntity)
+	if errors.Is(err, ErrRecordNotIndexable) {
+		respondWithError(ctx, err, http.StatusUnprocessableEntity)
 		return
 
 	} else if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		respondWithError(ctx, err, http.StatusInternalServerError)
 		return
 	}
 	uniresp.WriteJSONResponse(ctx.Writer, doc)
 
 }
 
+// ConversionReport runs conversion of a sample of query history
+// records (without indexing them) and reports counts of successful
+// conversions and of each error class. URL arguments `from` and `to`
+// are UNIX timestamps (defaulting to the last 24 hours), `sample`
+// specifies the number of processed records which are drawn evenly
+// from the whole interval.
+func (a *Actions) ConversionReport(ctx *gin.Context) {
+	now := time.Now().Unix()
+	to, err := strconv.ParseInt(ctx.DefaultQuery("to", strconv.FormatInt(now, 10)), 10, 64)
+	if err != nil {
+		respondWithError(ctx, fmt.Errorf("invalid `to` timestamp"), http.StatusBadRequest)
+		return
+	}
+	from, err := strconv.ParseInt(ctx.DefaultQuery("from", strconv.FormatInt(to-24*3600, 10)), 10, 64)
+	if err != nil || from > to {
+		respondWithError(ctx, fmt.Errorf("invalid `from` timestamp"), http.StatusBadRequest)
+		return
+	}
+	sample, err := strconv.Atoi(ctx.DefaultQuery("sample", strconv.Itoa(defaultConvReportSize)))
+	if err != nil || sample <= 0 || sample > MaxConversionReportSample {
+		respondWithError(
+			ctx,
+			fmt.Errorf("invalid sample argument (max. %d)", MaxConversionReportSample),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	report, err := a.idxService.Indexer().ConversionReport(from, to, sample)
+	if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, report)
+}
+
+// parseMinScore obtains the `minScore` URL argument used to remove
+// low-relevance hits from search results (0 = no filtering).
+func parseMinScore(ctx *gin.Context) (float64, error) {
+	minScore, err := strconv.ParseFloat(ctx.DefaultQuery("minScore", "0"), 64)
+	if err != nil || minScore < 0 {
+		return 0, fmt.Errorf("invalid minScore")
+	}
+	return minScore, nil
+}
+
+// parseSupertype obtains the optional `supertype` URL argument
+// restricting search to a single query supertype.
+func parseSupertype(ctx *gin.Context) (cncdb.QuerySupertype, error) {
+	st := cncdb.QuerySupertype(ctx.Query("supertype"))
+	if st != "" && !st.IsIndexable() {
+		return "", fmt.Errorf("%w %s", ErrInvalidSupertype, st)
+	}
+	return st, nil
+}
+
 func (a *Actions) Search(ctx *gin.Context) {
 	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "10"))
 	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusBadRequest)
+		respondWithError(ctx, err, http.StatusBadRequest)
+		return
+	}
+	minScore, err := parseMinScore(ctx)
+	if err != nil {
+		respondWithError(ctx, err, http.StatusBadRequest)
+		return
+	}
+	supertype, err := parseSupertype(ctx)
+	if err != nil {
+		respondWithError(ctx, err, http.StatusBadRequest)
 		return
 	}
 	order := make([]string, 0, 3)
@@ -129,30 +282,117 @@ func (a *Actions) Search(ctx *gin.Context) {
 
 	var queryData []searchedTerm
 	if err := ctx.BindJSON(&queryData); err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusBadRequest)
+		respondWithError(ctx, err, http.StatusBadRequest)
 		return
 	}
+	if supertype != "" {
+		queryData = append(queryData, supertypeTerm(supertype))
+	}
 	log.Debug().Any("searchArgs", queryData).Msg("obtained search query")
-	queryData = append(
-		queryData,
-		searchedTerm{
-			Field:       "user_id",
-			Value:       ctx.Param("userId"),
-			Requirement: "must",
-		},
-	)
-	rec, err := a.idxService.indexer.Search(queryData, limit, order, fields)
+	rec, err := a.idxService.Indexer().SearchCached(ctx.Param("userId"), queryData, limit, order, fields)
+	if errors.Is(err, ErrInvalidSortField) || errors.Is(err, ErrInvalidSearchTerm) {
+		respondWithError(ctx, err, http.StatusBadRequest)
+		return
+
+	} else if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, filterByMinScore(rec, minScore))
+}
+
+func (a *Actions) Suggest(ctx *gin.Context) {
+	prefix := ctx.Query("prefix")
+	if prefix == "" {
+		respondWithError(ctx, fmt.Errorf("missing prefix"), http.StatusBadRequest)
+		return
+	}
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultNumSuggestions)))
+	if err != nil || limit <= 0 {
+		respondWithError(ctx, fmt.Errorf("invalid limit"), http.StatusBadRequest)
+		return
+	}
+	if limit > maxNumSuggestions {
+		limit = maxNumSuggestions
+	}
+	ans, err := a.idxService.Indexer().Suggest(ctx.Param("userId"), prefix, limit)
+	if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"suggestions": ans})
+}
+
+// Facets returns term counts of a facetable field. Optional
+// `userId` argument restricts the aggregation to a single user.
+func (a *Actions) Facets(ctx *gin.Context) {
+	field := ctx.Query("field")
+	if field == "" {
+		respondWithError(ctx, fmt.Errorf("missing field"), http.StatusBadRequest)
+		return
+	}
+	size, err := strconv.Atoi(ctx.DefaultQuery("size", strconv.Itoa(defaultNumFacets)))
+	if err != nil || size <= 0 {
+		respondWithError(ctx, fmt.Errorf("invalid size"), http.StatusBadRequest)
+		return
+	}
+	if size > maxNumFacets {
+		size = maxNumFacets
+	}
+	filters := make([]searchedTerm, 0, 1)
+	if userID := ctx.Query("userId"); userID != "" {
+		filters = append(filters, userScopeTerm(userID))
+	}
+	ans, err := a.idxService.Indexer().Facets(field, size, filters)
+	if errors.Is(err, ErrFieldNotFacetable) {
+		respondWithError(ctx, err, http.StatusBadRequest)
+		return
+
+	} else if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"field": field, "terms": ans})
+}
+
+// QueryTypeStats returns numbers of indexed queries of each supertype
+// created within an (optional) time window specified by the `from`
+// and `to` arguments. The endpoint is meant for admins only (access
+// is checked by the API server).
+func (a *Actions) QueryTypeStats(ctx *gin.Context) {
+	window := map[string]any{"from": nil, "to": nil}
+	for _, arg := range []string{"from", "to"} {
+		if v := ctx.Query(arg); v != "" {
+			t, err := documents.ParseCreated(v)
+			if err != nil {
+				respondWithError(ctx, fmt.Errorf("invalid `%s`: %w", arg, err), http.StatusBadRequest)
+				return
+			}
+			window[arg] = t.Format(time.RFC3339)
+		}
+	}
+	ans, err := a.idxService.Indexer().CountBySupertypeWithin(ctx.Query("from"), ctx.Query("to"))
 	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		respondWithError(ctx, err, http.StatusInternalServerError)
 		return
 	}
-	uniresp.WriteJSONResponse(ctx.Writer, rec)
+	uniresp.WriteJSONResponse(ctx.Writer, map[string]any{"window": window, "counts": ans})
 }
 
 func (a *Actions) SearchWithQuery(ctx *gin.Context) {
 	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "10"))
 	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusBadRequest)
+		respondWithError(ctx, err, http.StatusBadRequest)
+		return
+	}
+	minScore, err := parseMinScore(ctx)
+	if err != nil {
+		respondWithError(ctx, err, http.StatusBadRequest)
+		return
+	}
+	supertype, err := parseSupertype(ctx)
+	if err != nil {
+		respondWithError(ctx, err, http.StatusBadRequest)
 		return
 	}
 	order := make([]string, 0, 3)
@@ -165,13 +405,19 @@ func (a *Actions) SearchWithQuery(ctx *gin.Context) {
 	}
 
 	srchQuery := fmt.Sprintf("+user_id:%s %s", ctx.Param("userId"), ctx.Query("q"))
-	rec, err := a.idxService.indexer.SearchWithQuery(srchQuery, limit, order, fields)
+	if supertype != "" {
+		srchQuery = fmt.Sprintf("+query_supertype:%s %s", supertype, srchQuery)
+	}
+	rec, err := a.idxService.Indexer().SearchWithQuery(srchQuery, limit, order, fields)
+	if errors.Is(err, ErrInvalidSortField) {
+		respondWithError(ctx, err, http.StatusBadRequest)
+		return
 
-	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+	} else if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
 		return
 	}
-	uniresp.WriteJSONResponse(ctx.Writer, rec)
+	uniresp.WriteJSONResponse(ctx.Writer, filterByMinScore(rec, minScore))
 }
 
 func (a *Actions) Update(ctx *gin.Context) {
@@ -181,7 +427,7 @@ func (a *Actions) Update(ctx *gin.Context) {
 	}
 	hRec.Name = ctx.Query("name")
 	if err := a.idxService.Indexer().Update(hRec); err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		respondWithIndexWriteError(ctx, err)
 		return
 	}
 	uniresp.WriteJSONResponse(ctx.Writer, hRec)
@@ -193,24 +439,100 @@ func (a *Actions) Delete(ctx *gin.Context) {
 		return
 	}
 	if err := a.idxService.Indexer().Delete(hRec.CreateIndexID()); err != nil {
-		uniresp.RespondWithErrorJSON(ctx, err, http.StatusInternalServerError)
+		respondWithIndexWriteError(ctx, err)
 		return
 	}
 	uniresp.WriteJSONResponse(ctx.Writer, hRec)
 }
 
+type cqlExtractArgs struct {
+	Query       string `json:"query"`
+	DefaultAttr string `json:"defaultAttr"`
+}
+
+// cqlExtractResponse contains the extracted properties in the form
+// they would be indexed (see documents.Concordance)
+type cqlExtractResponse struct {
+	Query            string `json:"query"`
+	DefaultAttr      string `json:"default_attr"`
+	Structures       string `json:"structures"`
+	StructAttrNames  string `json:"struct_attr_names"`
+	StructAttrValues string `json:"struct_attr_values"`
+	PosAttrNames     string `json:"pos_attr_names"`
+	PosAttrValues    string `json:"pos_attr_values"`
+	Error            string `json:"error,omitempty"`
+}
+
+// ExtractCQL extracts attributes, structures and their values from
+// a provided CQL query without storing anything. In case the query cannot
+// be parsed, the parsing error is part of the (otherwise empty) response.
+// If `defaultAttr` is not specified, `word` is used.
+func (a *Actions) ExtractCQL(ctx *gin.Context) {
+	var args cqlExtractArgs
+	if err := ctx.BindJSON(&args); err != nil {
+		respondWithError(ctx, err, http.StatusBadRequest)
+		return
+	}
+	if args.Query == "" {
+		respondWithError(ctx, fmt.Errorf("missing query"), http.StatusBadRequest)
+		return
+	}
+	if args.DefaultAttr == "" {
+		args.DefaultAttr = dfltCQLDefaultAttr
+	}
+	ans := cqlExtractResponse{Query: args.Query}
+	doc, err := documents.ExtractRawCQLProps(args.Query, args.DefaultAttr)
+	if err != nil {
+		ans.Error = err.Error()
+	}
+	idoc := doc.AsIndexableDoc(0).(*documents.Concordance)
+	ans.DefaultAttr = idoc.DefaultAttr
+	ans.Structures = idoc.Structures
+	ans.StructAttrNames = idoc.StructAttrNames
+	ans.StructAttrValues = idoc.StructAttrValues
+	ans.PosAttrNames = idoc.PosAttrNames
+	ans.PosAttrValues = idoc.PosAttrValues
+	uniresp.WriteJSONResponse(ctx.Writer, ans)
+}
+
+// ReindexFailed tries to index again records which failed
+// to be indexed previously.
+func (a *Actions) ReindexFailed(ctx *gin.Context) {
+	stats, err := a.idxService.Indexer().ReindexFailed()
+	if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, stats)
+}
+
+// Purge removes an archive record along with all the
+// related query history documents from the index.
+func (a *Actions) Purge(ctx *gin.Context) {
+	summary, err := a.idxService.Indexer().Purge(ctx.Param("id"))
+	if err != nil {
+		respondWithError(ctx, err, http.StatusInternalServerError)
+		return
+	}
+	if summary.NumArchiveRecords == 0 && len(summary.DeletedIndexDocs)+len(summary.PendingRetryIndexDocs) == 0 {
+		respondWithError(ctx, cncdb.ErrRecordNotFound, http.StatusNotFound)
+		return
+	}
+	uniresp.WriteJSONResponse(ctx.Writer, summary)
+}
+
 func (a *Actions) getHistoryRecord(ctx *gin.Context) *cncdb.HistoryRecord {
 	queryID := ctx.Param("queryId")
 	userIDStr := ctx.Param("userId")
 	userID, err := strconv.Atoi(userIDStr)
 	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+		respondWithError(ctx, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
 		return nil
 	}
 	createdStr := ctx.Param("created")
 	created, err := strconv.Atoi(createdStr)
 	if err != nil {
-		uniresp.RespondWithErrorJSON(ctx, fmt.Errorf("invalid `created` unix timestamp"), http.StatusBadRequest)
+		respondWithError(ctx, fmt.Errorf("invalid `created` unix timestamp"), http.StatusBadRequest)
 		return nil
 	}
 
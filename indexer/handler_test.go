@@ -0,0 +1,112 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"camus/cncdb"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeQueryHist provides a fixed query history (sorted from
+// the oldest record)
+type fakeQueryHist struct {
+	cncdb.IQHistArchOps
+	history []cncdb.HistoryRecord
+}
+
+func (fqh *fakeQueryHist) LoadRecentNHistory(num int) ([]cncdb.HistoryRecord, error) {
+	ans := make([]cncdb.HistoryRecord, 0, num)
+	for i := len(fqh.history) - 1; i >= 0 && len(ans) < num; i-- {
+		ans = append(ans, fqh.history[i])
+	}
+	return ans, nil
+}
+
+func (fqh *fakeQueryHist) LoadHistoryBetween(from, to int64, num int) ([]cncdb.HistoryRecord, error) {
+	ans := make([]cncdb.HistoryRecord, 0, num)
+	for _, item := range fqh.history {
+		if item.Created >= from && item.Created <= to && len(ans) < num {
+			ans = append(ans, item)
+		}
+	}
+	return ans, nil
+}
+
+func newTestIndexerEngine(idxer *Indexer) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	handler := NewActions(NewService(idxer.conf, idxer, idxer.rdb))
+	engine := gin.New()
+	engine.GET("/query-history/build", handler.IndexLatestRecords)
+	engine.POST("/user-query-history/:userId/:queryId/:created", handler.Update)
+	engine.DELETE("/user-query-history/:userId/:queryId/:created", handler.Delete)
+	return engine
+}
+
+func TestIndexWriteDuringMaintenance(t *testing.T) {
+	idxer, mr := prepareIndexerWithRedis(t)
+	defer cleanData(idxer.DataPath())
+	defer idxer.Close()
+	hRec := corpusPolicyTestRecord(t, "wl1", "syn2020")
+	assert.NoError(t, mr.Set("concordance:wl1", hRec.Rec.Data))
+	engine := newTestIndexerEngine(idxer)
+
+	idxer.maintenanceMutex.Lock()
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(method, "/user-query-history/1/wl1/1700000000", nil))
+		assert.Equal(t, http.StatusConflict, w.Code, method)
+		assert.Equal(t, "30", w.Header().Get("Retry-After"), method)
+	}
+	idxer.maintenanceMutex.Unlock()
+
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(method, "/user-query-history/1/wl1/1700000000", nil))
+		assert.Equal(t, http.StatusOK, w.Code, method)
+		assert.Empty(t, w.Header().Get("Retry-After"), method)
+	}
+}
+
+func TestIndexLatestRecordsDuringMaintenance(t *testing.T) {
+	idxer, mr := prepareIndexerWithRedis(t)
+	defer cleanData(idxer.DataPath())
+	defer idxer.Close()
+	hRec := corpusPolicyTestRecord(t, "wl1", "syn2020")
+	assert.NoError(t, mr.Set("concordance:wl1", hRec.Rec.Data))
+	idxer.queryHistDb = &fakeQueryHist{history: []cncdb.HistoryRecord{*hRec}}
+	engine := newTestIndexerEngine(idxer)
+
+	idxer.maintenanceMutex.Lock()
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/query-history/build?numRec=10", nil))
+	idxer.maintenanceMutex.Unlock()
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/query-history/build?numRec=10", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	count, err := idxer.DocCount()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+}
@@ -23,25 +23,137 @@ import (
 	"camus/indexer/documents"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/index/scorch"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
 	"github.com/blevesearch/bleve/v2/search/query"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
 )
 
+const (
+	suggestFetchMultiplier = 5
+	labelStopWordsMetaKey  = "camus_label_stop_words"
+
+	// concRecordsBatchSize specifies how many conc records are
+	// loaded at once during bulk reindexing (see getConcRecords)
+	concRecordsBatchSize = 500
+)
+
+// sortableFields lists fields which can be used for ordering search
+// results (with optional `-` prefix for descending order).
+var sortableFields = []string{"_score", "_id", "id", "created", "user_id", "query_supertype"}
+
+// facetableFields lists (mostly multi-valued) fields we allow
+// to aggregate via Facets
+var facetableFields = []string{
+	"struct_attr_names", "struct_attr_values", "struct_attr_pairs", "pos_attr_names", "structures",
+	"corpora", "subcorpus", "query_supertype", "default_attr",
+}
+
+// datetimeFields lists fields searchable via date ranges
+// (see searchedTerm.From, searchedTerm.To)
+var datetimeFields = []string{"created"}
+
+// booleanFields lists fields indexed as booleans. Such fields
+// cannot be searched via a match query.
+var booleanFields = []string{"has_filters", "is_slow", "subconc_truncated", "cql_pending", "use_regexp"}
+
+// exactFields maps analyzed fields to their non-analyzed variants
+// searchable via exact term match (see searchedTerm.IsExact)
+var exactFields = map[string]string{"corpora": "corpora_exact"}
+
 type requirement string
 
+type FacetTerm struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// validateOrder tests whether all the order items refer to sortable fields.
+func validateOrder(order []string) error {
+	for _, item := range order {
+		if !slices.Contains(sortableFields, strings.TrimPrefix(item, "-")) {
+			return fmt.Errorf("%w: %s (sortable fields: %s)",
+				ErrInvalidSortField, item, strings.Join(sortableFields, ", "))
+		}
+	}
+	return nil
+}
+
 type searchedTerm struct {
 	Field       string      `json:"field"`
 	Value       string      `json:"value"`
 	Requirement requirement `json:"requirement"`
 	IsWildcard  bool        `json:"isWildCard"`
+
+	// Min and Max specify an inclusive numeric range
+	// for numeric fields (e.g. `user_id_num`). If any of
+	// them is set, Value and IsWildcard are ignored.
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+
+	// From and To specify an inclusive range of dates for datetime
+	// fields (i.e. `created`). Any of them can be omitted. Supported
+	// formats are listed in documents.CreatedLayouts, values without
+	// timezone are interpreted as UTC (e.g. `2024-05-01` means midnight UTC).
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// IsPrefix specifies that Value is a prefix of a searched term
+	IsPrefix bool `json:"isPrefix"`
+
+	// IsExact specifies that Value must match exactly a whole
+	// (non-analyzed) value of the field. Only fields listed
+	// in exactFields support this.
+	IsExact bool `json:"isExact"`
+
+	// Boost multiplies the score contribution of the term so e.g.
+	// matches in `name` can outrank matches in `raw_query`. Zero means
+	// the default (1.0). Values between 0.1 and 10 are reasonable,
+	// higher ones tend to make other terms irrelevant for ranking.
+	Boost float64 `json:"boost"`
+}
+
+func (st searchedTerm) IsNumericRange() bool {
+	return st.Min != nil || st.Max != nil
+}
+
+func (st searchedTerm) IsDateRange() bool {
+	return st.From != "" || st.To != ""
+}
+
+// dateRange parses From and To values. Omitted values
+// are returned as zero time.
+func (st searchedTerm) dateRange() (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+	if st.From != "" {
+		from, err = documents.ParseCreated(st.From)
+		if err != nil {
+			return from, to, err
+		}
+	}
+	if st.To != "" {
+		to, err = documents.ParseCreated(st.To)
+		if err != nil {
+			return from, to, err
+		}
+	}
+	return from, to, nil
 }
 
 type Indexer struct {
@@ -50,41 +162,176 @@ type Indexer struct {
 	queryHistDb cncdb.IQHistArchOps
 	rdb         *archiver.RedisAdapter
 	bleveIdx    bleve.Index
+	backend     Backend
 	dataPath    string
 	recsToIndex <-chan cncdb.HistoryRecord
+
+	// optimizeMutex prevents running multiple forced merges at once
+	optimizeMutex sync.Mutex
+
+	// maintenanceMutex is held exclusively during index maintenance
+	// (see Optimize). Explicitly requested writes do not wait for
+	// the maintenance to finish (see tryWrite).
+	maintenanceMutex sync.RWMutex
+
+	// pendingDeletes contains documents we failed to delete during
+	// a purge (see Purge)
+	pendingDeletes *pendingDeletes
+
+	// searchCache is nil in case the caching is disabled
+	searchCache *searchCache
+
+	// subcPropsCache wraps concArchDb in case subcorpus props
+	// caching is enabled (otherwise it is nil)
+	subcPropsCache *cncdb.CachingSubcConcArchOps
+
+	// analyzerGroups contains configured analyzer groups
+	// available in the index mapping (see availableAnalyzerGroups)
+	analyzerGroups []documents.AnalyzerGroup
+
+	// numOversizedDocs counts documents reduced due to
+	// Conf.MaxIndexDocBytes since the indexer has been created
+	numOversizedDocs atomic.Uint64
+}
+
+// IndexStorageStats contains basic info about index segments
+// and its disk usage
+type IndexStorageStats struct {
+	NumFileSegments uint64 `json:"numFileSegments"`
+	OnDiskBytes     uint64 `json:"onDiskBytes"`
+}
+
+func statsMapUint(m map[string]any, key string) uint64 {
+	v, _ := m[key].(uint64)
+	return v
 }
 
 func (idx *Indexer) DocCount() (uint64, error) {
-	return idx.bleveIdx.DocCount()
+	return idx.backend.DocCount()
+}
+
+// Mapping returns the mapping of the live index. Please note that
+// it may differ from documents.CreateMapping() in case the index has been
+// created by an older version of Camus.
+func (idx *Indexer) Mapping() mapping.IndexMapping {
+	return idx.bleveIdx.Mapping()
 }
 
 func (idx *Indexer) DataPath() string {
 	return idx.dataPath
 }
 
+func (idx *Indexer) scorchIndex() (*scorch.Scorch, error) {
+	adv, err := idx.bleveIdx.Advanced()
+	if err != nil {
+		return nil, fmt.Errorf("failed to access index internals: %w", err)
+	}
+	sc, ok := adv.(*scorch.Scorch)
+	if !ok {
+		return nil, fmt.Errorf("index backend does not support this operation")
+	}
+	return sc, nil
+}
+
+// Optimize forces merging of index segments into a single one, which
+// reclaims space occupied by deleted documents. The method returns
+// storage stats before and after the merge. In case another optimization
+// is already running, ErrOptimizationInProgress is returned. During
+// the optimization, Update, Delete and IndexRecentRecords fail with
+// ErrIndexLocked.
+func (idx *Indexer) Optimize(ctx context.Context) (IndexStorageStats, IndexStorageStats, error) {
+	if !idx.optimizeMutex.TryLock() {
+		return IndexStorageStats{}, IndexStorageStats{}, ErrOptimizationInProgress
+	}
+	defer idx.optimizeMutex.Unlock()
+	idx.maintenanceMutex.Lock()
+	defer idx.maintenanceMutex.Unlock()
+	sc, err := idx.scorchIndex()
+	if err != nil {
+		return IndexStorageStats{}, IndexStorageStats{}, err
+	}
+	getStats := func() IndexStorageStats {
+		m := sc.StatsMap()
+		return IndexStorageStats{
+			NumFileSegments: statsMapUint(m, "num_root_filesegments"),
+			OnDiskBytes:     statsMapUint(m, "CurOnDiskBytes"),
+		}
+	}
+	before := getStats()
+	t0 := time.Now()
+	if err := sc.ForceMerge(ctx, nil); err != nil {
+		return before, before, fmt.Errorf("failed to optimize index: %w", err)
+	}
+	after := getStats()
+	log.Info().
+		Any("before", before).
+		Any("after", after).
+		Float64("procTime", time.Since(t0).Seconds()).
+		Msg("optimized fulltext index")
+	return before, after, nil
+}
+
 // IndexRecentRecords takes latest `numLatest` records and
 // (re)indexes them. It returns number of actually indexed
 // records and possible error. In case there are unindexable
 // records among the ones fetched for processing (which is a normal
 // - non error thing - e.g. sample, shuffle, filter,...),
 // such records are ignored.
-func (idx *Indexer) IndexRecentRecords(numLatest int) (int, error) {
-	history, err := idx.queryHistDb.LoadRecentNHistory(numLatest)
+// Records are processed from the oldest to the newest one and a checkpoint
+// (see Conf.ReindexCheckpointKey) is stored periodically. With `resume`
+// set to true, the function continues from the checkpoint of a previous
+// (interrupted) run instead (ErrNoReindexCheckpoint is returned if there
+// is no checkpoint). The checkpoint is cleared once all the records
+// are processed. In case the index becomes locked (see tryWrite), the
+// checkpoint is stored and ErrIndexLocked is returned.
+func (idx *Indexer) IndexRecentRecords(numLatest int, resume bool) (int, error) {
+	var history []cncdb.HistoryRecord
+	var err error
+	if resume {
+		history, err = idx.loadHistoryFromCheckpoint(numLatest)
+
+	} else {
+		history, err = idx.queryHistDb.LoadRecentNHistory(numLatest)
+		slices.Reverse(history)
+	}
 	if err != nil {
 		return 0, fmt.Errorf("failed to index records: %w", err)
 	}
 	var numIndexed int
-	for _, hRec := range history {
-		hRec.Rec, err = idx.GetConcRecord(hRec.QueryID)
+	var batch concRecordsBatch
+	for i, hRec := range history {
+		if i > 0 && i%reindexCheckpointInterval == 0 {
+			idx.storeCheckpoint(history[i-1].Created)
+		}
+		if i%concRecordsBatchSize == 0 {
+			batchHistory := history[i:min(i+concRecordsBatchSize, len(history))]
+			queryIDs := make([]string, len(batchHistory))
+			for j, bRec := range batchHistory {
+				queryIDs[j] = bRec.QueryID
+			}
+			batch = getConcRecords(queryIDs, idx.rdb.GetConcRecord, idx.concArchDb)
+		}
+		hRec.Rec, err = batch.get(hRec.QueryID)
 		if err != nil {
 			log.Error().Err(err).Msgf("failed to get record %s", hRec.QueryID)
 			continue
 		} else if hRec.Rec != nil {
 			log.Debug().Any("item", hRec).Msg("about to store item to Bleve index")
-			indexed, err := idx.IndexRecord(&hRec)
+			var indexed bool
+			err := idx.tryWrite(func() error {
+				var err error
+				indexed, err = idx.IndexRecord(&hRec)
+				return err
+			})
 			if !indexed && err == nil {
 				continue
 
+			} else if errors.Is(err, ErrIndexLocked) {
+				if i > 0 {
+					idx.storeCheckpoint(history[i-1].Created)
+				}
+				return numIndexed, fmt.Errorf("failed to index records: %w", err)
+
 			} else if err != nil {
 				log.Error().Err(err).Any("hRec", hRec).Msg("invalid record, skipping")
 				continue
@@ -92,13 +339,53 @@ func (idx *Indexer) IndexRecentRecords(numLatest int) (int, error) {
 			numIndexed++
 		}
 	}
+	idx.clearCheckpoint()
 	return numIndexed, nil
 }
 
+// normalizeCreated returns a record with the `created` value all the
+// importers should use. First, the timestamp is converted to seconds
+// according to the configured unit. Then, in case the timestamp is
+// still implausible and the fallback is enabled, archive record's
+// creation time is used. If nothing changes, the original record is returned.
+func (idx *Indexer) normalizeCreated(hRec *cncdb.HistoryRecord) *cncdb.HistoryRecord {
+	created, changed := normalizeTimestampUnit(hRec.Created, idx.conf.CreatedTimestampUnit)
+	if changed && idx.conf.CreatedTimestampUnit != CreatedUnitMilliseconds {
+		log.Warn().
+			Str("queryId", hRec.QueryID).
+			Int64("historyCreated", hRec.Created).
+			Int64("correctedCreated", created).
+			Msg("query history timestamp not in seconds, converted")
+	}
+	if idx.conf.FallbackToRecordCreated && !isPlausibleCreated(created) &&
+		hRec.Rec != nil && !hRec.Rec.Created.IsZero() {
+		log.Debug().
+			Str("queryId", hRec.QueryID).
+			Int64("historyCreated", hRec.Created).
+			Time("recordCreated", hRec.Rec.Created).
+			Msg("implausible query history timestamp, using archive record creation time")
+		created = hRec.Rec.Created.Unix()
+	}
+	if created == hRec.Created {
+		return hRec
+	}
+	ans := *hRec
+	ans.Created = created
+	return &ans
+}
+
 // RecToDoc converts a conc/wlist/... archive record into an indexable
-// document. In case the record is OK but of an unsupported type (e.g. "shuffle"),
-// nil document is returned along with ErrRecordNotIndexable error.
+// document. In case the record is OK but of an unsupported type (e.g. "shuffle")
+// or its primary corpus is excluded from indexing (see Conf.IsIndexedCorpus),
+// nil document is returned along with ErrUnsupportedSupertype or ErrCorpusNotIndexed
+// error respectively. A record of an indexable type without an available
+// conversion produces ErrRecordNotIndexable. Records without loaded archive
+// data (hRec.Rec == nil) produce ErrMissingRecordData.
 func (idx *Indexer) RecToDoc(hRec *cncdb.HistoryRecord) (IndexableMidDoc, error) {
+	if hRec.Rec == nil {
+		return nil, fmt.Errorf("failed to convert rec. %s to doc.: %w", hRec.QueryID, ErrMissingRecordData)
+	}
+	hRec = idx.normalizeCreated(hRec)
 	var rec cncdb.UntypedQueryRecord
 	if err := json.Unmarshal([]byte(hRec.Rec.Data), &rec); err != nil {
 		return nil, fmt.Errorf("failed to convert rec. to doc.: %w", err)
@@ -108,57 +395,158 @@ func (idx *Indexer) RecToDoc(hRec *cncdb.HistoryRecord) (IndexableMidDoc, error)
 		return nil, fmt.Errorf("failed to convert rec. to doc.: %w", err)
 	}
 	if !qstype.IsIndexable() {
+		return nil, ErrUnsupportedSupertype
+	}
+	var primaryCorpus string
+	if len(rec.Corpora) > 0 {
+		primaryCorpus = rec.Corpora[0]
+	}
+	if !idx.conf.IsIndexedCorpus(primaryCorpus) {
+		log.Debug().
+			Str("queryId", hRec.QueryID).
+			Str("corpus", primaryCorpus).
+			Msg("record skipped due to corpus indexing policy")
+		return nil, ErrCorpusNotIndexed
+	}
+	importer, ok := recordImporters[qstype]
+	if !ok {
 		return nil, ErrRecordNotIndexable
 	}
-	var ans IndexableMidDoc
-	switch qstype {
-	case cncdb.QuerySupertypeConc:
-		ans, err = importConc(&rec, qstype, hRec, idx.concArchDb)
-	case cncdb.QuerySupertypeWlist:
-		ans, err = importWlist(&rec, qstype, hRec, idx.concArchDb)
-	case cncdb.QuerySupertypeKwords:
-		ans, err = importKwords(&rec, qstype, hRec, idx.concArchDb)
-	case cncdb.QuerySupertypePquery:
-		ans, err = importPquery(&rec, qstype, hRec, idx.concArchDb, idx.rdb)
-	default:
-		err = ErrRecordNotIndexable
+	ans, err := importer(idx, &rec, hRec)
+	if err == nil {
+		idx.normalizeCorpora(ans)
 	}
 	return ans, err
 }
 
+// normalizeCorpora replaces corpora aliases in a document
+// with their canonical names (see Conf.CorpusAliases)
+func (idx *Indexer) normalizeCorpora(doc IndexableMidDoc) {
+	if len(idx.conf.CorpusAliases) == 0 {
+		return
+	}
+	canonicalize := func(corpora []string) {
+		for i, c := range corpora {
+			corpora[i] = idx.conf.CanonicalCorpus(c)
+		}
+	}
+	switch tDoc := doc.(type) {
+	case *documents.MidConc:
+		canonicalize(tDoc.Corpora)
+		for i, rq := range tDoc.RawQueries {
+			if rq.Corpus != "" {
+				tDoc.RawQueries[i].Corpus = idx.conf.CanonicalCorpus(rq.Corpus)
+			}
+		}
+	case *documents.MidWordlist:
+		canonicalize(tDoc.Corpora)
+	case *documents.MidKwords:
+		canonicalize(tDoc.Corpora)
+	case *documents.MidPQuery:
+		canonicalize(tDoc.Corpora)
+	}
+}
+
 // IndexRecord indexes a provided archive record. The returned bool
 // specifies whether the record was indexed. It is perfectly OK if
 // a provided document is not indexed and without returned error
 // as not all records we deal with are supported for indexing
 // (e.g. additional stages of concordance queries - like shuffle,
-// filter, ...)
+// filter, ...). Records which failed to be indexed or which could not
+// be converted (but may be after a conversion change) are stored for
+// a later re-indexing (see ReindexFailed).
 func (idx *Indexer) IndexRecord(hRec *cncdb.HistoryRecord) (bool, error) {
-	doc, err := idx.RecToDoc(hRec)
-	if err == ErrRecordNotIndexable {
+	ok, err := idx.indexRecord(hRec)
+	if IsPermanentlyNotIndexable(err) {
+		// such records cannot be indexed by any later attempt so we do
+		// not mark them as failed (they would only take space intended
+		// for retryable failures)
+		log.Debug().Err(err).Str("id", hRec.QueryID).Msg("skipping not indexable record")
+		return false, nil
+
+	} else if err == ErrRecordNotIndexable {
+		idx.markFailed(hRec)
 		return false, nil
 
+	} else if err != nil {
+		idx.markFailed(hRec)
+		return false, err
+	}
+	return ok, nil
+}
+
+// indexRecord is the actual implementation of IndexRecord. Unlike
+// IndexRecord, it returns ErrRecordNotIndexable (or its permanent
+// variants) for records which cannot be converted and it does not
+// store failed records.
+func (idx *Indexer) indexRecord(hRec *cncdb.HistoryRecord) (bool, error) {
+	if idx.conf.IsAnonymousUser(hRec.UserID) {
+		log.Debug().Str("id", hRec.QueryID).Msg("skipping indexing of anonymous user's record")
+		return false, nil
+	}
+	doc, err := idx.RecToDoc(hRec)
+	if errors.Is(err, ErrRecordNotIndexable) {
+		return false, err
+
 	} else if err != nil {
 		return false, fmt.Errorf("failed to index record: %w", err)
 	}
-	docToIndex := doc.AsIndexableDoc()
+	docToIndex := doc.AsIndexableDoc(idx.conf.MaxRawQueryLen)
+	idx.assignAnalyzerGroup(docToIndex)
+	idx.reduceOversizedDoc(docToIndex)
 	if zerolog.GlobalLevel() <= zerolog.DebugLevel {
 		spew.Dump(docToIndex)
 	}
-	err = idx.bleveIdx.Index(docToIndex.GetID(), docToIndex)
+	// Note: we use the ID derived from the history record (and not from
+	// the document) so it always matches IDs used for deletion
+	err = idx.backend.Index(hRec.CreateIndexID(), docToIndex)
 	if err != nil {
 		return false, fmt.Errorf("failed to index record: %w", err)
 	}
+	idx.searchCache.invalidateUser(strconv.Itoa(hRec.UserID))
 	log.Debug().Str("id", hRec.QueryID).Msg("indexed record")
 	return true, nil
 }
 
+// reduceOversizedDoc makes sure the document does not exceed
+// the configured max. size (see Conf.MaxIndexDocBytes)
+func (idx *Indexer) reduceOversizedDoc(doc documents.IndexableDoc) {
+	if idx.conf.MaxIndexDocBytes <= 0 {
+		return
+	}
+	origSize := documents.EstimateSize(doc)
+	if origSize <= idx.conf.MaxIndexDocBytes {
+		return
+	}
+	removed := documents.ReduceDoc(doc, idx.conf.MaxIndexDocBytes)
+	idx.numOversizedDocs.Add(1)
+	log.Warn().
+		Str("id", doc.GetID()).
+		Int("origSize", origSize).
+		Int("reducedSize", documents.EstimateSize(doc)).
+		Int("maxSize", idx.conf.MaxIndexDocBytes).
+		Strs("removedFields", removed).
+		Msg("indexing reduced version of an oversized document")
+}
+
+// NumOversizedDocs returns the number of documents reduced
+// due to Conf.MaxIndexDocBytes since the indexer has been created.
+// The value is not persisted so it is exposed by the running service
+// only (see Actions.IndexInfo), not by the index stats.
+func (idx *Indexer) NumOversizedDocs() uint64 {
+	return idx.numOversizedDocs.Load()
+}
+
 func (idx *Indexer) Count() (uint64, error) {
-	return idx.bleveIdx.DocCount()
+	return idx.backend.DocCount()
 }
 
 // SearchWithQuery is intended for human interface as it exposes Bleve's
 // query language (stuff like `author: "Doe" +type: fiction -subtype: romance`)
 func (idx *Indexer) SearchWithQuery(q string, limit int, order []string, fields []string) (*bleve.SearchResult, error) {
+	if err := validateOrder(order); err != nil {
+		return nil, err
+	}
 	query := bleve.NewQueryStringQuery(q)
 	search := bleve.NewSearchRequest(query)
 	search.Size = limit
@@ -172,11 +560,91 @@ func (idx *Indexer) SearchWithQuery(q string, limit int, order []string, fields
 	} else {
 		search.Fields = []string{"*"}
 	}
-	return idx.bleveIdx.Search(search)
+	return idx.backend.Search(search)
 }
 
-// Search provides a search interface for other applications
-func (idx *Indexer) Search(terms []searchedTerm, limit int, order []string, fields []string) (*bleve.SearchResult, error) {
+// termToQuery converts a searched term into a respective Bleve query.
+// In case groupAnalyzers are provided, match queries of group analyzed
+// fields are run with the default analyzer and each of the group ones.
+func termToQuery(term searchedTerm, groupAnalyzers ...string) query.Query {
+	if term.IsNumericRange() {
+		inclusive := true
+		rq := bleve.NewNumericRangeInclusiveQuery(term.Min, term.Max, &inclusive, &inclusive)
+		rq.SetField(term.Field)
+		return rq
+
+	} else if term.IsDateRange() {
+		// Note: invalid values are expected to be caught by termsToQuery,
+		// here they are treated as omitted
+		from, to, _ := term.dateRange()
+		inclusive := true
+		dq := bleve.NewDateRangeInclusiveQuery(from, to, &inclusive, &inclusive)
+		dq.SetField(term.Field)
+		return dq
+
+	} else if term.IsWildcard {
+		// Note: here we have to convert the query to lower case manually
+		// as it appears Bleve does not use respective mapping filter and
+		// our fields use 'kontext_query_analyzer' which applies lowercase
+		// conversion. Also note that this may cause problem in some edge
+		// cases as the filter's algorithm is not the same as used
+		// in strings.ToLower
+		wc := bleve.NewWildcardQuery("*" + strings.ToLower(term.Value) + "*")
+		wc.SetField(term.Field)
+		return wc
+
+	} else if term.IsExact {
+		tq := bleve.NewTermQuery(term.Value)
+		tq.SetField(exactFields[term.Field])
+		return tq
+
+	} else if slices.Contains(booleanFields, term.Field) {
+		bq := bleve.NewBoolFieldQuery(term.Value == "true")
+		bq.SetField(term.Field)
+		return bq
+
+	} else if term.IsPrefix {
+		// the same note regarding lowercase as in the wildcard case applies here
+		pq := bleve.NewPrefixQuery(strings.ToLower(term.Value))
+		pq.SetField(term.Field)
+		return pq
+	}
+	if len(groupAnalyzers) > 0 && slices.Contains(documents.GroupAnalyzedFields, term.Field) {
+		dq := bleve.NewDisjunctionQuery()
+		for _, analyzer := range append([]string{documents.QueryAnalyzer}, groupAnalyzers...) {
+			mq := bleve.NewMatchQuery(term.Value)
+			mq.SetField(term.Field)
+			mq.Analyzer = analyzer
+			dq.AddQuery(mq)
+		}
+		return dq
+	}
+	mq := bleve.NewMatchQuery(term.Value)
+	mq.SetField(term.Field)
+	return mq
+}
+
+// userScopeTerm creates a term restricting search to a specific user
+func userScopeTerm(userID string) searchedTerm {
+	return searchedTerm{
+		Field:       "user_id",
+		Value:       userID,
+		Requirement: "must",
+	}
+}
+
+// supertypeTerm creates a term restricting search to a single query supertype
+func supertypeTerm(st cncdb.QuerySupertype) searchedTerm {
+	return searchedTerm{
+		Field:       "query_supertype",
+		Value:       string(st),
+		Requirement: "must",
+	}
+}
+
+// termsToQuery combines searched terms into a boolean query
+// based on their requirements (see termToQuery for groupAnalyzers)
+func termsToQuery(terms []searchedTerm, groupAnalyzers ...string) (*query.BooleanQuery, error) {
 	boolQuery := bleve.NewBooleanQuery()
 	for _, term := range terms {
 		var addQueryFn func(m ...query.Query)
@@ -188,24 +656,40 @@ func (idx *Indexer) Search(terms []searchedTerm, limit int, order []string, fiel
 		case "should":
 			addQueryFn = boolQuery.AddShould
 		default:
-			return nil, fmt.Errorf("unexpected query object requirement: \"%s\"", term.Requirement)
-		}
-		if term.IsWildcard {
-			// Note: here we have to convert the query to lower case manually
-			// as it appears Bleve does not use respective mapping filter and
-			// our fields use 'kontext_query_analyzer' which applies lowercase
-			// conversion. Also note that this may cause problem in some edge
-			// cases as the filter's algorithm is not the same as used
-			// in strings.ToLower
-			wc := bleve.NewWildcardQuery("*" + strings.ToLower(term.Value) + "*")
-			wc.SetField(term.Field)
-			addQueryFn(wc)
-
-		} else {
-			wc := bleve.NewMatchQuery(term.Value)
-			wc.SetField(term.Field)
-			addQueryFn(wc)
+			return nil, fmt.Errorf(
+				"%w: unexpected query object requirement \"%s\"", ErrInvalidSearchTerm, term.Requirement)
+		}
+		if _, ok := exactFields[term.Field]; term.IsExact && !ok {
+			return nil, fmt.Errorf("%w: field %s does not support exact matching", ErrInvalidSearchTerm, term.Field)
 		}
+		if term.IsDateRange() {
+			if !slices.Contains(datetimeFields, term.Field) {
+				return nil, fmt.Errorf("%w: field %s does not support date ranges", ErrInvalidSearchTerm, term.Field)
+			}
+			if _, _, err := term.dateRange(); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidSearchTerm, err)
+			}
+		}
+		if term.Boost < 0 {
+			return nil, fmt.Errorf("%w: invalid boost of field %s (must be >= 0)", ErrInvalidSearchTerm, term.Field)
+		}
+		q := termToQuery(term, groupAnalyzers...)
+		if bq, ok := q.(query.BoostableQuery); ok && term.Boost > 0 {
+			bq.SetBoost(term.Boost)
+		}
+		addQueryFn(q)
+	}
+	return boolQuery, nil
+}
+
+// Search provides a search interface for other applications
+func (idx *Indexer) Search(terms []searchedTerm, limit int, order []string, fields []string) (*bleve.SearchResult, error) {
+	if err := validateOrder(order); err != nil {
+		return nil, err
+	}
+	boolQuery, err := termsToQuery(terms, idx.groupAnalyzers()...)
+	if err != nil {
+		return nil, err
 	}
 	search := bleve.NewSearchRequest(boolQuery)
 	search.Size = limit
@@ -219,7 +703,133 @@ func (idx *Indexer) Search(terms []searchedTerm, limit int, order []string, fiel
 	} else {
 		search.Fields = []string{"*"}
 	}
-	return idx.bleveIdx.Search(search)
+	return idx.backend.Search(search)
+}
+
+// SearchCached is a variant of Search for searching within documents
+// of a single user. In case the search cache is enabled, results
+// are cached (keyed by all the search arguments).
+func (idx *Indexer) SearchCached(userID string, terms []searchedTerm, limit int, order []string, fields []string) (*bleve.SearchResult, error) {
+	// copy the terms so we never modify the caller's backing array
+	terms = append(append([]searchedTerm(nil), terms...), userScopeTerm(userID))
+	if !idx.searchCache.enabled() {
+		return idx.Search(terms, limit, order, fields)
+	}
+	key := mkSearchCacheKey(userID, terms, limit, order, fields)
+	if key == "" {
+		return idx.Search(terms, limit, order, fields)
+	}
+	if res, ok := idx.searchCache.get(key); ok {
+		return res, nil
+	}
+	res, err := idx.Search(terms, limit, order, fields)
+	if err != nil {
+		return nil, err
+	}
+	idx.searchCache.set(key, userID, res)
+	return res, nil
+}
+
+// filterByMinScore returns a result with hits scored below minScore
+// removed. The original result is not modified (it may be cached).
+// Please note that scores are not comparable across different queries
+// so the threshold makes sense mostly within a single query type.
+// Also note that `total_hits` still reflects the unfiltered result.
+func filterByMinScore(res *bleve.SearchResult, minScore float64) *bleve.SearchResult {
+	if minScore <= 0 || res == nil {
+		return res
+	}
+	ans := *res
+	ans.Hits = make(search.DocumentMatchCollection, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		if hit.Score >= minScore {
+			ans.Hits = append(ans.Hits, hit)
+		}
+	}
+	return &ans
+}
+
+// SearchCacheStats returns info about the search cache usage
+func (idx *Indexer) SearchCacheStats() SearchCacheStats {
+	return idx.searchCache.stats()
+}
+
+// SubcPropsCacheStats returns info about the subcorpus props cache usage
+func (idx *Indexer) SubcPropsCacheStats() cncdb.SubcPropsCacheStats {
+	return idx.subcPropsCache.SubcPropsCacheStats()
+}
+
+// Facets returns the most frequent terms of a (facetable) field
+// among documents matching the filters. With no filters, all
+// the documents are considered.
+func (idx *Indexer) Facets(field string, size int, filters []searchedTerm) ([]FacetTerm, error) {
+	if !slices.Contains(facetableFields, field) {
+		return []FacetTerm{}, fmt.Errorf("%w: %s (facetable fields: %s)",
+			ErrFieldNotFacetable, field, strings.Join(facetableFields, ", "))
+	}
+	var q query.Query
+	if len(filters) > 0 {
+		bq, err := termsToQuery(filters, idx.groupAnalyzers()...)
+		if err != nil {
+			return []FacetTerm{}, err
+		}
+		q = bq
+
+	} else {
+		q = bleve.NewMatchAllQuery()
+	}
+	search := bleve.NewSearchRequest(q)
+	search.Size = 0
+	search.AddFacet(field, bleve.NewFacetRequest(field, size))
+	res, err := idx.backend.Search(search)
+	if err != nil {
+		return []FacetTerm{}, fmt.Errorf("failed to get facets of %s: %w", field, err)
+	}
+	ans := make([]FacetTerm, 0, size)
+	if fr, ok := res.Facets[field]; ok && fr.Terms != nil {
+		for _, t := range fr.Terms.Terms() {
+			ans = append(ans, FacetTerm{Term: t.Term, Count: t.Count})
+		}
+	}
+	return ans, nil
+}
+
+// Suggest returns up to `limit` distinct recent queries of a user
+// where either the raw query or the query name starts with `prefix`.
+// The most recent queries go first.
+func (idx *Indexer) Suggest(userID, prefix string, limit int) ([]string, error) {
+	matchPrefix := bleve.NewDisjunctionQuery(
+		termToQuery(searchedTerm{Field: "raw_query", Value: prefix, IsPrefix: true}),
+		termToQuery(searchedTerm{Field: "name", Value: prefix, IsPrefix: true}),
+	)
+	q := bleve.NewConjunctionQuery(termToQuery(userScopeTerm(userID)), matchPrefix)
+	search := bleve.NewSearchRequest(q)
+	// we fetch more items as some of them can be duplicates
+	search.Size = limit * suggestFetchMultiplier
+	search.SortBy([]string{"-created"})
+	search.Fields = []string{"raw_query"}
+	res, err := idx.backend.Search(search)
+	if err != nil {
+		return []string{}, fmt.Errorf("failed to search for suggestions: %w", err)
+	}
+	ans := make([]string, 0, limit)
+	found := make(map[string]bool)
+	for _, hit := range res.Hits {
+		rq, ok := hit.Fields["raw_query"].(string)
+		if !ok {
+			continue
+		}
+		rq = strings.TrimSpace(rq)
+		if rq == "" || found[rq] {
+			continue
+		}
+		found[rq] = true
+		ans = append(ans, rq)
+		if len(ans) >= limit {
+			break
+		}
+	}
+	return ans, nil
 }
 
 func (idx *Indexer) Update(hRec *cncdb.HistoryRecord) error {
@@ -231,12 +841,28 @@ func (idx *Indexer) Update(hRec *cncdb.HistoryRecord) error {
 	}
 	hRec.Rec = rec
 	log.Debug().Any("item", hRec).Msg("about to store item to Bleve index")
-	_, err = idx.IndexRecord(hRec)
-	return err
+	return idx.tryWrite(func() error {
+		_, err := idx.IndexRecord(hRec)
+		return err
+	})
 }
 
 func (idx *Indexer) Delete(recID string) error {
-	return idx.bleveIdx.Delete(recID)
+	return idx.tryWrite(func() error {
+		idx.searchCache.invalidateByDocID(recID)
+		return idx.backend.Delete(recID)
+	})
+}
+
+// tryWrite runs an explicitly requested index write operation. In case
+// index maintenance is in progress (see Optimize), the function does not
+// wait and returns ErrIndexLocked so the caller can retry later.
+func (idx *Indexer) tryWrite(fn func() error) error {
+	if !idx.maintenanceMutex.TryRLock() {
+		return fmt.Errorf("%w: index maintenance in progress", ErrIndexLocked)
+	}
+	defer idx.maintenanceMutex.RUnlock()
+	return fn()
 }
 
 func (idx *Indexer) GetConcRecord(queryID string) (*cncdb.ArchRecord, error) {
@@ -259,14 +885,95 @@ func (idx *Indexer) GetConcRecord(queryID string) (*cncdb.ArchRecord, error) {
 	return &rec, nil
 }
 
+// concRecordsBatch contains conc records loaded by getConcRecords.
+// IDs present neither in recs nor in failed refer to records
+// which are gone.
+type concRecordsBatch struct {
+	recs   map[string]*cncdb.ArchRecord
+	failed map[string]error
+}
+
+// get returns a record of the batch with the same semantics
+// as Indexer.GetConcRecord has
+func (b concRecordsBatch) get(queryID string) (*cncdb.ArchRecord, error) {
+	if err, ok := b.failed[queryID]; ok {
+		return nil, err
+	}
+	rec, ok := b.recs[queryID]
+	if !ok {
+		return nil, nil
+	}
+	// the same query may occur in the history multiple times
+	// so each caller gets its own copy
+	ans := *rec
+	return &ans, nil
+}
+
+// getConcRecords is a batch variant of GetConcRecord intended for
+// bulk (re)indexing. Records are loaded from Redis one by one (using
+// getFromRedis) and all the Redis misses are then loaded from MySQL
+// at once so historical records aged out of Redis do not cost us
+// one MySQL query per record.
+func getConcRecords(
+	queryIDs []string,
+	getFromRedis func(id string) (cncdb.ArchRecord, error),
+	db cncdb.IConcArchOps,
+) concRecordsBatch {
+	ans := concRecordsBatch{
+		recs:   make(map[string]*cncdb.ArchRecord),
+		failed: make(map[string]error),
+	}
+	missing := make([]string, 0, len(queryIDs))
+	processed := make(map[string]bool)
+	for _, queryID := range queryIDs {
+		if processed[queryID] {
+			continue
+		}
+		processed[queryID] = true
+		rec, err := getFromRedis(queryID)
+		if err == cncdb.ErrRecordNotFound {
+			missing = append(missing, queryID)
+
+		} else if err != nil {
+			ans.failed[queryID] = fmt.Errorf("failed to process query %s: %w", queryID, err)
+
+		} else {
+			ans.recs[queryID] = &rec
+		}
+	}
+	if len(missing) == 0 {
+		return ans
+	}
+	log.Info().Int("numRecords", len(missing)).Msg("records not found in Redis, trying MySQL")
+	recs, err := db.LoadRecordsByIDs(missing)
+	if err != nil {
+		for _, queryID := range missing {
+			ans.failed[queryID] = fmt.Errorf("failed to load query %s from MySQL: %w", queryID, err)
+		}
+		return ans
+	}
+	for _, queryID := range missing {
+		if len(recs[queryID]) == 0 {
+			log.Warn().Str("queryId", queryID).Msg("record is gone - cannot process, ignoring")
+			continue
+		}
+		ans.recs[queryID] = &recs[queryID][0]
+	}
+	return ans
+}
+
 // Start initializes and runs Indexer
 func (idx *Indexer) Start(ctx context.Context) {
 	go func() {
+		retryTicker := time.NewTicker(pendingDeleteRetryInterval)
+		defer retryTicker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
 				log.Info().Msg("about to close ArchKeeper")
 				return
+			case <-retryTicker.C:
+				idx.retryPendingDeletes()
 			case hRec := <-idx.recsToIndex:
 				if _, err := idx.IndexRecord(&hRec); err != nil {
 					log.Error().Err(err).Any("hRec", hRec).Msg("unable to index record")
@@ -281,6 +988,112 @@ func (idx *Indexer) Stop(ctx context.Context) error {
 	return nil
 }
 
+// asIndexLockedErr converts errors signalling that the index is held
+// by another process (i.e. a timeout when waiting for the index lock
+// when opening the index) into ErrIndexLocked. Other errors are returned
+// unchanged.
+// Please note that e.g. a closed index (bleve.ErrorIndexClosed) is not
+// considered locked as waiting for it makes no sense.
+func asIndexLockedErr(err error) error {
+	if errors.Is(err, bolt.ErrTimeout) {
+		return fmt.Errorf("%w: %w", ErrIndexLocked, err)
+	}
+	return err
+}
+
+// normalizeStopWords creates a canonical (lowercase, sorted) form
+// of a stop words list so it can be compared with the stored one
+func normalizeStopWords(words []string) []string {
+	ans := make([]string, len(words))
+	for i, w := range words {
+		ans[i] = strings.ToLower(w)
+	}
+	slices.Sort(ans)
+	return slices.Compact(ans)
+}
+
+// storeLabelStopWords stores the stop words used in the index mapping
+// to the index metadata
+func storeLabelStopWords(bleveIdx bleve.Index, words []string) error {
+	data, err := json.Marshal(normalizeStopWords(words))
+	if err != nil {
+		return fmt.Errorf("failed to store label stop words: %w", err)
+	}
+	if err := bleveIdx.SetInternal([]byte(labelStopWordsMetaKey), data); err != nil {
+		return fmt.Errorf("failed to store label stop words: %w", err)
+	}
+	return nil
+}
+
+// checkLabelStopWords warns in case the configured stop words
+// differ from the ones the index has been created with. The mapping
+// of an existing index cannot be changed so the index must be
+// re-created for the new list to take effect.
+func checkLabelStopWords(bleveIdx bleve.Index, words []string) {
+	data, err := bleveIdx.GetInternal([]byte(labelStopWordsMetaKey))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load label stop words from index metadata")
+		return
+	}
+	stored := []string{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &stored); err != nil {
+			log.Error().Err(err).Msg("failed to load label stop words from index metadata")
+			return
+		}
+	}
+	if !slices.Equal(stored, normalizeStopWords(words)) {
+		log.Warn().
+			Strs("indexStopWords", stored).
+			Strs("configuredStopWords", words).
+			Msg("configured `labelStopWords` differ from the ones used by the index; " +
+				"the index must be re-created for the change to take effect")
+	}
+}
+
+// availableAnalyzerGroups returns configured analyzer groups present
+// in the mapping of an index. Groups added after the index has been created
+// are ignored (with a warning) as the mapping cannot be changed.
+func availableAnalyzerGroups(bleveIdx bleve.Index, groups []documents.AnalyzerGroup) []documents.AnalyzerGroup {
+	ans := make([]documents.AnalyzerGroup, 0, len(groups))
+	for _, group := range groups {
+		if bleveIdx.Mapping().AnalyzerNamed(documents.GroupQueryAnalyzer(group.Name)) == nil {
+			log.Warn().
+				Str("group", group.Name).
+				Msg("analyzer group not found in the index mapping, ignoring; " +
+					"the index must be re-created for the group to take effect")
+			continue
+		}
+		ans = append(ans, group)
+	}
+	return ans
+}
+
+// assignAnalyzerGroup sets an analyzer group of a document
+// based on its primary corpus. The first matching group is used.
+func (idx *Indexer) assignAnalyzerGroup(doc documents.IndexableDoc) {
+	gdoc, ok := doc.(documents.AnalyzerGroupDoc)
+	if !ok {
+		return
+	}
+	corpname := gdoc.PrimaryCorpus()
+	for _, group := range idx.analyzerGroups {
+		if group.MatchesCorpus(corpname) {
+			gdoc.SetAnalyzerGroup(group.Name)
+			return
+		}
+	}
+}
+
+// groupAnalyzers returns names of query analyzers of available analyzer groups
+func (idx *Indexer) groupAnalyzers() []string {
+	ans := make([]string, len(idx.analyzerGroups))
+	for i, group := range idx.analyzerGroups {
+		ans[i] = documents.GroupQueryAnalyzer(group.Name)
+	}
+	return ans
+}
+
 func NewIndexer(
 	conf *Conf,
 	concArchDb cncdb.IConcArchOps,
@@ -288,9 +1101,9 @@ func NewIndexer(
 	rdb *archiver.RedisAdapter,
 	recsToIndex <-chan cncdb.HistoryRecord,
 ) (*Indexer, error) {
-	bleveIdx, err := bleve.Open(conf.IndexDirPath)
+	bleveIdx, err := openWithProgress(conf.IndexDirPath)
 	if err == bleve.ErrorIndexMetaMissing || err == bleve.ErrorIndexPathDoesNotExist {
-		mapping, err := documents.CreateMapping()
+		mapping, err := documents.CreateMapping(conf.LabelStopWords, conf.AnalyzerGroups...)
 		if err != nil {
 			return nil, err
 		}
@@ -298,19 +1111,48 @@ func NewIndexer(
 		if err != nil {
 			return nil, fmt.Errorf("failed to create new index: %w", err)
 		}
+		if err := storeLabelStopWords(bleveIdx, conf.LabelStopWords); err != nil {
+			return nil, fmt.Errorf("failed to create new index: %w", err)
+		}
 
 	} else if err != nil {
 		return nil, fmt.Errorf("failed to open index: %w", err)
+
+	} else {
+		checkLabelStopWords(bleveIdx, conf.LabelStopWords)
+	}
+	backend, err := newBackend(conf, bleveIdx)
+	if err != nil {
+		bleveIdx.Close()
+		return nil, fmt.Errorf("failed to initialize index backend: %w", err)
+	}
+	ans := &Indexer{
+		conf:           conf,
+		concArchDb:     concArchDb,
+		queryHistDb:    queryHistDb,
+		rdb:            rdb,
+		bleveIdx:       bleveIdx,
+		backend:        backend,
+		recsToIndex:    recsToIndex,
+		dataPath:       conf.IndexDirPath,
+		pendingDeletes: newPendingDeletes(),
+		analyzerGroups: availableAnalyzerGroups(bleveIdx, conf.AnalyzerGroups),
+	}
+	if rdb != nil {
+		ans.pendingDeletes = newPersistentPendingDeletes(rdb, conf.PendingDeletesKey)
+	}
+	if conf.SubcPropsCacheSize > 0 {
+		ans.subcPropsCache = cncdb.NewCachingSubcConcArchOps(
+			concArchDb, conf.SubcPropsCacheSize, conf.SubcPropsCacheTTLDur())
+		ans.concArchDb = ans.subcPropsCache
+	}
+	if conf.IsLenientSubcPropsLookup() {
+		ans.concArchDb = &lenientSubcConcArchOps{IConcArchOps: ans.concArchDb}
+	}
+	if conf.SearchCacheSize > 0 {
+		ans.searchCache = newSearchCache(conf.SearchCacheSize, conf.SearchCacheTTLDur())
 	}
-	return &Indexer{
-		conf:        conf,
-		concArchDb:  concArchDb,
-		queryHistDb: queryHistDb,
-		rdb:         rdb,
-		bleveIdx:    bleveIdx,
-		recsToIndex: recsToIndex,
-		dataPath:    conf.IndexDirPath,
-	}, nil
+	return ans, nil
 }
 
 type asyncIndexerRes struct {
@@ -334,8 +1176,11 @@ func NewIndexerOrDie(
 	select {
 	case ans := <-resultChan:
 		return ans.value, ans.err
-	case <-time.After(time.Second * 10):
-		fmt.Println("Failed to open index due to timeout. The index is likely in use.")
+	case <-time.After(conf.IndexOpenTimeoutDur()):
+		fmt.Printf(
+			"Failed to open index due to timeout (%s). The index is likely in use.\n",
+			conf.IndexOpenTimeout,
+		)
 		os.Exit(10)
 	}
 	return nil, fmt.Errorf("failed to open index - unknown error")
@@ -19,12 +19,18 @@ package indexer
 
 import (
 	"camus/cncdb"
+	"camus/indexer/documents"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search"
 	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
 )
 
 func prepareIndexer() *Indexer {
@@ -99,3 +105,1502 @@ func TestEscaping(t *testing.T) {
 
 	cleanData(idxer.DataPath())
 }
+
+func indexTestConc(t *testing.T, idxer *Indexer, id string, userID int, query string) {
+	created := time.Now()
+	form := map[string]any{
+		"form_type":           "query",
+		"curr_query_types":    map[string]string{"corp1": "advanced"},
+		"curr_queries":        map[string]string{"corp1": query},
+		"selected_text_types": map[string][]string{},
+	}
+	rawForm, err := json.Marshal(unspecifiedQueryRecord{ID: id, LastopForm: form})
+	if err != nil {
+		panic(err)
+	}
+	ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+		QueryID: id,
+		Created: created.Unix(),
+		UserID:  userID,
+		Rec: &cncdb.ArchRecord{
+			ID:         id,
+			Data:       string(rawForm),
+			Created:    created,
+			LastAccess: created,
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestUserIDNumericRange(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	indexTestConc(t, idxer, "q1", 5, "[word=\"foo\"]")
+	indexTestConc(t, idxer, "q2", 1500, "[word=\"foo\"]")
+	indexTestConc(t, idxer, "q3", 2001, "[word=\"foo\"]")
+
+	minUID, maxUID := 1000.0, 2000.0
+	result, err := idxer.Search(
+		[]searchedTerm{
+			{Field: "user_id_num", Requirement: "must", Min: &minUID, Max: &maxUID},
+		},
+		10, nil, []string{"id"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Hits.Len())
+	assert.Equal(t, "q2", result.Hits[0].Fields["id"])
+}
+
+func TestAnonymousUserNotIndexed(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	anonID := 0
+	idxer.conf.AnonymousUserID = &anonID
+
+	rawForm, err := json.Marshal(unspecifiedQueryRecord{
+		ID: "anon1",
+		LastopForm: map[string]any{
+			"form_type":        "query",
+			"curr_query_types": map[string]string{"corp1": "advanced"},
+			"curr_queries":     map[string]string{"corp1": "[word=\"foo\"]"},
+		},
+	})
+	assert.NoError(t, err)
+	ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+		QueryID: "anon1",
+		Created: time.Now().Unix(),
+		UserID:  anonID,
+		Rec:     &cncdb.ArchRecord{ID: "anon1", Data: string(rawForm)},
+	})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	v, err := idxer.DocCount()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), v)
+}
+
+func TestSuggest(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	indexTestConc(t, idxer, "q1", 1, "[lemma=\"dog\"]")
+	indexTestConc(t, idxer, "q2", 1, "[word=\"cat\"]")
+	indexTestConc(t, idxer, "q3", 2, "[lemma=\"house\"]")
+
+	ans, err := idxer.Suggest("1", "[LEMMA", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"[lemma=\"dog\"]"}, ans)
+}
+
+func TestSearchInvalidOrder(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	_, err := idxer.SearchWithQuery("foo", 10, []string{"-created", "-raw_query"}, nil)
+	assert.ErrorIs(t, err, ErrInvalidSortField)
+	_, err = idxer.Search([]searchedTerm{}, 10, []string{"-_score", "user_id"}, nil)
+	assert.NoError(t, err)
+}
+
+func TestPurgeRemovesAllRelatedDocs(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	indexTestConc(t, idxer, "purgeme", 1, "[word=\"foo\"]")
+	indexTestConc(t, idxer, "purgeme", 2, "[word=\"foo\"]")
+	indexTestConc(t, idxer, "keepme", 1, "[word=\"bar\"]")
+
+	summary, err := idxer.Purge("purgeme")
+	assert.NoError(t, err)
+	assert.Len(t, summary.DeletedIndexDocs, 2)
+	assert.Empty(t, summary.PendingRetryIndexDocs)
+	cnt, err := idxer.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), cnt)
+}
+
+func TestFacets(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	indexTestConc(t, idxer, "fc1", 1, "[word=\"foo\"]")
+	indexTestConc(t, idxer, "fc2", 1, "[word=\"bar\" & lemma=\"bar\"]")
+	indexTestConc(t, idxer, "fc3", 2, "[word=\"baz\"]")
+
+	terms, err := idxer.Facets("pos_attr_names", 10, []searchedTerm{})
+	assert.NoError(t, err)
+	assert.Equal(t, []FacetTerm{{Term: "word", Count: 3}, {Term: "lemma", Count: 1}}, terms)
+
+	terms, err = idxer.Facets("pos_attr_names", 10, []searchedTerm{userScopeTerm("2")})
+	assert.NoError(t, err)
+	assert.Equal(t, []FacetTerm{{Term: "word", Count: 1}}, terms)
+
+	_, err = idxer.Facets("raw_query", 10, []searchedTerm{})
+	assert.ErrorIs(t, err, ErrFieldNotFacetable)
+}
+
+func TestWordlistFilterWords(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	created := time.Now()
+	rawForm, err := json.Marshal(unspecifiedQueryRecord{
+		ID:      "wl1",
+		Corpora: []string{"corp1"},
+		Form: map[string]any{
+			"form_type":     "wlist",
+			"wlattr":        "lemma",
+			"wlpat":         ".*",
+			"pfilter_words": []string{"Dog", " cat"},
+			"nfilter_words": []string{"house"},
+		},
+	})
+	assert.NoError(t, err)
+	ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+		QueryID: "wl1",
+		Created: created.Unix(),
+		UserID:  1,
+		Rec:     &cncdb.ArchRecord{ID: "wl1", Data: string(rawForm), Created: created},
+	})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	minCount := 2.0
+	result, err := idxer.Search(
+		[]searchedTerm{
+			{Field: "pfilter_count", Requirement: "must", Min: &minCount},
+			{Field: "pfilter_words", Value: "cat", Requirement: "must"},
+		},
+		10, nil, []string{"id", "nfilter_count"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Hits.Len())
+	assert.Equal(t, 1.0, result.Hits[0].Fields["nfilter_count"])
+
+	result, err = idxer.Search(
+		[]searchedTerm{{Field: "nfilter_words", Value: "dog", Requirement: "must"}},
+		10, nil, []string{"id"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Hits.Len())
+}
+
+func TestPQueryFreqBounds(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	created := time.Now()
+	for id, form := range map[string]map[string]any{
+		"pq1": {"form_type": "pquery", "conc_ids": []string{}, "min_fq": 10, "max_rel_fq": 2.5},
+		"pq2": {"form_type": "pquery", "conc_ids": []string{}},
+	} {
+		rawForm, err := json.Marshal(unspecifiedQueryRecord{
+			ID:      id,
+			Corpora: []string{"corp1"},
+			Form:    form,
+		})
+		assert.NoError(t, err)
+		ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+			QueryID: id,
+			Created: created.Unix(),
+			UserID:  1,
+			Rec:     &cncdb.ArchRecord{ID: id, Data: string(rawForm), Created: created},
+		})
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	minFreq := 5.0
+	result, err := idxer.Search(
+		[]searchedTerm{{Field: "min_fq", Requirement: "must", Min: &minFreq}},
+		10, nil, []string{"id", "max_rel_fq", "max_fq"},
+	)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, result.Hits.Len()) {
+		assert.Equal(t, "pq1", result.Hits[0].Fields["id"])
+		assert.Equal(t, 2.5, result.Hits[0].Fields["max_rel_fq"])
+		assert.NotContains(t, result.Hits[0].Fields, "max_fq")
+	}
+}
+
+func TestSearchCorporaExact(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	created := time.Now()
+	for id, corp := range map[string]string{"c1": "syn2020", "c2": "syn2020v2"} {
+		rawForm, err := json.Marshal(unspecifiedQueryRecord{
+			ID:      id,
+			Corpora: []string{corp},
+			Form: map[string]any{
+				"form_type": "wlist",
+				"wlattr":    "lemma",
+				"wlpat":     ".*",
+			},
+		})
+		assert.NoError(t, err)
+		ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+			QueryID: id,
+			Created: created.Unix(),
+			UserID:  1,
+			Rec:     &cncdb.ArchRecord{ID: id, Data: string(rawForm), Created: created},
+		})
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	result, err := idxer.Search(
+		[]searchedTerm{{Field: "corpora", Value: "syn2020", Requirement: "must", IsExact: true}},
+		10, nil, []string{"id"},
+	)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, result.Hits.Len()) {
+		assert.Equal(t, "c1", result.Hits[0].Fields["id"])
+	}
+
+	_, err = idxer.Search(
+		[]searchedTerm{{Field: "subcorpus", Value: "foo", Requirement: "must", IsExact: true}},
+		10, nil, []string{"id"},
+	)
+	assert.Error(t, err)
+}
+
+func TestSearchCached(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	idxer.searchCache = newSearchCache(10, time.Minute)
+
+	indexTestConc(t, idxer, "sc1", 1, "[word=\"foo\"]")
+	terms := []searchedTerm{{Field: "pos_attr_values", Value: "foo", Requirement: "must"}}
+
+	result, err := idxer.SearchCached("1", terms, 10, nil, []string{"id"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Hits.Len())
+	result, err = idxer.SearchCached("1", terms, 10, nil, []string{"id"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Hits.Len())
+	stats := idxer.SearchCacheStats()
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses)
+
+	// indexing user's new document must invalidate the cached result
+	indexTestConc(t, idxer, "sc2", 1, "[word=\"foo\"]")
+	result, err = idxer.SearchCached("1", terms, 10, nil, []string{"id"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Hits.Len())
+	assert.Equal(t, int64(2), idxer.SearchCacheStats().Misses)
+}
+
+func TestSearchCachedKeepsCallerTerms(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	indexTestConc(t, idxer, "sc1", 1, "[word=\"foo\"]")
+	terms := make([]searchedTerm, 1, 4)
+	terms[0] = searchedTerm{Field: "pos_attr_values", Value: "foo", Requirement: "must"}
+	_, err := idxer.SearchCached("1", terms, 10, nil, []string{"id"})
+	assert.NoError(t, err)
+	assert.Len(t, terms, 1)
+	assert.Equal(t, searchedTerm{}, terms[:2][1])
+}
+
+func TestIndexRecordMissingRec(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+		QueryID: "norec",
+		Created: time.Now().Unix(),
+		UserID:  1,
+	})
+	assert.ErrorIs(t, err, ErrMissingRecordData)
+	assert.False(t, ok)
+}
+
+func TestMappingSerializable(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	data, err := json.Marshal(idxer.Mapping())
+	assert.NoError(t, err)
+	var m map[string]any
+	assert.NoError(t, json.Unmarshal(data, &m))
+	types, ok := m["types"].(map[string]any)
+	assert.True(t, ok)
+	assert.Contains(t, types, "conc")
+	assert.Contains(t, types, "wlist")
+}
+
+func TestIndexSlowFlag(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	created := time.Now()
+	rawForm, err := json.Marshal(map[string]any{
+		"id":                  "slow1",
+		"treat_as_slow_query": true,
+		"lastop_form": map[string]any{
+			"form_type":        "query",
+			"curr_query_types": map[string]string{"corp1": "advanced"},
+			"curr_queries":     map[string]string{"corp1": "[word=\".*\"]"},
+		},
+	})
+	assert.NoError(t, err)
+	ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+		QueryID: "slow1",
+		Created: created.Unix(),
+		UserID:  1,
+		Rec:     &cncdb.ArchRecord{ID: "slow1", Data: string(rawForm), Created: created},
+	})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	indexTestConc(t, idxer, "fast1", 1, "[word=\"foo\"]")
+
+	result, err := idxer.Search(
+		[]searchedTerm{{Field: "is_slow", Value: "true", Requirement: "must"}},
+		10, nil, []string{"id"},
+	)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, result.Hits.Len()) {
+		assert.Equal(t, "slow1", result.Hits[0].Fields["id"])
+	}
+}
+
+func TestSearchRawQueryByCorpus(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	created := time.Now()
+	rawForm, err := json.Marshal(map[string]any{
+		"id": "par1",
+		"lastop_form": map[string]any{
+			"form_type": "query",
+			"curr_query_types": map[string]string{
+				"intercorp_cs": "advanced",
+				"intercorp_de": "advanced",
+			},
+			"curr_queries": map[string]string{
+				"intercorp_cs": "[word=\"dům\"]",
+				"intercorp_de": "[word=\"Haus\"]",
+			},
+		},
+	})
+	assert.NoError(t, err)
+	ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+		QueryID: "par1",
+		Created: created.Unix(),
+		UserID:  1,
+		Rec:     &cncdb.ArchRecord{ID: "par1", Data: string(rawForm), Created: created},
+	})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	result, err := idxer.Search(
+		[]searchedTerm{{Field: "raw_query_by_corpus", Value: "intercorp_de:[word=\"Haus\"]", Requirement: "must"}},
+		10, nil, []string{"id"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Hits.Len())
+
+	result, err = idxer.Search(
+		[]searchedTerm{{Field: "raw_query_by_corpus", Value: "intercorp_cs:[word=\"Haus\"]", Requirement: "must"}},
+		10, nil, []string{"id"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Hits.Len())
+}
+
+func TestSearchBySupertype(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	indexTestConc(t, idxer, "conc1", 1, "[word=\"foo\"]")
+
+	result, err := idxer.SearchCached(
+		"1", []searchedTerm{supertypeTerm(cncdb.QuerySupertypeConc)}, 10, nil, []string{"id"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Hits.Len())
+
+	result, err = idxer.SearchCached(
+		"1", []searchedTerm{supertypeTerm(cncdb.QuerySupertypeWlist)}, 10, nil, []string{"id"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Hits.Len())
+}
+
+func TestFilterByMinScore(t *testing.T) {
+	res := &bleve.SearchResult{
+		Hits: search.DocumentMatchCollection{
+			{ID: "a", Score: 2.5},
+			{ID: "b", Score: 0.3},
+			{ID: "c", Score: 1.0},
+		},
+	}
+	filtered := filterByMinScore(res, 1.0)
+	assert.Equal(t, 2, filtered.Hits.Len())
+	assert.Equal(t, "a", filtered.Hits[0].ID)
+	assert.Equal(t, "c", filtered.Hits[1].ID)
+	// the original (possibly cached) result must be kept intact
+	assert.Equal(t, 3, res.Hits.Len())
+	assert.Same(t, res, filterByMinScore(res, 0))
+}
+
+func TestLabelStopWords(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-index")
+	assert.NoError(t, err)
+	defer cleanData(tempDir)
+	conf := Conf{
+		IndexDirPath:            tempDir,
+		QueryHistoryNumPreserve: 100,
+		LabelStopWords:          []string{"V2"},
+	}
+	idxer, err := NewIndexer(&conf, &cncdb.DummyConcArchSQL{}, &cncdb.MySQLQueryHistDryRun{}, nil, nil)
+	assert.NoError(t, err)
+
+	created := time.Now()
+	rawForm, err := json.Marshal(unspecifiedQueryRecord{
+		ID:      "sw1",
+		Corpora: []string{"syn", "v2"},
+		LastopForm: map[string]any{
+			"form_type":        "query",
+			"curr_query_types": map[string]string{"syn": "advanced"},
+			"curr_queries":     map[string]string{"syn": "[word=\"foo\"]"},
+		},
+	})
+	assert.NoError(t, err)
+	ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+		QueryID: "sw1",
+		Created: created.Unix(),
+		UserID:  1,
+		Rec:     &cncdb.ArchRecord{ID: "sw1", Data: string(rawForm), Created: created},
+	})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	for value, numHits := range map[string]int{"syn": 1, "v2": 0} {
+		result, err := idxer.Search(
+			[]searchedTerm{{Field: "corpora", Value: value, Requirement: "must"}},
+			10, nil, []string{"id"},
+		)
+		assert.NoError(t, err)
+		assert.Equal(t, numHits, result.Hits.Len(), value)
+	}
+	data, err := idxer.bleveIdx.GetInternal([]byte(labelStopWordsMetaKey))
+	assert.NoError(t, err)
+	assert.Equal(t, `["v2"]`, string(data))
+}
+
+func TestIndexClosedIsNotLocked(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	assert.NoError(t, idxer.bleveIdx.Close())
+
+	rawForm, err := json.Marshal(unspecifiedQueryRecord{
+		ID: "locked1",
+		LastopForm: map[string]any{
+			"form_type":        "query",
+			"curr_query_types": map[string]string{"corp1": "advanced"},
+			"curr_queries":     map[string]string{"corp1": "[word=\"foo\"]"},
+		},
+	})
+	assert.NoError(t, err)
+	ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+		QueryID: "locked1",
+		Created: time.Now().Unix(),
+		UserID:  1,
+		Rec:     &cncdb.ArchRecord{ID: "locked1", Data: string(rawForm)},
+	})
+	assert.False(t, ok)
+	assert.ErrorIs(t, err, bleve.ErrorIndexClosed)
+	assert.NotErrorIs(t, err, ErrIndexLocked)
+	err = idxer.Delete("1/1/locked1")
+	assert.ErrorIs(t, err, bleve.ErrorIndexClosed)
+	assert.NotErrorIs(t, err, ErrIndexLocked)
+}
+
+func TestSearchStructAttrPairs(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	created := time.Now()
+	for id, tt := range map[string]map[string][]string{
+		"tt1": {"doc.txtype": {"fiction"}, "doc.pubyear": {"2020"}},
+		"tt2": {"doc.txtype": {"poetry"}, "doc.genre": {"fiction"}},
+	} {
+		rawForm, err := json.Marshal(map[string]any{
+			"id": id,
+			"lastop_form": map[string]any{
+				"form_type":           "query",
+				"curr_query_types":    map[string]string{"syn2020": "simple"},
+				"curr_queries":        map[string]string{"syn2020": "dům"},
+				"selected_text_types": tt,
+			},
+		})
+		assert.NoError(t, err)
+		ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+			QueryID: id,
+			Created: created.Unix(),
+			UserID:  1,
+			Rec:     &cncdb.ArchRecord{ID: id, Data: string(rawForm), Created: created},
+		})
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	// flattened values do not preserve the association
+	result, err := idxer.SearchWithQuery("+struct_attr_values:fiction", 10, nil, []string{"id"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Hits.Len())
+
+	result, err = idxer.SearchWithQuery("+struct_attr_pairs:doc.txtype=fiction", 10, nil, []string{"id"})
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, result.Hits.Len()) {
+		assert.Equal(t, "tt1", result.Hits[0].Fields["id"])
+	}
+
+	result, err = idxer.Search(
+		[]searchedTerm{
+			{Field: "struct_attr_pairs", Value: "doc.genre=fiction", Requirement: "must"},
+			{Field: "struct_attr_pairs", Value: "doc.txtype=poetry", Requirement: "must"},
+		},
+		10, nil, []string{"id"},
+	)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, result.Hits.Len()) {
+		assert.Equal(t, "tt2", result.Hits[0].Fields["id"])
+	}
+}
+
+func TestCorpusAliasesNormalized(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	idxer.conf.CorpusAliases = map[string]string{"syn2020_v2": "syn2020"}
+
+	created := time.Now()
+	rawForm, err := json.Marshal(unspecifiedQueryRecord{
+		ID:      "a1",
+		Corpora: []string{"syn2020_v2"},
+		Form: map[string]any{
+			"form_type": "wlist",
+			"wlattr":    "lemma",
+			"wlpat":     ".*",
+		},
+	})
+	assert.NoError(t, err)
+	ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+		QueryID: "a1",
+		Created: created.Unix(),
+		UserID:  1,
+		Rec:     &cncdb.ArchRecord{ID: "a1", Data: string(rawForm), Created: created},
+	})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	result, err := idxer.Search(
+		[]searchedTerm{{Field: "corpora", Value: "syn2020", Requirement: "must", IsExact: true}},
+		10, nil, []string{"id"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Hits.Len())
+
+	result, err = idxer.Search(
+		[]searchedTerm{{Field: "corpora", Value: "syn2020_v2", Requirement: "must", IsExact: true}},
+		10, nil, []string{"id"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Hits.Len())
+}
+
+func TestCorpusAliasesValidation(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-index")
+	assert.NoError(t, err)
+	defer cleanData(tempDir)
+	mkConf := func(aliases map[string]string) *Conf {
+		return &Conf{
+			IndexDirPath:                    tempDir,
+			QueryHistoryNumPreserve:         100,
+			QueryHistoryCleanupInterval:     "1m",
+			QueryHistoryMarkPendingInterval: "1h",
+			QueryHistoryMaxNumDeleteAtOnce:  10,
+			CorpusAliases:                   aliases,
+		}
+	}
+	conf := mkConf(map[string]string{"syn2020_v2": "syn2020", "syn2020": "syn2020"})
+	assert.NoError(t, conf.ValidateAndDefaults())
+	assert.Equal(t, "syn2020", conf.CanonicalCorpus("syn2020_v2"))
+	assert.Equal(t, "syn2015", conf.CanonicalCorpus("syn2015"))
+	assert.Error(t, mkConf(map[string]string{"a": "b", "b": "c"}).ValidateAndDefaults())
+	assert.Error(t, mkConf(map[string]string{"a": ""}).ValidateAndDefaults())
+}
+
+func TestIndexRecentRecordsResumeWithoutCheckpoint(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	_, err := idxer.IndexRecentRecords(10, true)
+	assert.ErrorIs(t, err, ErrNoReindexCheckpoint)
+}
+
+func TestSearchBySubcorpusID(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	created := time.Now()
+	rawForm, err := json.Marshal(map[string]any{
+		"id":         "sub1",
+		"usesubcorp": "Ab3xKq9ZtPw",
+		"lastop_form": map[string]any{
+			"form_type":        "query",
+			"curr_query_types": map[string]string{"syn2020": "advanced"},
+			"curr_queries":     map[string]string{"syn2020": "[word=\"dům\"]"},
+		},
+	})
+	assert.NoError(t, err)
+	ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+		QueryID: "sub1",
+		Created: created.Unix(),
+		UserID:  1,
+		Rec:     &cncdb.ArchRecord{ID: "sub1", Data: string(rawForm), Created: created},
+	})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	result, err := idxer.SearchWithQuery("+subcorpus_id:Ab3xKq9ZtPw", 10, nil, []string{"id"})
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, result.Hits.Len()) {
+		assert.Equal(t, "sub1", result.Hits[0].Fields["id"])
+	}
+
+	// the field is not analyzed
+	result, err = idxer.SearchWithQuery("+subcorpus_id:ab3xkq9ztpw", 10, nil, []string{"id"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Hits.Len())
+}
+
+type mapConcDB map[string]cncdb.ArchRecord
+
+func (db mapConcDB) GetConcRecord(id string) (cncdb.ArchRecord, error) {
+	ans, ok := db[id]
+	if !ok {
+		return cncdb.ArchRecord{}, cncdb.ErrRecordNotFound
+	}
+	return ans, nil
+}
+
+func TestImportPqueryMaxSubconc(t *testing.T) {
+	concs := make(mapConcDB)
+	concIDs := make([]string, 5)
+	for i := range concIDs {
+		concIDs[i] = fmt.Sprintf("conc%d", i)
+		rawConc, err := json.Marshal(unspecifiedQueryRecord{
+			ID:      concIDs[i],
+			Corpora: []string{"corp1"},
+			LastopForm: map[string]any{
+				"form_type":           "query",
+				"curr_query_types":    map[string]string{"corp1": "advanced"},
+				"curr_queries":        map[string]string{"corp1": fmt.Sprintf("[word=\"w%d\"]", i)},
+				"selected_text_types": map[string][]string{},
+			},
+		})
+		assert.NoError(t, err)
+		concs[concIDs[i]] = cncdb.ArchRecord{ID: concIDs[i], Data: string(rawConc)}
+	}
+	rawForm, err := json.Marshal(unspecifiedQueryRecord{
+		ID:      "pq1",
+		Corpora: []string{"corp1"},
+		Form:    map[string]any{"form_type": "pquery", "conc_ids": concIDs},
+	})
+	assert.NoError(t, err)
+	hRec := &cncdb.HistoryRecord{
+		QueryID: "pq1",
+		Created: time.Now().Unix(),
+		UserID:  1,
+		Rec:     &cncdb.ArchRecord{ID: "pq1", Data: string(rawForm)},
+	}
+	var rec cncdb.UntypedQueryRecord
+	assert.NoError(t, json.Unmarshal([]byte(hRec.Rec.Data), &rec))
+
+	doc, err := importPquery(&rec, cncdb.QuerySupertypePquery, hRec, &cncdb.DummyConcArchSQL{}, concs, 2, false)
+	assert.NoError(t, err)
+	pq := doc.(*documents.MidPQuery)
+	assert.True(t, pq.SubconcTruncated)
+	assert.Len(t, pq.RawQueries, 2)
+
+	doc, err = importPquery(&rec, cncdb.QuerySupertypePquery, hRec, &cncdb.DummyConcArchSQL{}, concs, 10, false)
+	assert.NoError(t, err)
+	pq = doc.(*documents.MidPQuery)
+	assert.False(t, pq.SubconcTruncated)
+	assert.Len(t, pq.RawQueries, 5)
+
+	// negative value disables the limit
+	doc, err = importPquery(&rec, cncdb.QuerySupertypePquery, hRec, &cncdb.DummyConcArchSQL{}, concs, -1, false)
+	assert.NoError(t, err)
+	pq = doc.(*documents.MidPQuery)
+	assert.False(t, pq.SubconcTruncated)
+	assert.Len(t, pq.RawQueries, 5)
+}
+
+func TestPqueryMaxSubconcValidation(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-index")
+	assert.NoError(t, err)
+	defer cleanData(tempDir)
+	mkConf := func(maxSubconc int) *Conf {
+		return &Conf{
+			IndexDirPath:                    tempDir,
+			QueryHistoryNumPreserve:         100,
+			QueryHistoryCleanupInterval:     "1m",
+			QueryHistoryMarkPendingInterval: "1h",
+			QueryHistoryMaxNumDeleteAtOnce:  10,
+			PqueryMaxSubconc:                maxSubconc,
+		}
+	}
+	conf := mkConf(0)
+	assert.NoError(t, conf.ValidateAndDefaults())
+	assert.Equal(t, dfltPqueryMaxSubconc, conf.PqueryMaxSubconc)
+	conf = mkConf(-1)
+	assert.NoError(t, conf.ValidateAndDefaults())
+	assert.Equal(t, -1, conf.PqueryMaxSubconc)
+	conf = mkConf(7)
+	assert.NoError(t, conf.ValidateAndDefaults())
+	assert.Equal(t, 7, conf.PqueryMaxSubconc)
+}
+
+func TestStatsCountBySupertype(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	indexTestConc(t, idxer, "q1", 1, "[word=\"foo\"]")
+	indexTestConc(t, idxer, "q2", 1, "[word=\"bar\"]")
+	rawForm, err := json.Marshal(unspecifiedQueryRecord{
+		ID:      "pq1",
+		Corpora: []string{"corp1"},
+		Form:    map[string]any{"form_type": "pquery", "conc_ids": []string{}},
+	})
+	assert.NoError(t, err)
+	ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+		QueryID: "pq1",
+		Created: time.Now().Unix(),
+		UserID:  1,
+		Rec:     &cncdb.ArchRecord{ID: "pq1", Data: string(rawForm)},
+	})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	stats, err := idxer.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), stats.NumDocs)
+	assert.Equal(t, map[string]uint64{"conc": 2, "pquery": 1}, stats.NumDocsBySupertype)
+}
+
+func TestStatsCountBySupertypeWithin(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	concForm := map[string]any{
+		"form_type":        "query",
+		"curr_query_types": map[string]string{"corp1": "advanced"},
+		"curr_queries":     map[string]string{"corp1": "[word=\"foo\"]"},
+	}
+	for _, item := range []struct {
+		id      string
+		rec     unspecifiedQueryRecord
+		created time.Time
+	}{
+		{id: "c1", rec: unspecifiedQueryRecord{LastopForm: concForm}, created: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{id: "c2", rec: unspecifiedQueryRecord{LastopForm: concForm}, created: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)},
+		{
+			id:      "pq1",
+			rec:     unspecifiedQueryRecord{Form: map[string]any{"form_type": "pquery", "conc_ids": []string{}}},
+			created: time.Date(2024, 6, 15, 10, 0, 0, 0, time.UTC),
+		},
+	} {
+		item.rec.ID = item.id
+		item.rec.Corpora = []string{"corp1"}
+		rawForm, err := json.Marshal(item.rec)
+		assert.NoError(t, err)
+		ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+			QueryID: item.id,
+			Created: item.created.Unix(),
+			UserID:  1,
+			Rec:     &cncdb.ArchRecord{ID: item.id, Data: string(rawForm), Created: item.created},
+		})
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	counts, err := idxer.CountBySupertypeWithin("2024-05-15", "2024-06-30")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint64{"conc": 1, "pquery": 1}, counts)
+	counts, err = idxer.CountBySupertypeWithin("", "2024-05-01T10:00:00Z")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint64{"conc": 1}, counts)
+	counts, err = idxer.CountBySupertypeWithin("2025-01-01", "")
+	assert.NoError(t, err)
+	assert.Empty(t, counts)
+	_, err = idxer.CountBySupertypeWithin("yesterday", "")
+	assert.ErrorIs(t, err, ErrInvalidSearchTerm)
+}
+
+func TestOpenReadOnlyLocked(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	_, err := OpenReadOnly(&Conf{IndexDirPath: idxer.DataPath()}, 100*time.Millisecond)
+	assert.ErrorIs(t, err, ErrIndexLocked)
+
+	assert.NoError(t, idxer.Close())
+	roIdxer, err := OpenReadOnly(&Conf{IndexDirPath: idxer.DataPath()}, 100*time.Millisecond)
+	if assert.NoError(t, err) {
+		_, err = roIdxer.Stats()
+		assert.NoError(t, err)
+		roIdxer.Close()
+	}
+}
+
+func TestSearchBoost(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	created := time.Now()
+	for id, props := range map[string][2]string{
+		"byName":  {"foo query", "[word=\"bar\"]"},
+		"byQuery": {"other", "[word=\"foo\"]"},
+	} {
+		rawForm, err := json.Marshal(unspecifiedQueryRecord{
+			ID: id,
+			LastopForm: map[string]any{
+				"form_type":           "query",
+				"curr_query_types":    map[string]string{"corp1": "advanced"},
+				"curr_queries":        map[string]string{"corp1": props[1]},
+				"selected_text_types": map[string][]string{},
+			},
+		})
+		assert.NoError(t, err)
+		ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+			QueryID: id,
+			Created: created.Unix(),
+			UserID:  1,
+			Name:    props[0],
+			Rec:     &cncdb.ArchRecord{ID: id, Data: string(rawForm), Created: created},
+		})
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	search := func(nameBoost, queryBoost float64) []string {
+		result, err := idxer.Search(
+			[]searchedTerm{
+				{Field: "name", Value: "foo", Requirement: "should", Boost: nameBoost},
+				{Field: "raw_query", Value: "[word=\"foo\"]", Requirement: "should", Boost: queryBoost},
+			},
+			10, nil, []string{"id"},
+		)
+		assert.NoError(t, err)
+		ans := make([]string, 0, result.Hits.Len())
+		for _, hit := range result.Hits {
+			ans = append(ans, hit.Fields["id"].(string))
+		}
+		return ans
+	}
+	assert.Equal(t, []string{"byName", "byQuery"}, search(10, 1))
+	assert.Equal(t, []string{"byQuery", "byName"}, search(0, 10))
+
+	_, err := idxer.Search(
+		[]searchedTerm{{Field: "name", Value: "foo", Requirement: "should", Boost: -1}},
+		10, nil, []string{"id"},
+	)
+	assert.ErrorIs(t, err, ErrInvalidSearchTerm)
+}
+
+func TestSkipCQLExtraction(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	idxer.conf.SkipCQLExtraction = true
+
+	indexTestConc(t, idxer, "fast1", 1, "[lemma=\"dům\"]")
+
+	result, err := idxer.Search(
+		[]searchedTerm{{Field: "raw_query", Value: "[lemma=\"dům\"]", Requirement: "must"}},
+		10, nil, []string{"id", "cql_pending", "pos_attr_names"},
+	)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, result.Hits.Len()) {
+		assert.Equal(t, true, result.Hits[0].Fields["cql_pending"])
+		assert.Empty(t, result.Hits[0].Fields["pos_attr_names"])
+	}
+
+	result, err = idxer.Search(
+		[]searchedTerm{{Field: "cql_pending", Value: "true", Requirement: "must"}},
+		10, nil, []string{"id"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Hits.Len())
+}
+
+func TestSearchInvalidTerms(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	_, err := idxer.Search(
+		[]searchedTerm{{Field: "name", Value: "foo", Requirement: "maybe"}},
+		10, nil, []string{"id"},
+	)
+	assert.ErrorIs(t, err, ErrInvalidSearchTerm)
+
+	_, err = idxer.Search(
+		[]searchedTerm{{Field: "name", Value: "foo", Requirement: "must", IsExact: true}},
+		10, nil, []string{"id"},
+	)
+	assert.ErrorIs(t, err, ErrInvalidSearchTerm)
+}
+
+func TestNormalizeTimestampUnit(t *testing.T) {
+	secs := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC).Unix()
+
+	ts, changed := normalizeTimestampUnit(secs, CreatedUnitAuto)
+	assert.Equal(t, secs, ts)
+	assert.False(t, changed)
+
+	ts, changed = normalizeTimestampUnit(secs*1000+123, CreatedUnitAuto)
+	assert.Equal(t, secs, ts)
+	assert.True(t, changed)
+
+	ts, changed = normalizeTimestampUnit(secs*1000000, CreatedUnitAuto)
+	assert.Equal(t, secs, ts)
+	assert.True(t, changed)
+
+	ts, changed = normalizeTimestampUnit(0, CreatedUnitAuto)
+	assert.Equal(t, int64(0), ts)
+	assert.False(t, changed)
+
+	ts, changed = normalizeTimestampUnit(secs*1000, CreatedUnitSeconds)
+	assert.Equal(t, secs*1000, ts)
+	assert.False(t, changed)
+
+	ts, changed = normalizeTimestampUnit(secs*1000, CreatedUnitMilliseconds)
+	assert.Equal(t, secs, ts)
+	assert.True(t, changed)
+}
+
+func TestNormalizeCreatedMilliseconds(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	idxer.conf.CreatedTimestampUnit = CreatedUnitAuto
+
+	created := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+	hRec := &cncdb.HistoryRecord{QueryID: "ms1", Created: created.UnixMilli()}
+	norm := idxer.normalizeCreated(hRec)
+	assert.Equal(t, created.Unix(), norm.Created)
+	assert.Equal(t, created.UnixMilli(), hRec.Created)
+
+	hRec = &cncdb.HistoryRecord{QueryID: "s1", Created: created.Unix()}
+	assert.Same(t, hRec, idxer.normalizeCreated(hRec))
+}
+
+func corpusPolicyTestRecord(t *testing.T, id, corp string) *cncdb.HistoryRecord {
+	created := time.Now()
+	rawForm, err := json.Marshal(unspecifiedQueryRecord{
+		ID:      id,
+		Corpora: []string{corp},
+		Form: map[string]any{
+			"form_type": "wlist",
+			"wlattr":    "lemma",
+			"wlpat":     ".*",
+		},
+	})
+	assert.NoError(t, err)
+	return &cncdb.HistoryRecord{
+		QueryID: id,
+		Created: created.Unix(),
+		UserID:  1,
+		Rec:     &cncdb.ArchRecord{ID: id, Data: string(rawForm), Created: created},
+	}
+}
+
+func TestRecToDocCorpusDenied(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	idxer.conf.NonIndexedCorpora = []string{"private1"}
+	idxer.conf.CorpusAliases = map[string]string{"private1_v2": "private1"}
+
+	doc, err := idxer.RecToDoc(corpusPolicyTestRecord(t, "d1", "private1"))
+	assert.Nil(t, doc)
+	assert.ErrorIs(t, err, ErrRecordNotIndexable)
+
+	doc, err = idxer.RecToDoc(corpusPolicyTestRecord(t, "d2", "private1_v2"))
+	assert.Nil(t, doc)
+	assert.ErrorIs(t, err, ErrRecordNotIndexable)
+
+	doc, err = idxer.RecToDoc(corpusPolicyTestRecord(t, "d3", "syn2020"))
+	assert.NoError(t, err)
+	assert.NotNil(t, doc)
+}
+
+func TestRecToDocCorpusAllowed(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	idxer.conf.IndexedCorpora = []string{"syn2020", "intercorp"}
+	idxer.conf.NonIndexedCorpora = []string{"intercorp"}
+
+	doc, err := idxer.RecToDoc(corpusPolicyTestRecord(t, "a1", "syn2020"))
+	assert.NoError(t, err)
+	assert.NotNil(t, doc)
+
+	_, err = idxer.RecToDoc(corpusPolicyTestRecord(t, "a2", "test_corp"))
+	assert.ErrorIs(t, err, ErrRecordNotIndexable)
+
+	_, err = idxer.RecToDoc(corpusPolicyTestRecord(t, "a3", "intercorp"))
+	assert.ErrorIs(t, err, ErrRecordNotIndexable)
+
+	ok, err := idxer.IndexRecord(corpusPolicyTestRecord(t, "a4", "test_corp"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestConversionReport(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	history := []cncdb.HistoryRecord{
+		*corpusPolicyTestRecord(t, "ok1", "syn2020"),
+		*corpusPolicyTestRecord(t, "ok2", "syn2020"),
+		{QueryID: "missing1", Created: time.Now().Unix()},
+		{
+			QueryID: "broken1",
+			Created: time.Now().Unix(),
+			Rec:     &cncdb.ArchRecord{ID: "broken1", Data: "{not json"},
+		},
+		{
+			QueryID: "shuffle1",
+			Created: time.Now().Unix(),
+			Rec: &cncdb.ArchRecord{
+				ID:   "shuffle1",
+				Data: `{"id": "shuffle1", "lastop_form": {"form_type": "shuffle"}}`,
+			},
+		},
+	}
+	report := idxer.conversionReport(history)
+	assert.Equal(t, 5, report.NumSampled)
+	assert.Equal(t, 2, report.Classes[ConversionClassOK].Count)
+	assert.Equal(t, []string{"ok1", "ok2"}, report.Classes[ConversionClassOK].ExampleIDs)
+	assert.Equal(t, []string{"missing1"}, report.Classes[ConversionClassMissingData].ExampleIDs)
+	assert.Equal(t, []string{"broken1"}, report.Classes[ConversionClassParseError].ExampleIDs)
+	assert.Equal(t, []string{"shuffle1"}, report.Classes[ConversionClassNotIndexable].ExampleIDs)
+
+	_, err := idxer.ConversionReport(0, time.Now().Unix(), MaxConversionReportSample+1)
+	assert.Error(t, err)
+	_, err = idxer.ConversionReport(time.Now().Unix(), 0, 10)
+	assert.Error(t, err)
+}
+
+func TestIndexUseRegexp(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	created := time.Now()
+	for id, useRegexp := range map[string]any{"re1": true, "re2": false, "re3": nil} {
+		form := map[string]any{
+			"form_type":           "query",
+			"curr_query_types":    map[string]string{"corp1": "simple"},
+			"curr_queries":        map[string]string{"corp1": "dům.*"},
+			"selected_text_types": map[string][]string{},
+			"curr_parsed_queries": map[string]any{
+				"corp1": []any{[]any{[]any{[]any{"word", "dům.*"}, true}}},
+			},
+		}
+		if useRegexp != nil {
+			form["curr_use_regexp_values"] = map[string]any{"corp1": useRegexp}
+		}
+		rawForm, err := json.Marshal(unspecifiedQueryRecord{ID: id, LastopForm: form})
+		assert.NoError(t, err)
+		ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+			QueryID: id,
+			Created: created.Unix(),
+			UserID:  1,
+			Rec:     &cncdb.ArchRecord{ID: id, Data: string(rawForm), Created: created},
+		})
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	result, err := idxer.Search(
+		[]searchedTerm{{Field: "use_regexp", Value: "true", Requirement: "must"}},
+		10, nil, []string{"id"},
+	)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, result.Hits.Len()) {
+		assert.Equal(t, "re1", result.Hits[0].Fields["id"])
+	}
+
+	result, err = idxer.Search(
+		[]searchedTerm{{Field: "use_regexp", Value: "false", Requirement: "must"}},
+		10, nil, []string{"id"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Hits.Len())
+}
+
+func TestAnalyzerGroups(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-index")
+	assert.NoError(t, err)
+	defer cleanData(tempDir)
+	conf := Conf{
+		IndexDirPath:            tempDir,
+		QueryHistoryNumPreserve: 100,
+		AnalyzerGroups: []documents.AnalyzerGroup{
+			{Name: "en", CorpusPrefixes: []string{"brown"}, TokenFilters: []string{"stemmer_en_snowball"}},
+		},
+	}
+	idxer, err := NewIndexer(&conf, &cncdb.DummyConcArchSQL{}, &cncdb.MySQLQueryHistDryRun{}, nil, nil)
+	assert.NoError(t, err)
+
+	created := time.Now()
+	for id, corpname := range map[string]string{"ag1": "brown", "ag2": "syn2020"} {
+		rawForm, err := json.Marshal(unspecifiedQueryRecord{
+			ID:      id,
+			Corpora: []string{corpname},
+			LastopForm: map[string]any{
+				"form_type":        "query",
+				"curr_query_types": map[string]string{corpname: "simple"},
+				"curr_queries":     map[string]string{corpname: "running dogs"},
+			},
+		})
+		assert.NoError(t, err)
+		ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+			QueryID: id,
+			Created: created.Unix(),
+			UserID:  1,
+			Rec:     &cncdb.ArchRecord{ID: id, Data: string(rawForm), Created: created},
+		})
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	for value, expected := range map[string][]string{"dog": {"ag1"}, "dogs": {"ag1", "ag2"}} {
+		result, err := idxer.Search(
+			[]searchedTerm{{Field: "raw_query", Value: value, Requirement: "must"}},
+			10, []string{"id"}, []string{"id"},
+		)
+		assert.NoError(t, err)
+		ids := make([]string, 0, result.Hits.Len())
+		for _, hit := range result.Hits {
+			ids = append(ids, hit.Fields["id"].(string))
+		}
+		assert.Equal(t, expected, ids, value)
+	}
+}
+
+func TestAnalyzerGroupsMissingInMapping(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	groups := []documents.AnalyzerGroup{{Name: "en", CorpusPrefixes: []string{"brown"}}}
+	assert.Empty(t, availableAnalyzerGroups(idxer.bleveIdx, groups))
+}
+
+func TestCreatedDateRange(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	// 2024-05-01T10:00:00Z
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	rawForm, err := json.Marshal(unspecifiedQueryRecord{
+		ID: "dr1",
+		LastopForm: map[string]any{
+			"form_type":        "query",
+			"curr_query_types": map[string]string{"corp1": "advanced"},
+			"curr_queries":     map[string]string{"corp1": "[word=\"x\"]"},
+		},
+	})
+	assert.NoError(t, err)
+	hRec := &cncdb.HistoryRecord{
+		QueryID: "dr1",
+		Created: created.Unix(),
+		UserID:  1,
+		Rec:     &cncdb.ArchRecord{ID: "dr1", Data: string(rawForm), Created: created},
+	}
+	doc, err := idxer.RecToDoc(hRec)
+	assert.NoError(t, err)
+	assert.Equal(t, time.UTC, doc.(*documents.MidConc).Created.Location())
+	ok, err := idxer.IndexRecord(hRec)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	for _, tc := range []struct {
+		from, to string
+		expected int
+	}{
+		{from: "2024-05-01T10:00:00Z", expected: 1},
+		{from: "2024-05-01T10:00:01Z", expected: 0},
+		{to: "2024-05-01T10:00:00", expected: 1},
+		{to: "2024-05-01T09:59:59", expected: 0},
+		{from: "2024-05-01T12:00:00+02:00", to: "2024-05-01T12:00:00+02:00", expected: 1},
+		{from: "2024-05-01T10:00:00+01:00", to: "2024-05-01T11:59:59+02:00", expected: 0},
+		{from: "2024-05-01", to: "2024-05-02", expected: 1},
+		{from: "2024-05-02", expected: 0},
+	} {
+		result, err := idxer.Search(
+			[]searchedTerm{{Field: "created", From: tc.from, To: tc.to, Requirement: "must"}},
+			10, nil, []string{"created"},
+		)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, result.Hits.Len(), "from: %s, to: %s", tc.from, tc.to)
+		if result.Hits.Len() > 0 {
+			assert.Equal(t, "2024-05-01T10:00:00Z", result.Hits[0].Fields["created"])
+		}
+	}
+
+	result, err := idxer.SearchWithQuery(`created:>="2024-05-01T10:00:00Z"`, 10, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Hits.Len())
+	result, err = idxer.SearchWithQuery(`created:>"2024-05-01T10:00:00Z"`, 10, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Hits.Len())
+
+	_, err = idxer.Search(
+		[]searchedTerm{{Field: "created", From: "01/05/2024", Requirement: "must"}}, 10, nil, nil)
+	assert.ErrorIs(t, err, ErrInvalidSearchTerm)
+	_, err = idxer.Search(
+		[]searchedTerm{{Field: "raw_query", From: "2024-05-01", Requirement: "must"}}, 10, nil, nil)
+	assert.ErrorIs(t, err, ErrInvalidSearchTerm)
+}
+
+type failingSubcConcArch struct {
+	cncdb.DummyConcArchSQL
+}
+
+func (db *failingSubcConcArch) GetSubcorpusProps(subcID string) (cncdb.SubcProps, error) {
+	return cncdb.SubcProps{}, errors.New("connection refused")
+}
+
+func TestSubcPropsLookupLenient(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+
+	created := time.Now()
+	rawForm, err := json.Marshal(map[string]any{
+		"id":         "sub2",
+		"usesubcorp": "Ab3xKq9ZtPw",
+		"lastop_form": map[string]any{
+			"form_type":        "query",
+			"curr_query_types": map[string]string{"syn2020": "advanced"},
+			"curr_queries":     map[string]string{"syn2020": "[word=\"dům\"]"},
+		},
+	})
+	assert.NoError(t, err)
+	hRec := &cncdb.HistoryRecord{
+		QueryID: "sub2",
+		Created: created.Unix(),
+		UserID:  1,
+		Rec:     &cncdb.ArchRecord{ID: "sub2", Data: string(rawForm), Created: created},
+	}
+
+	idxer.concArchDb = &failingSubcConcArch{}
+	_, err = idxer.RecToDoc(hRec)
+	assert.Error(t, err)
+
+	idxer.concArchDb = &lenientSubcConcArchOps{IConcArchOps: &failingSubcConcArch{}}
+	ok, err := idxer.IndexRecord(hRec)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	result, err := idxer.SearchWithQuery("+subcorpus_id:Ab3xKq9ZtPw", 10, nil, []string{"id"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Hits.Len())
+}
+
+func TestReopenExistingIndex(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	assert.NoError(t, idxer.Close())
+
+	idxer2, err := NewIndexer(idxer.conf, &cncdb.DummyConcArchSQL{}, &cncdb.MySQLQueryHistDryRun{}, nil, nil)
+	assert.NoError(t, err)
+	idxer2.Close()
+}
+
+func TestIndexOversizedDoc(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	idxer.conf.MaxIndexDocBytes = 4096
+
+	titles := make([]string, 1000)
+	for i := range titles {
+		titles[i] = fmt.Sprintf("title%04d", i)
+	}
+	created := time.Now()
+	rawForm, err := json.Marshal(map[string]any{
+		"id": "big1",
+		"lastop_form": map[string]any{
+			"form_type":           "query",
+			"curr_query_types":    map[string]string{"syn2020": "simple"},
+			"curr_queries":        map[string]string{"syn2020": "dům"},
+			"selected_text_types": map[string][]string{"doc.title": titles, "doc.txtype": {"fiction"}},
+		},
+	})
+	assert.NoError(t, err)
+	ok, err := idxer.IndexRecord(&cncdb.HistoryRecord{
+		QueryID: "big1",
+		Created: created.Unix(),
+		UserID:  1,
+		Rec:     &cncdb.ArchRecord{ID: "big1", Data: string(rawForm), Created: created},
+	})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), idxer.NumOversizedDocs())
+
+	result, err := idxer.SearchWithQuery("+raw_query:dům", 10, nil, []string{"id", "struct_attr_values"})
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, result.Hits.Len()) {
+		assert.Equal(t, "big1", result.Hits[0].Fields["id"])
+		assert.Empty(t, result.Hits[0].Fields["struct_attr_values"])
+	}
+}
+
+type batchLoadingConcArch struct {
+	cncdb.DummyConcArchSQL
+	recs          map[string]cncdb.ArchRecord
+	numBatchCalls int
+	requestedIDs  []string
+	err           error
+}
+
+func (db *batchLoadingConcArch) LoadRecordsByIDs(ids []string) (map[string][]cncdb.ArchRecord, error) {
+	db.numBatchCalls++
+	db.requestedIDs = append(db.requestedIDs, ids...)
+	if db.err != nil {
+		return map[string][]cncdb.ArchRecord{}, db.err
+	}
+	ans := make(map[string][]cncdb.ArchRecord)
+	for _, id := range ids {
+		if rec, ok := db.recs[id]; ok {
+			ans[id] = []cncdb.ArchRecord{rec}
+		}
+	}
+	return ans, nil
+}
+
+func TestGetConcRecordsMixedRedisHits(t *testing.T) {
+	inRedis := map[string]cncdb.ArchRecord{
+		"r1": {ID: "r1", Data: "redis1"},
+		"r2": {ID: "r2", Data: "redis2"},
+	}
+	getFromRedis := func(id string) (cncdb.ArchRecord, error) {
+		if id == "broken" {
+			return cncdb.ArchRecord{}, errors.New("connection reset")
+		}
+		rec, ok := inRedis[id]
+		if !ok {
+			return cncdb.ArchRecord{}, cncdb.ErrRecordNotFound
+		}
+		return rec, nil
+	}
+	db := &batchLoadingConcArch{
+		recs: map[string]cncdb.ArchRecord{
+			"m1": {ID: "m1", Data: "mysql1"},
+			"m2": {ID: "m2", Data: "mysql2"},
+		},
+	}
+	batch := getConcRecords([]string{"r1", "m1", "gone", "r2", "m2", "m1", "broken"}, getFromRedis, db)
+	assert.Equal(t, 1, db.numBatchCalls)
+	assert.ElementsMatch(t, []string{"m1", "m2", "gone"}, db.requestedIDs)
+
+	for id, data := range map[string]string{"r1": "redis1", "r2": "redis2", "m1": "mysql1", "m2": "mysql2"} {
+		rec, err := batch.get(id)
+		assert.NoError(t, err)
+		if assert.NotNil(t, rec, id) {
+			assert.Equal(t, data, rec.Data)
+		}
+	}
+	rec, err := batch.get("gone")
+	assert.NoError(t, err)
+	assert.Nil(t, rec)
+	_, err = batch.get("broken")
+	assert.Error(t, err)
+}
+
+func TestGetConcRecordsMySQLFailure(t *testing.T) {
+	db := &batchLoadingConcArch{err: errors.New("too many connections")}
+	batch := getConcRecords(
+		[]string{"r1", "m1"},
+		func(id string) (cncdb.ArchRecord, error) {
+			if id == "r1" {
+				return cncdb.ArchRecord{ID: id}, nil
+			}
+			return cncdb.ArchRecord{}, cncdb.ErrRecordNotFound
+		},
+		db,
+	)
+	rec, err := batch.get("r1")
+	assert.NoError(t, err)
+	assert.NotNil(t, rec)
+	_, err = batch.get("m1")
+	assert.Error(t, err)
+}
+
+func TestAsIndexLockedErr(t *testing.T) {
+	err := asIndexLockedErr(fmt.Errorf("failed to open: %w", bolt.ErrTimeout))
+	assert.ErrorIs(t, err, ErrIndexLocked)
+	assert.ErrorIs(t, err, bolt.ErrTimeout)
+
+	err = asIndexLockedErr(bleve.ErrorIndexClosed)
+	assert.NotErrorIs(t, err, ErrIndexLocked)
+	assert.ErrorIs(t, err, bleve.ErrorIndexClosed)
+
+	assert.NoError(t, asIndexLockedErr(nil))
+}
+
+func TestStratifyInterval(t *testing.T) {
+	assert.Equal(
+		t,
+		[][2]int64{{0, 24}, {25, 49}, {50, 74}, {75, 99}},
+		stratifyInterval(0, 99, 4),
+	)
+	assert.Equal(
+		t,
+		[][2]int64{{10, 12}, {13, 16}, {17, 19}, {20, 23}},
+		stratifyInterval(10, 23, 4),
+	)
+	assert.Equal(t, [][2]int64{{5, 5}, {6, 6}}, stratifyInterval(5, 6, 10))
+	assert.Equal(t, [][2]int64{{5, 5}}, stratifyInterval(5, 5, 10))
+}
+
+func TestConversionReportSamplesWholeInterval(t *testing.T) {
+	idxer, _ := prepareIndexerWithRedis(t)
+	defer cleanData(idxer.DataPath())
+	defer idxer.Close()
+
+	qh := &fakeQueryHist{}
+	for i := 0; i < 100; i++ {
+		qh.history = append(qh.history, cncdb.HistoryRecord{QueryID: fmt.Sprintf("q%02d", i), Created: int64(i)})
+	}
+	idxer.queryHistDb = qh
+
+	report, err := idxer.ConversionReport(0, 99, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, report.NumStrata)
+	assert.Equal(t, 10, report.NumSampled)
+	assert.Equal(
+		t,
+		[]string{"q00", "q10", "q20", "q30", "q40"},
+		report.Classes[ConversionClassMissingData].ExampleIDs,
+	)
+
+	// the second half of the interval is empty, its quota
+	// is used by the first stratum
+	qh.history = qh.history[:50]
+	sample, err := idxer.loadStratifiedSample(stratifyInterval(0, 99, 2), 10)
+	assert.NoError(t, err)
+	assert.Len(t, sample, 10)
+	qh.history = append(qh.history, cncdb.HistoryRecord{QueryID: "q99", Created: 99})
+	sample, err = idxer.loadStratifiedSample(stratifyInterval(0, 199, 4), 8)
+	assert.NoError(t, err)
+	ids := make([]string, len(sample))
+	for i, hRec := range sample {
+		ids[i] = hRec.QueryID
+	}
+	assert.Equal(t, []string{"q00", "q01", "q02", "q03", "q04", "q05", "q06", "q99"}, ids)
+
+	// not enough records in the whole interval
+	sample, err = idxer.loadStratifiedSample(stratifyInterval(0, 199, 4), 100)
+	assert.NoError(t, err)
+	assert.Len(t, sample, 51)
+}
@@ -0,0 +1,274 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"camus/archiver"
+	"camus/cncdb"
+	"camus/indexer/documents"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	migrationBatchSize        = 500
+	migrationCheckpointKey    = "camus_migration_last_doc_id"
+	migrationTargetDirSuffix  = ".migrating"
+	migrationBackupDirPattern = "%s.bak-%s"
+)
+
+// MigrationSummary describes results of MigrateIndex
+type MigrationSummary struct {
+	NumSourceDocs  uint64 `json:"numSourceDocs"`
+	NumProcessed   int    `json:"numProcessed"`
+	NumIndexed     int    `json:"numIndexed"`
+	NumMissing     int    `json:"numMissing"`
+	NumNotIndexed  int    `json:"numNotIndexed"`
+	NumErrors      int    `json:"numErrors"`
+	NumInvalidDocs int    `json:"numInvalidDocs"`
+	Resumed        bool   `json:"resumed"`
+	BackupDirPath  string `json:"backupDirPath,omitempty"`
+}
+
+// indexMigration copies documents from an old index to a new one
+// by re-converting their source records. The ID of the last processed
+// document is stored within the target index so an interrupted
+// migration can be resumed.
+type indexMigration struct {
+	source     bleve.Index
+	target     *Indexer
+	loadRecord func(queryID string) (*cncdb.ArchRecord, error)
+	batchSize  int
+}
+
+func (m *indexMigration) lastProcessedID() (string, error) {
+	v, err := m.target.bleveIdx.GetInternal([]byte(migrationCheckpointKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to load migration checkpoint: %w", err)
+	}
+	return string(v), nil
+}
+
+func (m *indexMigration) migrateDoc(docID, name string, summary *MigrationSummary) error {
+	hRec, err := cncdb.ParseIndexID(docID)
+	if err != nil {
+		log.Warn().Err(err).Msg("skipping document with invalid ID")
+		summary.NumInvalidDocs++
+		return nil
+	}
+	hRec.Name = name
+	hRec.Rec, err = m.loadRecord(hRec.QueryID)
+	if err != nil {
+		return err
+	}
+	if hRec.Rec == nil {
+		summary.NumMissing++
+		return nil
+	}
+	indexed, err := m.target.IndexRecord(&hRec)
+	if errors.Is(err, ErrIndexLocked) {
+		return err
+
+	} else if err != nil {
+		log.Error().Err(err).Str("docId", docID).Msg("failed to migrate document, skipping")
+		summary.NumErrors++
+		return nil
+	}
+	if indexed {
+		summary.NumIndexed++
+
+	} else {
+		summary.NumNotIndexed++
+	}
+	return nil
+}
+
+// migrationBatchRequest creates a request for the next `size` source
+// documents (ordered by their IDs) following the lastID document.
+func migrationBatchRequest(lastID string, size int) *bleve.SearchRequest {
+	search := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	search.Size = size
+	search.Fields = []string{"name"}
+	search.SortBy([]string{"_id"})
+	if lastID != "" {
+		search.SearchAfter = []string{lastID}
+	}
+	return search
+}
+
+func (m *indexMigration) run(ctx context.Context) (MigrationSummary, error) {
+	var summary MigrationSummary
+	var err error
+	summary.NumSourceDocs, err = m.source.DocCount()
+	if err != nil {
+		return summary, fmt.Errorf("failed to migrate index: %w", err)
+	}
+	lastID, err := m.lastProcessedID()
+	if err != nil {
+		return summary, fmt.Errorf("failed to migrate index: %w", err)
+	}
+	if lastID != "" {
+		summary.Resumed = true
+		log.Info().Str("lastDocId", lastID).Msg("resuming unfinished index migration")
+	}
+	t0 := time.Now()
+	for {
+		if err := ctx.Err(); err != nil {
+			return summary, fmt.Errorf("index migration interrupted: %w", err)
+		}
+		res, err := m.source.Search(migrationBatchRequest(lastID, m.batchSize))
+		if err != nil {
+			return summary, fmt.Errorf("failed to migrate index: %w", err)
+		}
+		if len(res.Hits) == 0 {
+			break
+		}
+		for _, hit := range res.Hits {
+			name, _ := hit.Fields["name"].(string)
+			if err := m.migrateDoc(hit.ID, name, &summary); err != nil {
+				return summary, fmt.Errorf("failed to migrate document %s: %w", hit.ID, err)
+			}
+			summary.NumProcessed++
+		}
+		lastID = res.Hits[len(res.Hits)-1].ID
+		if err := m.target.bleveIdx.SetInternal([]byte(migrationCheckpointKey), []byte(lastID)); err != nil {
+			return summary, fmt.Errorf("failed to store migration checkpoint: %w", err)
+		}
+		log.Info().
+			Int("numProcessed", summary.NumProcessed).
+			Uint64("numSourceDocs", summary.NumSourceDocs).
+			Float64("elapsedSecs", time.Since(t0).Seconds()).
+			Msg("index migration progress")
+	}
+	if err := m.target.bleveIdx.DeleteInternal([]byte(migrationCheckpointKey)); err != nil {
+		return summary, fmt.Errorf("failed to remove migration checkpoint: %w", err)
+	}
+	return summary, nil
+}
+
+// swapIndexDirs replaces the index directory with the migrated one.
+// The old index is kept as a backup. Both steps are plain renames
+// within the same parent directory so each of them is atomic.
+func swapIndexDirs(indexDir, migratedDir string, now time.Time) (string, error) {
+	backupDir := fmt.Sprintf(migrationBackupDirPattern, indexDir, now.Format("20060102T150405"))
+	if err := os.Rename(indexDir, backupDir); err != nil {
+		return "", fmt.Errorf("failed to back up old index: %w", err)
+	}
+	if err := os.Rename(migratedDir, indexDir); err != nil {
+		if err2 := os.Rename(backupDir, indexDir); err2 != nil {
+			log.Error().Err(err2).Str("backupDir", backupDir).Msg("failed to restore old index")
+		}
+		return "", fmt.Errorf("failed to move migrated index: %w", err)
+	}
+	return backupDir, nil
+}
+
+// openMigrationTarget opens an unfinished target index (if restart
+// is false) or creates a new one with the current mapping.
+func openMigrationTarget(conf *Conf, path string, restart bool) (bleve.Index, error) {
+	if restart {
+		if err := os.RemoveAll(path); err != nil {
+			return nil, fmt.Errorf("failed to remove unfinished migration: %w", err)
+		}
+	}
+	bleveIdx, err := bleve.Open(path)
+	if err == nil {
+		return bleveIdx, nil
+
+	} else if err != bleve.ErrorIndexMetaMissing && err != bleve.ErrorIndexPathDoesNotExist {
+		return nil, fmt.Errorf("failed to open migration target: %w", err)
+	}
+	mapping, err := documents.CreateMapping(conf.LabelStopWords, conf.AnalyzerGroups...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migration target: %w", err)
+	}
+	bleveIdx, err = bleve.New(path, mapping)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migration target: %w", err)
+	}
+	if err := storeLabelStopWords(bleveIdx, conf.LabelStopWords); err != nil {
+		return nil, fmt.Errorf("failed to create migration target: %w", err)
+	}
+	return bleveIdx, nil
+}
+
+// MigrateIndex re-creates the index with the current mapping. All the
+// documents of the existing index have their source records re-fetched
+// and converted again. Once finished, the new index replaces the old one
+// (which is kept as a backup). An interrupted migration is resumed
+// unless restart is true. The index must not be used by another process
+// (e.g. a running Camus service) - in such case ErrIndexLocked is returned.
+func MigrateIndex(
+	ctx context.Context,
+	conf *Conf,
+	concArchDb cncdb.IConcArchOps,
+	queryHistDb cncdb.IQHistArchOps,
+	rdb *archiver.RedisAdapter,
+	lockTimeout time.Duration,
+	restart bool,
+) (MigrationSummary, error) {
+	source, err := bleve.OpenUsing(
+		conf.IndexDirPath,
+		map[string]any{
+			"read_only":    true,
+			"bolt_timeout": lockTimeout.String(),
+		},
+	)
+	if err != nil {
+		return MigrationSummary{}, fmt.Errorf("failed to open index: %w", asIndexLockedErr(err))
+	}
+	targetPath := conf.IndexDirPath + migrationTargetDirSuffix
+	targetIdx, err := openMigrationTarget(conf, targetPath, restart)
+	if err != nil {
+		source.Close()
+		return MigrationSummary{}, err
+	}
+	target := &Indexer{
+		conf:           conf,
+		concArchDb:     concArchDb,
+		queryHistDb:    queryHistDb,
+		rdb:            rdb,
+		bleveIdx:       targetIdx,
+		backend:        newBleveBackend(targetIdx),
+		dataPath:       targetPath,
+		pendingDeletes: newPendingDeletes(),
+		analyzerGroups: availableAnalyzerGroups(targetIdx, conf.AnalyzerGroups),
+	}
+	if conf.IsLenientSubcPropsLookup() {
+		target.concArchDb = &lenientSubcConcArchOps{IConcArchOps: concArchDb}
+	}
+	migration := &indexMigration{
+		source:     source,
+		target:     target,
+		loadRecord: target.GetConcRecord,
+		batchSize:  migrationBatchSize,
+	}
+	summary, err := migration.run(ctx)
+	source.Close()
+	targetIdx.Close()
+	if err != nil {
+		return summary, err
+	}
+	summary.BackupDirPath, err = swapIndexDirs(conf.IndexDirPath, targetPath, time.Now())
+	return summary, err
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"camus/cncdb"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// prepareMigration creates a source index with three conc documents
+// and an empty target indexer
+func prepareMigration(t *testing.T) (*Indexer, *Indexer, map[string]*cncdb.ArchRecord) {
+	source := prepareIndexer()
+	recs := make(map[string]*cncdb.ArchRecord)
+	for i, id := range []string{"m1", "m2", "m3"} {
+		indexTestConc(t, source, id, i+1, "[word=\"foo\"]")
+	}
+	for _, id := range []string{"m1", "m2", "m3"} {
+		rawForm := `{"id": "` + id + `", "lastop_form": {"form_type": "query", ` +
+			`"curr_query_types": {"corp1": "advanced"}, "curr_queries": {"corp1": "[word=\"foo\"]"}}}`
+		recs[id] = &cncdb.ArchRecord{ID: id, Data: rawForm, Created: time.Now()}
+	}
+	target := prepareIndexer()
+	return source, target, recs
+}
+
+func TestIndexMigration(t *testing.T) {
+	source, target, recs := prepareMigration(t)
+	defer cleanData(source.DataPath())
+	defer cleanData(target.DataPath())
+	delete(recs, "m2")
+
+	migration := &indexMigration{
+		source: source.bleveIdx,
+		target: target,
+		loadRecord: func(queryID string) (*cncdb.ArchRecord, error) {
+			return recs[queryID], nil
+		},
+		batchSize: 2,
+	}
+	summary, err := migration.run(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, summary.Resumed)
+	assert.Equal(t, uint64(3), summary.NumSourceDocs)
+	assert.Equal(t, 3, summary.NumProcessed)
+	assert.Equal(t, 2, summary.NumIndexed)
+	assert.Equal(t, 1, summary.NumMissing)
+	count, err := target.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+	cp, err := migration.lastProcessedID()
+	assert.NoError(t, err)
+	assert.Empty(t, cp)
+}
+
+func TestIndexMigrationResume(t *testing.T) {
+	source, target, recs := prepareMigration(t)
+	defer cleanData(source.DataPath())
+	defer cleanData(target.DataPath())
+
+	migration := &indexMigration{
+		source: source.bleveIdx,
+		target: target,
+		loadRecord: func(queryID string) (*cncdb.ArchRecord, error) {
+			return recs[queryID], nil
+		},
+		batchSize: 10,
+	}
+	res, err := source.bleveIdx.Search(migrationBatchRequest("", 10))
+	assert.NoError(t, err)
+	assert.NoError(t, target.bleveIdx.SetInternal([]byte(migrationCheckpointKey), []byte(res.Hits[0].ID)))
+
+	summary, err := migration.run(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, summary.Resumed)
+	assert.Equal(t, 2, summary.NumProcessed)
+	assert.Equal(t, 2, summary.NumIndexed)
+}
+
+func TestSwapIndexDirs(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-migration")
+	assert.NoError(t, err)
+	defer cleanData(tempDir)
+	indexDir := filepath.Join(tempDir, "index")
+	migratedDir := indexDir + migrationTargetDirSuffix
+	assert.NoError(t, os.Mkdir(indexDir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(indexDir, "old"), []byte{}, 0644))
+	assert.NoError(t, os.Mkdir(migratedDir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(migratedDir, "new"), []byte{}, 0644))
+
+	backupDir, err := swapIndexDirs(indexDir, migratedDir, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, indexDir+".bak-20240501T120000", backupDir)
+	assert.FileExists(t, filepath.Join(indexDir, "new"))
+	assert.FileExists(t, filepath.Join(backupDir, "old"))
+	assert.NoDirExists(t, migratedDir)
+}
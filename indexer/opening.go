@@ -0,0 +1,51 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// openProgressLogInterval specifies how often we report
+// that an index is still being opened
+const openProgressLogInterval = 5 * time.Second
+
+// openWithProgress opens an existing index and periodically
+// logs how long the opening has taken so far.
+func openWithProgress(path string) (bleve.Index, error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t0 := time.Now()
+		ticker := time.NewTicker(openProgressLogInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				log.Info().
+					Str("path", path).
+					Msgf("still opening index after %ds", int(time.Since(t0).Seconds()))
+			}
+		}
+	}()
+	return bleve.Open(path)
+}
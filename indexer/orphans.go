@@ -0,0 +1,123 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"camus/cncdb"
+	"fmt"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// OrphanSweepResult describes a single run of SweepOrphans
+type OrphanSweepResult struct {
+	NumChecked int `json:"numChecked"`
+	NumRemoved int `json:"numRemoved"`
+	NumErrors  int `json:"numErrors"`
+
+	// NextCursor is the query ID of the last checked document which
+	// is to be passed to the next run. An empty value means
+	// the whole index has been swept (next run starts from the beginning).
+	NextCursor string `json:"nextCursor"`
+}
+
+// orphanSweepRequest creates a request for the next `size`
+// documents (ordered by their query IDs) following the cursor query ID.
+// We use the indexed `id` field instead of the document ID as
+// Elasticsearch does not support sorting by `_id`. As the field is
+// not unique, documents of the cursor query which did not fit into
+// the previous batch are skipped - this is OK as all the documents
+// of an orphaned query are removed at once (see sweepOrphans).
+func orphanSweepRequest(cursor string, size int) *bleve.SearchRequest {
+	search := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	search.Size = size
+	search.SortBy([]string{"id"})
+	if cursor != "" {
+		search.SearchAfter = []string{cursor}
+	}
+	return search
+}
+
+// SweepOrphans checks up to maxDocs index documents following the cursor
+// query and removes the ones whose archive records no longer exist
+// (e.g. due to a database delete performed outside Camus). Repeated calls
+// with returned NextCursor sweep the whole index.
+func (idx *Indexer) SweepOrphans(cursor string, maxDocs int) (OrphanSweepResult, error) {
+	return idx.sweepOrphans(cursor, maxDocs, idx.GetConcRecord)
+}
+
+func (idx *Indexer) sweepOrphans(
+	cursor string,
+	maxDocs int,
+	loadRecord func(queryID string) (*cncdb.ArchRecord, error),
+) (OrphanSweepResult, error) {
+	var ans OrphanSweepResult
+	res, err := idx.backend.Search(orphanSweepRequest(cursor, maxDocs))
+	if err != nil {
+		return ans, fmt.Errorf("failed to sweep orphaned documents: %w", err)
+	}
+	// documents of a single query are adjacent so we check
+	// each query just once
+	var lastQueryID string
+	for _, hit := range res.Hits {
+		ans.NumChecked++
+		hRec, err := cncdb.ParseIndexID(hit.ID)
+		if err != nil {
+			log.Warn().Err(err).Msg("skipping document with invalid ID in orphan sweep")
+			continue
+		}
+		if hRec.QueryID == lastQueryID {
+			continue
+		}
+		lastQueryID = hRec.QueryID
+		rec, err := loadRecord(hRec.QueryID)
+		if err != nil {
+			log.Error().Err(err).Str("docId", hit.ID).Msg("failed to check existence of indexed record")
+			ans.NumErrors++
+			continue
+		}
+		if rec != nil {
+			continue
+		}
+		// we remove all the documents of the query (including the ones
+		// which may not fit into this batch, see orphanSweepRequest)
+		docIDs, err := idx.findDocsByQueryID(hRec.QueryID)
+		if err != nil {
+			log.Error().Err(err).Str("docId", hit.ID).Msg("failed to find orphaned documents in index")
+			ans.NumErrors++
+			continue
+		}
+		for _, docID := range docIDs {
+			if err := idx.Delete(docID); err != nil {
+				log.Error().Err(err).Str("docId", docID).Msg("failed to delete orphaned document from index")
+				ans.NumErrors++
+				continue
+			}
+			log.Info().Str("docId", docID).Msg("deleted orphaned document from index")
+			ans.NumRemoved++
+		}
+	}
+	if len(res.Hits) == maxDocs {
+		hRec, err := cncdb.ParseIndexID(res.Hits[len(res.Hits)-1].ID)
+		if err != nil {
+			return ans, fmt.Errorf("failed to sweep orphaned documents: %w", err)
+		}
+		ans.NextCursor = hRec.QueryID
+	}
+	return ans, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"camus/cncdb"
+	"errors"
+	"testing"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweepOrphans(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	for _, id := range []string{"a1", "a2", "a3", "a4", "a5"} {
+		indexTestConc(t, idxer, id, 1, "[word=\"x\"]")
+	}
+	existing := map[string]bool{"a1": true, "a3": true, "a5": true}
+	loadRecord := func(queryID string) (*cncdb.ArchRecord, error) {
+		if queryID == "a4" {
+			return nil, errors.New("connection refused")
+		}
+		if existing[queryID] {
+			return &cncdb.ArchRecord{ID: queryID}, nil
+		}
+		return nil, nil
+	}
+
+	res, err := idxer.sweepOrphans("", 3, loadRecord)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, res.NumChecked)
+	assert.Equal(t, 1, res.NumRemoved)
+	assert.Equal(t, 0, res.NumErrors)
+	assert.NotEmpty(t, res.NextCursor)
+
+	res, err = idxer.sweepOrphans(res.NextCursor, 3, loadRecord)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.NumChecked)
+	assert.Equal(t, 0, res.NumRemoved)
+	assert.Equal(t, 1, res.NumErrors)
+	assert.Empty(t, res.NextCursor)
+
+	count, err := idxer.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), count)
+}
+
+func TestSweepOrphansRemovesAllQueryDocs(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	indexTestConc(t, idxer, "b1", 1, "[word=\"x\"]")
+	for userID := 1; userID <= 3; userID++ {
+		indexTestConc(t, idxer, "b2", userID, "[word=\"x\"]")
+	}
+	indexTestConc(t, idxer, "b3", 1, "[word=\"x\"]")
+	loadRecord := func(queryID string) (*cncdb.ArchRecord, error) {
+		if queryID == "b2" {
+			return nil, nil
+		}
+		return &cncdb.ArchRecord{ID: queryID}, nil
+	}
+
+	// the batch ends in the middle of the `b2` documents
+	res, err := idxer.sweepOrphans("", 2, loadRecord)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.NumChecked)
+	assert.Equal(t, 3, res.NumRemoved)
+	assert.Equal(t, "b2", res.NextCursor)
+
+	res, err = idxer.sweepOrphans(res.NextCursor, 2, loadRecord)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.NumChecked)
+	assert.Equal(t, 0, res.NumRemoved)
+	assert.Empty(t, res.NextCursor)
+
+	count, err := idxer.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+}
+
+func TestOrphanSweepRequestToES(t *testing.T) {
+	ans, err := searchRequestToES(orphanSweepRequest("abc", 100))
+	assert.NoError(t, err)
+	expected := map[string]any{
+		"query":            map[string]any{"match_all": map[string]any{}},
+		"size":             100.0,
+		"from":             0.0,
+		"track_total_hits": true,
+		"sort":             []any{map[string]any{"id": "asc"}},
+		"search_after":     []any{"abc"},
+		"_source":          false,
+	}
+	assert.Equal(t, expected, jsonRoundTrip(t, ans))
+}
+
+func TestSortByDocIDUnsupportedByES(t *testing.T) {
+	req := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	req.SortBy([]string{"_id"})
+	_, err := searchRequestToES(req)
+	assert.ErrorIs(t, err, ErrUnsupportedByBackend)
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxPurgedDocsPerQuery      = 10000
+	pendingDeleteRetryInterval = time.Minute
+)
+
+// PurgeSummary describes what has been removed by Indexer.Purge
+type PurgeSummary struct {
+	ID                    string   `json:"id"`
+	NumArchiveRecords     int      `json:"numArchiveRecords"`
+	DeletedIndexDocs      []string `json:"deletedIndexDocs"`
+	PendingRetryIndexDocs []string `json:"pendingRetryIndexDocs"`
+}
+
+// pendingDeletesStore is a persistent storage of pending
+// deletes (typically a Redis set, see Conf.PendingDeletesKey)
+type pendingDeletesStore interface {
+	SAdd(key string, members ...string) error
+	SRem(key string, members ...string) error
+	SMembers(key string) ([]string, error)
+}
+
+// pendingDeletes stores IDs of index documents we failed to delete
+// so they can be retried later. In case a store is configured, the
+// IDs are persisted there so they survive restarts.
+type pendingDeletes struct {
+	ids   map[string]struct{}
+	store pendingDeletesStore
+	key   string
+	mu    sync.Mutex
+}
+
+func (pd *pendingDeletes) add(docID string) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	pd.ids[docID] = struct{}{}
+	if pd.store == nil {
+		return
+	}
+	if err := pd.store.SAdd(pd.key, docID); err != nil {
+		log.Error().
+			Err(err).
+			Str("docId", docID).
+			Msg("failed to persist pending index delete, will be retried only until restart")
+	}
+}
+
+func (pd *pendingDeletes) list() []string {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	ans := make([]string, 0, len(pd.ids))
+	for k := range pd.ids {
+		ans = append(ans, k)
+	}
+	return ans
+}
+
+func (pd *pendingDeletes) remove(docID string) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	delete(pd.ids, docID)
+	if pd.store == nil {
+		return
+	}
+	if err := pd.store.SRem(pd.key, docID); err != nil {
+		log.Error().
+			Err(err).
+			Str("docId", docID).
+			Msg("failed to remove persisted pending index delete")
+	}
+}
+
+func newPendingDeletes() *pendingDeletes {
+	return &pendingDeletes{ids: make(map[string]struct{})}
+}
+
+// newPersistentPendingDeletes creates pendingDeletes backed by the provided
+// store and loads IDs stored there by previous runs.
+func newPersistentPendingDeletes(store pendingDeletesStore, key string) *pendingDeletes {
+	ans := newPendingDeletes()
+	ans.store = store
+	ans.key = key
+	ids, err := store.SMembers(key)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("key", key).
+			Msg("failed to load persisted pending index deletes")
+		return ans
+	}
+	for _, docID := range ids {
+		ans.ids[docID] = struct{}{}
+	}
+	if len(ids) > 0 {
+		log.Info().Int("numDocs", len(ids)).Msg("loaded pending index deletes from previous runs")
+	}
+	return ans
+}
+
+// findDocsByQueryID returns IDs of all the history documents
+// referring to the provided query (archive record) ID.
+func (idx *Indexer) findDocsByQueryID(queryID string) ([]string, error) {
+	q := bleve.NewTermQuery(queryID)
+	q.SetField("id")
+	search := bleve.NewSearchRequest(q)
+	search.Size = maxPurgedDocsPerQuery
+	res, err := idx.backend.Search(search)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search documents of query %s: %w", queryID, err)
+	}
+	ans := make([]string, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		ans = append(ans, hit.ID)
+	}
+	return ans, nil
+}
+
+// Purge removes an archive record from the database along with
+// all the history documents referring to it from the index.
+// Index documents which cannot be deleted (after the archive record
+// is already gone) are queued and their deletion is retried
+// periodically so no orphaned documents remain in the index.
+func (idx *Indexer) Purge(queryID string) (PurgeSummary, error) {
+	ans := PurgeSummary{
+		ID:                    queryID,
+		DeletedIndexDocs:      []string{},
+		PendingRetryIndexDocs: []string{},
+	}
+	docIDs, err := idx.findDocsByQueryID(queryID)
+	if err != nil {
+		return ans, fmt.Errorf("failed to purge record %s: %w", queryID, err)
+	}
+	recs, err := idx.concArchDb.LoadRecordsByID(queryID)
+	if err != nil {
+		return ans, fmt.Errorf("failed to purge record %s: %w", queryID, err)
+	}
+	// Note: the records may be loaded from a lagging read replica
+	// so we always perform the removal (on the primary database)
+	if err := idx.concArchDb.RemoveRecordsByID(queryID); err != nil {
+		return ans, fmt.Errorf("failed to purge record %s: %w", queryID, err)
+	}
+	ans.NumArchiveRecords = len(recs)
+	for _, docID := range docIDs {
+		idx.searchCache.invalidateByDocID(docID)
+		if err := idx.backend.Delete(docID); err != nil {
+			log.Error().
+				Err(err).
+				Str("queryId", queryID).
+				Str("docId", docID).
+				Msg("failed to delete purged document from index, will retry later")
+			idx.pendingDeletes.add(docID)
+			ans.PendingRetryIndexDocs = append(ans.PendingRetryIndexDocs, docID)
+			continue
+		}
+		ans.DeletedIndexDocs = append(ans.DeletedIndexDocs, docID)
+	}
+	return ans, nil
+}
+
+func (idx *Indexer) retryPendingDeletes() {
+	for _, docID := range idx.pendingDeletes.list() {
+		idx.searchCache.invalidateByDocID(docID)
+		if err := idx.backend.Delete(docID); err != nil {
+			log.Error().
+				Err(err).
+				Str("docId", docID).
+				Msg("failed to delete document from index (will try again)")
+			continue
+		}
+		idx.pendingDeletes.remove(docID)
+		log.Info().Str("docId", docID).Msg("deleted pending document from index")
+	}
+}
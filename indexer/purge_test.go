@@ -0,0 +1,90 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePendingDeletesStore struct {
+	sets    map[string]map[string]struct{}
+	failAdd bool
+}
+
+func (s *fakePendingDeletesStore) SAdd(key string, members ...string) error {
+	if s.failAdd {
+		return errors.New("connection refused")
+	}
+	if s.sets[key] == nil {
+		s.sets[key] = make(map[string]struct{})
+	}
+	for _, m := range members {
+		s.sets[key][m] = struct{}{}
+	}
+	return nil
+}
+
+func (s *fakePendingDeletesStore) SRem(key string, members ...string) error {
+	for _, m := range members {
+		delete(s.sets[key], m)
+	}
+	return nil
+}
+
+func (s *fakePendingDeletesStore) SMembers(key string) ([]string, error) {
+	ans := make([]string, 0, len(s.sets[key]))
+	for m := range s.sets[key] {
+		ans = append(ans, m)
+	}
+	return ans, nil
+}
+
+func TestPendingDeletesSurviveRestart(t *testing.T) {
+	store := &fakePendingDeletesStore{sets: make(map[string]map[string]struct{})}
+	pd := newPersistentPendingDeletes(store, "pending")
+	pd.add("doc1")
+	pd.add("doc2")
+	pd.add("doc3")
+	pd.remove("doc2")
+
+	reloaded := newPersistentPendingDeletes(store, "pending")
+	ids := reloaded.list()
+	sort.Strings(ids)
+	assert.Equal(t, []string{"doc1", "doc3"}, ids)
+
+	reloaded.remove("doc1")
+	members, err := store.SMembers("pending")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"doc3"}, members)
+}
+
+func TestPendingDeletesStoreFailureKeepsMemory(t *testing.T) {
+	store := &fakePendingDeletesStore{
+		sets:    make(map[string]map[string]struct{}),
+		failAdd: true,
+	}
+	pd := newPersistentPendingDeletes(store, "pending")
+	pd.add("doc1")
+	assert.Equal(t, []string{"doc1"}, pd.list())
+	members, err := store.SMembers("pending")
+	assert.NoError(t, err)
+	assert.Empty(t, members)
+}
@@ -0,0 +1,162 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// SearchCacheStats provides basic info about search cache efficiency
+type SearchCacheStats struct {
+	Enabled bool  `json:"enabled"`
+	Size    int   `json:"size"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+type searchCacheEntry struct {
+	key     string
+	userID  string
+	created time.Time
+	result  *bleve.SearchResult
+}
+
+// searchCache is a simple LRU cache of search results with
+// a limited time of validity of its items. Entries are bound
+// to users so they can be invalidated once user's documents
+// change.
+type searchCache struct {
+	capacity int
+	ttl      time.Duration
+	items    map[string]*list.Element
+	lru      *list.List
+	hits     int64
+	misses   int64
+	mu       sync.Mutex
+}
+
+func (sc *searchCache) enabled() bool {
+	return sc != nil && sc.capacity > 0
+}
+
+// mkSearchCacheKey creates a key from all the search arguments
+func mkSearchCacheKey(userID string, terms []searchedTerm, limit int, order, fields []string) string {
+	data, err := json.Marshal([]any{userID, terms, limit, order, fields})
+	if err != nil {
+		// this should not happen with our types; we just make sure
+		// no two different searches share the same key
+		return ""
+	}
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (sc *searchCache) get(key string) (*bleve.SearchResult, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	elm, ok := sc.items[key]
+	if !ok {
+		sc.misses++
+		return nil, false
+	}
+	entry := elm.Value.(*searchCacheEntry)
+	if time.Since(entry.created) > sc.ttl {
+		sc.lru.Remove(elm)
+		delete(sc.items, key)
+		sc.misses++
+		return nil, false
+	}
+	sc.lru.MoveToFront(elm)
+	sc.hits++
+	return entry.result, true
+}
+
+func (sc *searchCache) set(key, userID string, result *bleve.SearchResult) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if elm, ok := sc.items[key]; ok {
+		sc.lru.Remove(elm)
+		delete(sc.items, key)
+	}
+	for sc.lru.Len() >= sc.capacity {
+		oldest := sc.lru.Back()
+		sc.lru.Remove(oldest)
+		delete(sc.items, oldest.Value.(*searchCacheEntry).key)
+	}
+	sc.items[key] = sc.lru.PushFront(&searchCacheEntry{
+		key:     key,
+		userID:  userID,
+		created: time.Now(),
+		result:  result,
+	})
+}
+
+// invalidateUser removes all the entries of a specified user
+func (sc *searchCache) invalidateUser(userID string) {
+	if !sc.enabled() {
+		return
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for elm := sc.lru.Front(); elm != nil; {
+		next := elm.Next()
+		entry := elm.Value.(*searchCacheEntry)
+		if entry.userID == userID {
+			sc.lru.Remove(elm)
+			delete(sc.items, entry.key)
+		}
+		elm = next
+	}
+}
+
+// invalidateByDocID removes entries of a user a document belongs to.
+// We rely on the `userID/created/queryID` format of the document IDs.
+func (sc *searchCache) invalidateByDocID(docID string) {
+	userID, _, _ := strings.Cut(docID, "/")
+	sc.invalidateUser(userID)
+}
+
+func (sc *searchCache) stats() SearchCacheStats {
+	if !sc.enabled() {
+		return SearchCacheStats{}
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return SearchCacheStats{
+		Enabled: true,
+		Size:    sc.lru.Len(),
+		Hits:    sc.hits,
+		Misses:  sc.misses,
+	}
+}
+
+func newSearchCache(capacity int, ttl time.Duration) *searchCache {
+	return &searchCache{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
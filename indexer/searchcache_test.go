@@ -0,0 +1,64 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	sc := newSearchCache(2, time.Minute)
+	sc.set("a", "1", &bleve.SearchResult{})
+	sc.set("b", "1", &bleve.SearchResult{})
+	_, ok := sc.get("a")
+	assert.True(t, ok)
+	sc.set("c", "2", &bleve.SearchResult{})
+	_, ok = sc.get("b")
+	assert.False(t, ok)
+	_, ok = sc.get("a")
+	assert.True(t, ok)
+	_, ok = sc.get("c")
+	assert.True(t, ok)
+}
+
+func TestSearchCacheTTLAndInvalidation(t *testing.T) {
+	sc := newSearchCache(10, time.Millisecond)
+	sc.set("a", "1", &bleve.SearchResult{})
+	time.Sleep(5 * time.Millisecond)
+	_, ok := sc.get("a")
+	assert.False(t, ok)
+
+	sc = newSearchCache(10, time.Minute)
+	sc.set("a", "1", &bleve.SearchResult{})
+	sc.set("b", "2", &bleve.SearchResult{})
+	sc.invalidateByDocID("1/1700000000/foo")
+	_, ok = sc.get("a")
+	assert.False(t, ok)
+	_, ok = sc.get("b")
+	assert.True(t, ok)
+}
+
+func TestNilSearchCache(t *testing.T) {
+	var sc *searchCache
+	assert.False(t, sc.enabled())
+	sc.invalidateUser("1")
+	assert.Equal(t, SearchCacheStats{}, sc.stats())
+}
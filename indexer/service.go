@@ -21,17 +21,77 @@ import (
 	"camus/archiver"
 	"camus/cncdb"
 	"context"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
+// IndexState describes availability of the index of a Service
+type IndexState string
+
+const (
+	IndexStateOpening     IndexState = "opening"
+	IndexStateOpenTimeout IndexState = "open timeout"
+	IndexStateOpen        IndexState = "open"
+)
+
 type Service struct {
-	indexer *Indexer
+	conf    *Conf
+	indexer atomic.Pointer[Indexer]
 	redis   *archiver.RedisAdapter
+
+	// openTimedOut is set in case OpenIndex has not finished
+	// within Conf.IndexOpenTimeout
+	openTimedOut atomic.Bool
 }
 
+// Indexer returns the indexer of the service. In case the index
+// is still being opened (see OpenIndex), nil is returned.
 func (service *Service) Indexer() *Indexer {
-	return service.indexer
+	return service.indexer.Load()
+}
+
+// IndexState tells whether the index is already open (see OpenIndex).
+func (service *Service) IndexState() IndexState {
+	if service.indexer.Load() != nil {
+		return IndexStateOpen
+	}
+	if service.openTimedOut.Load() {
+		return IndexStateOpenTimeout
+	}
+	return IndexStateOpening
+}
+
+// OpenIndex opens (or creates) the index and makes it available
+// via Indexer(). The method blocks until the index is opened. In case
+// it takes longer than Conf.IndexOpenTimeout, the service switches
+// to IndexStateOpenTimeout (but it keeps waiting for the index).
+func (service *Service) OpenIndex(
+	concArchDb cncdb.IConcArchOps,
+	queryHistDb cncdb.IQHistArchOps,
+	recsToIndex <-chan cncdb.HistoryRecord,
+) (*Indexer, error) {
+	resultChan := make(chan asyncIndexerRes, 1)
+	go func() {
+		res, err := NewIndexer(service.conf, concArchDb, queryHistDb, service.redis, recsToIndex)
+		resultChan <- asyncIndexerRes{res, err}
+	}()
+	var ans asyncIndexerRes
+	select {
+	case ans = <-resultChan:
+	case <-time.After(service.conf.IndexOpenTimeoutDur()):
+		service.openTimedOut.Store(true)
+		log.Error().
+			Str("timeout", service.conf.IndexOpenTimeout).
+			Msg("index not opened within the timeout (the index is likely in use), still waiting")
+		ans = <-resultChan
+	}
+	if ans.err != nil {
+		return nil, ans.err
+	}
+	service.indexer.Store(ans.value)
+	return ans.value, nil
 }
 
 func (service *Service) Start(ctx context.Context) {
@@ -60,8 +120,12 @@ func NewService(
 	indexer *Indexer,
 	redis *archiver.RedisAdapter,
 ) *Service {
-	return &Service{
-		indexer: indexer,
-		redis:   redis,
+	ans := &Service{
+		conf:  conf,
+		redis: redis,
+	}
+	if indexer != nil {
+		ans.indexer.Store(indexer)
 	}
+	return ans
 }
@@ -0,0 +1,93 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"camus/cncdb"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newOpeningTestEngine(service *Service) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	handler := NewActions(service)
+	engine := gin.New()
+	engine.Use(handler.RequireOpenIndex)
+	engine.GET("/query-history/index-info", handler.IndexInfo)
+	return engine
+}
+
+func TestServiceOpenIndex(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	assert.NoError(t, idxer.Close())
+	idxer.conf.IndexOpenTimeout = "10s"
+	service := NewService(idxer.conf, nil, nil)
+	engine := newOpeningTestEngine(service)
+
+	assert.Equal(t, IndexStateOpening, service.IndexState())
+	assert.Nil(t, service.Indexer())
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/query-history/index-info", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	opened, err := service.OpenIndex(&cncdb.DummyConcArchSQL{}, &cncdb.MySQLQueryHistDryRun{}, nil)
+	assert.NoError(t, err)
+	defer opened.Close()
+	assert.Equal(t, IndexStateOpen, service.IndexState())
+	assert.Same(t, opened, service.Indexer())
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/query-history/index-info", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestServiceOpenIndexTimeout(t *testing.T) {
+	idxer := prepareIndexer()
+	defer cleanData(idxer.DataPath())
+	conf := *idxer.conf
+	conf.IndexOpenTimeout = "1s"
+	service := NewService(&conf, nil, nil)
+
+	type openRes struct {
+		idxer *Indexer
+		err   error
+	}
+	done := make(chan openRes, 1)
+	go func() {
+		// the index is held by idxer so the opening blocks
+		opened, err := service.OpenIndex(&cncdb.DummyConcArchSQL{}, &cncdb.MySQLQueryHistDryRun{}, nil)
+		done <- openRes{opened, err}
+	}()
+	assert.Eventually(
+		t,
+		func() bool { return service.IndexState() == IndexStateOpenTimeout },
+		5*time.Second,
+		50*time.Millisecond,
+	)
+	assert.NoError(t, idxer.Close())
+	res := <-done
+	assert.NoError(t, res.err)
+	defer res.idxer.Close()
+	assert.Equal(t, IndexStateOpen, service.IndexState())
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// maxSupertypeFacets is a safe upper limit of distinct
+// query supertypes we expect in the index
+const maxSupertypeFacets = 20
+
+// IndexStats provides basic info about the index
+// (e.g. for monitoring purposes)
+type IndexStats struct {
+	NumDocs            uint64            `json:"numDocs"`
+	NumDocsBySupertype map[string]uint64 `json:"numDocsBySupertype"`
+	OnDiskBytes        uint64            `json:"onDiskBytes"`
+}
+
+// CountBySupertype returns numbers of indexed documents
+// for each query supertype.
+func (idx *Indexer) CountBySupertype() (map[string]uint64, error) {
+	return idx.CountBySupertypeWithin("", "")
+}
+
+// CountBySupertypeWithin returns numbers of documents created within
+// an inclusive date range for each query supertype. Any of the range
+// values can be empty (see searchedTerm.From, searchedTerm.To for
+// the supported formats).
+func (idx *Indexer) CountBySupertypeWithin(from, to string) (map[string]uint64, error) {
+	filters := make([]searchedTerm, 0, 1)
+	if from != "" || to != "" {
+		filters = append(filters, searchedTerm{Field: "created", From: from, To: to, Requirement: "must"})
+	}
+	terms, err := idx.Facets("query_supertype", maxSupertypeFacets, filters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count documents by supertype: %w", err)
+	}
+	ans := make(map[string]uint64)
+	for _, t := range terms {
+		ans[t.Term] = uint64(t.Count)
+	}
+	return ans, nil
+}
+
+// Stats returns total and per-supertype document counts along
+// with the disk usage of the index.
+func (idx *Indexer) Stats() (IndexStats, error) {
+	var ans IndexStats
+	numDocs, err := idx.backend.DocCount()
+	if err != nil {
+		return ans, fmt.Errorf("failed to get index stats: %w", err)
+	}
+	ans.NumDocs = numDocs
+	ans.NumDocsBySupertype, err = idx.CountBySupertype()
+	if err != nil {
+		return ans, fmt.Errorf("failed to get index stats: %w", err)
+	}
+	sc, err := idx.scorchIndex()
+	if err != nil {
+		return ans, fmt.Errorf("failed to get index stats: %w", err)
+	}
+	ans.OnDiskBytes = statsMapUint(sc.StatsMap(), "CurOnDiskBytes")
+	return ans, nil
+}
+
+// Close closes the underlying index. It is intended for
+// short-lived (e.g. CLI) uses of the indexer.
+func (idx *Indexer) Close() error {
+	return idx.bleveIdx.Close()
+}
+
+// OpenReadOnly opens an existing index for reading only. The indexer
+// has no database access so only searching and stats are available.
+// In case the index is held by another process (typically a running
+// Camus service) for longer than lockTimeout, ErrIndexLocked is returned.
+func OpenReadOnly(conf *Conf, lockTimeout time.Duration) (*Indexer, error) {
+	bleveIdx, err := bleve.OpenUsing(
+		conf.IndexDirPath,
+		map[string]any{
+			"read_only":    true,
+			"bolt_timeout": lockTimeout.String(),
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index: %w", asIndexLockedErr(err))
+	}
+	backend, err := newBackend(conf, bleveIdx)
+	if err != nil {
+		bleveIdx.Close()
+		return nil, fmt.Errorf("failed to initialize index backend: %w", err)
+	}
+	return &Indexer{
+		conf:           conf,
+		bleveIdx:       bleveIdx,
+		backend:        backend,
+		dataPath:       conf.IndexDirPath,
+		pendingDeletes: newPendingDeletes(),
+		analyzerGroups: availableAnalyzerGroups(bleveIdx, conf.AnalyzerGroups),
+	}, nil
+}
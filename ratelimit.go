@@ -0,0 +1,140 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"camus/cnf"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// rateLimiterCleanupInterval specifies how often the limiter
+	// removes idle (i.e. fully refilled) buckets
+	rateLimiterCleanupInterval = time.Minute
+)
+
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// rateLimiter is a token bucket rate limiter with a separate
+// bucket for each key (e.g. user ID)
+type rateLimiter struct {
+	rate        float64
+	burst       float64
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+	mu          sync.Mutex
+
+	// keyByIP specifies whether requests are keyed by the client IP
+	// even if the user ID is available (see cnf.RateLimitConf.KeyBy)
+	keyByIP bool
+
+	// now provides the current time (replaceable for testing)
+	now func() time.Time
+}
+
+// allow tests whether a request identified by key can be processed.
+// If not, a duration after which a token will be available is returned.
+func (rl *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if now.Sub(rl.lastCleanup) >= rateLimiterCleanupInterval {
+		rl.cleanup(now)
+	}
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: rl.burst, lastRefill: now}
+		rl.buckets[key] = bucket
+	}
+	bucket.tokens = min(rl.burst, bucket.tokens+now.Sub(bucket.lastRefill).Seconds()*rl.rate)
+	bucket.lastRefill = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / rl.rate * float64(time.Second))
+}
+
+// cleanup removes buckets which would be full by now as they
+// are equal to new ones
+func (rl *rateLimiter) cleanup(now time.Time) {
+	for k, bucket := range rl.buckets {
+		if bucket.tokens+now.Sub(bucket.lastRefill).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, k)
+		}
+	}
+	rl.lastCleanup = now
+}
+
+// requestKey returns a key identifying the client of a request.
+// Requests are keyed by the `userId` URL parameter or, if missing
+// (or if keyByIP is set), by the client IP.
+func (rl *rateLimiter) requestKey(ctx *gin.Context) string {
+	if !rl.keyByIP {
+		if userID := ctx.Param("userId"); userID != "" {
+			return "user:" + userID
+		}
+	}
+	return "ip:" + ctx.ClientIP()
+}
+
+// Middleware creates a handler rejecting requests exceeding the limit
+// with HTTP 429 and the `Retry-After` header (see requestKey for how
+// the requests are distinguished).
+func (rl *rateLimiter) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ok, wait := rl.allow(rl.requestKey(ctx), rl.now())
+		if !ok {
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respondWithError(ctx, fmt.Errorf("rate limit exceeded"), http.StatusTooManyRequests)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// newRateLimitMiddleware creates a rate limiting middleware. In case
+// the limiting is disabled, a pass-through handler is returned.
+func newRateLimitMiddleware(conf cnf.RateLimitConf) gin.HandlerFunc {
+	if !conf.IsEnabled() {
+		return func(ctx *gin.Context) {
+			ctx.Next()
+		}
+	}
+	rl := newRateLimiter(conf.RequestsPerSec, conf.Burst, time.Now)
+	rl.keyByIP = conf.KeyBy == cnf.RateLimitKeyIP
+	return rl.Middleware()
+}
+
+func newRateLimiter(rate float64, burst int, now func() time.Time) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*tokenBucket),
+		now:     now,
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterRefill(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(2, 3, nil)
+	for i := 0; i < 3; i++ {
+		ok, _ := rl.allow("u1", t0)
+		assert.True(t, ok)
+	}
+	ok, _ := rl.allow("u1", t0)
+	assert.False(t, ok)
+	// other keys have their own buckets
+	ok, _ = rl.allow("u2", t0)
+	assert.True(t, ok)
+	// with 2 tokens per second, one token is available after 500ms
+	ok, _ = rl.allow("u1", t0.Add(500*time.Millisecond))
+	assert.True(t, ok)
+	ok, _ = rl.allow("u1", t0.Add(500*time.Millisecond))
+	assert.False(t, ok)
+	// the bucket never exceeds the burst
+	for i := 0; i < 3; i++ {
+		ok, _ := rl.allow("u1", t0.Add(time.Hour))
+		assert.True(t, ok)
+	}
+	ok, _ = rl.allow("u1", t0.Add(time.Hour))
+	assert.False(t, ok)
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(0.5, 1, nil)
+	ok, wait := rl.allow("u1", t0)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), wait)
+	ok, wait = rl.allow("u1", t0)
+	assert.False(t, ok)
+	assert.Equal(t, 2*time.Second, wait)
+	ok, wait = rl.allow("u1", t0.Add(1500*time.Millisecond))
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+}
+
+func TestRateLimiterCleanup(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(1, 5, nil)
+	rl.lastCleanup = t0
+	rl.allow("idle", t0)
+	for i := 0; i < 5; i++ {
+		rl.allow("busy", t0.Add(rateLimiterCleanupInterval-time.Second))
+	}
+	assert.Len(t, rl.buckets, 2)
+	// the `idle` bucket is full again, the `busy` one is not
+	rl.allow("other", t0.Add(rateLimiterCleanupInterval))
+	assert.Contains(t, rl.buckets, "busy")
+	assert.NotContains(t, rl.buckets, "idle")
+	assert.Contains(t, rl.buckets, "other")
+	assert.Equal(t, t0.Add(rateLimiterCleanupInterval), rl.lastCleanup)
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(0.25, 1, func() time.Time { return now })
+	engine := gin.New()
+	engine.GET("/user/:userId", rl.Middleware(), func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	request := func(userID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user/"+userID, nil))
+		return w
+	}
+	assert.Equal(t, http.StatusOK, request("1").Code)
+	w := request("1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "4", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, request("2").Code)
+	now = now.Add(2500 * time.Millisecond)
+	w = request("1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	now = now.Add(1500 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, request("1").Code)
+}
+
+func TestRateLimiterMiddlewareKeyByIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(0.25, 1, func() time.Time { return now })
+	rl.keyByIP = true
+	engine := gin.New()
+	engine.GET("/user/:userId", rl.Middleware(), func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	request := func(userID, remoteAddr string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/user/"+userID, nil)
+		req.RemoteAddr = remoteAddr
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, request("1", "10.0.0.1:1234"))
+	// rotating user IDs does not help
+	assert.Equal(t, http.StatusTooManyRequests, request("2", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, request("3", "10.0.0.1:1235"))
+	assert.Equal(t, http.StatusOK, request("2", "10.0.0.2:1234"))
+}
+
+func TestRateLimiterRequestKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx.Request.RemoteAddr = "10.0.0.1:1234"
+	ctx.Params = gin.Params{{Key: "userId", Value: "1"}}
+	rl := newRateLimiter(1, 1, nil)
+	assert.Equal(t, "user:1", rl.requestKey(ctx))
+	rl.keyByIP = true
+	assert.Equal(t, "ip:10.0.0.1", rl.requestKey(ctx))
+	rl.keyByIP = false
+	ctx.Params = nil
+	assert.Equal(t, "ip:10.0.0.1", rl.requestKey(ctx))
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporting
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// dropOldestQueue is a bounded non-blocking queue. In case it is full,
+// the oldest item is dropped to make room for the new one. This
+// ensures that producers (e.g. the archiver) are never stalled
+// by a slow or unavailable reporting backend.
+type dropOldestQueue[T any] struct {
+	items      chan T
+	numDropped atomic.Int64
+}
+
+// push adds an item to the queue without blocking. The returned
+// value tells whether some older item has been dropped.
+func (q *dropOldestQueue[T]) push(item T) bool {
+	var dropped bool
+	for {
+		select {
+		case q.items <- item:
+			return dropped
+		default:
+		}
+		select {
+		case <-q.items:
+			q.numDropped.Add(1)
+			dropped = true
+		default:
+		}
+	}
+}
+
+// forward moves queued items to the `out` channel until
+// the context is cancelled. Only this goroutine may block.
+func (q *dropOldestQueue[T]) forward(ctx context.Context, out chan<- T) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case item := <-q.items:
+			select {
+			case out <- item:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func newDropOldestQueue[T any](size int) *dropOldestQueue[T] {
+	return &dropOldestQueue[T]{items: make(chan T, max(size, 1))}
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporting
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDropOldestQueueDropsOldest(t *testing.T) {
+	q := newDropOldestQueue[int](3)
+	for i := 1; i <= 3; i++ {
+		assert.False(t, q.push(i))
+	}
+	assert.True(t, q.push(4))
+	assert.True(t, q.push(5))
+	assert.Equal(t, int64(2), q.numDropped.Load())
+	assert.Equal(t, 3, <-q.items)
+	assert.Equal(t, 4, <-q.items)
+	assert.Equal(t, 5, <-q.items)
+}
+
+func TestDropOldestQueueNonBlocking(t *testing.T) {
+	q := newDropOldestQueue[int](2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blocked := make(chan int) // nobody reads, i.e. backend is down
+	go q.forward(ctx, blocked)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			q.push(i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("push blocked on unavailable backend")
+	}
+	assert.GreaterOrEqual(t, q.numDropped.Load(), int64(97))
+}
+
+func TestDropOldestQueueForwards(t *testing.T) {
+	q := newDropOldestQueue[int](10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan int, 10)
+	go q.forward(ctx, out)
+	q.push(1)
+	q.push(2)
+	assert.Equal(t, 1, <-out)
+	assert.Equal(t, 2, <-out)
+	assert.Equal(t, int64(0), q.numDropped.Load())
+}
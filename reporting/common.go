@@ -25,6 +25,26 @@ type OpStats struct {
 	NumMerged   int `json:"numMerged"`
 	NumInserted int `json:"numInserted"`
 	NumFetched  int `json:"numFetched"`
+
+	// NumTimeouts counts records whose processing exceeded
+	// the configured time limit (these are not part of NumErrors).
+	// Please note that the value is not written to TimescaleDB.
+	NumTimeouts int `json:"numTimeouts"`
+
+	// WriteLatencyP95Ms is 95th percentile of archive write operations
+	// durations. When aggregating, the maximum value is kept.
+	// Please note that the value is not written to TimescaleDB.
+	WriteLatencyP95Ms int64 `json:"writeLatencyP95Ms"`
+
+	// NumDedupFalsePositives counts records the deduplicator considered
+	// archived but they were not found in the database (Bloom filter
+	// false positives).
+	NumDedupFalsePositives int `json:"numDedupFalsePositives"`
+
+	// DedupFalsePositiveRatio is the false positive ratio observed
+	// over a window of recent deduplicator tests. When aggregating,
+	// the most recent value is kept.
+	DedupFalsePositiveRatio float64 `json:"dedupFalsePositiveRatio"`
 }
 
 func (bgs *OpStats) UpdateBy(other OpStats) {
@@ -32,10 +52,14 @@ func (bgs *OpStats) UpdateBy(other OpStats) {
 	bgs.NumMerged += other.NumMerged
 	bgs.NumInserted += other.NumInserted
 	bgs.NumFetched += other.NumFetched
+	bgs.NumTimeouts += other.NumTimeouts
+	bgs.WriteLatencyP95Ms = max(bgs.WriteLatencyP95Ms, other.WriteLatencyP95Ms)
+	bgs.NumDedupFalsePositives += other.NumDedupFalsePositives
+	bgs.DedupFalsePositiveRatio = other.DedupFalsePositiveRatio
 }
 
 func (bgs *OpStats) ShowsActivity() bool {
-	return bgs.NumErrors+bgs.NumMerged+bgs.NumInserted+bgs.NumFetched > 0
+	return bgs.NumErrors+bgs.NumMerged+bgs.NumInserted+bgs.NumFetched+bgs.NumTimeouts > 0
 }
 
 // ------------
@@ -54,6 +78,16 @@ type QueryHistoryDelStats struct {
 	SQLTableSize int64 `json:"sqlTableSize"`
 	NumDeleted   int   `json:"numDeleted"`
 	NumErrors    int   `json:"numErrors"`
+
+	// NumPendingDeletion is the number of query history records
+	// marked for deletion and still waiting to be deleted. A growing
+	// value means the marking outpaces the deletion.
+	NumPendingDeletion int64 `json:"numPendingDeletion"`
+
+	// NumOrphansRemoved is the number of index documents removed
+	// because their archive records no longer exist
+	// (see indexer.Conf.QueryHistoryOrphanSweepMaxDocs)
+	NumOrphansRemoved int `json:"numOrphansRemoved"`
 }
 
 // ------------
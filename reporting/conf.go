@@ -0,0 +1,125 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporting
+
+import (
+	"fmt"
+
+	"github.com/czcorpus/hltscl"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	TypeTimescale = "timescale"
+	TypeFile      = "file"
+	TypeDummy     = "dummy"
+
+	dfltFileMaxSizeMB   = 10
+	dfltFileMaxBackups  = 5
+	dfltNumRetainRecent = 20
+	dfltQueueSize       = 100
+)
+
+type FileConf struct {
+	OpsPath        string `json:"opsPath"`
+	CleanupPath    string `json:"cleanupPath"`
+	QHDeletionPath string `json:"qhDeletionPath"`
+	MaxFileSizeMB  int    `json:"maxFileSizeMB"`
+	MaxBackups     int    `json:"maxBackups"`
+}
+
+// Conf configures the reporting sink. For backward compatibility,
+// the TimescaleDB connection properties are kept at the top level
+// of the section.
+type Conf struct {
+	hltscl.PgConf
+	Type string   `json:"type"`
+	File FileConf `json:"file"`
+
+	// NumRetainRecent specifies how many recent stats of each
+	// type are kept in memory (and exposed via `/overview`)
+	NumRetainRecent int `json:"numRetainRecent"`
+
+	// QueueSize specifies how many TimescaleDB entries of each
+	// stats type can wait for writing. Once the queue is full
+	// (e.g. the database is down), the oldest entries are dropped.
+	QueueSize int `json:"queueSize"`
+}
+
+func (conf *Conf) ValidateAndDefaults() error {
+	if conf.Type == "" {
+		if conf.Host != "" {
+			conf.Type = TypeTimescale
+
+		} else {
+			conf.Type = TypeDummy
+		}
+		log.Warn().
+			Str("value", conf.Type).
+			Msg("reporting configuration `type` not specified, using inferred value")
+	}
+	if conf.NumRetainRecent < 0 {
+		return fmt.Errorf("reporting `numRetainRecent` must be >= 0")
+	}
+	if conf.NumRetainRecent == 0 {
+		conf.NumRetainRecent = dfltNumRetainRecent
+		log.Warn().
+			Int("value", dfltNumRetainRecent).
+			Msg("reporting configuration `numRetainRecent` not specified, using default")
+	}
+	switch conf.Type {
+	case TypeTimescale:
+		if conf.Host == "" {
+			return fmt.Errorf("reporting type `%s` requires `host`", TypeTimescale)
+		}
+		if conf.QueueSize < 0 {
+			return fmt.Errorf("reporting `queueSize` must be >= 0")
+		}
+		if conf.QueueSize == 0 {
+			conf.QueueSize = dfltQueueSize
+			log.Warn().
+				Int("value", dfltQueueSize).
+				Msg("reporting configuration `queueSize` not specified, using default")
+		}
+	case TypeFile:
+		if conf.File.OpsPath == "" || conf.File.CleanupPath == "" || conf.File.QHDeletionPath == "" {
+			return fmt.Errorf(
+				"reporting type `%s` requires `file.opsPath`, `file.cleanupPath` and `file.qhDeletionPath`",
+				TypeFile,
+			)
+		}
+		if conf.File.MaxFileSizeMB < 0 || conf.File.MaxBackups < 0 {
+			return fmt.Errorf("reporting `file.maxFileSizeMB` and `file.maxBackups` must be >= 0")
+		}
+		if conf.File.MaxFileSizeMB == 0 {
+			conf.File.MaxFileSizeMB = dfltFileMaxSizeMB
+			log.Warn().
+				Int("value", dfltFileMaxSizeMB).
+				Msg("reporting configuration `file.maxFileSizeMB` not specified, using default")
+		}
+		if conf.File.MaxBackups == 0 {
+			conf.File.MaxBackups = dfltFileMaxBackups
+			log.Warn().
+				Int("value", dfltFileMaxBackups).
+				Msg("reporting configuration `file.maxBackups` not specified, using default")
+		}
+	case TypeDummy:
+	default:
+		return fmt.Errorf("unknown reporting type `%s`", conf.Type)
+	}
+	return nil
+}
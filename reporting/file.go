@@ -0,0 +1,196 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// rotatingFile is an append-only file which is rotated
+// (file -> file.1 -> file.2 ...) once it reaches maxSize.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	mu         sync.Mutex
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open report file %s: %w", rf.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open report file %s: %w", rf.path, err)
+	}
+	rf.file = f
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return fmt.Errorf("failed to rotate report file %s: %w", rf.path, err)
+	}
+	rf.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxBackups))
+	for i := rf.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if rf.maxBackups > 0 {
+		if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate report file %s: %w", rf.path, err)
+		}
+
+	} else if err := os.Remove(rf.path); err != nil {
+		return fmt.Errorf("failed to rotate report file %s: %w", rf.path, err)
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) writeLine(data []byte) error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return err
+		}
+	}
+	if rf.size > 0 && rf.size+int64(len(data))+1 > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := rf.file.Write(append(data, '\n'))
+	rf.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write report file %s: %w", rf.path, err)
+	}
+	return nil
+}
+
+func (rf *rotatingFile) close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	ans := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := ans.open(); err != nil {
+		return nil, err
+	}
+	return ans, nil
+}
+
+// ------------
+
+// FileReporting writes all the reported stats as JSON lines
+// into plain files. It is intended for installations without
+// TimescaleDB.
+type FileReporting struct {
+	opsFile     *rotatingFile
+	cleanupFile *rotatingFile
+	qhDelFile   *rotatingFile
+	location    *time.Location
+}
+
+func (fr *FileReporting) Start(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		log.Info().Msg("about to close FileReporting")
+	}()
+}
+
+func (fr *FileReporting) Stop(ctx context.Context) error {
+	log.Warn().Msg("stopping FileReporting")
+	var ans error
+	for _, f := range []*rotatingFile{fr.opsFile, fr.cleanupFile, fr.qhDelFile} {
+		if err := f.close(); err != nil {
+			ans = err
+		}
+	}
+	return ans
+}
+
+func writeFileEntry[T any](f *rotatingFile, tz *time.Location, item T) {
+	data, err := json.Marshal(TimedStats[T]{Time: time.Now().In(tz), Stats: item})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to encode report entry")
+		return
+	}
+	if err := f.writeLine(data); err != nil {
+		log.Error().Err(err).Msg("failed to write report entry")
+	}
+}
+
+func (fr *FileReporting) WriteOperationsStatus(item OpStats) {
+	writeFileEntry(fr.opsFile, fr.location, item)
+}
+
+func (fr *FileReporting) WriteCleanupStatus(item CleanupStats) {
+	writeFileEntry(fr.cleanupFile, fr.location, item)
+}
+
+func (fr *FileReporting) WriteQueryHistoryDeletionStatus(item QueryHistoryDelStats) {
+	writeFileEntry(fr.qhDelFile, fr.location, item)
+}
+
+func NewFileReporting(conf FileConf, tz *time.Location) (*FileReporting, error) {
+	maxSize := int64(conf.MaxFileSizeMB) * 1024 * 1024
+	opsFile, err := newRotatingFile(conf.OpsPath, maxSize, conf.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	cleanupFile, err := newRotatingFile(conf.CleanupPath, maxSize, conf.MaxBackups)
+	if err != nil {
+		opsFile.close()
+		return nil, err
+	}
+	qhDelFile, err := newRotatingFile(conf.QHDeletionPath, maxSize, conf.MaxBackups)
+	if err != nil {
+		opsFile.close()
+		cleanupFile.close()
+		return nil, err
+	}
+	return &FileReporting{
+		opsFile:     opsFile,
+		cleanupFile: cleanupFile,
+		qhDelFile:   qhDelFile,
+		location:    tz,
+	}, nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporting
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type TimedStats[T any] struct {
+	Time  time.Time `json:"time"`
+	Stats T         `json:"stats"`
+}
+
+// ringBuffer keeps the last N inserted items
+type ringBuffer[T any] struct {
+	items []T
+	next  int
+	full  bool
+}
+
+func (rb *ringBuffer[T]) add(item T) {
+	if len(rb.items) == 0 {
+		return
+	}
+	rb.items[rb.next] = item
+	rb.next = (rb.next + 1) % len(rb.items)
+	if rb.next == 0 {
+		rb.full = true
+	}
+}
+
+// list returns the stored items from the newest to the oldest
+func (rb *ringBuffer[T]) list() []T {
+	size := rb.next
+	if rb.full {
+		size = len(rb.items)
+	}
+	ans := make([]T, 0, size)
+	for i := 1; i <= size; i++ {
+		ans = append(ans, rb.items[(rb.next-i+len(rb.items))%len(rb.items)])
+	}
+	return ans
+}
+
+func newRingBuffer[T any](size int) *ringBuffer[T] {
+	return &ringBuffer[T]{items: make([]T, size)}
+}
+
+// ------------
+
+type RecentStats struct {
+	Operations           []TimedStats[OpStats]              `json:"operations"`
+	Cleanup              []TimedStats[CleanupStats]         `json:"cleanup"`
+	QueryHistoryDeletion []TimedStats[QueryHistoryDelStats] `json:"queryHistoryDeletion"`
+}
+
+// InMemoryReporting retains the last N stats of each type
+// so they can be exposed via the HTTP API. Optionally, it
+// can wrap another IReporting implementation which then
+// receives all the written stats too.
+type InMemoryReporting struct {
+	wrapped  IReporting
+	ops      *ringBuffer[TimedStats[OpStats]]
+	cleanup  *ringBuffer[TimedStats[CleanupStats]]
+	qhDel    *ringBuffer[TimedStats[QueryHistoryDelStats]]
+	location *time.Location
+	mu       sync.RWMutex
+}
+
+func (imr *InMemoryReporting) Start(ctx context.Context) {
+	if imr.wrapped != nil {
+		imr.wrapped.Start(ctx)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		log.Info().Msg("about to close InMemoryReporting")
+	}()
+}
+
+func (imr *InMemoryReporting) Stop(ctx context.Context) error {
+	if imr.wrapped != nil {
+		return imr.wrapped.Stop(ctx)
+	}
+	log.Warn().Msg("stopping InMemoryReporting")
+	return nil
+}
+
+func (imr *InMemoryReporting) WriteOperationsStatus(item OpStats) {
+	imr.mu.Lock()
+	imr.ops.add(TimedStats[OpStats]{Time: time.Now().In(imr.location), Stats: item})
+	imr.mu.Unlock()
+	if imr.wrapped != nil {
+		imr.wrapped.WriteOperationsStatus(item)
+	}
+}
+
+func (imr *InMemoryReporting) WriteCleanupStatus(item CleanupStats) {
+	imr.mu.Lock()
+	imr.cleanup.add(TimedStats[CleanupStats]{Time: time.Now().In(imr.location), Stats: item})
+	imr.mu.Unlock()
+	if imr.wrapped != nil {
+		imr.wrapped.WriteCleanupStatus(item)
+	}
+}
+
+func (imr *InMemoryReporting) WriteQueryHistoryDeletionStatus(item QueryHistoryDelStats) {
+	imr.mu.Lock()
+	imr.qhDel.add(TimedStats[QueryHistoryDelStats]{Time: time.Now().In(imr.location), Stats: item})
+	imr.mu.Unlock()
+	if imr.wrapped != nil {
+		imr.wrapped.WriteQueryHistoryDeletionStatus(item)
+	}
+}
+
+// droppedEntriesCounter is implemented by reporting backends which
+// may drop entries (see StatusWriter)
+type droppedEntriesCounter interface {
+	NumDroppedEntries() int64
+}
+
+// NumDroppedEntries returns the number of stats entries dropped
+// by the wrapped reporting (e.g. due to an unavailable database).
+// In case the wrapped reporting never drops entries, zero is returned.
+func (imr *InMemoryReporting) NumDroppedEntries() int64 {
+	if dc, ok := imr.wrapped.(droppedEntriesCounter); ok {
+		return dc.NumDroppedEntries()
+	}
+	return 0
+}
+
+// Recent returns the retained stats (newest first)
+func (imr *InMemoryReporting) Recent() RecentStats {
+	imr.mu.RLock()
+	defer imr.mu.RUnlock()
+	return RecentStats{
+		Operations:           imr.ops.list(),
+		Cleanup:              imr.cleanup.list(),
+		QueryHistoryDeletion: imr.qhDel.list(),
+	}
+}
+
+// NewInMemoryReporting creates a new instance retaining numRetain
+// items of each stats type. The `wrapped` argument can be nil.
+func NewInMemoryReporting(wrapped IReporting, numRetain int, tz *time.Location) *InMemoryReporting {
+	return &InMemoryReporting{
+		wrapped:  wrapped,
+		ops:      newRingBuffer[TimedStats[OpStats]](numRetain),
+		cleanup:  newRingBuffer[TimedStats[CleanupStats]](numRetain),
+		qhDel:    newRingBuffer[TimedStats[QueryHistoryDelStats]](numRetain),
+		location: tz,
+	}
+}
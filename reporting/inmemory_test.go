@@ -0,0 +1,64 @@
+// Copyright 2024 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/czcorpus/hltscl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemoryReportingRetainsLastN(t *testing.T) {
+	imr := NewInMemoryReporting(nil, 3, time.UTC)
+	for i := 1; i <= 5; i++ {
+		imr.WriteCleanupStatus(CleanupStats{NumDeleted: i})
+	}
+	recent := imr.Recent().Cleanup
+	assert.Len(t, recent, 3)
+	assert.Equal(t, 5, recent[0].Stats.NumDeleted)
+	assert.Equal(t, 4, recent[1].Stats.NumDeleted)
+	assert.Equal(t, 3, recent[2].Stats.NumDeleted)
+}
+
+func TestInMemoryReportingPartiallyFilled(t *testing.T) {
+	imr := NewInMemoryReporting(nil, 10, time.UTC)
+	imr.WriteQueryHistoryDeletionStatus(QueryHistoryDelStats{NumDeleted: 1})
+	imr.WriteQueryHistoryDeletionStatus(QueryHistoryDelStats{NumDeleted: 2})
+	recent := imr.Recent()
+	assert.Len(t, recent.QueryHistoryDeletion, 2)
+	assert.Equal(t, 2, recent.QueryHistoryDeletion[0].Stats.NumDeleted)
+	assert.Empty(t, recent.Operations)
+}
+
+func TestInMemoryReportingNumDroppedEntries(t *testing.T) {
+	imr := NewInMemoryReporting(nil, 3, time.UTC)
+	assert.Equal(t, int64(0), imr.NumDroppedEntries())
+	imr = NewInMemoryReporting(&DummyWriter{}, 3, time.UTC)
+	assert.Equal(t, int64(0), imr.NumDroppedEntries())
+
+	sw := &StatusWriter{
+		opsQueue:       newDropOldestQueue[hltscl.Entry](1),
+		cleanupQueue:   newDropOldestQueue[hltscl.Entry](1),
+		indexInfoQueue: newDropOldestQueue[hltscl.Entry](1),
+	}
+	sw.opsQueue.numDropped.Store(2)
+	sw.indexInfoQueue.numDropped.Store(1)
+	imr = NewInMemoryReporting(sw, 3, time.UTC)
+	assert.Equal(t, int64(3), imr.NumDroppedEntries())
+}
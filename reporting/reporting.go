@@ -33,7 +33,9 @@ create table camus_operations_stats (
   num_errors int,
   num_merged int,
   num_inserted int,
-  index_size int
+  index_size int,
+  num_dedup_false_positives int,
+  dedup_false_positive_ratio float
 );
 
 select create_hypertable('camus_operations_stats', 'time');
@@ -52,13 +54,30 @@ create table camus_query_history_deletion_stats (
   "time" timestamp with time zone NOT NULL,
   num_deleted int,
   index_size int,
-  num_errors int
+  num_errors int,
+  num_pending_deletion int,
+  num_orphans_removed int
 );
 
 select create_hypertable('camus_query_history_deletion_stats', 'time');
 
+Upgrading existing tables (columns added in later versions; the writes
+fail for tables without them):
+
+alter table camus_operations_stats
+  add column if not exists num_dedup_false_positives int,
+  add column if not exists dedup_false_positive_ratio float;
+
+alter table camus_query_history_deletion_stats
+  add column if not exists num_pending_deletion int,
+  add column if not exists num_orphans_removed int;
+
 */
 
+// StatusWriter writes stats to TimescaleDB. The Write* methods never
+// block - entries are passed through bounded queues and in case
+// the database is unavailable for too long, the oldest entries
+// are dropped.
 type StatusWriter struct {
 	tableWriterOps        *hltscl.TableWriter
 	tableWriterCleanup    *hltscl.TableWriter
@@ -66,11 +85,34 @@ type StatusWriter struct {
 	opsDataCh             chan<- hltscl.Entry
 	cleanupDataCh         chan<- hltscl.Entry
 	indexInfoDataCh       chan<- hltscl.Entry
+	opsQueue              *dropOldestQueue[hltscl.Entry]
+	cleanupQueue          *dropOldestQueue[hltscl.Entry]
+	indexInfoQueue        *dropOldestQueue[hltscl.Entry]
 	errCh                 <-chan hltscl.WriteError
 	location              *time.Location
 }
 
+// NumDroppedEntries returns the total number of stats entries
+// dropped due to full queues (i.e. an unavailable database).
+func (job *StatusWriter) NumDroppedEntries() int64 {
+	return job.opsQueue.numDropped.Load() +
+		job.cleanupQueue.numDropped.Load() +
+		job.indexInfoQueue.numDropped.Load()
+}
+
+func (job *StatusWriter) enqueue(q *dropOldestQueue[hltscl.Entry], table string, entry hltscl.Entry) {
+	if q.push(entry) {
+		log.Warn().
+			Str("table", table).
+			Int64("numDroppedTotal", job.NumDroppedEntries()).
+			Msg("reporting queue full (TimescaleDB unavailable?), dropped the oldest entry")
+	}
+}
+
 func (job *StatusWriter) Start(ctx context.Context) {
+	go job.opsQueue.forward(ctx, job.opsDataCh)
+	go job.cleanupQueue.forward(ctx, job.cleanupDataCh)
+	go job.indexInfoQueue.forward(ctx, job.indexInfoDataCh)
 	go func() {
 		for {
 			select {
@@ -94,35 +136,49 @@ func (job *StatusWriter) Stop(ctx context.Context) error {
 
 func (ds *StatusWriter) WriteOperationsStatus(item OpStats) {
 	if ds.tableWriterOps != nil {
-		ds.opsDataCh <- *ds.tableWriterOps.NewEntry(time.Now().In(ds.location)).
+		entry := ds.tableWriterOps.NewEntry(time.Now().In(ds.location)).
 			Int("num_merged", item.NumMerged).
 			Int("num_errors", item.NumErrors).
 			Int("num_fetched", item.NumFetched).
-			Int("num_inserted", item.NumInserted)
+			Int("num_inserted", item.NumInserted).
+			Int("num_dedup_false_positives", item.NumDedupFalsePositives).
+			Float("dedup_false_positive_ratio", item.DedupFalsePositiveRatio)
+		ds.enqueue(ds.opsQueue, "camus_operations_stats", *entry)
 	}
 }
 
 func (ds *StatusWriter) WriteCleanupStatus(item CleanupStats) {
 	if ds.tableWriterCleanup != nil {
-		ds.cleanupDataCh <- *ds.tableWriterCleanup.NewEntry(time.Now().In(ds.location)).
+		entry := ds.tableWriterCleanup.NewEntry(time.Now().In(ds.location)).
 			Int("num_errors", item.NumErrors).
 			Int("num_fetched", item.NumFetched).
 			Int("num_merged", item.NumMerged).
 			Int("num_deleted", item.NumDeleted)
+		ds.enqueue(ds.cleanupQueue, "camus_cleanup_stats", *entry)
 	}
 }
 
 func (ds *StatusWriter) WriteQueryHistoryDeletionStatus(item QueryHistoryDelStats) {
 	if ds.tableWriterQHDelStats != nil {
-		ds.indexInfoDataCh <- *ds.tableWriterCleanup.NewEntry(time.Now().In(ds.location)).
+		entry := ds.tableWriterQHDelStats.NewEntry(time.Now().In(ds.location)).
 			Int("index_size", int(item.IndexSize)).
 			Int("sql_table_size", int(item.SQLTableSize)).
 			Int("num_deleted", item.NumDeleted).
-			Int("num_errors", item.NumErrors)
+			Int("num_errors", item.NumErrors).
+			Int("num_pending_deletion", int(item.NumPendingDeletion)).
+			Int("num_orphans_removed", item.NumOrphansRemoved)
+		ds.enqueue(ds.indexInfoQueue, "camus_query_history_deletion_stats", *entry)
 	}
 }
 
-func NewStatusWriter(conf hltscl.PgConf, tz *time.Location, onError func(err error)) (*StatusWriter, error) {
+// NewStatusWriter creates a new TimescaleDB writer with queues
+// of queueSize entries for each of the stats tables.
+func NewStatusWriter(
+	conf hltscl.PgConf,
+	queueSize int,
+	tz *time.Location,
+	onError func(err error),
+) (*StatusWriter, error) {
 
 	conn, err := hltscl.CreatePool(conf)
 	if err != nil {
@@ -158,6 +214,9 @@ func NewStatusWriter(conf hltscl.PgConf, tz *time.Location, onError func(err err
 		opsDataCh:             opsDataCh,
 		cleanupDataCh:         cleanupDataCh,
 		indexInfoDataCh:       indexInfoDataCh,
+		opsQueue:              newDropOldestQueue[hltscl.Entry](queueSize),
+		cleanupQueue:          newDropOldestQueue[hltscl.Entry](queueSize),
+		indexInfoQueue:        newDropOldestQueue[hltscl.Entry](queueSize),
 		errCh:                 mergedErr,
 		location:              tz,
 	}, nil